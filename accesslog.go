@@ -0,0 +1,379 @@
+package trafico
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// AccessLogConfig configures the structured audit log of GraphQL operations
+type AccessLogConfig struct {
+	Enabled bool `json:"enabled,omitempty"`
+	// Output is one of stdout (default), file or syslog
+	Output string `json:"output,omitempty"`
+
+	FilePath   string `json:"filePath,omitempty"`
+	MaxSizeMB  int    `json:"maxSizeMB,omitempty"`
+	MaxBackups int    `json:"maxBackups,omitempty"`
+
+	// SyslogAddress is a host:port reached over SyslogNetwork (udp by default)
+	SyslogNetwork string `json:"syslogNetwork,omitempty"`
+	SyslogAddress string `json:"syslogAddress,omitempty"`
+	SyslogTag     string `json:"syslogTag,omitempty"`
+
+	// SampleRate is the fraction of allowed requests to log; blocked requests are always logged
+	SampleRate float64 `json:"sampleRate,omitempty"`
+
+	// LogVariables includes the variables, minus redacted ones, in each entry
+	LogVariables bool `json:"logVariables,omitempty"`
+}
+
+const (
+	defaultMaxSizeMB  = 100
+	defaultMaxBackups = 3
+	defaultSyslogTag  = "trafico"
+	// syslogBufferSize bounds the messages waiting to be sent, the ones
+	// beyond being dropped
+	syslogBufferSize = 1000
+
+	decisionAllowed = "allowed"
+	decisionBlocked = "blocked"
+	decisionDryRun  = "dry_run"
+)
+
+// accessLogEntry is a single JSON line of the audit log
+type accessLogEntry struct {
+	Time           string         `json:"time"`
+	Middleware     string         `json:"middleware"`
+	RequestID      string         `json:"requestId,omitempty"`
+	ClientIP       string         `json:"clientIp,omitempty"`
+	ClientName     string         `json:"clientName,omitempty"`
+	ClientVersion  string         `json:"clientVersion,omitempty"`
+	ClientIdentity string         `json:"clientIdentity,omitempty"`
+	OperationName  string         `json:"operationName,omitempty"`
+	OperationType  string         `json:"operationType,omitempty"`
+	Queries        []string       `json:"queries,omitempty"`
+	Mutations      []string       `json:"mutations,omitempty"`
+	FieldPaths     []string       `json:"fieldPaths,omitempty"`
+	VariablesHash  string         `json:"variablesHash,omitempty"`
+	Variables      map[string]any `json:"variables,omitempty"`
+	DocumentSize   int            `json:"documentSize"`
+	Decision       string         `json:"decision"`
+	Reason         string         `json:"reason,omitempty"`
+	Status         int            `json:"status,omitempty"`
+	LatencyMs      float64        `json:"latencyMs"`
+}
+
+// accessLog writes sampled audit entries; a nil *accessLog logs nothing
+type accessLog struct {
+	middleware   string
+	sampleRate   float64
+	logVariables bool
+	writer       lineWriter
+
+	mu  sync.Mutex
+	rnd *rand.Rand
+}
+
+// lineWriter writes complete log lines to an output
+type lineWriter interface {
+	writeLine(line []byte) error
+}
+
+// newAccessLog opens the configured output, or returns nil when disabled
+func newAccessLog(config AccessLogConfig, middleware string) (*accessLog, error) {
+	if !config.Enabled {
+		return nil, nil
+	}
+	if config.SampleRate < 0 || config.SampleRate > 1 {
+		return nil, fmt.Errorf("accessLog: sampleRate must be between 0 and 1, got %v", config.SampleRate)
+	}
+
+	var writer lineWriter
+	var err error
+	switch config.Output {
+	case "", "stdout":
+		writer = stdoutWriter{}
+	case "file":
+		writer, err = fileWriterFor(config)
+	case "syslog":
+		writer, err = newSyslogWriter(config)
+	default:
+		err = fmt.Errorf("unknown output %q", config.Output)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("accessLog: %w", err)
+	}
+
+	sampleRate := config.SampleRate
+	if sampleRate == 0 {
+		sampleRate = 1
+	}
+
+	return &accessLog{
+		middleware:   middleware,
+		sampleRate:   sampleRate,
+		logVariables: config.LogVariables,
+		writer:       writer,
+		rnd:          rand.New(rand.NewSource(time.Now().UnixNano())),
+	}, nil
+}
+
+// log writes the entry, subject to sampling of allowed requests
+func (a *accessLog) log(entry accessLogEntry) {
+	if a == nil {
+		return
+	}
+	if entry.Decision == decisionAllowed && a.sampleRate < 1 {
+		a.mu.Lock()
+		skip := a.rnd.Float64() >= a.sampleRate
+		a.mu.Unlock()
+		if skip {
+			return
+		}
+	}
+
+	entry.Middleware = a.middleware
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return
+	}
+	if err := a.writer.writeLine(line); err != nil {
+		logf("access log write failed: %v", err)
+	}
+}
+
+// clientIP returns the address of the direct client
+func clientIP(req *http.Request) string {
+	host, _, err := net.SplitHostPort(req.RemoteAddr)
+	if err != nil {
+		return req.RemoteAddr
+	}
+	return host
+}
+
+// variablesHash returns a stable hash of the variables so that requests can be
+// correlated without logging their values
+func variablesHash(variables map[string]any) string {
+	if len(variables) == 0 {
+		return ""
+	}
+	// json.Marshal sorts map keys, which makes the encoding canonical
+	data, err := json.Marshal(variables)
+	if err != nil {
+		return ""
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+type stdoutWriter struct{}
+
+var stdoutMu sync.Mutex
+
+func (stdoutWriter) writeLine(line []byte) error {
+	stdoutMu.Lock()
+	defer stdoutMu.Unlock()
+	_, err := os.Stdout.Write(append(line, '\n'))
+	return err
+}
+
+var (
+	fileWritersMu sync.Mutex
+	fileWriters   = make(map[string]*rotatingFile)
+)
+
+// rotatingFile is a size-rotated log file shared by every instance writing to the same path
+type rotatingFile struct {
+	path       string
+	maxSize    int64
+	maxBackups int
+
+	mu   sync.Mutex
+	file *os.File
+	size int64
+}
+
+func fileWriterFor(config AccessLogConfig) (*rotatingFile, error) {
+	if config.FilePath == "" {
+		return nil, fmt.Errorf("filePath is required for file output")
+	}
+
+	fileWritersMu.Lock()
+	defer fileWritersMu.Unlock()
+
+	if f, ok := fileWriters[config.FilePath]; ok {
+		return f, nil
+	}
+
+	maxSizeMB := config.MaxSizeMB
+	if maxSizeMB <= 0 {
+		maxSizeMB = defaultMaxSizeMB
+	}
+	maxBackups := config.MaxBackups
+	if maxBackups <= 0 {
+		maxBackups = defaultMaxBackups
+	}
+
+	f := &rotatingFile{
+		path:       config.FilePath,
+		maxSize:    int64(maxSizeMB) * 1024 * 1024,
+		maxBackups: maxBackups,
+	}
+	if err := f.open(); err != nil {
+		return nil, err
+	}
+	fileWriters[config.FilePath] = f
+	return f, nil
+}
+
+func (f *rotatingFile) open() error {
+	file, err := os.OpenFile(f.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	f.file = file
+	f.size = info.Size()
+	return nil
+}
+
+func (f *rotatingFile) writeLine(line []byte) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.size+int64(len(line))+1 > f.maxSize && f.size > 0 {
+		if err := f.rotate(); err != nil {
+			return err
+		}
+	}
+	n, err := f.file.Write(append(line, '\n'))
+	f.size += int64(n)
+	return err
+}
+
+// rotate shifts path.N to path.N+1, dropping the oldest backup, and reopens
+// path; when the file cannot be moved aside it is reopened to keep logging
+func (f *rotatingFile) rotate() error {
+	err := f.file.Close()
+	if err == nil {
+		for i := f.maxBackups - 1; i >= 1; i-- {
+			_ = os.Rename(f.path+"."+strconv.Itoa(i), f.path+"."+strconv.Itoa(i+1))
+		}
+		err = os.Rename(f.path, f.path+".1")
+	}
+	if err != nil {
+		logf("access log rotation of %s failed: %v", f.path, err)
+	}
+	return f.open()
+}
+
+// syslogWriter formats RFC 5424 messages for a remote syslog daemon
+type syslogWriter struct {
+	tag      string
+	hostname string
+	sender   *syslogSender
+}
+
+// syslogSender is the bounded queue and connection shared by instances
+// logging to the same daemon; messages are sent in the background so that
+// requests never wait on the network
+type syslogSender struct {
+	network string
+	address string
+	queue   chan []byte
+	dropped int64
+	conn    net.Conn
+}
+
+var (
+	syslogSendersMu sync.Mutex
+	syslogSenders   = make(map[string]*syslogSender)
+)
+
+func newSyslogWriter(config AccessLogConfig) (*syslogWriter, error) {
+	if config.SyslogAddress == "" {
+		return nil, fmt.Errorf("syslogAddress is required for syslog output")
+	}
+	network := config.SyslogNetwork
+	if network == "" {
+		network = "udp"
+	}
+	tag := config.SyslogTag
+	if tag == "" {
+		tag = defaultSyslogTag
+	}
+	hostname, _ := os.Hostname()
+	if hostname == "" {
+		hostname = "-"
+	}
+
+	syslogSendersMu.Lock()
+	defer syslogSendersMu.Unlock()
+
+	key := network + "://" + config.SyslogAddress
+	sender, ok := syslogSenders[key]
+	if !ok {
+		sender = &syslogSender{network: network, address: config.SyslogAddress, queue: make(chan []byte, syslogBufferSize)}
+		syslogSenders[key] = sender
+		go sender.run()
+	}
+	return &syslogWriter{tag: tag, hostname: hostname, sender: sender}, nil
+}
+
+// writeLine queues the message, dropping it when the buffer is full
+func (s *syslogWriter) writeLine(line []byte) error {
+	// Facility local0, severity informational
+	msg := fmt.Sprintf("<134>1 %s %s %s %d - - %s", time.Now().UTC().Format(time.RFC3339Nano), s.hostname, s.tag, os.Getpid(), line)
+	if s.sender.network != "udp" {
+		// Octet counting framing for stream transports (RFC 6587)
+		msg = strconv.Itoa(len(msg)) + " " + msg
+	}
+	select {
+	case s.sender.queue <- []byte(msg):
+	default:
+		atomic.AddInt64(&s.sender.dropped, 1)
+	}
+	return nil
+}
+
+func (s *syslogSender) run() {
+	for msg := range s.queue {
+		if dropped := atomic.SwapInt64(&s.dropped, 0); dropped > 0 {
+			logf("syslog buffer for %s full, dropped %d messages", s.address, dropped)
+		}
+		if err := s.send(msg); err != nil {
+			logf("access log write failed: %v", err)
+		}
+	}
+}
+
+// send writes the message, dialing the daemon again after a failure
+func (s *syslogSender) send(msg []byte) error {
+	if s.conn == nil {
+		conn, err := net.DialTimeout(s.network, s.address, 5*time.Second)
+		if err != nil {
+			return err
+		}
+		s.conn = conn
+	}
+	if _, err := s.conn.Write(msg); err != nil {
+		s.conn.Close()
+		s.conn = nil
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,59 @@
+package trafico
+
+import (
+	"fmt"
+
+	"github.com/alainrk/trafico/parser"
+)
+
+const (
+	fieldNamesField = "field"
+	fieldNamesAlias = "alias"
+	fieldNamesPair  = "pair"
+)
+
+// validFieldNames checks the fieldNames option
+func validFieldNames(mode string) error {
+	switch mode {
+	case "", fieldNamesField, fieldNamesAlias, fieldNamesPair:
+		return nil
+	}
+	return fmt.Errorf("unknown fieldNames %q, expected field, alias or pair", mode)
+}
+
+// rootFieldLabels returns the sorted, distinct labels of the root fields of
+// the given operation type, as configured by fieldNames: the alias, or an
+// alias=field pair for aliased fields. Reserved words and ignored fields are
+// left out, as for the field names themselves.
+func (g *GraphQLParser) rootFieldLabels(parsed *ParsedRequest, operationType string) []string {
+	var labels []string
+	for _, entry := range parsed.entries() {
+		doc := entry.Document
+		if doc == nil {
+			continue
+		}
+		for _, op := range doc.Operations {
+			if op.Type != operationType {
+				continue
+			}
+			for _, field := range doc.RootFields(op) {
+				if g.filter.excludes(field) {
+					continue
+				}
+				labels = append(labels, fieldLabel(field, g.fieldNames))
+			}
+		}
+	}
+	return sortedUnique(labels)
+}
+
+func fieldLabel(field *parser.Selection, mode string) string {
+	switch {
+	case mode == fieldNamesAlias:
+		return field.ResponseKey()
+	case mode == fieldNamesPair && field.Alias != "":
+		return field.Alias + "=" + field.Name
+	default:
+		return field.Name
+	}
+}
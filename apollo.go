@@ -0,0 +1,398 @@
+package trafico
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"math"
+	"math/rand"
+	"net/http"
+	"os"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/alainrk/trafico/parser"
+)
+
+// ApolloConfig reports operation statistics in the Apollo usage reporting
+// format, to Apollo Studio or a compatible endpoint, so that operations
+// served by any GraphQL server show up with their latency and error rate
+type ApolloConfig struct {
+	Enabled bool `json:"enabled,omitempty"`
+	// APIKey authenticates the reports; GraphRef is the graph@variant
+	// they are reported to
+	APIKey   string `json:"apiKey,omitempty"`
+	GraphRef string `json:"graphRef,omitempty"`
+	// Endpoint receives the reports, Apollo Studio by default
+	Endpoint string `json:"endpoint,omitempty"`
+	// SampleRate is the fraction of requests reported, 1 by default
+	SampleRate float64 `json:"sampleRate,omitempty"`
+	// Interval is the period between reports, 20s by default
+	Interval string `json:"interval,omitempty"`
+}
+
+const (
+	defaultApolloEndpoint = "https://usage-reporting.api.apollographql.com/api/ingress/traces"
+	defaultApolloInterval = 20 * time.Second
+
+	apolloAgentVersion = "trafico"
+	// Latencies are counted in 384 buckets growing by 10% from 1µs
+	apolloBucketCount = 384
+)
+
+var apolloBucketLog = math.Log(1.1)
+
+// apolloReporter aggregates operation statistics between reports; a nil
+// *apolloReporter does nothing
+type apolloReporter struct {
+	apiKey     string
+	graphRef   string
+	endpoint   string
+	sampleRate float64
+	hostname   string
+	client     *http.Client
+
+	mu    sync.Mutex
+	rnd   *rand.Rand
+	stats map[string]*apolloOperation
+}
+
+// apolloOperation holds the statistics of an operation signature
+type apolloOperation struct {
+	byClient   map[[2]string]*apolloLatency
+	rootFields map[string][]string
+}
+
+type apolloLatency struct {
+	buckets  map[int]int64
+	requests int64
+	errors   int64
+}
+
+var (
+	apolloReportersMu sync.Mutex
+	apolloReporters   = make(map[string]*apolloReporter)
+)
+
+// newApolloReporter returns the reporter of the graph and endpoint, shared by
+// the instances Traefik builds so that a single one sends the reports
+func newApolloReporter(config ApolloConfig) (*apolloReporter, error) {
+	if !config.Enabled {
+		return nil, nil
+	}
+	if config.APIKey == "" || config.GraphRef == "" {
+		return nil, fmt.Errorf("apollo: apiKey and graphRef are required")
+	}
+	if config.SampleRate < 0 || config.SampleRate > 1 {
+		return nil, fmt.Errorf("apollo: sampleRate must be between 0 and 1, got %v", config.SampleRate)
+	}
+	interval := defaultApolloInterval
+	if config.Interval != "" {
+		d, err := time.ParseDuration(config.Interval)
+		if err != nil || d <= 0 {
+			return nil, fmt.Errorf("apollo: invalid interval %q", config.Interval)
+		}
+		interval = d
+	}
+
+	a := &apolloReporter{
+		apiKey:     config.APIKey,
+		graphRef:   config.GraphRef,
+		endpoint:   config.Endpoint,
+		sampleRate: config.SampleRate,
+		client:     &http.Client{Timeout: 10 * time.Second},
+		rnd:        rand.New(rand.NewSource(time.Now().UnixNano())),
+		stats:      make(map[string]*apolloOperation),
+	}
+	if a.endpoint == "" {
+		a.endpoint = defaultApolloEndpoint
+	}
+	if a.sampleRate == 0 {
+		a.sampleRate = 1
+	}
+	a.hostname, _ = os.Hostname()
+
+	apolloReportersMu.Lock()
+	defer apolloReportersMu.Unlock()
+	key := a.graphRef + "\n" + a.endpoint
+	if existing, ok := apolloReporters[key]; ok {
+		return existing, nil
+	}
+	apolloReporters[key] = a
+	go a.run(interval)
+	return a, nil
+}
+
+// observe records the executed operations of a forwarded request
+func (a *apolloReporter) observe(parsed *ParsedRequest, latency time.Duration, failed bool) {
+	if a == nil {
+		return
+	}
+	bucket := apolloBucket(latency)
+	client := [2]string{parsed.ClientName, parsed.ClientVersion}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.sampleRate < 1 && a.rnd.Float64() >= a.sampleRate {
+		return
+	}
+	for _, entry := range parsed.entries() {
+		for _, op := range executedOperations(entry.Document, entry.Request.OperationName) {
+			signature := apolloSignature(entry.Request.Query, op.Name)
+			stats := a.stats[signature]
+			if stats == nil {
+				stats = &apolloOperation{byClient: make(map[[2]string]*apolloLatency), rootFields: make(map[string][]string)}
+				typeName := rootTypeName(op.Type)
+				for _, field := range entry.Document.RootFields(op) {
+					stats.rootFields[typeName] = append(stats.rootFields[typeName], field.Name)
+				}
+				stats.rootFields[typeName] = sortedUnique(stats.rootFields[typeName])
+				a.stats[signature] = stats
+			}
+			latencies := stats.byClient[client]
+			if latencies == nil {
+				latencies = &apolloLatency{buckets: make(map[int]int64)}
+				stats.byClient[client] = latencies
+			}
+			latencies.buckets[bucket]++
+			latencies.requests++
+			if failed {
+				latencies.errors++
+			}
+		}
+	}
+}
+
+// apolloSignature is the key of an operation in the reports: its name and
+// its normalized document
+func apolloSignature(query, operationName string) string {
+	normalized, err := parser.Normalize(query)
+	if err != nil {
+		normalized = strings.TrimSpace(query)
+	}
+	return "# " + operationName + "\n" + normalized
+}
+
+func rootTypeName(opType string) string {
+	switch opType {
+	case "mutation":
+		return "Mutation"
+	case "subscription":
+		return "Subscription"
+	}
+	return "Query"
+}
+
+// apolloBucket returns the latency bucket of the duration
+func apolloBucket(d time.Duration) int {
+	bucket := math.Ceil(math.Log(float64(d.Nanoseconds())/1000) / apolloBucketLog)
+	if math.IsNaN(bucket) || bucket <= 0 {
+		return 0
+	}
+	if bucket >= apolloBucketCount {
+		return apolloBucketCount - 1
+	}
+	return int(bucket)
+}
+
+func (a *apolloReporter) run(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for now := range ticker.C {
+		a.mu.Lock()
+		stats := a.stats
+		a.stats = make(map[string]*apolloOperation)
+		a.mu.Unlock()
+
+		if len(stats) == 0 {
+			continue
+		}
+		if err := a.send(a.encode(stats, now)); err != nil {
+			logf("sending the usage report to %s failed: %v", a.endpoint, err)
+		}
+	}
+}
+
+// send posts the gzipped report
+func (a *apolloReporter) send(report []byte) error {
+	var body bytes.Buffer
+	zw := gzip.NewWriter(&body)
+	if _, err := zw.Write(report); err != nil {
+		return err
+	}
+	if err := zw.Close(); err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest(http.MethodPost, a.endpoint, &body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/protobuf")
+	req.Header.Set("Content-Encoding", "gzip")
+	req.Header.Set("Accept", "application/json")
+	req.Header.Set("X-Api-Key", a.apiKey)
+	req.Header.Set("User-Agent", apolloAgentVersion)
+	resp, err := a.client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= http.StatusMultipleChoices {
+		return fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// encode builds the Report protobuf message of the usage reporting protocol
+func (a *apolloReporter) encode(stats map[string]*apolloOperation, end time.Time) []byte {
+	var header protoBuffer
+	header.string(5, a.hostname)
+	header.string(6, apolloAgentVersion)
+	header.string(8, runtime.Version())
+	header.string(9, runtime.GOOS+" "+runtime.GOARCH)
+	header.string(12, a.graphRef)
+
+	var report protoBuffer
+	report.message(1, header.bytes())
+	var timestamp protoBuffer
+	timestamp.uint(1, uint64(end.Unix()))
+	timestamp.uint(2, uint64(end.Nanosecond()))
+	report.message(2, timestamp.bytes())
+
+	signatures := make([]string, 0, len(stats))
+	for signature := range stats {
+		signatures = append(signatures, signature)
+	}
+	sort.Strings(signatures)
+
+	var operations uint64
+	for _, signature := range signatures {
+		op := stats[signature]
+		var tracesAndStats protoBuffer
+		for client, latencies := range op.byClient {
+			operations += uint64(latencies.requests)
+
+			var context protoBuffer
+			context.string(2, client[0])
+			context.string(3, client[1])
+
+			var latencyStats protoBuffer
+			latencyStats.uint(2, uint64(latencies.requests))
+			latencyStats.uint(8, uint64(latencies.errors))
+			latencyStats.packedSint(13, apolloHistogram(latencies.buckets))
+
+			var contextualized protoBuffer
+			contextualized.message(1, context.bytes())
+			contextualized.message(2, latencyStats.bytes())
+			tracesAndStats.message(2, contextualized.bytes())
+		}
+		for typeName, fields := range op.rootFields {
+			var referenced protoBuffer
+			for _, field := range fields {
+				referenced.string(1, field)
+			}
+			var entry protoBuffer
+			entry.string(1, typeName)
+			entry.message(2, referenced.bytes())
+			tracesAndStats.message(4, entry.bytes())
+		}
+
+		var entry protoBuffer
+		entry.string(1, signature)
+		entry.message(2, tracesAndStats.bytes())
+		report.message(5, entry.bytes())
+	}
+	report.uint(6, operations)
+	report.uint(7, 1)
+	return report.bytes()
+}
+
+// apolloHistogram encodes the buckets as the protocol expects: a count per
+// bucket, runs of empty buckets written as their negated length and
+// trailing empty buckets dropped
+func apolloHistogram(buckets map[int]int64) []int64 {
+	last := -1
+	for bucket := range buckets {
+		if bucket > last {
+			last = bucket
+		}
+	}
+	var counts []int64
+	zeros := int64(0)
+	for bucket := 0; bucket <= last; bucket++ {
+		count := buckets[bucket]
+		if count == 0 {
+			zeros++
+			continue
+		}
+		if zeros == 1 {
+			counts = append(counts, 0)
+		} else if zeros > 1 {
+			counts = append(counts, -zeros)
+		}
+		zeros = 0
+		counts = append(counts, count)
+	}
+	return counts
+}
+
+// protoBuffer writes protobuf fields; empty values are omitted as in proto3
+type protoBuffer struct {
+	buf []byte
+}
+
+func (p *protoBuffer) bytes() []byte {
+	return p.buf
+}
+
+func (p *protoBuffer) varint(v uint64) {
+	for v >= 0x80 {
+		p.buf = append(p.buf, byte(v)|0x80)
+		v >>= 7
+	}
+	p.buf = append(p.buf, byte(v))
+}
+
+func (p *protoBuffer) tag(field, wireType int) {
+	p.varint(uint64(field)<<3 | uint64(wireType))
+}
+
+func (p *protoBuffer) uint(field int, v uint64) {
+	if v == 0 {
+		return
+	}
+	p.tag(field, 0)
+	p.varint(v)
+}
+
+func (p *protoBuffer) string(field int, s string) {
+	if s == "" {
+		return
+	}
+	p.tag(field, 2)
+	p.varint(uint64(len(s)))
+	p.buf = append(p.buf, s...)
+}
+
+// message writes an embedded message, even an empty one
+func (p *protoBuffer) message(field int, data []byte) {
+	p.tag(field, 2)
+	p.varint(uint64(len(data)))
+	p.buf = append(p.buf, data...)
+}
+
+// packedSint writes a packed repeated sint64 field (zigzag encoded)
+func (p *protoBuffer) packedSint(field int, values []int64) {
+	if len(values) == 0 {
+		return
+	}
+	var packed protoBuffer
+	for _, v := range values {
+		packed.varint(uint64(v<<1) ^ uint64(v>>63))
+	}
+	p.message(field, packed.bytes())
+}
@@ -0,0 +1,98 @@
+package trafico
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+
+	"github.com/alainrk/trafico/parser"
+)
+
+const defaultArgHeaderPrefix = "X-GraphQL-Arg-"
+
+// argumentExtractor forwards scalar arguments of configured root fields in
+// headers; a nil *argumentExtractor does nothing
+type argumentExtractor struct {
+	// headers maps field and argument names to the header carrying the value
+	headers map[string]map[string]string
+	// order lists the headers sorted, so they are cleared deterministically
+	order []string
+	lists listHeaders
+}
+
+func newArgumentExtractor(fields map[string][]string, prefix string, lists listHeaders) (*argumentExtractor, error) {
+	if len(fields) == 0 {
+		return nil, nil
+	}
+	if prefix == "" {
+		prefix = defaultArgHeaderPrefix
+	}
+
+	e := &argumentExtractor{headers: make(map[string]map[string]string, len(fields)), lists: lists}
+	for field, args := range fields {
+		if field == "" || len(args) == 0 {
+			return nil, fmt.Errorf("extractArgs: field %q needs at least one argument", field)
+		}
+		e.headers[field] = make(map[string]string, len(args))
+		for _, arg := range args {
+			if arg == "" {
+				return nil, fmt.Errorf("extractArgs: empty argument name for field %q", field)
+			}
+			name := http.CanonicalHeaderKey(prefix + field + "-" + arg)
+			e.headers[field][arg] = name
+			e.order = append(e.order, name)
+		}
+	}
+	sort.Strings(e.order)
+	return e, nil
+}
+
+// setHeaders writes the distinct scalar values of each configured argument,
+// literal or resolved from variables, across every selection of the root field
+func (e *argumentExtractor) setHeaders(header http.Header, parsed *ParsedRequest) {
+	if e == nil {
+		return
+	}
+	for _, name := range e.order {
+		header.Del(name)
+	}
+
+	values := make(map[string][]string)
+	for _, entry := range parsed.entries() {
+		e.collect(values, entry.Document, entry.Request.OperationName, entry.Request.Variables)
+	}
+
+	for _, name := range e.order {
+		if len(values[name]) > 0 {
+			e.lists.set(header, name, values[name])
+		}
+	}
+}
+
+// collect adds the argument values of a document to values, by header name
+func (e *argumentExtractor) collect(values map[string][]string, doc *parser.Document, operationName string, variables map[string]any) {
+	for _, op := range executedOperations(doc, operationName) {
+		for _, selection := range doc.RootFields(op) {
+			args, ok := e.headers[selection.Name]
+			if !ok {
+				continue
+			}
+			for _, arg := range selection.Arguments {
+				name, ok := args[arg.Name]
+				if !ok {
+					continue
+				}
+				value := arg.Value.Resolve(variables)
+				switch value.(type) {
+				case string, int64, float64, bool:
+				default:
+					// Lists, input objects and nulls are not forwarded
+					continue
+				}
+				if rendered := headerValue(value); !containsString(values[name], rendered) {
+					values[name] = append(values[name], rendered)
+				}
+			}
+		}
+	}
+}
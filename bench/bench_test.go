@@ -0,0 +1,134 @@
+package bench
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/alainrk/trafico"
+	"github.com/alainrk/trafico/parser"
+)
+
+// costLimits make the parser count the fields of every level and operation,
+// fragments expanded, as the cost checks do; they are high enough for every
+// document of the corpus to pass
+var costLimits = parser.Limits{MaxFieldsPerLevel: 1 << 20, MaxTotalFields: 1 << 20}
+
+func BenchmarkParse(b *testing.B) {
+	for _, doc := range Corpus() {
+		b.Run(doc.Name, func(b *testing.B) {
+			b.ReportAllocs()
+			b.SetBytes(int64(queriesSize(doc)))
+			for i := 0; i < b.N; i++ {
+				for _, query := range doc.Queries {
+					if _, err := parser.Parse(query); err != nil {
+						b.Fatal(err)
+					}
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkExtract(b *testing.B) {
+	for _, doc := range Corpus() {
+		b.Run(doc.Name, func(b *testing.B) {
+			parsed := parseAll(b, doc)
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				for _, d := range parsed {
+					parser.ExtractOperations(d)
+					for _, op := range d.Operations {
+						d.RootFields(op)
+					}
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkCost(b *testing.B) {
+	for _, doc := range Corpus() {
+		b.Run(doc.Name, func(b *testing.B) {
+			b.ReportAllocs()
+			b.SetBytes(int64(queriesSize(doc)))
+			for i := 0; i < b.N; i++ {
+				for _, query := range doc.Queries {
+					if _, err := parser.ParseWithLimits(query, costLimits); err != nil {
+						b.Fatal(err)
+					}
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkServeHTTP(b *testing.B) {
+	benchmarkServeHTTP(b, trafico.CreateConfig())
+}
+
+// BenchmarkServeHTTPPolicies adds the policies charging each request:
+// client identity, field quotas and rules
+func BenchmarkServeHTTPPolicies(b *testing.B) {
+	config := trafico.CreateConfig()
+	config.ClientIdentity = trafico.ClientIdentityConfig{Enabled: true, Sources: []trafico.IdentitySourceConfig{{Type: "ip"}}}
+	config.Quota = trafico.QuotaConfig{Enabled: true, Limit: 1 << 62, Source: "identity"}
+	config.Rules = []trafico.RuleConfig{{Name: "readOnly", Headers: map[string]string{"X-Read-Only": ""}, OperationTypes: []string{"mutation"}}}
+	benchmarkServeHTTP(b, config)
+}
+
+func benchmarkServeHTTP(b *testing.B, config *trafico.Config) {
+	for _, doc := range Corpus() {
+		b.Run(doc.Name, func(b *testing.B) {
+			handler := newHandler(b, config)
+			rw := httptest.NewRecorder()
+			b.ReportAllocs()
+			b.SetBytes(int64(len(doc.Body)))
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				handler.ServeHTTP(rw, newRequest(doc))
+			}
+		})
+	}
+}
+
+func newHandler(tb testing.TB, config *trafico.Config) http.Handler {
+	middleware, err := trafico.NewMiddleware(config, "bench")
+	if err != nil {
+		tb.Fatal(err)
+	}
+	return middleware(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		// Like a transport, consume and close the forwarded body
+		_, _ = io.Copy(io.Discard, req.Body)
+		req.Body.Close()
+	}))
+}
+
+func newRequest(doc Document) *http.Request {
+	req := httptest.NewRequest(http.MethodPost, "/graphql", bytes.NewReader(doc.Body))
+	req.Header.Set("Content-Type", "application/json")
+	return req
+}
+
+func parseAll(tb testing.TB, doc Document) []*parser.Document {
+	parsed := make([]*parser.Document, len(doc.Queries))
+	for i, query := range doc.Queries {
+		d, err := parser.Parse(query)
+		if err != nil {
+			tb.Fatal(err)
+		}
+		parsed[i] = d
+	}
+	return parsed
+}
+
+func queriesSize(doc Document) int {
+	size := 0
+	for _, query := range doc.Queries {
+		size += len(query)
+	}
+	return size
+}
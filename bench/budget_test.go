@@ -0,0 +1,66 @@
+package bench
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"github.com/alainrk/trafico"
+	"github.com/alainrk/trafico/parser"
+)
+
+// Allocation budgets guard against regressions: they leave about a third of
+// headroom over the allocations measured when they were set, and are to be
+// lowered when an optimization lands. ServeHTTP budgets include building the
+// request.
+var (
+	parseBudgets = map[string]float64{
+		"small":     14,
+		"dashboard": 50,
+		"batch":     2500,
+		"entities":  32,
+		"nested":    105,
+		"wide":      1350,
+	}
+	serveHTTPBudgets = map[string]float64{
+		"small":     48,
+		"dashboard": 105,
+		"batch":     3500,
+		"entities":  2150,
+		"nested":    140,
+		"wide":      1420,
+	}
+)
+
+func TestParseAllocations(t *testing.T) {
+	if testing.Short() {
+		t.Skip("allocation budgets are not checked in short mode")
+	}
+	for _, doc := range Corpus() {
+		allocs := testing.AllocsPerRun(20, func() {
+			for _, query := range doc.Queries {
+				if _, err := parser.Parse(query); err != nil {
+					t.Fatal(err)
+				}
+			}
+		})
+		if budget := parseBudgets[doc.Name]; allocs > budget {
+			t.Errorf("parsing %s allocates %.0f times, over its budget of %.0f", doc.Name, allocs, budget)
+		}
+	}
+}
+
+func TestServeHTTPAllocations(t *testing.T) {
+	if testing.Short() {
+		t.Skip("allocation budgets are not checked in short mode")
+	}
+	handler := newHandler(t, trafico.CreateConfig())
+	rw := httptest.NewRecorder()
+	for _, doc := range Corpus() {
+		allocs := testing.AllocsPerRun(20, func() {
+			handler.ServeHTTP(rw, newRequest(doc))
+		})
+		if budget := serveHTTPBudgets[doc.Name]; allocs > budget {
+			t.Errorf("serving %s allocates %.0f times, over its budget of %.0f", doc.Name, allocs, budget)
+		}
+	}
+}
@@ -0,0 +1,108 @@
+// Package bench holds the document corpora and the benchmarks of the parser
+// and the middleware, to evaluate performance-sensitive changes on realistic
+// and pathological requests alike
+package bench
+
+import (
+	"encoding/json"
+	"strconv"
+	"strings"
+)
+
+// Document is a request of the corpus
+type Document struct {
+	Name string
+	// Queries are the GraphQL documents of the request, one per batch entry
+	Queries []string
+	// Body is the JSON request body
+	Body []byte
+}
+
+const smallQuery = `query Viewer { viewer { id name email } }`
+
+const dashboardQuery = `query Dashboard($id: ID!, $first: Int = 10) {
+  viewer: user(id: $id) {
+    id
+    name
+    ...Avatar
+    orders(first: $first, filter: {since: "2024-01-01"}) {
+      edges { node { id total currency items { sku quantity price } } }
+      pageInfo { hasNextPage endCursor }
+    }
+  }
+  notifications(unread: true) { id message createdAt }
+}
+
+fragment Avatar on User {
+  avatar(size: 64) { url width height }
+}`
+
+const entitiesQuery = `query Entities($representations: [_Any!]!) {
+  _entities(representations: $representations) {
+    ... on User { id name reviews { id body rating product { upc } } }
+    ... on Product { upc price inStock shippingEstimate }
+  }
+}`
+
+// Sizes of the generated documents
+const (
+	batchSize       = 50
+	entityCount     = 100
+	nestingDepth    = 64
+	aliasesPerLevel = 500
+)
+
+// Corpus returns the documents of the benchmarks: a small query, a typical
+// dashboard query, a large batch, a federation entities call and two
+// pathological documents, deeply nested and very wide
+func Corpus() []Document {
+	return []Document{
+		request("small", smallQuery, nil),
+		request("dashboard", dashboardQuery, map[string]any{"id": "42", "first": 20}),
+		batch("batch", dashboardQuery, batchSize),
+		request("entities", entitiesQuery, map[string]any{"representations": representations(entityCount)}),
+		request("nested", nestedQuery(nestingDepth), nil),
+		request("wide", wideQuery(aliasesPerLevel), nil),
+	}
+}
+
+func request(name, query string, variables map[string]any) Document {
+	body, _ := json.Marshal(map[string]any{"query": query, "variables": variables})
+	return Document{Name: name, Queries: []string{query}, Body: body}
+}
+
+func batch(name, query string, size int) Document {
+	entries := make([]map[string]any, size)
+	queries := make([]string, size)
+	for i := range entries {
+		entries[i] = map[string]any{"query": query, "variables": map[string]any{"id": strconv.Itoa(i)}}
+		queries[i] = query
+	}
+	body, _ := json.Marshal(entries)
+	return Document{Name: name, Queries: queries, Body: body}
+}
+
+// representations returns the entity references a gateway sends a subgraph
+func representations(count int) []any {
+	refs := make([]any, count)
+	for i := range refs {
+		refs[i] = map[string]any{"__typename": "User", "id": strconv.Itoa(i)}
+	}
+	return refs
+}
+
+// nestedQuery selects a field nested depth times
+func nestedQuery(depth int) string {
+	return "query Nested { " + strings.Repeat("node { id ", depth) + strings.Repeat("} ", depth) + "}"
+}
+
+// wideQuery selects the same field under count aliases
+func wideQuery(count int) string {
+	var b strings.Builder
+	b.WriteString("query Wide { ")
+	for i := 0; i < count; i++ {
+		b.WriteString("a" + strconv.Itoa(i) + ": user(id: " + strconv.Itoa(i) + ") { id name } ")
+	}
+	b.WriteString("}")
+	return b.String()
+}
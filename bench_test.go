@@ -0,0 +1,88 @@
+package trafico
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+const benchmarkQuery = `query Dashboard($id: ID!, $first: Int = 10) {
+  viewer: user(id: $id) {
+    id
+    name
+    orders(first: $first, filter: {since: "2024-01-01"}) {
+      edges { node { id total items { sku quantity } } }
+    }
+  }
+  notifications(unread: true) { id message }
+}`
+
+func benchmarkBody(b *testing.B) string {
+	body, err := json.Marshal(GraphQLRequest{
+		Query:         benchmarkQuery,
+		OperationName: "Dashboard",
+		Variables:     map[string]any{"id": "42", "first": 20},
+	})
+	if err != nil {
+		b.Fatal(err)
+	}
+	return string(body)
+}
+
+func benchmarkServeHTTP(b *testing.B, config *Config) {
+	middleware, err := NewMiddleware(config, "bench")
+	if err != nil {
+		b.Fatal(err)
+	}
+	handler := middleware(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		// Like a transport, consume and close the forwarded body
+		_, _ = io.Copy(io.Discard, req.Body)
+		req.Body.Close()
+	}))
+	body := benchmarkBody(b)
+	rw := httptest.NewRecorder()
+
+	b.ReportAllocs()
+	b.SetBytes(int64(len(body)))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		req := httptest.NewRequest(http.MethodPost, "/graphql", strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		handler.ServeHTTP(rw, req)
+	}
+}
+
+func BenchmarkServeHTTP(b *testing.B) {
+	benchmarkServeHTTP(b, CreateConfig())
+}
+
+func BenchmarkServeHTTPExtractors(b *testing.B) {
+	config := CreateConfig()
+	config.TenantSource = TenantSourceConfig{Argument: "user.id"}
+	config.ExtractArgs = map[string][]string{"user": {"id"}}
+	config.Routing = RoutingConfig{Fields: map[string]string{"user": "users"}, DefaultService: "default"}
+	config.FieldPaths = FieldPathsConfig{Enabled: true}
+	benchmarkServeHTTP(b, config)
+}
+
+func BenchmarkOperationFingerprint(b *testing.B) {
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		operationFingerprint(benchmarkQuery)
+	}
+}
+
+// BenchmarkRequestOverhead measures the baseline cost of building requests
+// in the benchmarks above, to subtract from their results
+func BenchmarkRequestOverhead(b *testing.B) {
+	body := benchmarkBody(b)
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		req := httptest.NewRequest(http.MethodPost, "/graphql", strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		_, _ = io.Copy(io.Discard, req.Body)
+	}
+}
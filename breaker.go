@@ -0,0 +1,278 @@
+package trafico
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// CircuitBreakerConfig isolates failing root fields: once a field fails too
+// often, requests selecting it are answered with a 503 for a cooldown period,
+// after which a single request probes whether the field recovered
+type CircuitBreakerConfig struct {
+	Enabled bool `json:"enabled,omitempty"`
+	// Window is the period over which failures are counted, 10s by default
+	Window string `json:"window,omitempty"`
+	// MinRequests is the number of requests in a window before a circuit can
+	// open, 20 by default; FailureRatio the share of failures opening it, 0.5
+	MinRequests  int     `json:"minRequests,omitempty"`
+	FailureRatio float64 `json:"failureRatio,omitempty"`
+	// Cooldown is how long an open circuit fails fast, 30s by default
+	Cooldown string `json:"cooldown,omitempty"`
+	// InspectResponses also counts the GraphQL errors of JSON responses as
+	// failures of the root field in their path, besides 5xx statuses
+	InspectResponses bool `json:"inspectResponses,omitempty"`
+	// MaxCircuits bounds the root fields tracked, 1000 by default
+	MaxCircuits int `json:"maxCircuits,omitempty"`
+}
+
+const (
+	defaultBreakerWindow       = 10 * time.Second
+	defaultBreakerCooldown     = 30 * time.Second
+	defaultBreakerMinRequests  = 20
+	defaultBreakerFailureRatio = 0.5
+	defaultBreakerMaxCircuits  = 1000
+)
+
+// circuitBreaker tracks a circuit per root field; a nil *circuitBreaker does nothing
+type circuitBreaker struct {
+	window       time.Duration
+	cooldown     time.Duration
+	minRequests  int
+	failureRatio float64
+	inspect      bool
+	maxCircuits  int
+
+	mu       sync.Mutex
+	circuits map[string]*circuit
+}
+
+type circuit struct {
+	open     bool
+	openedAt time.Time
+	// probe is set while the request probing an open circuit is in flight
+	probe *breakerProbe
+
+	windowStart time.Time
+	requests    int
+	failures    int
+}
+
+func newCircuitBreaker(config CircuitBreakerConfig) (*circuitBreaker, error) {
+	if !config.Enabled {
+		return nil, nil
+	}
+	if config.MinRequests < 0 || config.MaxCircuits < 0 || config.FailureRatio < 0 || config.FailureRatio > 1 {
+		return nil, fmt.Errorf("circuitBreaker: minRequests and maxCircuits must not be negative and failureRatio must be between 0 and 1")
+	}
+
+	b := &circuitBreaker{
+		window:       defaultBreakerWindow,
+		cooldown:     defaultBreakerCooldown,
+		minRequests:  config.MinRequests,
+		failureRatio: config.FailureRatio,
+		inspect:      config.InspectResponses,
+		maxCircuits:  config.MaxCircuits,
+		circuits:     make(map[string]*circuit),
+	}
+	for setting, value := range map[string]string{"window": config.Window, "cooldown": config.Cooldown} {
+		if value == "" {
+			continue
+		}
+		d, err := time.ParseDuration(value)
+		if err != nil || d <= 0 {
+			return nil, fmt.Errorf("circuitBreaker: invalid %s %q", setting, value)
+		}
+		if setting == "window" {
+			b.window = d
+		} else {
+			b.cooldown = d
+		}
+	}
+	if b.minRequests == 0 {
+		b.minRequests = defaultBreakerMinRequests
+	}
+	if b.failureRatio == 0 {
+		b.failureRatio = defaultBreakerFailureRatio
+	}
+	if b.maxCircuits == 0 {
+		b.maxCircuits = defaultBreakerMaxCircuits
+	}
+	return b, nil
+}
+
+// breakerFields returns the root fields of the executed operations, by
+// response key so that errors can be attributed through aliases
+func breakerFields(parsed *ParsedRequest) map[string]string {
+	fields := make(map[string]string)
+	for _, entry := range parsed.entries() {
+		for _, op := range executedOperations(entry.Document, entry.Request.OperationName) {
+			for _, field := range entry.Document.RootFields(op) {
+				fields[field.ResponseKey()] = field.Name
+			}
+		}
+	}
+	return fields
+}
+
+// distinctFields returns the sorted root field names, once each
+func distinctFields(fields map[string]string) []string {
+	names := make([]string, 0, len(fields))
+	for _, name := range fields {
+		names = append(names, name)
+	}
+	return sortedUnique(names)
+}
+
+// breakerProbe is a request probing open circuits
+type breakerProbe struct {
+	circuits []*circuit
+}
+
+// allow returns the first field whose circuit is open, or "" when the request
+// may go through; a request reaching a cooled down circuit becomes its probe,
+// to be released once the request is done whether it was forwarded or not
+func (b *circuitBreaker) allow(fields map[string]string) (string, *breakerProbe) {
+	if b == nil {
+		return "", nil
+	}
+	now := time.Now()
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	probe := &breakerProbe{}
+	for _, name := range distinctFields(fields) {
+		c := b.circuits[name]
+		if c == nil || !c.open {
+			continue
+		}
+		if c.probe != nil || now.Sub(c.openedAt) < b.cooldown {
+			b.releaseLocked(probe)
+			return name, nil
+		}
+		c.probe = probe
+		probe.circuits = append(probe.circuits, c)
+	}
+	if len(probe.circuits) == 0 {
+		return "", nil
+	}
+	return "", probe
+}
+
+// release frees the circuits the probe still holds, when the request ended
+// before its outcome was recorded, so that the next request probes them
+func (b *circuitBreaker) release(probe *breakerProbe) {
+	if b == nil || probe == nil {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.releaseLocked(probe)
+}
+
+func (b *circuitBreaker) releaseLocked(probe *breakerProbe) {
+	for _, c := range probe.circuits {
+		if c.probe == probe {
+			c.probe = nil
+		}
+	}
+}
+
+// record counts the outcome of a forwarded request for each of its root fields
+func (b *circuitBreaker) record(fields map[string]string, recorder *statusRecorder) {
+	if b == nil || len(fields) == 0 {
+		return
+	}
+
+	// Server errors fail every field, GraphQL errors the field of their path
+	failed := make(map[string]bool)
+	if recorder.Status() >= http.StatusInternalServerError {
+		for _, name := range fields {
+			failed[name] = true
+		}
+	} else if body, ok := recorder.capturedJSON(); ok && b.inspect {
+		for _, key := range graphqlErrorFields(body) {
+			if key == "" {
+				for _, name := range fields {
+					failed[name] = true
+				}
+				break
+			}
+			if name, ok := fields[key]; ok {
+				failed[name] = true
+			}
+		}
+	}
+
+	now := time.Now()
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for _, name := range distinctFields(fields) {
+		c := b.circuits[name]
+		if c == nil {
+			if len(b.circuits) >= b.maxCircuits {
+				continue
+			}
+			c = &circuit{windowStart: now}
+			b.circuits[name] = c
+		}
+		wasOpen := c.open
+		c.update(b, now, failed[name])
+		switch {
+		case c.open && !wasOpen:
+			logf("circuit of root field %s opened after %d failures in %d requests", name, c.failures, c.requests)
+		case wasOpen && !c.open:
+			logf("circuit of root field %s closed after a successful probe", name)
+		}
+	}
+}
+
+func (c *circuit) update(b *circuitBreaker, now time.Time, failed bool) {
+	if c.open {
+		if c.probe == nil {
+			return
+		}
+		c.probe = nil
+		if failed {
+			c.openedAt = now
+			return
+		}
+		*c = circuit{windowStart: now}
+		return
+	}
+
+	if now.Sub(c.windowStart) >= b.window {
+		c.windowStart, c.requests, c.failures = now, 0, 0
+	}
+	c.requests++
+	if failed {
+		c.failures++
+	}
+	if c.requests >= b.minRequests && float64(c.failures) >= b.failureRatio*float64(c.requests) {
+		c.open, c.openedAt = true, now
+	}
+}
+
+// graphqlErrorFields returns the first path segment (a root field response
+// key) of each error of a JSON response, "" for errors without a path
+func graphqlErrorFields(body []byte) []string {
+	var resp struct {
+		Errors []struct {
+			Path []any `json:"path"`
+		} `json:"errors"`
+	}
+	if err := json.Unmarshal(body, &resp); err != nil {
+		return nil
+	}
+	keys := make([]string, 0, len(resp.Errors))
+	for _, e := range resp.Errors {
+		key := ""
+		if len(e.Path) > 0 {
+			key, _ = e.Path[0].(string)
+		}
+		keys = append(keys, key)
+	}
+	return keys
+}
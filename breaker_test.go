@@ -0,0 +1,65 @@
+package trafico
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestCircuitBreaker(t *testing.T) {
+	config := CreateConfig()
+	config.CircuitBreaker = CircuitBreakerConfig{Enabled: true, MinRequests: 2, Cooldown: "50ms", InspectResponses: true}
+	failing := map[string]bool{"a": true, "c": true}
+	var probing func()
+	handler := newTestHandler(t, config, func(rw http.ResponseWriter, req *http.Request) {
+		if probing != nil {
+			probing()
+		}
+		switch {
+		case failing["a"] && req.Header.Get("X-GraphQL-Queries") == "a":
+			rw.WriteHeader(http.StatusBadGateway)
+		case failing["c"] && req.Header.Get("X-GraphQL-Queries") == "c":
+			rw.Header().Set("Content-Type", "application/json")
+			_, _ = rw.Write([]byte(`{"errors":[{"message":"boom","path":["c"]}]}`))
+		}
+	})
+
+	steps := []struct {
+		name   string
+		query  string
+		status int
+	}{
+		{"first failure", "{ a }", http.StatusBadGateway},
+		{"second failure", "{ a }", http.StatusBadGateway},
+		{"open", "{ a }", http.StatusServiceUnavailable},
+		{"aliased", "{ x: a }", http.StatusServiceUnavailable},
+		{"other field", "{ b }", http.StatusOK},
+		{"GraphQL error", "{ c }", http.StatusOK},
+		{"second GraphQL error", "{ c }", http.StatusOK},
+		{"open on GraphQL errors", "{ c }", http.StatusServiceUnavailable},
+	}
+	for _, step := range steps {
+		if rw := postGraphQL(handler, `{"query":"`+step.query+`"}`, nil); rw.Code != step.status {
+			t.Fatalf("%s: status %d, want %d", step.name, rw.Code, step.status)
+		}
+	}
+
+	// Once cooled down, a single request probes the field while the others
+	// keep failing fast
+	time.Sleep(100 * time.Millisecond)
+	delete(failing, "a")
+	var concurrent int
+	probing = func() {
+		probing = nil
+		concurrent = postGraphQL(handler, `{"query":"{ a }"}`, nil).Code
+	}
+	if rw := postGraphQL(handler, `{"query":"{ a }"}`, nil); rw.Code != http.StatusOK {
+		t.Fatalf("probe: status %d, want 200", rw.Code)
+	}
+	if concurrent != http.StatusServiceUnavailable {
+		t.Errorf("request during the probe: status %d, want 503", concurrent)
+	}
+	if rw := postGraphQL(handler, `{"query":"{ a }"}`, nil); rw.Code != http.StatusOK {
+		t.Errorf("closed: status %d, want 200", rw.Code)
+	}
+}
@@ -0,0 +1,84 @@
+package trafico
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"net/http"
+	"strconv"
+)
+
+// CanaryConfig exposes a stable bucket, 0 to 99, computed from the operation
+// or the client, so that weighted routing can send a deterministic slice of
+// the traffic to a canary backend
+type CanaryConfig struct {
+	Enabled bool `json:"enabled,omitempty"`
+	// Source is what the bucket is computed from: fingerprint (the document
+	// and operationName, the default) or client (the client name identified
+	// when clients is enabled)
+	Source string `json:"source,omitempty"`
+	// Salt reshuffles the buckets, e.g. to move to another slice of operations
+	Salt   string `json:"salt,omitempty"`
+	Header string `json:"header,omitempty"`
+}
+
+const (
+	defaultCanaryHeader = "X-GraphQL-Canary-Bucket"
+
+	canarySourceFingerprint = "fingerprint"
+	canarySourceClient      = "client"
+)
+
+// canaryBucketer computes the canary bucket; a nil *canaryBucketer does nothing
+type canaryBucketer struct {
+	source string
+	salt   string
+	header string
+}
+
+func newCanaryBucketer(config CanaryConfig) (*canaryBucketer, error) {
+	if !config.Enabled {
+		return nil, nil
+	}
+	c := &canaryBucketer{source: config.Source, salt: config.Salt, header: config.Header}
+	switch c.source {
+	case "":
+		c.source = canarySourceFingerprint
+	case canarySourceFingerprint, canarySourceClient:
+	default:
+		return nil, fmt.Errorf("canary: unknown source %q, expected fingerprint or client", config.Source)
+	}
+	if c.header == "" {
+		c.header = defaultCanaryHeader
+	}
+	return c, nil
+}
+
+// setHeader writes the bucket of the request; requests without a document,
+// or without an identified client for the client source, get none
+func (c *canaryBucketer) setHeader(header http.Header, parsed *ParsedRequest) {
+	if c == nil {
+		return
+	}
+	header.Del(c.header)
+
+	var key string
+	switch c.source {
+	case canarySourceClient:
+		key = parsed.ClientName
+	default:
+		if query := parsed.query(); query != "" {
+			key = operationFingerprint(query) + "\n" + parsed.OperationName
+		}
+	}
+	if key == "" {
+		return
+	}
+	header.Set(c.header, strconv.Itoa(canaryBucket(c.salt, key)))
+}
+
+// canaryBucket maps the key to a bucket between 0 and 99
+func canaryBucket(salt, key string) int {
+	sum := sha256.Sum256([]byte(salt + "\n" + key))
+	return int(binary.BigEndian.Uint64(sum[:8]) % 100)
+}
@@ -0,0 +1,140 @@
+package trafico
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// ClientsConfig configures Apollo client name/version extraction
+type ClientsConfig struct {
+	Enabled       bool   `json:"enabled,omitempty"`
+	NameHeader    string `json:"nameHeader,omitempty"`
+	VersionHeader string `json:"versionHeader,omitempty"`
+	// Allowlist rejects requests from clients whose name isn't listed;
+	// AllowlistFile adds the names of a file or HTTP(S) URL, one per line
+	Allowlist     []string `json:"allowlist,omitempty"`
+	AllowlistFile string   `json:"allowlistFile,omitempty"`
+}
+
+const (
+	apolloClientNameHeader    = "apollographql-client-name"
+	apolloClientVersionHeader = "apollographql-client-version"
+
+	defaultClientNameHeader    = "X-GraphQL-Client-Name"
+	defaultClientVersionHeader = "X-GraphQL-Client-Version"
+
+	maxClientInfoLength = 128
+)
+
+// clientIdentifier normalizes client info; a nil *clientIdentifier does nothing
+type clientIdentifier struct {
+	nameHeader    string
+	versionHeader string
+	// allowlist holds a map[string]bool, or nil when every client is allowed
+	allowlist *policySource
+	// failClosed rejects every client while a remote allowlist is unavailable
+	failClosed bool
+}
+
+func newClientIdentifier(config ClientsConfig, reloadInterval time.Duration, startupFailure string, failClosed bool) (*clientIdentifier, error) {
+	if !config.Enabled {
+		return nil, nil
+	}
+	c := &clientIdentifier{
+		nameHeader:    config.NameHeader,
+		versionHeader: config.VersionHeader,
+		failClosed:    failClosed,
+	}
+	if c.nameHeader == "" {
+		c.nameHeader = defaultClientNameHeader
+	}
+	if c.versionHeader == "" {
+		c.versionHeader = defaultClientVersionHeader
+	}
+
+	compile := func(data []byte) (any, error) {
+		allowlist := make(map[string]bool, len(config.Allowlist))
+		for _, name := range config.Allowlist {
+			allowlist[name] = true
+		}
+		for _, line := range strings.Split(string(data), "\n") {
+			if name := strings.TrimSpace(line); name != "" && !strings.HasPrefix(name, "#") {
+				allowlist[name] = true
+			}
+		}
+		return allowlist, nil
+	}
+	switch {
+	case config.AllowlistFile != "":
+		allowlist, err := newPolicySource(config.AllowlistFile, reloadInterval, startupFailure, compile)
+		if err != nil {
+			return nil, fmt.Errorf("clients: allowlistFile: %w", err)
+		}
+		c.allowlist = allowlist
+	case len(config.Allowlist) > 0:
+		allowlist, _ := compile(nil)
+		c.allowlist = newStaticPolicy(allowlist)
+	}
+	return c, nil
+}
+
+// identify reads the client name and version from the Apollo headers, falling
+// back to extensions.clientInfo (of the first batch entry carrying one), and
+// writes them to the normalized headers
+func (c *clientIdentifier) identify(header http.Header, parsed *ParsedRequest) (string, string) {
+	if c == nil {
+		return "", ""
+	}
+
+	name := header.Get(apolloClientNameHeader)
+	version := header.Get(apolloClientVersionHeader)
+	for _, entry := range parsed.entries() {
+		clientInfo, ok := entry.Request.Extensions["clientInfo"].(map[string]any)
+		if !ok {
+			continue
+		}
+		if name == "" {
+			name, _ = clientInfo["clientName"].(string)
+		}
+		if version == "" {
+			version, _ = clientInfo["clientVersion"].(string)
+		}
+		break
+	}
+	name = normalizeClientInfo(name)
+	version = normalizeClientInfo(version)
+
+	header.Del(c.nameHeader)
+	header.Del(c.versionHeader)
+	if name != "" {
+		header.Set(c.nameHeader, name)
+	}
+	if version != "" {
+		header.Set(c.versionHeader, version)
+	}
+	return name, version
+}
+
+// allowed reports whether the client passes the allowlist, if one is configured
+func (c *clientIdentifier) allowed(name string) bool {
+	if c == nil || c.allowlist == nil {
+		return true
+	}
+	// A remote allowlist that could not be loaded yet allows every client,
+	// unless policy failures fail closed
+	allowlist, ok := c.allowlist.current().(map[string]bool)
+	if !ok {
+		return !c.failClosed
+	}
+	return allowlist[name]
+}
+
+func normalizeClientInfo(value string) string {
+	value = strings.TrimSpace(headerValue(value))
+	if len(value) > maxClientInfoLength {
+		value = value[:maxClientInfoLength]
+	}
+	return value
+}
@@ -0,0 +1,175 @@
+// Command trafico runs a GraphQL request through the middleware offline and
+// prints what it extracted and decided, e.g. to check documents against the
+// configured allowlists and limits in CI.
+//
+//	trafico -config trafico.json -f query.graphql
+//	trafico -http < captured-request.txt
+//
+// The exit status is 0 when the request would be forwarded, 2 when it would
+// be rejected and 1 on errors.
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+
+	"github.com/alainrk/trafico"
+)
+
+const debugSecret = "trafico-cli"
+
+// headerFlags collects repeated -H "Name: value" flags
+type headerFlags []string
+
+func (h *headerFlags) String() string     { return strings.Join(*h, ", ") }
+func (h *headerFlags) Set(v string) error { *h = append(*h, v); return nil }
+
+func main() {
+	os.Exit(run(os.Args[1:], os.Stdin, os.Stdout, os.Stderr))
+}
+
+func run(args []string, stdin io.Reader, stdout, stderr io.Writer) int {
+	flags := flag.NewFlagSet("trafico", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	configFile := flags.String("config", "", "JSON plugin configuration, defaults to the built-in defaults")
+	input := flags.String("f", "-", "GraphQL document, or HTTP request with -http; - reads stdin")
+	capture := flags.Bool("http", false, "read an HTTP request capture instead of a GraphQL document")
+	operationName := flags.String("operation-name", "", "operationName of the request")
+	variables := flags.String("variables", "", "JSON object of variables")
+	host := flags.String("host", "", "Host of the request, to select a profile")
+	var headers headerFlags
+	flags.Var(&headers, "H", `request header "Name: value", repeatable`)
+	if err := flags.Parse(args); err != nil {
+		return 1
+	}
+
+	config, err := loadConfig(*configFile)
+	if err != nil {
+		fmt.Fprintf(stderr, "trafico: %v\n", err)
+		return 1
+	}
+
+	data, err := readInput(*input, stdin)
+	if err != nil {
+		fmt.Fprintf(stderr, "trafico: %v\n", err)
+		return 1
+	}
+
+	var req *http.Request
+	if *capture {
+		req, err = http.ReadRequest(bufio.NewReader(bytes.NewReader(data)))
+		if err != nil {
+			fmt.Fprintf(stderr, "trafico: reading HTTP request: %v\n", err)
+			return 1
+		}
+		req.RequestURI = ""
+	} else {
+		body, err := requestBody(string(data), *operationName, *variables)
+		if err != nil {
+			fmt.Fprintf(stderr, "trafico: %v\n", err)
+			return 1
+		}
+		req = httptest.NewRequest(http.MethodPost, "/graphql", bytes.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if *host != "" {
+		req.Host = *host
+	}
+	for _, header := range headers {
+		name, value, ok := strings.Cut(header, ":")
+		if !ok {
+			fmt.Fprintf(stderr, "trafico: invalid header %q, expected \"Name: value\"\n", header)
+			return 1
+		}
+		req.Header.Add(strings.TrimSpace(name), strings.TrimSpace(value))
+	}
+
+	// The debug echo answers with the report instead of forwarding, and
+	// nothing is exported from the command
+	config.Debug = trafico.DebugConfig{Enabled: true, Secret: debugSecret}
+	config.Metrics = trafico.MetricsConfig{}
+	config.Tracing = trafico.TracingConfig{}
+	config.AccessLog = trafico.AccessLogConfig{}
+	config.Events = trafico.EventsConfig{}
+	middleware, err := trafico.NewMiddleware(config, "cli")
+	if err != nil {
+		fmt.Fprintf(stderr, "trafico: invalid configuration: %v\n", err)
+		return 1
+	}
+	handler := middleware(http.HandlerFunc(func(rw http.ResponseWriter, _ *http.Request) {
+		rw.WriteHeader(http.StatusNoContent)
+	}))
+	req.Header.Set("X-Trafico-Debug", debugSecret)
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	if rec.Code == http.StatusNoContent {
+		fmt.Fprintln(stderr, "trafico: the request is not a GraphQL request (method or Content-Type) and would be forwarded as is")
+		return 0
+	}
+	_, _ = stdout.Write(rec.Body.Bytes())
+
+	var report struct {
+		Decision string `json:"decision"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &report); err != nil {
+		// Rejected before the report could be produced
+		return 2
+	}
+	if report.Decision == "blocked" {
+		return 2
+	}
+	return 0
+}
+
+func loadConfig(path string) (*trafico.Config, error) {
+	config := trafico.CreateConfig()
+	if path == "" {
+		return config, nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, config); err != nil {
+		return nil, fmt.Errorf("config %s: %w", path, err)
+	}
+	return config, nil
+}
+
+func readInput(path string, stdin io.Reader) ([]byte, error) {
+	if path == "-" {
+		return io.ReadAll(stdin)
+	}
+	return os.ReadFile(path)
+}
+
+// requestBody wraps the document in a JSON request; documents that already
+// are JSON requests are sent as is
+func requestBody(document, operationName, variables string) ([]byte, error) {
+	var decoded any
+	if json.Unmarshal([]byte(document), &decoded) == nil {
+		return []byte(document), nil
+	}
+
+	request := map[string]any{"query": document}
+	if operationName != "" {
+		request["operationName"] = operationName
+	}
+	if variables != "" {
+		var values map[string]any
+		if err := json.Unmarshal([]byte(variables), &values); err != nil {
+			return nil, fmt.Errorf("invalid -variables: %w", err)
+		}
+		request["variables"] = values
+	}
+	return json.Marshal(request)
+}
@@ -0,0 +1,145 @@
+package trafico
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"sync"
+)
+
+// CoalescingConfig collapses identical queries in flight at the same time
+// into a single backend request whose response is sent to all of them.
+// Requests are identical when they carry the same documents, operationName
+// and variables to the same host and path, with the same identity headers.
+type CoalescingConfig struct {
+	Enabled bool `json:"enabled,omitempty"`
+	// IdentityHeaders tell apart the callers, Authorization and Cookie by
+	// default; requests differing in any of them are never coalesced
+	IdentityHeaders []string `json:"identityHeaders,omitempty"`
+	// MaxResponseKB bounds the response shared, 256 by default; the requests
+	// waiting on a larger response are forwarded on their own
+	MaxResponseKB int `json:"maxResponseKB,omitempty"`
+}
+
+const defaultCoalescingMaxResponseKB = 256
+
+var defaultCoalescingIdentityHeaders = []string{"Authorization", "Cookie"}
+
+// coalescer tracks the queries in flight; a nil *coalescer does nothing
+type coalescer struct {
+	identityHeaders  []string
+	maxResponseBytes int
+
+	mu    sync.Mutex
+	calls map[string]*coalescedCall
+}
+
+// coalescedCall is a backend request in flight; its response is set before
+// done is closed when it can be shared
+type coalescedCall struct {
+	done   chan struct{}
+	shared bool
+	status int
+	header http.Header
+	body   []byte
+}
+
+func newCoalescer(config CoalescingConfig) (*coalescer, error) {
+	if !config.Enabled {
+		return nil, nil
+	}
+	if config.MaxResponseKB < 0 {
+		return nil, fmt.Errorf("coalescing: maxResponseKB must not be negative")
+	}
+	c := &coalescer{
+		identityHeaders:  config.IdentityHeaders,
+		maxResponseBytes: config.MaxResponseKB * 1024,
+		calls:            make(map[string]*coalescedCall),
+	}
+	if len(c.identityHeaders) == 0 {
+		c.identityHeaders = defaultCoalescingIdentityHeaders
+	}
+	if c.maxResponseBytes == 0 {
+		c.maxResponseBytes = defaultCoalescingMaxResponseKB * 1024
+	}
+	return c, nil
+}
+
+// key identifies the request among the ones in flight, "" when it must not
+// be coalesced: it executes something else than queries, or its body is
+// streamed and its variables may be unknown. The accepted encodings are part
+// of the key, so that a client is never sent a compression it cannot decode.
+func (c *coalescer) key(req *http.Request, parsed *ParsedRequest, body *pooledBody) string {
+	if c == nil || body.streamed() || !onlyQueries(parsed) {
+		return ""
+	}
+	h := sha256.New()
+	h.Write([]byte(req.Host + "\n" + req.URL.Path + "\n" + requestFingerprint(parsed)))
+	h.Write([]byte("\nAccept-Encoding: " + req.Header.Get("Accept-Encoding")))
+	for _, name := range c.identityHeaders {
+		for _, value := range req.Header.Values(name) {
+			h.Write([]byte("\n" + name + ": " + value))
+		}
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// serve forwards the first request of a key and answers the ones arriving
+// while it is in flight with its response, unless that response is an
+// error or too large to share, in which case they are forwarded as well
+func (c *coalescer) serve(key string, rw http.ResponseWriter, req *http.Request, metrics *metrics, forward func(http.ResponseWriter)) {
+	c.mu.Lock()
+	call, inFlight := c.calls[key]
+	if !inFlight {
+		call = &coalescedCall{done: make(chan struct{})}
+		c.calls[key] = call
+	}
+	c.mu.Unlock()
+
+	if inFlight {
+		select {
+		case <-call.done:
+		case <-req.Context().Done():
+			return
+		}
+		if !call.shared {
+			forward(rw)
+			return
+		}
+		metrics.cacheHit("coalescing")
+		for name, values := range call.header {
+			rw.Header()[name] = values
+		}
+		rw.WriteHeader(call.status)
+		_, _ = rw.Write(call.body)
+		return
+	}
+
+	// Waiting requests are released even if the backend panics
+	defer func() {
+		c.mu.Lock()
+		delete(c.calls, key)
+		c.mu.Unlock()
+		close(call.done)
+	}()
+
+	before := rw.Header().Clone()
+	recorder := newStatusRecorder(rw)
+	recorder.capture(c.maxResponseBytes)
+	forward(recorder)
+	if recorder.overflow || recorder.Status() >= http.StatusInternalServerError {
+		return
+	}
+
+	// Only the headers the backend set are shared, not the ones of this request
+	call.header = make(http.Header)
+	for name, values := range rw.Header() {
+		if !equalValues(values, before[name]) {
+			call.header[name] = append([]string(nil), values...)
+		}
+	}
+	call.status = recorder.Status()
+	call.body = append([]byte(nil), recorder.body.Bytes()...)
+	call.shared = true
+}
@@ -0,0 +1,93 @@
+package trafico
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestCoalescerKey(t *testing.T) {
+	c, err := newCoalescer(CoalescingConfig{Enabled: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	key := func(body string, header map[string]string) string {
+		req := httptest.NewRequest(http.MethodPost, "/graphql", strings.NewReader(body))
+		for name, value := range header {
+			req.Header.Set(name, value)
+		}
+		req.Header.Set("Content-Type", "application/json")
+		pooled, err := readBody(req, 1<<20)
+		if err != nil {
+			t.Fatal(err)
+		}
+		parsed, _ := (&GraphQLParser{}).parseRequest(req, pooled)
+		return c.key(req, parsed, pooled)
+	}
+
+	query := `{"query":"{ a }","variables":{"x":1}}`
+	base := key(query, nil)
+	tests := []struct {
+		name   string
+		body   string
+		header map[string]string
+		same   bool
+	}{
+		{"identical", query, nil, true},
+		{"other variables", `{"query":"{ a }","variables":{"x":2}}`, nil, false},
+		{"other document", `{"query":"{ b }","variables":{"x":1}}`, nil, false},
+		{"other caller", query, map[string]string{"Authorization": "Bearer x"}, false},
+		{"other encodings", query, map[string]string{"Accept-Encoding": "gzip"}, false},
+		{"other header", query, map[string]string{"X-Other": "1"}, true},
+	}
+	for _, tt := range tests {
+		if got := key(tt.body, tt.header); (got == base) != tt.same {
+			t.Errorf("%s: same key %v, want %v", tt.name, got == base, tt.same)
+		}
+	}
+	if got := key(`{"query":"mutation { a }"}`, nil); got != "" {
+		t.Errorf("mutation: key %q, want none", got)
+	}
+}
+
+func TestCoalescing(t *testing.T) {
+	config := CreateConfig()
+	config.Coalescing = CoalescingConfig{Enabled: true}
+	release := make(chan struct{})
+	var mu sync.Mutex
+	calls := 0
+	handler := newTestHandler(t, config, func(rw http.ResponseWriter, req *http.Request) {
+		mu.Lock()
+		calls++
+		mu.Unlock()
+		<-release
+		rw.Header().Set("X-Backend", "1")
+		_, _ = rw.Write([]byte(`{"data":{"a":1}}`))
+	})
+
+	var wg sync.WaitGroup
+	responses := make([]*httptest.ResponseRecorder, 3)
+	for i := range responses {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			responses[i] = postGraphQL(handler, `{"query":"{ a }"}`, nil)
+		}(i)
+	}
+	// Let the requests join the one in flight
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+	wg.Wait()
+
+	if calls != 1 {
+		t.Errorf("%d backend calls, want 1", calls)
+	}
+	for i, rw := range responses {
+		if rw.Code != http.StatusOK || rw.Body.String() != `{"data":{"a":1}}` || rw.Header().Get("X-Backend") != "1" {
+			t.Errorf("response %d: %d %v %q", i, rw.Code, rw.Header(), rw.Body.String())
+		}
+	}
+}
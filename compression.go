@@ -0,0 +1,46 @@
+package trafico
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"io"
+	"strings"
+)
+
+// maxDecodedBytes bounds the decompressed size of an inspected response, so
+// that a small compressed body cannot expand without limit
+const maxDecodedBytes = 8 << 20
+
+// decodeContent returns the body decompressed according to its
+// Content-Encoding, for inspection only: the bytes sent to the client are
+// never rewritten. Bodies in other encodings (such as br) are not inspected
+// and are passed through untouched.
+func decodeContent(encoding string, body []byte) ([]byte, bool) {
+	var reader io.ReadCloser
+	var err error
+	switch strings.ToLower(strings.TrimSpace(encoding)) {
+	case "", "identity":
+		return body, true
+	case "gzip", "x-gzip":
+		reader, err = gzip.NewReader(bytes.NewReader(body))
+	case "deflate":
+		// HTTP deflate is zlib-wrapped, though some servers send raw DEFLATE
+		if reader, err = zlib.NewReader(bytes.NewReader(body)); err != nil {
+			reader, err = flate.NewReader(bytes.NewReader(body)), nil
+		}
+	default:
+		return nil, false
+	}
+	if err != nil {
+		return nil, false
+	}
+	defer reader.Close()
+
+	decoded, err := io.ReadAll(io.LimitReader(reader, maxDecodedBytes+1))
+	if err != nil || len(decoded) > maxDecodedBytes {
+		return nil, false
+	}
+	return decoded, true
+}
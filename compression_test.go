@@ -0,0 +1,62 @@
+package trafico
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"io"
+	"strings"
+	"testing"
+)
+
+func compressed(t *testing.T, newWriter func(io.Writer) io.WriteCloser, data string) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	w := newWriter(&buf)
+	if _, err := io.WriteString(w, data); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestDecodeContent(t *testing.T) {
+	const body = `{"errors":[{"message":"boom","path":["a"]}]}`
+	gzipped := compressed(t, func(w io.Writer) io.WriteCloser { return gzip.NewWriter(w) }, body)
+	zlibbed := compressed(t, func(w io.Writer) io.WriteCloser { return zlib.NewWriter(w) }, body)
+	raw := compressed(t, func(w io.Writer) io.WriteCloser {
+		fw, _ := flate.NewWriter(w, flate.DefaultCompression)
+		return fw
+	}, body)
+	bomb := compressed(t, func(w io.Writer) io.WriteCloser { return zlib.NewWriter(w) }, strings.Repeat("a", maxDecodedBytes+1))
+
+	tests := []struct {
+		name     string
+		encoding string
+		body     []byte
+		ok       bool
+	}{
+		{"identity", "", []byte(body), true},
+		{"gzip", "gzip", gzipped, true},
+		{"x-gzip", "X-Gzip", gzipped, true},
+		{"zlib deflate", "deflate", zlibbed, true},
+		{"raw deflate", "deflate", raw, true},
+		{"brotli", "br", []byte(body), false},
+		{"invalid gzip", "gzip", []byte(body), false},
+		{"too large", "deflate", bomb, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			decoded, ok := decodeContent(tt.encoding, tt.body)
+			if ok != tt.ok {
+				t.Fatalf("ok = %v, want %v", ok, tt.ok)
+			}
+			if ok && string(decoded) != body {
+				t.Errorf("decoded %q, want %q", decoded, body)
+			}
+		})
+	}
+}
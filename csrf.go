@@ -0,0 +1,71 @@
+package trafico
+
+import (
+	"mime"
+	"net/http"
+	"strings"
+)
+
+// CSRFConfig rejects POSTs a browser could send cross-site without a CORS
+// preflight: a simple Content-Type (text/plain, form encodings, or none)
+// without any of Headers, which only scripts allowed by CORS can set
+type CSRFConfig struct {
+	Enabled bool `json:"enabled,omitempty"`
+	// Headers exempt a request carrying any of them, X-Apollo-Operation-Name,
+	// Apollo-Require-Preflight and X-Requested-With by default
+	Headers []string `json:"headers,omitempty"`
+	// RequireHeader also requires one of Headers on requests with a
+	// non-simple Content-Type such as application/json
+	RequireHeader bool `json:"requireHeader,omitempty"`
+}
+
+var defaultCSRFHeaders = []string{"X-Apollo-Operation-Name", "Apollo-Require-Preflight", "X-Requested-With"}
+
+const csrfMessage = "this request has been blocked as a potential cross-site request forgery: " +
+	"send it with Content-Type: application/json or with one of the headers "
+
+// csrfPolicy checks POSTs for CSRF; a nil *csrfPolicy does nothing
+type csrfPolicy struct {
+	headers       []string
+	requireHeader bool
+	message       string
+}
+
+func newCSRFPolicy(config CSRFConfig) *csrfPolicy {
+	if !config.Enabled {
+		return nil
+	}
+	c := &csrfPolicy{headers: config.Headers, requireHeader: config.RequireHeader}
+	if len(c.headers) == 0 {
+		c.headers = defaultCSRFHeaders
+	}
+	c.message = csrfMessage + strings.Join(c.headers, ", ")
+	return c
+}
+
+// blocked reports whether the POST could have been forged by another site
+func (c *csrfPolicy) blocked(req *http.Request) bool {
+	if c == nil {
+		return false
+	}
+	for _, name := range c.headers {
+		if req.Header.Get(name) != "" {
+			return false
+		}
+	}
+	if c.requireHeader {
+		return true
+	}
+
+	// Content types a cross-site form or fetch can send without preflight;
+	// text/plain is the usual way to smuggle a JSON body
+	mediaType, _, err := mime.ParseMediaType(req.Header.Get("Content-Type"))
+	if err != nil {
+		return true
+	}
+	switch mediaType {
+	case "text/plain", "application/x-www-form-urlencoded", "multipart/form-data":
+		return true
+	}
+	return false
+}
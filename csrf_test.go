@@ -0,0 +1,73 @@
+package trafico
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestCSRFBlocked(t *testing.T) {
+	tests := []struct {
+		name        string
+		config      CSRFConfig
+		contentType string
+		header      string
+		want        bool
+	}{
+		{"json", CSRFConfig{}, "application/json", "", false},
+		{"json with charset", CSRFConfig{}, "application/json; charset=utf-8", "", false},
+		{"graphql", CSRFConfig{}, "application/graphql", "", false},
+		{"text/plain", CSRFConfig{}, "text/plain", "", true},
+		{"text/plain with charset", CSRFConfig{}, "Text/Plain; charset=utf-8", "", true},
+		{"form", CSRFConfig{}, "application/x-www-form-urlencoded", "", true},
+		{"multipart", CSRFConfig{}, "multipart/form-data; boundary=x", "", true},
+		{"no content type", CSRFConfig{}, "", "", true},
+		{"invalid content type", CSRFConfig{}, "text/plain;;", "", true},
+		{"form with apollo header", CSRFConfig{}, formContentType, "X-Apollo-Operation-Name", false},
+		{"form with preflight header", CSRFConfig{}, formContentType, "Apollo-Require-Preflight", false},
+		{"form with requested with", CSRFConfig{}, formContentType, "X-Requested-With", false},
+		{"custom header", CSRFConfig{Headers: []string{"X-CSRF"}}, "text/plain", "X-CSRF", false},
+		{"default header not configured", CSRFConfig{Headers: []string{"X-CSRF"}}, "text/plain", "X-Requested-With", true},
+		{"required header missing", CSRFConfig{RequireHeader: true}, "application/json", "", true},
+		{"required header", CSRFConfig{RequireHeader: true}, "application/json", "X-Requested-With", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.config.Enabled = true
+			req := httptest.NewRequest(http.MethodPost, "/graphql", nil)
+			if tt.contentType != "" {
+				req.Header.Set("Content-Type", tt.contentType)
+			}
+			if tt.header != "" {
+				req.Header.Set(tt.header, "1")
+			}
+			if got := newCSRFPolicy(tt.config).blocked(req); got != tt.want {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCSRFRejection(t *testing.T) {
+	config := CreateConfig()
+	config.CSRF = CSRFConfig{Enabled: true}
+	calls := 0
+	handler := newTestHandler(t, config, func(rw http.ResponseWriter, req *http.Request) {
+		calls++
+	})
+
+	req := httptest.NewRequest(http.MethodPost, "/graphql", strings.NewReader(`{"query":"mutation { a }"}`))
+	req.Header.Set("Content-Type", "text/plain")
+	rw := httptest.NewRecorder()
+	handler.ServeHTTP(rw, req)
+	if rw.Code != http.StatusBadRequest || calls != 0 {
+		t.Errorf("status %d after %d calls, want 400 after none", rw.Code, calls)
+	}
+	// Requests other than POSTs are not checked
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/graphql?query={a}", nil))
+	if calls != 1 {
+		t.Errorf("GET was not forwarded")
+	}
+}
@@ -0,0 +1,151 @@
+package trafico
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"net/http"
+)
+
+// DebugConfig enables the debug echo: requests carrying the secret in Header
+// are answered with a JSON report of what trafico parsed and decided, and
+// never reach the backend
+type DebugConfig struct {
+	Enabled bool   `json:"enabled,omitempty"`
+	Header  string `json:"header,omitempty"`
+	Secret  string `json:"secret,omitempty"`
+}
+
+const defaultDebugHeader = "X-Trafico-Debug"
+
+// debugEcho answers debug requests; a nil *debugEcho does nothing
+type debugEcho struct {
+	header string
+	secret []byte
+}
+
+func newDebugEcho(config DebugConfig) (*debugEcho, error) {
+	if !config.Enabled {
+		return nil, nil
+	}
+	if config.Secret == "" {
+		return nil, fmt.Errorf("debug: a secret is required")
+	}
+	d := &debugEcho{header: config.Header, secret: []byte(config.Secret)}
+	if d.header == "" {
+		d.header = defaultDebugHeader
+	}
+	return d, nil
+}
+
+// requested reports whether the request asks for the debug report, removing
+// the secret from the request either way
+func (d *debugEcho) requested(req *http.Request) bool {
+	if d == nil {
+		return false
+	}
+	value := req.Header.Get(d.header)
+	req.Header.Del(d.header)
+	return value != "" && subtle.ConstantTimeCompare([]byte(value), d.secret) == 1
+}
+
+// debugReport is the JSON answer of the debug echo
+type debugReport struct {
+	Decision      string              `json:"decision"`
+	Decisions     []debugDecision     `json:"decisions,omitempty"`
+	OperationName string              `json:"operationName,omitempty"`
+	OperationType string              `json:"operationType,omitempty"`
+	Operations    []debugOperation    `json:"operations,omitempty"`
+	Queries       []string            `json:"queries,omitempty"`
+	Mutations     []string            `json:"mutations,omitempty"`
+	FieldPaths    []string            `json:"fieldPaths,omitempty"`
+	ClientName    string              `json:"clientName,omitempty"`
+	ClientVersion string              `json:"clientVersion,omitempty"`
+	Fingerprint   string              `json:"fingerprint,omitempty"`
+	Headers       map[string][]string `json:"headers,omitempty"`
+
+	before http.Header
+}
+
+// debugDecision is a rejection the request triggers; Enforced is false for
+// rules in dry run
+type debugDecision struct {
+	Reason   string `json:"reason"`
+	Status   int    `json:"status"`
+	Message  string `json:"message"`
+	Enforced bool   `json:"enforced"`
+}
+
+type debugOperation struct {
+	Name       string   `json:"name,omitempty"`
+	Type       string   `json:"type"`
+	RootFields []string `json:"rootFields,omitempty"`
+}
+
+func newDebugReport(req *http.Request) *debugReport {
+	return &debugReport{before: req.Header.Clone()}
+}
+
+// decide records a rejection instead of answering with it
+func (r *debugReport) decide(g *GraphQLParser, status int, reason, message string) {
+	r.Decisions = append(r.Decisions, debugDecision{Reason: reason, Status: status, Message: message, Enforced: g.enforced(reason)})
+}
+
+// write completes the report from the parsed request and the headers trafico
+// set, and answers with it
+func (r *debugReport) write(rw http.ResponseWriter, req *http.Request, parsed *ParsedRequest) {
+	r.Decision = decisionAllowed
+	for _, decision := range r.Decisions {
+		if decision.Enforced {
+			r.Decision = decisionBlocked
+			break
+		}
+		r.Decision = decisionDryRun
+	}
+
+	r.OperationName, r.OperationType = parsed.OperationName, parsed.OperationType
+	r.Queries, r.Mutations, r.FieldPaths = parsed.Queries, parsed.Mutations, parsed.FieldPaths
+	r.ClientName, r.ClientVersion = parsed.ClientName, parsed.ClientVersion
+	for _, entry := range parsed.entries() {
+		if entry.Document == nil {
+			continue
+		}
+		for _, op := range entry.Document.Operations {
+			operation := debugOperation{Name: op.Name, Type: op.Type}
+			for _, field := range entry.Document.RootFields(op) {
+				operation.RootFields = append(operation.RootFields, fieldLabel(field, fieldNamesPair))
+			}
+			r.Operations = append(r.Operations, operation)
+		}
+	}
+	if query := parsed.query(); query != "" {
+		r.Fingerprint = operationFingerprint(query)
+	}
+
+	// Headers added or changed by trafico
+	for name, values := range req.Header {
+		if !equalValues(values, r.before[name]) {
+			if r.Headers == nil {
+				r.Headers = make(map[string][]string)
+			}
+			r.Headers[name] = values
+		}
+	}
+
+	rw.Header().Set("Content-Type", "application/json")
+	rw.Header().Set("Cache-Control", "no-store")
+	data, _ := json.MarshalIndent(r, "", "  ")
+	_, _ = rw.Write(append(data, '\n'))
+}
+
+func equalValues(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
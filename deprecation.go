@@ -0,0 +1,159 @@
+package trafico
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/alainrk/trafico/parser"
+)
+
+// DeprecationConfig configures detection of selected @deprecated fields; it
+// requires a schema (schema or schemaFile)
+type DeprecationConfig struct {
+	Enabled bool `json:"enabled,omitempty"`
+	// Header lists the deprecated Type.field coordinates on the forwarded request
+	Header string `json:"header,omitempty"`
+	// ResponseWarning also adds a Warning header to the response
+	ResponseWarning bool `json:"responseWarning,omitempty"`
+}
+
+const defaultDeprecatedFieldsHeader = "X-GraphQL-Deprecated-Fields"
+
+// deprecationDetector reports deprecated field usage; a nil *deprecationDetector does nothing
+type deprecationDetector struct {
+	schema          *policySource
+	header          string
+	responseWarning bool
+	lists           listHeaders
+}
+
+func newDeprecationDetector(config DeprecationConfig, schema *policySource, lists listHeaders) (*deprecationDetector, error) {
+	if !config.Enabled {
+		return nil, nil
+	}
+	if schema == nil {
+		return nil, fmt.Errorf("deprecations: a schema is required, set schema or schemaFile")
+	}
+
+	d := &deprecationDetector{
+		schema:          schema,
+		header:          config.Header,
+		responseWarning: config.ResponseWarning,
+		lists:           lists,
+	}
+	if d.header == "" {
+		d.header = defaultDeprecatedFieldsHeader
+	}
+	return d, nil
+}
+
+// loadSchema parses the inline SDL or the SDL file (or URL), returning nil when
+// neither is configured; the file is reloaded at the given interval
+func loadSchema(inline, file string, reloadInterval time.Duration, startupFailure string) (*policySource, error) {
+	if file != "" {
+		schema, err := newPolicySource(file, reloadInterval, startupFailure, compileSchema)
+		if err != nil {
+			return nil, fmt.Errorf("schema: %w", err)
+		}
+		return schema, nil
+	}
+	if inline == "" {
+		return nil, nil
+	}
+
+	schema, err := compileSchema([]byte(inline))
+	if err != nil {
+		return nil, fmt.Errorf("schema: %w", err)
+	}
+	return newStaticPolicy(schema), nil
+}
+
+func compileSchema(data []byte) (any, error) {
+	return parser.ParseSchema(string(data))
+}
+
+// apply sets the request header and response warning for deprecated fields selected by the document
+func (d *deprecationDetector) apply(rw http.ResponseWriter, req *http.Request, parsed *ParsedRequest) {
+	if d == nil {
+		return
+	}
+	req.Header.Del(d.header)
+
+	schema, _ := d.schema.current().(*parser.Schema)
+	if schema == nil {
+		return
+	}
+	var fields []string
+	for _, entry := range parsed.entries() {
+		if entry.Document != nil {
+			fields = append(fields, deprecatedFields(schema, entry.Document, entry.Request.OperationName)...)
+		}
+	}
+	if fields = sortedUnique(fields); len(fields) == 0 {
+		return
+	}
+	d.lists.set(req.Header, d.header, fields)
+	if d.responseWarning {
+		rw.Header().Add("Warning", `299 - "Deprecated GraphQL fields: `+strings.Join(fields, ", ")+`"`)
+	}
+}
+
+// deprecatedFields returns the sorted Type.field coordinates of the deprecated
+// fields selected anywhere in the executed operation, through fragments included
+func deprecatedFields(schema *parser.Schema, doc *parser.Document, operationName string) []string {
+	found := make(map[string]bool)
+	visited := make(map[string]bool)
+
+	var walk func(typeName string, selections []*parser.Selection)
+	walk = func(typeName string, selections []*parser.Selection) {
+		for _, selection := range selections {
+			switch selection.Kind {
+			case parser.FieldSelection:
+				if strings.HasPrefix(selection.Name, "__") {
+					continue
+				}
+				def := schema.Types[typeName]
+				if def == nil {
+					continue
+				}
+				field := def.Field(selection.Name)
+				if field == nil {
+					continue
+				}
+				if _, ok := field.Deprecation(); ok {
+					found[typeName+"."+selection.Name] = true
+				}
+				if len(selection.SelectionSet) > 0 {
+					walk(field.Type.NamedType(), selection.SelectionSet)
+				}
+			case parser.InlineFragmentSelection:
+				next := typeName
+				if selection.TypeCondition != "" {
+					next = selection.TypeCondition
+				}
+				walk(next, selection.SelectionSet)
+			case parser.FragmentSpreadSelection:
+				fragment := doc.Fragment(selection.Name)
+				if fragment == nil || visited[fragment.Name] {
+					continue
+				}
+				visited[fragment.Name] = true
+				walk(fragment.TypeCondition, fragment.SelectionSet)
+			}
+		}
+	}
+
+	for _, op := range executedOperations(doc, operationName) {
+		walk(schema.RootType(op.Type), op.SelectionSet)
+	}
+
+	fields := make([]string, 0, len(found))
+	for field := range found {
+		fields = append(fields, field)
+	}
+	sort.Strings(fields)
+	return fields
+}
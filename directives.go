@@ -0,0 +1,82 @@
+package trafico
+
+import (
+	"net/http"
+
+	"github.com/alainrk/trafico/parser"
+)
+
+// DirectivesConfig enables exposing the directives applied to the executed
+// operations and their root selections
+type DirectivesConfig struct {
+	Enabled bool   `json:"enabled,omitempty"`
+	Header  string `json:"header,omitempty"`
+}
+
+const defaultDirectivesHeader = "X-GraphQL-Directives"
+
+// directiveExtractor lists directive names; a nil *directiveExtractor does nothing
+type directiveExtractor struct {
+	header string
+	lists  listHeaders
+}
+
+func newDirectiveExtractor(config DirectivesConfig, lists listHeaders) *directiveExtractor {
+	if !config.Enabled {
+		return nil
+	}
+	e := &directiveExtractor{header: config.Header, lists: lists}
+	if e.header == "" {
+		e.header = defaultDirectivesHeader
+	}
+	return e
+}
+
+// setHeader writes the sorted, distinct names (without @) of the directives
+// of the executed operations, of their root fields and of the fragments
+// selected at the root, such as @live, @cached or @defer
+func (e *directiveExtractor) setHeader(header http.Header, parsed *ParsedRequest) {
+	if e == nil {
+		return
+	}
+	header.Del(e.header)
+
+	var names []string
+	add := func(directives []*parser.Directive) {
+		for _, directive := range directives {
+			names = append(names, directive.Name)
+		}
+	}
+
+	for _, entry := range parsed.entries() {
+		doc := entry.Document
+		visited := make(map[string]bool)
+		var walk func(selections []*parser.Selection)
+		walk = func(selections []*parser.Selection) {
+			for _, selection := range selections {
+				add(selection.Directives)
+				switch selection.Kind {
+				case parser.InlineFragmentSelection:
+					walk(selection.SelectionSet)
+				case parser.FragmentSpreadSelection:
+					fragment := doc.Fragment(selection.Name)
+					if fragment == nil || visited[fragment.Name] {
+						continue
+					}
+					visited[fragment.Name] = true
+					add(fragment.Directives)
+					walk(fragment.SelectionSet)
+				}
+			}
+		}
+
+		for _, op := range executedOperations(doc, entry.Request.OperationName) {
+			add(op.Directives)
+			walk(op.SelectionSet)
+		}
+	}
+
+	if names = sortedUnique(names); len(names) > 0 {
+		e.lists.set(header, e.header, names)
+	}
+}
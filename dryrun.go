@@ -0,0 +1,113 @@
+package trafico
+
+import (
+	"fmt"
+	"net/http"
+)
+
+// Rules grouping the rejection reasons, as named in dryRunRules
+const (
+	ruleLimits        = "limits"
+	ruleOperationName = "operationName"
+	ruleClients       = "clients"
+	ruleInspectors    = "inspectors"
+	ruleFailureMode   = "failureMode"
+	ruleIdempotency   = "idempotency"
+	ruleBreaker       = "circuitBreaker"
+	ruleCSRF          = "csrf"
+	ruleScreening     = "screening"
+	ruleQuota         = "quota"
+	ruleEndpoint      = "endpoint"
+	rulePolicyRules   = "rules"
+	ruleSubscriptions = "subscriptions"
+)
+
+// ruleOf returns the rule a rejection reason belongs to; reasons not listed
+// come from inspectors
+func ruleOf(reason string) string {
+	switch reason {
+	case "document_limit", "batch_size", "too_many_operations":
+		return ruleLimits
+	case "anonymous_operation", "missing_operation_name", "unknown_operation_name":
+		return ruleOperationName
+	case "unknown_client":
+		return ruleClients
+	case "missing_idempotency_key", "idempotency_key_reused", "idempotency_key_in_use", "duplicate_request":
+		return ruleIdempotency
+	case "circuit_open":
+		return ruleBreaker
+	case "csrf":
+		return ruleCSRF
+	case "suspicious_variables":
+		return ruleScreening
+	case "quota_exhausted":
+		return ruleQuota
+	case "method_not_allowed":
+		return ruleEndpoint
+	case "rule_violation":
+		return rulePolicyRules
+	case "too_many_connections", "too_many_subscriptions":
+		return ruleSubscriptions
+	case "body_read", "invalid_json", "invalid_form", "syntax", "truncated", "panic":
+		return ruleFailureMode
+	}
+	return ruleInspectors
+}
+
+// newDryRunRules validates the rules of dryRunRules
+func newDryRunRules(rules []string) (map[string]bool, error) {
+	if len(rules) == 0 {
+		return nil, nil
+	}
+	dryRun := make(map[string]bool, len(rules))
+	for _, rule := range rules {
+		switch rule {
+		case ruleLimits, ruleOperationName, ruleClients, ruleInspectors, ruleFailureMode, ruleIdempotency, ruleBreaker, ruleCSRF, ruleScreening, ruleQuota, ruleEndpoint, rulePolicyRules, ruleSubscriptions:
+			dryRun[rule] = true
+		default:
+			return nil, fmt.Errorf("dryRunRules: unknown rule %q, expected limits, operationName, clients, inspectors, failureMode, idempotency, circuitBreaker, csrf, screening, quota, endpoint, rules or subscriptions", rule)
+		}
+	}
+	return dryRun, nil
+}
+
+// enforced reports whether rejections for the reason are enforced rather than
+// only reported
+func (g *GraphQLParser) enforced(reason string) bool {
+	return !g.dryRun && !g.dryRunRules[ruleOf(reason)]
+}
+
+// enforce reports whether the request must be rejected; in dry run the
+// would-be rejection is logged, counted and recorded with the request, which
+// is forwarded. Debug requests only add it to their report.
+func (g *GraphQLParser) enforce(req *http.Request, parsed *ParsedRequest, status int, reason, message string) bool {
+	if parsed.debug != nil {
+		parsed.debug.decide(g, status, reason, message)
+		return false
+	}
+	if g.enforced(reason) {
+		return true
+	}
+	logRequestf(req, "dry run: would reject request to %s with %d (%s): %s", req.URL.Path, status, reason, message)
+	g.metrics.dryRunRejection(reason)
+	if parsed.dryRunReason == "" {
+		parsed.dryRunReason = reason
+	}
+	return false
+}
+
+// decision is the access log decision of a forwarded request
+func (p *ParsedRequest) decision() string {
+	if p.dryRunReason != "" {
+		return decisionDryRun
+	}
+	return decisionAllowed
+}
+
+// firstRejection keeps the rejection found first
+func firstRejection(found, next *requestRejection) *requestRejection {
+	if found != nil {
+		return found
+	}
+	return next
+}
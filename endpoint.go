@@ -0,0 +1,233 @@
+package trafico
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// EndpointConfig answers OPTIONS on the GraphQL paths, with CORS preflight
+// responses when origins are allowed, and rejects the methods a GraphQL
+// endpoint does not serve with a 405 instead of passing them downstream
+type EndpointConfig struct {
+	Enabled bool `json:"enabled,omitempty"`
+	// Paths are the GraphQL endpoints, /graphql by default; a trailing *
+	// matches any path with the prefix
+	Paths []string `json:"paths,omitempty"`
+	// Methods are the methods served, GET and POST by default; HEAD is
+	// served along with GET
+	Methods []string   `json:"methods,omitempty"`
+	CORS    CORSConfig `json:"cors,omitempty"`
+}
+
+// CORSConfig allows cross-origin requests from browsers
+type CORSConfig struct {
+	// AllowedOrigins are the origins allowed, * allowing any
+	AllowedOrigins []string `json:"allowedOrigins,omitempty"`
+	// AllowedHeaders are allowed in addition to Content-Type, Accept and the
+	// headers the plugin reads, such as the idempotency key
+	AllowedHeaders []string `json:"allowedHeaders,omitempty"`
+	// ExposedHeaders are exposed in addition to the response headers the
+	// plugin sets, such as the remaining quota
+	ExposedHeaders   []string `json:"exposedHeaders,omitempty"`
+	AllowCredentials bool     `json:"allowCredentials,omitempty"`
+	// MaxAge is how long browsers cache preflight responses, 10m by default
+	MaxAge string `json:"maxAge,omitempty"`
+}
+
+const defaultCORSMaxAge = 10 * time.Minute
+
+var (
+	defaultEndpointPaths   = []string{"/graphql"}
+	defaultEndpointMethods = []string{http.MethodGet, http.MethodPost}
+)
+
+// endpointPolicy handles the methods of the GraphQL paths; a nil
+// *endpointPolicy does nothing
+type endpointPolicy struct {
+	paths   []string
+	methods map[string]bool
+	allow   string
+
+	origins          map[string]bool
+	anyOrigin        bool
+	allowHeaders     string
+	exposeHeaders    string
+	allowCredentials bool
+	maxAge           string
+}
+
+// newEndpointPolicy builds the policy; requestHeaders and responseHeaders
+// are the headers the plugin reads and sets, allowed and exposed to browsers
+func newEndpointPolicy(config EndpointConfig, requestHeaders, responseHeaders []string) (*endpointPolicy, error) {
+	if !config.Enabled {
+		return nil, nil
+	}
+	e := &endpointPolicy{paths: config.Paths, methods: make(map[string]bool), allowCredentials: config.CORS.AllowCredentials}
+	if len(e.paths) == 0 {
+		e.paths = defaultEndpointPaths
+	}
+	methods := config.Methods
+	if len(methods) == 0 {
+		methods = defaultEndpointMethods
+	}
+	for _, method := range methods {
+		method = strings.ToUpper(method)
+		e.methods[method] = true
+		if method == http.MethodGet {
+			e.methods[http.MethodHead] = true
+		}
+	}
+	e.methods[http.MethodOptions] = true
+	allowed := make([]string, 0, len(e.methods))
+	for method := range e.methods {
+		allowed = append(allowed, method)
+	}
+	sort.Strings(allowed)
+	e.allow = strings.Join(allowed, ", ")
+
+	for _, origin := range config.CORS.AllowedOrigins {
+		if origin == "*" {
+			e.anyOrigin = true
+			continue
+		}
+		if e.origins == nil {
+			e.origins = make(map[string]bool)
+		}
+		e.origins[strings.ToLower(strings.TrimSuffix(origin, "/"))] = true
+	}
+	e.allowHeaders = strings.Join(canonicalHeaders(append([]string{"Content-Type", "Accept"}, append(requestHeaders, config.CORS.AllowedHeaders...)...)), ", ")
+	e.exposeHeaders = strings.Join(canonicalHeaders(append(responseHeaders, config.CORS.ExposedHeaders...)), ", ")
+
+	maxAge := defaultCORSMaxAge
+	if config.CORS.MaxAge != "" {
+		d, err := time.ParseDuration(config.CORS.MaxAge)
+		if err != nil || d < 0 {
+			return nil, fmt.Errorf("endpoint: invalid cors.maxAge %q", config.CORS.MaxAge)
+		}
+		maxAge = d
+	}
+	e.maxAge = strconv.Itoa(int(maxAge.Seconds()))
+	return e, nil
+}
+
+// canonicalHeaders returns the sorted distinct canonical header names
+func canonicalHeaders(names []string) []string {
+	canonical := make([]string, 0, len(names))
+	for _, name := range names {
+		if name != "" {
+			canonical = append(canonical, http.CanonicalHeaderKey(name))
+		}
+	}
+	return sortedUnique(canonical)
+}
+
+// matches reports whether the request targets a GraphQL path
+func (e *endpointPolicy) matches(req *http.Request) bool {
+	for _, path := range e.paths {
+		if prefix, ok := strings.CutSuffix(path, "*"); ok {
+			if strings.HasPrefix(req.URL.Path, prefix) {
+				return true
+			}
+		} else if req.URL.Path == path {
+			return true
+		}
+	}
+	return false
+}
+
+// allowedOrigin returns the Access-Control-Allow-Origin value of the request,
+// empty when its origin is not allowed
+func (e *endpointPolicy) allowedOrigin(req *http.Request) string {
+	origin := req.Header.Get("Origin")
+	switch {
+	case origin == "":
+		return ""
+	case e.origins[strings.ToLower(origin)]:
+		return origin
+	case e.anyOrigin && e.allowCredentials:
+		// Credentials cannot be allowed for any origin with *
+		return origin
+	case e.anyOrigin:
+		return "*"
+	}
+	return ""
+}
+
+// setCORSHeaders allows the origin of the request to read the response
+func (e *endpointPolicy) setCORSHeaders(header http.Header, origin string) {
+	header.Set("Access-Control-Allow-Origin", origin)
+	if origin != "*" {
+		header.Add("Vary", "Origin")
+	}
+	if e.allowCredentials {
+		header.Set("Access-Control-Allow-Credentials", "true")
+	}
+}
+
+// serve answers the OPTIONS requests of the GraphQL paths and adds the CORS
+// headers to the responses of the others. It returns the status of the
+// methods not served, to be rejected, and reports whether the request was
+// answered.
+func (e *endpointPolicy) serve(rw http.ResponseWriter, req *http.Request) (bool, int) {
+	if e == nil || !e.matches(req) {
+		return false, 0
+	}
+	origin := e.allowedOrigin(req)
+	header := rw.Header()
+
+	if req.Method == http.MethodOptions {
+		header.Set("Allow", e.allow)
+		requested := req.Header.Get("Access-Control-Request-Method")
+		if origin != "" && requested != "" && e.methods[strings.ToUpper(requested)] {
+			e.setCORSHeaders(header, origin)
+			header.Set("Access-Control-Allow-Methods", e.allow)
+			header.Set("Access-Control-Allow-Headers", e.allowHeaders)
+			header.Set("Access-Control-Max-Age", e.maxAge)
+		}
+		rw.WriteHeader(http.StatusNoContent)
+		return true, 0
+	}
+
+	if !e.methods[req.Method] {
+		header.Set("Allow", e.allow)
+		return false, http.StatusMethodNotAllowed
+	}
+	if origin != "" {
+		e.setCORSHeaders(header, origin)
+		if e.exposeHeaders != "" {
+			header.Set("Access-Control-Expose-Headers", e.exposeHeaders)
+		}
+	}
+	return false, 0
+}
+
+// metadataHeaders returns the request headers the plugin reads from clients
+// and the response headers it sets, for browsers to be allowed to use them
+func (g *GraphQLParser) metadataHeaders() (request, response []string) {
+	request = []string{apolloClientNameHeader, apolloClientVersionHeader}
+	if g.requestIDs != nil {
+		request = append(request, g.requestIDs.header)
+		response = append(response, g.requestIDs.header)
+	}
+	if g.csrf != nil {
+		request = append(request, g.csrf.headers...)
+	}
+	if g.idempotency != nil {
+		request = append(request, g.idempotency.header)
+		response = append(response, idempotentReplayedHeader)
+	}
+	if g.quota != nil {
+		if g.quota.source == quotaSourceHeader {
+			request = append(request, g.quota.keyHeader)
+		}
+		response = append(response, g.quota.header, "Retry-After")
+	}
+	if g.nullFields != nil {
+		response = append(response, g.nullFields.header)
+	}
+	return request, response
+}
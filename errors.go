@@ -0,0 +1,103 @@
+package trafico
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// ErrorsConfig shapes the GraphQL error responses of the rejected requests
+type ErrorsConfig struct {
+	// Codes overrides the extensions.code of rejection reasons, such as
+	// quota_exhausted: RATE_LIMITED or timeout: GATEWAY_TIMEOUT
+	Codes map[string]string `json:"codes,omitempty"`
+}
+
+// graphqlResponseContentType is the media type of the GraphQL over HTTP
+// specification, used when clients accept it
+const graphqlResponseContentType = "application/graphql-response+json"
+
+// errorRenderer writes the rejections as GraphQL responses; a nil
+// *errorRenderer uses the default codes
+type errorRenderer struct {
+	codes map[string]string
+}
+
+func newErrorRenderer(config ErrorsConfig) (*errorRenderer, error) {
+	if len(config.Codes) == 0 {
+		return nil, nil
+	}
+	for reason, code := range config.Codes {
+		if reason == "" || strings.TrimSpace(code) == "" {
+			return nil, fmt.Errorf("errors: codes must map a reason to a non-empty code")
+		}
+	}
+	return &errorRenderer{codes: config.Codes}, nil
+}
+
+// code returns the extensions.code of a rejection
+func (r *errorRenderer) code(status int, reason string) string {
+	if r != nil {
+		if code, ok := r.codes[reason]; ok {
+			return code
+		}
+	}
+	switch reason {
+	case "syntax", "truncated":
+		return "GRAPHQL_PARSE_FAILED"
+	case "document_limit", "batch_size", "too_many_operations":
+		return "LIMIT_EXCEEDED"
+	case "anonymous_operation", "missing_operation_name", "unknown_operation_name":
+		return "OPERATION_RESOLUTION_FAILURE"
+	case "quota_exhausted":
+		return "QUOTA_EXHAUSTED"
+	case "timeout":
+		return "TIMEOUT"
+	}
+	switch status {
+	case http.StatusUnauthorized:
+		return "UNAUTHENTICATED"
+	case http.StatusForbidden:
+		return "FORBIDDEN"
+	case http.StatusMethodNotAllowed:
+		return "METHOD_NOT_ALLOWED"
+	case http.StatusConflict:
+		return "CONFLICT"
+	case http.StatusRequestEntityTooLarge:
+		return "PAYLOAD_TOO_LARGE"
+	case http.StatusTooManyRequests:
+		return "RATE_LIMITED"
+	case http.StatusServiceUnavailable:
+		return "SERVICE_UNAVAILABLE"
+	case http.StatusGatewayTimeout:
+		return "TIMEOUT"
+	}
+	if status >= http.StatusInternalServerError {
+		return "INTERNAL_SERVER_ERROR"
+	}
+	return "BAD_REQUEST"
+}
+
+// write answers with a GraphQL response carrying a single error, typed as
+// application/graphql-response+json when accept allows it
+func (r *errorRenderer) write(rw http.ResponseWriter, accept string, status int, reason, message string) {
+	type graphqlError struct {
+		Message    string         `json:"message"`
+		Extensions map[string]any `json:"extensions,omitempty"`
+	}
+	body, _ := json.Marshal(struct {
+		Errors []graphqlError `json:"errors"`
+	}{Errors: []graphqlError{{Message: message, Extensions: map[string]any{"code": r.code(status, reason)}}}})
+
+	contentType := "application/json"
+	if strings.Contains(accept, graphqlResponseContentType) {
+		contentType = graphqlResponseContentType
+	}
+	header := rw.Header()
+	header.Del("Content-Length")
+	header.Set("Content-Type", contentType+"; charset=utf-8")
+	header.Set("X-Content-Type-Options", "nosniff")
+	rw.WriteHeader(status)
+	_, _ = rw.Write(body)
+}
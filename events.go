@@ -0,0 +1,377 @@
+package trafico
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// EventsConfig configures asynchronous publishing of per-request operation metadata
+type EventsConfig struct {
+	Enabled bool `json:"enabled,omitempty"`
+	// Backend is nats or kafka
+	Backend string `json:"backend,omitempty"`
+
+	NATSAddress  string `json:"natsAddress,omitempty"`
+	NATSSubject  string `json:"natsSubject,omitempty"`
+	NATSUser     string `json:"natsUser,omitempty"`
+	NATSPassword string `json:"natsPassword,omitempty"`
+	NATSToken    string `json:"natsToken,omitempty"`
+
+	// KafkaRESTURL is the base URL of a Kafka REST proxy (Confluent v2 API)
+	KafkaRESTURL string `json:"kafkaRestURL,omitempty"`
+	KafkaTopic   string `json:"kafkaTopic,omitempty"`
+
+	// Subjects and topics may contain {operationType}, replaced per event
+	BufferSize    int    `json:"bufferSize,omitempty"`
+	BatchSize     int    `json:"batchSize,omitempty"`
+	FlushInterval string `json:"flushInterval,omitempty"`
+
+	ClientIDHeader string `json:"clientIdHeader,omitempty"`
+	// CountErrors inspects JSON responses to count GraphQL errors
+	CountErrors bool `json:"countErrors,omitempty"`
+	// IncludeVariables adds the variables, minus redacted ones, to each event
+	IncludeVariables bool `json:"includeVariables,omitempty"`
+}
+
+const (
+	defaultEventBufferSize    = 4096
+	defaultEventBatchSize     = 100
+	defaultEventFlushInterval = time.Second
+	defaultErrorCaptureBytes  = 1 << 20
+)
+
+// operationEvent is the payload published for each GraphQL request
+type operationEvent struct {
+	Time           string         `json:"time"`
+	Middleware     string         `json:"middleware"`
+	RequestID      string         `json:"requestId,omitempty"`
+	Fingerprint    string         `json:"fingerprint"`
+	OperationName  string         `json:"operationName,omitempty"`
+	OperationType  string         `json:"operationType,omitempty"`
+	Queries        []string       `json:"queries,omitempty"`
+	Mutations      []string       `json:"mutations,omitempty"`
+	Variables      map[string]any `json:"variables,omitempty"`
+	ClientID       string         `json:"clientId,omitempty"`
+	ClientIdentity string         `json:"clientIdentity,omitempty"`
+	Status         int            `json:"status"`
+	LatencyMs      float64        `json:"latencyMs"`
+	ErrorCount     int            `json:"errorCount"`
+}
+
+// eventPublisher delivers a batch of events to the backend
+type eventPublisher interface {
+	publish(events []*operationEvent) error
+	target() string
+}
+
+// eventEmitter buffers events and publishes them in the background; a nil
+// *eventEmitter emits nothing
+type eventEmitter struct {
+	middleware       string
+	clientIDHeader   string
+	countErrors      bool
+	includeVariables bool
+	sink             *eventSink
+}
+
+// eventSink is the bounded queue and worker shared by instances publishing to the same target
+type eventSink struct {
+	queue         chan *operationEvent
+	publisher     eventPublisher
+	batchSize     int
+	flushInterval time.Duration
+	dropped       int64
+}
+
+var (
+	eventSinksMu sync.Mutex
+	eventSinks   = make(map[string]*eventSink)
+)
+
+// newEventEmitter builds the emitter for the configuration, or nil when disabled
+func newEventEmitter(config EventsConfig, middleware string) (*eventEmitter, error) {
+	if !config.Enabled {
+		return nil, nil
+	}
+
+	var publisher eventPublisher
+	switch config.Backend {
+	case "nats":
+		if config.NATSAddress == "" || config.NATSSubject == "" {
+			return nil, fmt.Errorf("events: natsAddress and natsSubject are required for the nats backend")
+		}
+		publisher = &natsPublisher{
+			address: config.NATSAddress,
+			subject: config.NATSSubject,
+			user:    config.NATSUser,
+			pass:    config.NATSPassword,
+			token:   config.NATSToken,
+		}
+	case "kafka":
+		if config.KafkaRESTURL == "" || config.KafkaTopic == "" {
+			return nil, fmt.Errorf("events: kafkaRestURL and kafkaTopic are required for the kafka backend")
+		}
+		publisher = &kafkaRESTPublisher{
+			baseURL: strings.TrimRight(config.KafkaRESTURL, "/"),
+			topic:   config.KafkaTopic,
+			client:  &http.Client{Timeout: 10 * time.Second},
+		}
+	default:
+		return nil, fmt.Errorf("events: unknown backend %q", config.Backend)
+	}
+
+	flushInterval := defaultEventFlushInterval
+	if config.FlushInterval != "" {
+		d, err := time.ParseDuration(config.FlushInterval)
+		if err != nil || d <= 0 {
+			return nil, fmt.Errorf("events: invalid flushInterval %q", config.FlushInterval)
+		}
+		flushInterval = d
+	}
+	bufferSize := config.BufferSize
+	if bufferSize <= 0 {
+		bufferSize = defaultEventBufferSize
+	}
+	batchSize := config.BatchSize
+	if batchSize <= 0 {
+		batchSize = defaultEventBatchSize
+	}
+
+	eventSinksMu.Lock()
+	defer eventSinksMu.Unlock()
+
+	sink, ok := eventSinks[publisher.target()]
+	if !ok {
+		sink = &eventSink{
+			queue:         make(chan *operationEvent, bufferSize),
+			publisher:     publisher,
+			batchSize:     batchSize,
+			flushInterval: flushInterval,
+		}
+		eventSinks[publisher.target()] = sink
+		go sink.run()
+	}
+
+	return &eventEmitter{
+		middleware:       middleware,
+		clientIDHeader:   config.ClientIDHeader,
+		countErrors:      config.CountErrors,
+		includeVariables: config.IncludeVariables,
+		sink:             sink,
+	}, nil
+}
+
+// emit queues the event, dropping it when the buffer is full so the request path never blocks
+func (e *eventEmitter) emit(event *operationEvent) {
+	if e == nil {
+		return
+	}
+	event.Middleware = e.middleware
+	select {
+	case e.sink.queue <- event:
+	default:
+		atomic.AddInt64(&e.sink.dropped, 1)
+	}
+}
+
+func (s *eventSink) run() {
+	ticker := time.NewTicker(s.flushInterval)
+	defer ticker.Stop()
+
+	var batch []*operationEvent
+	for {
+		select {
+		case event := <-s.queue:
+			batch = append(batch, event)
+			if len(batch) < s.batchSize {
+				continue
+			}
+		case <-ticker.C:
+			if dropped := atomic.SwapInt64(&s.dropped, 0); dropped > 0 {
+				logf("event buffer for %s full, dropped %d events", s.publisher.target(), dropped)
+			}
+			if len(batch) == 0 {
+				continue
+			}
+		}
+		if err := s.publisher.publish(batch); err != nil {
+			logf("publishing %d events to %s failed: %v", len(batch), s.publisher.target(), err)
+		}
+		batch = nil
+	}
+}
+
+// eventDestination expands the {operationType} placeholder of a subject or topic
+func eventDestination(pattern string, event *operationEvent) string {
+	opType := event.OperationType
+	if opType == "" {
+		opType = "unknown"
+	}
+	return strings.ReplaceAll(pattern, "{operationType}", opType)
+}
+
+// natsPublisher speaks the NATS client protocol over a single TCP connection
+type natsPublisher struct {
+	address string
+	subject string
+	user    string
+	pass    string
+	token   string
+
+	mu   sync.Mutex
+	conn net.Conn
+}
+
+func (n *natsPublisher) target() string {
+	return "nats://" + n.address + "/" + n.subject
+}
+
+func (n *natsPublisher) connect() error {
+	conn, err := net.DialTimeout("tcp", n.address, 5*time.Second)
+	if err != nil {
+		return err
+	}
+	reader := bufio.NewReader(conn)
+
+	// The server greets with INFO before accepting CONNECT
+	_ = conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	line, err := reader.ReadString('\n')
+	if err != nil || !strings.HasPrefix(line, "INFO") {
+		conn.Close()
+		return fmt.Errorf("unexpected NATS greeting %q: %v", strings.TrimSpace(line), err)
+	}
+	_ = conn.SetReadDeadline(time.Time{})
+
+	options := map[string]any{"verbose": false, "pedantic": false, "name": "trafico", "lang": "go"}
+	if n.user != "" {
+		options["user"] = n.user
+		options["pass"] = n.pass
+	}
+	if n.token != "" {
+		options["auth_token"] = n.token
+	}
+	connectJSON, _ := json.Marshal(options)
+	if _, err := fmt.Fprintf(conn, "CONNECT %s\r\n", connectJSON); err != nil {
+		conn.Close()
+		return err
+	}
+
+	n.conn = conn
+	go n.readLoop(conn, reader)
+	return nil
+}
+
+// readLoop answers server pings and reports protocol errors
+func (n *natsPublisher) readLoop(conn net.Conn, reader *bufio.Reader) {
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			n.mu.Lock()
+			if n.conn == conn {
+				n.conn.Close()
+				n.conn = nil
+			}
+			n.mu.Unlock()
+			return
+		}
+		switch {
+		case strings.HasPrefix(line, "PING"):
+			n.mu.Lock()
+			_, _ = conn.Write([]byte("PONG\r\n"))
+			n.mu.Unlock()
+		case strings.HasPrefix(line, "-ERR"):
+			logf("NATS server %s: %s", n.address, strings.TrimSpace(line))
+		}
+	}
+}
+
+func (n *natsPublisher) publish(events []*operationEvent) error {
+	var buf bytes.Buffer
+	for _, event := range events {
+		payload, err := json.Marshal(event)
+		if err != nil {
+			continue
+		}
+		fmt.Fprintf(&buf, "PUB %s %d\r\n", eventDestination(n.subject, event), len(payload))
+		buf.Write(payload)
+		buf.WriteString("\r\n")
+	}
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	if n.conn == nil {
+		if err := n.connect(); err != nil {
+			return err
+		}
+	}
+	_ = n.conn.SetWriteDeadline(time.Now().Add(5 * time.Second))
+	if _, err := n.conn.Write(buf.Bytes()); err != nil {
+		n.conn.Close()
+		n.conn = nil
+		return err
+	}
+	return nil
+}
+
+// kafkaRESTPublisher produces records through a Kafka REST proxy
+type kafkaRESTPublisher struct {
+	baseURL string
+	topic   string
+	client  *http.Client
+}
+
+func (k *kafkaRESTPublisher) target() string {
+	return k.baseURL + "/topics/" + k.topic
+}
+
+func (k *kafkaRESTPublisher) publish(events []*operationEvent) error {
+	type record struct {
+		Value *operationEvent `json:"value"`
+	}
+	byTopic := make(map[string][]record)
+	for _, event := range events {
+		topic := eventDestination(k.topic, event)
+		byTopic[topic] = append(byTopic[topic], record{Value: event})
+	}
+
+	for topic, records := range byTopic {
+		body, err := json.Marshal(map[string]any{"records": records})
+		if err != nil {
+			return err
+		}
+		req, err := http.NewRequest(http.MethodPost, k.baseURL+"/topics/"+topic, bytes.NewReader(body))
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Content-Type", "application/vnd.kafka.json.v2+json")
+		resp, err := k.client.Do(req)
+		if err != nil {
+			return err
+		}
+		resp.Body.Close()
+		if resp.StatusCode >= 300 {
+			return fmt.Errorf("topic %s: status %d", topic, resp.StatusCode)
+		}
+	}
+	return nil
+}
+
+// graphqlErrorCount returns the number of entries in the "errors" member of a JSON response
+func graphqlErrorCount(body []byte) int {
+	var resp struct {
+		Errors []json.RawMessage `json:"errors"`
+	}
+	if err := json.Unmarshal(body, &resp); err != nil {
+		return 0
+	}
+	return len(resp.Errors)
+}
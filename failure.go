@@ -0,0 +1,80 @@
+package trafico
+
+import "fmt"
+
+// FailureModeConfig chooses, per failure, between forwarding the request
+// unchecked ("open", the default) and rejecting it ("closed")
+type FailureModeConfig struct {
+	// Default applies to the failures not configured below
+	Default string `json:"default,omitempty"`
+	// BodyRead covers request bodies that cannot be read
+	BodyRead string `json:"bodyRead,omitempty"`
+	// Parse covers invalid JSON or form bodies, GraphQL syntax errors and
+	// bodies larger than maxBufferedBodyKB or maxFormBodyKB whose document
+	// cannot be inspected
+	Parse string `json:"parse,omitempty"`
+	// Panic covers internal errors of the plugin
+	Panic string `json:"panic,omitempty"`
+	// Policies covers policies loaded from a URL that are not available yet,
+	// such as the clients allowlist
+	Policies string `json:"policies,omitempty"`
+}
+
+const (
+	failureModeOpen   = "open"
+	failureModeClosed = "closed"
+)
+
+// failurePolicy tells which failures reject the request
+type failurePolicy struct {
+	bodyRead bool
+	parse    bool
+	panic    bool
+	policies bool
+}
+
+func newFailurePolicy(config FailureModeConfig) (failurePolicy, error) {
+	closed := func(setting, value string) (bool, error) {
+		if value == "" {
+			value = config.Default
+		}
+		switch value {
+		case "", failureModeOpen:
+			return false, nil
+		case failureModeClosed:
+			return true, nil
+		}
+		return false, fmt.Errorf("failureMode: unknown %s %q, expected open or closed", setting, value)
+	}
+
+	var f failurePolicy
+	var err error
+	if f.bodyRead, err = closed("bodyRead", config.BodyRead); err != nil {
+		return f, err
+	}
+	if f.parse, err = closed("parse", config.Parse); err != nil {
+		return f, err
+	}
+	if f.panic, err = closed("panic", config.Panic); err != nil {
+		return f, err
+	}
+	if f.policies, err = closed("policies", config.Policies); err != nil {
+		return f, err
+	}
+	if config.Default != "" {
+		if _, err := closed("default", config.Default); err != nil {
+			return f, err
+		}
+	}
+	return f, nil
+}
+
+// parseFailure counts a request that could not be parsed, returning the
+// rejection when parse failures fail closed
+func (g *GraphQLParser) parseFailure(reason string, status int, message string) *requestRejection {
+	g.metrics.parseFailure(reason)
+	if !g.failures.parse {
+		return nil
+	}
+	return &requestRejection{status: status, reason: reason, message: message}
+}
@@ -0,0 +1,127 @@
+package trafico
+
+import (
+	"net/http"
+	"sort"
+
+	"github.com/alainrk/trafico/parser"
+)
+
+// FederationConfig enables recognition of Apollo Federation subgraph requests
+type FederationConfig struct {
+	Enabled bool `json:"enabled,omitempty"`
+	// EntityTypesHeader lists the __typename values of _entities representations
+	EntityTypesHeader string `json:"entityTypesHeader,omitempty"`
+	// RequestHeader tags the request as "entities" and/or "service"
+	RequestHeader string `json:"requestHeader,omitempty"`
+}
+
+const (
+	defaultEntityTypesHeader = "X-GraphQL-Entity-Types"
+	defaultFederationHeader  = "X-GraphQL-Federation"
+
+	entitiesField = "_entities"
+	serviceField  = "_service"
+)
+
+// federation tags subgraph traffic; a nil *federation does nothing
+type federation struct {
+	entityTypesHeader string
+	requestHeader     string
+	lists             listHeaders
+}
+
+func newFederation(config FederationConfig, lists listHeaders) *federation {
+	if !config.Enabled {
+		return nil
+	}
+	f := &federation{
+		entityTypesHeader: config.EntityTypesHeader,
+		requestHeader:     config.RequestHeader,
+		lists:             lists,
+	}
+	if f.entityTypesHeader == "" {
+		f.entityTypesHeader = defaultEntityTypesHeader
+	}
+	if f.requestHeader == "" {
+		f.requestHeader = defaultFederationHeader
+	}
+	return f
+}
+
+// entityTypes returns the sorted, distinct __typename values of the _entities
+// representations, whether passed as a variable or inline
+func (f *federation) entityTypes(doc *parser.Document, operationName string, variables map[string]any) []string {
+	seen := make(map[string]bool)
+	var types []string
+
+	for _, op := range executedOperations(doc, operationName) {
+		for _, field := range doc.RootFields(op) {
+			if field.Name != entitiesField {
+				continue
+			}
+			arg := field.Argument("representations")
+			if arg == nil {
+				continue
+			}
+			representations, _ := arg.Value.Resolve(variables).([]any)
+			for _, representation := range representations {
+				object, _ := representation.(map[string]any)
+				name, _ := object["__typename"].(string)
+				if name != "" && !seen[name] {
+					seen[name] = true
+					types = append(types, name)
+				}
+			}
+		}
+	}
+
+	sort.Strings(types)
+	return types
+}
+
+// setHeaders tags federation requests and exposes the requested entity types
+func (f *federation) setHeaders(header http.Header, parsed *ParsedRequest) {
+	if f == nil {
+		return
+	}
+	header.Del(f.requestHeader)
+	header.Del(f.entityTypesHeader)
+
+	var kinds []string
+	for _, field := range parsed.Queries {
+		switch field {
+		case entitiesField:
+			if !containsString(kinds, "entities") {
+				kinds = append(kinds, "entities")
+			}
+		case serviceField:
+			if !containsString(kinds, "service") {
+				kinds = append(kinds, "service")
+			}
+		}
+	}
+	if len(kinds) == 0 {
+		return
+	}
+	f.lists.set(header, f.requestHeader, kinds)
+
+	if containsString(kinds, "entities") {
+		var types []string
+		for _, entry := range parsed.entries() {
+			types = append(types, f.entityTypes(entry.Document, entry.Request.OperationName, entry.Request.Variables)...)
+		}
+		if types = sortedUnique(types); len(types) > 0 {
+			f.lists.set(header, f.entityTypesHeader, types)
+		}
+	}
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,145 @@
+package trafico
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+
+	"github.com/alainrk/trafico/parser"
+)
+
+// FieldPathsConfig enables extraction of the nested selection paths of the
+// executed operation (e.g. user.orders.items)
+type FieldPathsConfig struct {
+	Enabled bool `json:"enabled,omitempty"`
+	// MaxDepth is the number of path segments, root field included
+	MaxDepth int `json:"maxDepth,omitempty"`
+	// MaxPaths caps the number of paths; the sorted list is truncated
+	MaxPaths int `json:"maxPaths,omitempty"`
+	// Output is header (default), accessLog or both
+	Output string `json:"output,omitempty"`
+	Header string `json:"header,omitempty"`
+}
+
+const (
+	defaultFieldPathsHeader = "X-GraphQL-Field-Paths"
+	defaultFieldPathsDepth  = 3
+	defaultMaxFieldPaths    = 100
+
+	fieldPathsOutputHeader    = "header"
+	fieldPathsOutputAccessLog = "accessLog"
+	fieldPathsOutputBoth      = "both"
+)
+
+// fieldPathExtractor lists selection paths; a nil *fieldPathExtractor does nothing
+type fieldPathExtractor struct {
+	maxDepth int
+	maxPaths int
+	header   string
+	toHeader bool
+	toLog    bool
+	lists    listHeaders
+}
+
+func newFieldPathExtractor(config FieldPathsConfig, lists listHeaders) (*fieldPathExtractor, error) {
+	if !config.Enabled {
+		return nil, nil
+	}
+	if config.MaxDepth < 0 || config.MaxPaths < 0 {
+		return nil, fmt.Errorf("fieldPaths: maxDepth and maxPaths must not be negative")
+	}
+
+	e := &fieldPathExtractor{
+		maxDepth: config.MaxDepth,
+		maxPaths: config.MaxPaths,
+		header:   config.Header,
+		lists:    lists,
+	}
+	switch config.Output {
+	case "", fieldPathsOutputHeader:
+		e.toHeader = true
+	case fieldPathsOutputAccessLog:
+		e.toLog = true
+	case fieldPathsOutputBoth:
+		e.toHeader, e.toLog = true, true
+	default:
+		return nil, fmt.Errorf("fieldPaths: unknown output %q", config.Output)
+	}
+	if e.maxDepth == 0 {
+		e.maxDepth = defaultFieldPathsDepth
+	}
+	if e.maxPaths == 0 {
+		e.maxPaths = defaultMaxFieldPaths
+	}
+	if e.header == "" {
+		e.header = defaultFieldPathsHeader
+	}
+	return e, nil
+}
+
+// extract returns the sorted paths of the executed operations, capped at
+// maxPaths, and whether paths were dropped
+func (e *fieldPathExtractor) extract(parsed *ParsedRequest) ([]string, bool) {
+	if e == nil {
+		return nil, false
+	}
+
+	found := make(map[string]bool)
+	var doc *parser.Document
+	var walk func(prefix string, depth int, selections []*parser.Selection, visited map[string]bool)
+	walk = func(prefix string, depth int, selections []*parser.Selection, visited map[string]bool) {
+		for _, selection := range selections {
+			switch selection.Kind {
+			case parser.FieldSelection:
+				path := selection.Name
+				if prefix != "" {
+					path = prefix + "." + selection.Name
+				}
+				// Only the deepest paths are listed, their prefixes are implied
+				if depth == e.maxDepth || len(selection.SelectionSet) == 0 {
+					found[path] = true
+					continue
+				}
+				walk(path, depth+1, selection.SelectionSet, map[string]bool{})
+			case parser.InlineFragmentSelection:
+				walk(prefix, depth, selection.SelectionSet, visited)
+			case parser.FragmentSpreadSelection:
+				fragment := doc.Fragment(selection.Name)
+				if fragment == nil || visited[fragment.Name] {
+					continue
+				}
+				visited[fragment.Name] = true
+				walk(prefix, depth, fragment.SelectionSet, visited)
+			}
+		}
+	}
+	for _, entry := range parsed.entries() {
+		doc = entry.Document
+		for _, op := range executedOperations(doc, entry.Request.OperationName) {
+			walk("", 1, op.SelectionSet, map[string]bool{})
+		}
+	}
+
+	paths := make([]string, 0, len(found))
+	for path := range found {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	if len(paths) > e.maxPaths {
+		return paths[:e.maxPaths], true
+	}
+	return paths, false
+}
+
+// setHeader writes the paths when the header output is enabled and reports
+// whether the list was truncated
+func (e *fieldPathExtractor) setHeader(header http.Header, paths []string, truncated bool) bool {
+	if e == nil || !e.toHeader {
+		return false
+	}
+	header.Del(e.header)
+	if len(paths) == 0 {
+		return truncated
+	}
+	return e.lists.set(header, e.header, paths) || truncated
+}
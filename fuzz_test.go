@@ -0,0 +1,75 @@
+package trafico
+
+import (
+	"bytes"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+)
+
+// FuzzServeHTTP sends arbitrary bodies through a middleware with every
+// request-side extractor enabled; the request must always reach the next
+// handler with its body intact, and without the panic recovery kicking in,
+// unless it repeats the members identifying its operation
+func FuzzServeHTTP(f *testing.F) {
+	f.Add("application/json", `{"query":"query Q($id: ID!) { user(id: $id) { id ...F } } fragment F on User { name }","variables":{"id":"1"}}`)
+	f.Add("application/json", `{"query":"{ _entities(representations: [{__typename: \"User\"}]) { __typename } _service { sdl } }"}`)
+	f.Add("application/json", `{"query":"mutation { a b }","operationName":"missing","variables":{"tenant":{"id":[1,2]}},"extensions":{"clientInfo":{"name":1}}}`)
+	f.Add("application/json", `{"query":1,"variables":[]}`)
+	f.Add("application/json", `[{"query":"{ a }"}]`)
+	f.Add("application/json", `{"query":"mutation { a }","QUERY":"{ b }"}`)
+	f.Add("application/graphql", `{ user(id: "1") { orders { items { sku } } } }`)
+	f.Add("application/graphql", "\"\"\"")
+
+	var logs bytes.Buffer
+	log.SetOutput(&logs)
+	f.Cleanup(func() { log.SetOutput(os.Stderr) })
+
+	config := CreateConfig()
+	config.Variables = VariablesConfig{ExposeNames: true, Forward: []string{"id"}, Redact: []string{"password"}}
+	config.TenantSource = TenantSourceConfig{Variable: "tenant.id", Argument: "user.id"}
+	config.ExtractArgs = map[string][]string{"user": {"id"}}
+	config.Routing = RoutingConfig{Fields: map[string]string{"user": "users"}, DefaultService: "default", RewritePath: "/{service}"}
+	config.Federation.Enabled = true
+	config.Clients.Enabled = true
+	config.FieldPaths = FieldPathsConfig{Enabled: true, Output: fieldPathsOutputBoth}
+	config.MaxHeaderBytes = 64
+	config.Schema = "type Query { user(id: ID): User old: Int @deprecated } type User { id: ID name: String @deprecated(reason: \"x\") }"
+	config.Deprecations = DeprecationConfig{Enabled: true, ResponseWarning: true}
+	middleware, err := NewMiddleware(config, "fuzz")
+	if err != nil {
+		f.Fatal(err)
+	}
+
+	f.Fuzz(func(t *testing.T, contentType, body string) {
+		logs.Reset()
+		calls := 0
+		handler := middleware(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+			calls++
+			forwarded, err := io.ReadAll(req.Body)
+			if err != nil || string(forwarded) != body {
+				t.Errorf("body was not forwarded intact: %q", forwarded)
+			}
+		}))
+
+		req := httptest.NewRequest(http.MethodPost, "/graphql", strings.NewReader(body))
+		req.Header.Set("Content-Type", contentType)
+		rw := httptest.NewRecorder()
+		handler.ServeHTTP(rw, req)
+		if calls == 0 && rw.Code == http.StatusBadRequest && strings.Contains(rw.Body.String(), errAmbiguousMember.Error()) {
+			// Refused whatever the failure mode
+			return
+		}
+
+		if calls != 1 {
+			t.Errorf("next handler called %d times", calls)
+		}
+		if strings.Contains(logs.String(), "panic") {
+			t.Errorf("recovered from a panic: %s", logs.String())
+		}
+	})
+}
@@ -1,3 +1,3 @@
-module trafico
+module github.com/alainrk/trafico
 
 go 1.23.4
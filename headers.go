@@ -0,0 +1,115 @@
+package trafico
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+const (
+	headerFormatCSV   = "csv"
+	headerFormatJSON  = "json"
+	headerFormatMulti = "multi"
+
+	defaultHeaderDelimiter = ","
+
+	fieldsTruncatedHeader = "X-GraphQL-Fields-Truncated"
+)
+
+// listHeaders renders list-valued headers (fields, services, entity types...)
+// in the configured format
+type listHeaders struct {
+	format    string
+	delimiter string
+	// maxBytes caps the rendered value of each header, 0 means no limit
+	maxBytes int
+}
+
+func newListHeaders(format, delimiter string, maxBytes int) (listHeaders, error) {
+	switch format {
+	case "":
+		format = headerFormatCSV
+	case headerFormatCSV, headerFormatJSON, headerFormatMulti:
+	default:
+		return listHeaders{}, fmt.Errorf("unknown headerFormat %q, expected csv, json or multi", format)
+	}
+	if delimiter == "" {
+		delimiter = defaultHeaderDelimiter
+	}
+	if strings.ContainsAny(delimiter, "\r\n") {
+		return listHeaders{}, fmt.Errorf("headerDelimiter must not contain line breaks")
+	}
+	if maxBytes < 0 {
+		return listHeaders{}, fmt.Errorf("maxHeaderBytes must not be negative, got %d", maxBytes)
+	}
+	return listHeaders{format: format, delimiter: delimiter, maxBytes: maxBytes}, nil
+}
+
+// set replaces the header with the values: joined by the delimiter (csv), as a
+// JSON array (json) or as one header line per value (multi). Values that do not
+// fit in maxBytes are dropped from the end and set reports the truncation.
+func (l listHeaders) set(header http.Header, name string, values []string) bool {
+	kept := l.fit(values)
+	truncated := kept < len(values)
+	if kept == 0 {
+		header.Del(name)
+		return truncated
+	}
+	values = values[:kept]
+
+	switch l.format {
+	case headerFormatJSON:
+		data, _ := json.Marshal(values)
+		header.Set(name, string(data))
+	case headerFormatMulti:
+		header.Del(name)
+		for _, value := range values {
+			header.Add(name, value)
+		}
+	default:
+		header.Set(name, strings.Join(values, l.delimiter))
+	}
+	return truncated
+}
+
+// setValues writes a single value as is and several values as a list, for
+// headers that normally carry one value but may get several from a batch
+func (l listHeaders) setValues(header http.Header, name string, values []string) bool {
+	if len(values) == 1 {
+		header.Set(name, values[0])
+		return false
+	}
+	return l.set(header, name, values)
+}
+
+// fit returns how many leading values can be rendered within maxBytes
+func (l listHeaders) fit(values []string) int {
+	if l.maxBytes == 0 {
+		return len(values)
+	}
+
+	size := 0
+	for i, value := range values {
+		switch l.format {
+		case headerFormatJSON:
+			data, _ := json.Marshal(value)
+			// Brackets on the first value, a comma before the others
+			size += len(data) + 1
+			if i == 0 {
+				size++
+			}
+		case headerFormatMulti:
+			size += len(value)
+		default:
+			size += len(value)
+			if i > 0 {
+				size += len(l.delimiter)
+			}
+		}
+		if size > l.maxBytes {
+			return i
+		}
+	}
+	return len(values)
+}
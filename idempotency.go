@@ -0,0 +1,388 @@
+package trafico
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// IdempotencyConfig requires an idempotency key on documents holding
+// mutations and answers replays of a key without reaching the backend. Keys
+// are scoped to the client sending them: its clientIdentity, else its
+// Authorization header, else its IP.
+type IdempotencyConfig struct {
+	Enabled bool `json:"enabled,omitempty"`
+	// Header carries the key, Idempotency-Key by default
+	Header string `json:"header,omitempty"`
+	// Optional forwards mutations sent without a key, untracked
+	Optional bool `json:"optional,omitempty"`
+	// TTL is how long a key is remembered, 1h by default
+	TTL string `json:"ttl,omitempty"`
+	// MaxKeys bounds the keys remembered in memory, the oldest being evicted
+	// first
+	MaxKeys int `json:"maxKeys,omitempty"`
+	// Replay answers a replayed key with the stored response, when it was
+	// no larger than MaxResponseKB; otherwise replays get a 409
+	Replay        bool `json:"replay,omitempty"`
+	MaxResponseKB int  `json:"maxResponseKB,omitempty"`
+}
+
+const (
+	defaultIdempotencyHeader        = "Idempotency-Key"
+	defaultIdempotencyTTL           = time.Hour
+	defaultIdempotencyMaxKeys       = 10000
+	defaultIdempotencyMaxResponseKB = 64
+
+	idempotentReplayedHeader = "Idempotent-Replayed"
+)
+
+// unreplayedHeaders are response headers belonging to the original exchange,
+// which replays do not repeat
+var unreplayedHeaders = []string{
+	"Set-Cookie", "Connection", "Keep-Alive", "Proxy-Authenticate", "Proxy-Connection",
+	"Te", "Trailer", "Transfer-Encoding", "Upgrade",
+}
+
+// idempotencyStore remembers recent keys and their responses, in memory or in
+// the shared storage; a nil *idempotencyStore does nothing
+type idempotencyStore struct {
+	header           string
+	optional         bool
+	ttl              time.Duration
+	maxKeys          int
+	replay           bool
+	maxResponseBytes int
+	shared           *redisClient
+	// requestIDHeader is not replayed either, each request having its own
+	requestIDHeader string
+
+	mu      sync.Mutex
+	entries map[string]*list.Element
+	// order lists the entries from the oldest to the most recent
+	order *list.List
+}
+
+// idempotencyEntry is a key being served or served; the response is kept
+// once complete when it can be replayed
+type idempotencyEntry struct {
+	key         string
+	fingerprint string
+	expires     time.Time
+	done        bool
+
+	status int
+	header http.Header
+	body   []byte
+}
+
+// sharedIdempotencyEntry is an entry as kept in the shared storage
+type sharedIdempotencyEntry struct {
+	Fingerprint string      `json:"fingerprint"`
+	Done        bool        `json:"done,omitempty"`
+	Status      int         `json:"status,omitempty"`
+	Header      http.Header `json:"header,omitempty"`
+	Body        []byte      `json:"body,omitempty"`
+}
+
+func newIdempotencyStore(config IdempotencyConfig, storage *redisClient, requestIDHeader string) (*idempotencyStore, error) {
+	if !config.Enabled {
+		return nil, nil
+	}
+	if config.MaxKeys < 0 || config.MaxResponseKB < 0 {
+		return nil, fmt.Errorf("idempotency: maxKeys and maxResponseKB must not be negative")
+	}
+
+	s := &idempotencyStore{
+		header:           config.Header,
+		optional:         config.Optional,
+		ttl:              defaultIdempotencyTTL,
+		maxKeys:          config.MaxKeys,
+		replay:           config.Replay,
+		maxResponseBytes: config.MaxResponseKB * 1024,
+		shared:           storage,
+		requestIDHeader:  requestIDHeader,
+		entries:          make(map[string]*list.Element),
+		order:            list.New(),
+	}
+	if s.header == "" {
+		s.header = defaultIdempotencyHeader
+	}
+	if s.requestIDHeader == "" {
+		s.requestIDHeader = defaultRequestIDHeader
+	}
+	if config.TTL != "" {
+		d, err := time.ParseDuration(config.TTL)
+		if err != nil || d <= 0 {
+			return nil, fmt.Errorf("idempotency: invalid ttl %q", config.TTL)
+		}
+		s.ttl = d
+	}
+	if s.maxKeys == 0 {
+		s.maxKeys = defaultIdempotencyMaxKeys
+	}
+	if s.maxResponseBytes == 0 {
+		s.maxResponseBytes = defaultIdempotencyMaxResponseKB * 1024
+	}
+	return s, nil
+}
+
+// hasMutation reports whether any executed operation of the request is a mutation
+func hasMutation(parsed *ParsedRequest) bool {
+	for _, entry := range parsed.entries() {
+		for _, op := range executedOperations(entry.Document, entry.Request.OperationName) {
+			if op.Type == "mutation" {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// requestFingerprint identifies the documents and variables sent with a key,
+// so that a key reused for another request is detected
+func requestFingerprint(parsed *ParsedRequest) string {
+	h := sha256.New()
+	for _, entry := range parsed.entries() {
+		h.Write([]byte(operationFingerprint(entry.Request.Query)))
+		h.Write([]byte(entry.Request.OperationName))
+		variables, _ := json.Marshal(entry.Request.Variables)
+		h.Write(variables)
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// checkIdempotency applies the idempotency policy to mutations. It returns
+// the entry to complete with the response of a new key, and reports whether
+// the request was answered (rejected or replayed).
+func (g *GraphQLParser) checkIdempotency(rw http.ResponseWriter, req *http.Request, parsed *ParsedRequest) (*idempotencyEntry, bool) {
+	s := g.idempotency
+	if s == nil || !hasMutation(parsed) {
+		return nil, false
+	}
+
+	key := req.Header.Get(s.header)
+	if key == "" {
+		if s.optional {
+			return nil, false
+		}
+		return nil, g.rejectIdempotency(rw, req, parsed, http.StatusBadRequest, "missing_idempotency_key",
+			"mutations require an "+s.header+" header")
+	}
+
+	fingerprint := requestFingerprint(parsed)
+	entry, existing := s.begin(idempotencyScope(req, parsed)+"\x00"+key, fingerprint)
+	switch {
+	case !existing:
+		return entry, false
+	case entry.fingerprint != fingerprint:
+		return nil, g.rejectIdempotency(rw, req, parsed, http.StatusUnprocessableEntity, "idempotency_key_reused",
+			"the "+s.header+" was already used for a different request")
+	case !entry.done:
+		return nil, g.rejectIdempotency(rw, req, parsed, http.StatusConflict, "idempotency_key_in_use",
+			"a request with this "+s.header+" is still being processed")
+	case entry.header == nil:
+		return nil, g.rejectIdempotency(rw, req, parsed, http.StatusConflict, "duplicate_request",
+			"a request with this "+s.header+" was already processed")
+	}
+
+	for name, values := range entry.header {
+		rw.Header()[name] = values
+	}
+	rw.Header().Set(idempotentReplayedHeader, "true")
+	rw.WriteHeader(entry.status)
+	_, _ = rw.Write(entry.body)
+	g.record(req, parsed, parsed.decision(), "idempotent_replay", entry.status, 0)
+	return nil, true
+}
+
+// idempotencyScope returns the client the keys of the request belong to, so
+// that a client cannot replay the responses of another one
+func idempotencyScope(req *http.Request, parsed *ParsedRequest) string {
+	if parsed.ClientIdentity != "" {
+		return parsed.ClientIdentity
+	}
+	if authorization := req.Header.Get("Authorization"); authorization != "" {
+		sum := sha256.Sum256([]byte(authorization))
+		return "authorization:" + hex.EncodeToString(sum[:8])
+	}
+	return identityIP + ":" + clientIP(req)
+}
+
+// rejectIdempotency rejects the request unless the idempotency rule is in dry run
+func (g *GraphQLParser) rejectIdempotency(rw http.ResponseWriter, req *http.Request, parsed *ParsedRequest, status int, reason, message string) bool {
+	if !g.enforce(req, parsed, status, reason, message) {
+		return false
+	}
+	g.reject(rw, req, parsed, status, reason, message)
+	return true
+}
+
+// begin returns the live entry of the key, or registers a new one
+func (s *idempotencyStore) begin(key, fingerprint string) (*idempotencyEntry, bool) {
+	if s.shared != nil {
+		return s.beginShared(key, fingerprint)
+	}
+	now := time.Now()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if element, ok := s.entries[key]; ok {
+		entry := element.Value.(*idempotencyEntry)
+		if now.Before(entry.expires) {
+			return entry, true
+		}
+		s.remove(element)
+	}
+
+	// Expired entries are evicted first, then the oldest ones
+	for s.order.Len() > 0 {
+		oldest := s.order.Front()
+		if s.order.Len() < s.maxKeys && now.Before(oldest.Value.(*idempotencyEntry).expires) {
+			break
+		}
+		s.remove(oldest)
+	}
+
+	entry := &idempotencyEntry{key: key, fingerprint: fingerprint, expires: now.Add(s.ttl)}
+	s.entries[key] = s.order.PushBack(entry)
+	return entry, false
+}
+
+// complete stores the response of the key; server errors release the key so
+// that the client can retry
+func (s *idempotencyStore) complete(entry *idempotencyEntry, recorder *statusRecorder) {
+	if entry == nil {
+		return
+	}
+	status := recorder.Status()
+	if status >= http.StatusInternalServerError {
+		s.release(entry)
+		return
+	}
+	if s.shared != nil {
+		s.completeShared(entry, status, recorder)
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	element, ok := s.entries[entry.key]
+	if !ok || element.Value != entry {
+		return
+	}
+	entry.done = true
+	entry.status = status
+	if s.replay && recorder.body != nil && !recorder.overflow {
+		entry.header = s.replayedHeader(recorder.Header())
+		entry.body = append([]byte(nil), recorder.body.Bytes()...)
+	}
+}
+
+// release forgets the key of a request that did not get through, such as
+// one rejected before reaching the backend, so that the client can retry it
+func (s *idempotencyStore) release(entry *idempotencyEntry) {
+	if entry == nil {
+		return
+	}
+	if s.shared != nil {
+		if _, err := s.shared.do("DEL", s.sharedKey(entry.key)); err != nil {
+			logf("idempotency storage failed: %v", err)
+		}
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if element, ok := s.entries[entry.key]; ok && element.Value == entry {
+		s.remove(element)
+	}
+}
+
+// replayedHeader returns the response headers replays repeat
+func (s *idempotencyStore) replayedHeader(header http.Header) http.Header {
+	replayed := header.Clone()
+	// Headers listed in Connection are hop-by-hop too
+	for _, value := range header.Values("Connection") {
+		for _, name := range strings.Split(value, ",") {
+			replayed.Del(strings.TrimSpace(name))
+		}
+	}
+	for _, name := range unreplayedHeaders {
+		replayed.Del(name)
+	}
+	replayed.Del(s.requestIDHeader)
+	return replayed
+}
+
+func (s *idempotencyStore) remove(element *list.Element) {
+	delete(s.entries, element.Value.(*idempotencyEntry).key)
+	s.order.Remove(element)
+}
+
+// sharedKey is the storage key of an idempotency key, hashed to bound its size
+func (s *idempotencyStore) sharedKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return s.shared.key("idempotency", hex.EncodeToString(sum[:]))
+}
+
+// beginShared claims the key in the shared storage, or returns the entry of
+// the replica that claimed it. Storage failures let the request through
+// untracked.
+func (s *idempotencyStore) beginShared(key, fingerprint string) (*idempotencyEntry, bool) {
+	claim, _ := json.Marshal(sharedIdempotencyEntry{Fingerprint: fingerprint})
+	ttl := strconv.FormatInt(s.ttl.Milliseconds(), 10)
+	// A key expiring between SET and GET is claimed again
+	for attempt := 0; attempt < 2; attempt++ {
+		reply, err := s.shared.do("SET", s.sharedKey(key), string(claim), "NX", "PX", ttl)
+		if err != nil {
+			logf("idempotency storage failed: %v", err)
+			return nil, false
+		}
+		if reply != nil {
+			return &idempotencyEntry{key: key, fingerprint: fingerprint}, false
+		}
+
+		reply, err = s.shared.do("GET", s.sharedKey(key))
+		if err != nil {
+			logf("idempotency storage failed: %v", err)
+			return nil, false
+		}
+		data, ok := reply.(string)
+		if !ok {
+			continue
+		}
+		var stored sharedIdempotencyEntry
+		if err := json.Unmarshal([]byte(data), &stored); err != nil {
+			logf("idempotency storage holds an invalid entry: %v", err)
+			return nil, false
+		}
+		return &idempotencyEntry{
+			key:         key,
+			fingerprint: stored.Fingerprint,
+			done:        stored.Done,
+			status:      stored.Status,
+			header:      stored.Header,
+			body:        stored.Body,
+		}, true
+	}
+	return nil, false
+}
+
+// completeShared stores the response of the key, keeping its expiry
+func (s *idempotencyStore) completeShared(entry *idempotencyEntry, status int, recorder *statusRecorder) {
+	stored := sharedIdempotencyEntry{Fingerprint: entry.fingerprint, Done: true, Status: status}
+	if s.replay && recorder.body != nil && !recorder.overflow {
+		stored.Header = s.replayedHeader(recorder.Header())
+		stored.Body = recorder.body.Bytes()
+	}
+	data, _ := json.Marshal(stored)
+	if _, err := s.shared.do("SET", s.sharedKey(entry.key), string(data), "XX", "KEEPTTL"); err != nil {
+		logf("idempotency storage failed: %v", err)
+	}
+}
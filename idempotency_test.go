@@ -0,0 +1,186 @@
+package trafico
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newTestHandler builds the middleware around next
+func newTestHandler(t *testing.T, config *Config, next http.HandlerFunc) http.Handler {
+	t.Helper()
+	middleware, err := NewMiddleware(config, t.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return middleware(next)
+}
+
+// postGraphQL sends a JSON GraphQL request with the given headers
+func postGraphQL(handler http.Handler, body string, header map[string]string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPost, "/graphql", strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	for name, value := range header {
+		req.Header.Set(name, value)
+	}
+	rw := httptest.NewRecorder()
+	handler.ServeHTTP(rw, req)
+	return rw
+}
+
+func TestIdempotencyReplay(t *testing.T) {
+	config := CreateConfig()
+	config.Idempotency = IdempotencyConfig{Enabled: true, Replay: true}
+	calls := 0
+	handler := newTestHandler(t, config, func(rw http.ResponseWriter, req *http.Request) {
+		calls++
+		if req.Header.Get("X-Fail") != "" {
+			rw.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		rw.Header().Set("Set-Cookie", "session=1")
+		rw.Header().Set("X-Call", strconv.Itoa(calls))
+		_, _ = rw.Write([]byte(`{"data":{"call":` + strconv.Itoa(calls) + `}}`))
+	})
+
+	const (
+		mutation = `{"query":"mutation { a }"}`
+		other    = `{"query":"mutation { b }"}`
+	)
+	steps := []struct {
+		name     string
+		body     string
+		header   map[string]string
+		status   int
+		calls    int
+		replayed bool
+	}{
+		{"first", mutation, map[string]string{"Idempotency-Key": "k1"}, http.StatusOK, 1, false},
+		{"replay", mutation, map[string]string{"Idempotency-Key": "k1"}, http.StatusOK, 1, true},
+		{"key reused", other, map[string]string{"Idempotency-Key": "k1"}, http.StatusUnprocessableEntity, 1, false},
+		{"missing key", mutation, nil, http.StatusBadRequest, 1, false},
+		{"query without key", `{"query":"{ a }"}`, nil, http.StatusOK, 2, false},
+		{"other client", mutation, map[string]string{"Idempotency-Key": "k1", "Authorization": "Bearer other"}, http.StatusOK, 3, false},
+		{"server error", mutation, map[string]string{"Idempotency-Key": "k2", "X-Fail": "1"}, http.StatusBadGateway, 4, false},
+		{"retry after server error", mutation, map[string]string{"Idempotency-Key": "k2"}, http.StatusOK, 5, false},
+	}
+	var first *httptest.ResponseRecorder
+	for _, step := range steps {
+		rw := postGraphQL(handler, step.body, step.header)
+		if rw.Code != step.status || calls != step.calls {
+			t.Fatalf("%s: status %d after %d calls, want %d after %d: %s", step.name, rw.Code, calls, step.status, step.calls, rw.Body.String())
+		}
+		if replayed := rw.Header().Get(idempotentReplayedHeader) == "true"; replayed != step.replayed {
+			t.Errorf("%s: replayed %v, want %v", step.name, replayed, step.replayed)
+		}
+		switch {
+		case first == nil:
+			first = rw
+		case step.replayed:
+			if rw.Body.String() != first.Body.String() || rw.Header().Get("X-Call") != "1" {
+				t.Errorf("%s: replayed %q, want %q", step.name, rw.Body.String(), first.Body.String())
+			}
+			if rw.Header().Get("Set-Cookie") != "" {
+				t.Errorf("%s: Set-Cookie was replayed", step.name)
+			}
+		}
+	}
+}
+
+func TestIdempotencyKeyInUse(t *testing.T) {
+	config := CreateConfig()
+	config.Idempotency = IdempotencyConfig{Enabled: true}
+	var handler http.Handler
+	var concurrent *httptest.ResponseRecorder
+	handler = newTestHandler(t, config, func(rw http.ResponseWriter, req *http.Request) {
+		if concurrent == nil {
+			concurrent = postGraphQL(handler, `{"query":"mutation { a }"}`, map[string]string{"Idempotency-Key": "k"})
+		}
+	})
+
+	if rw := postGraphQL(handler, `{"query":"mutation { a }"}`, map[string]string{"Idempotency-Key": "k"}); rw.Code != http.StatusOK {
+		t.Fatalf("status %d: %s", rw.Code, rw.Body.String())
+	}
+	if concurrent.Code != http.StatusConflict {
+		t.Errorf("concurrent request: status %d, want 409", concurrent.Code)
+	}
+	// Without replay, a processed key is a duplicate
+	if rw := postGraphQL(handler, `{"query":"mutation { a }"}`, map[string]string{"Idempotency-Key": "k"}); rw.Code != http.StatusConflict {
+		t.Errorf("duplicate: status %d, want 409", rw.Code)
+	}
+}
+
+func TestIdempotencyReleasedOnQuotaRejection(t *testing.T) {
+	config := CreateConfig()
+	config.Idempotency = IdempotencyConfig{Enabled: true, Replay: true}
+	config.Quota = QuotaConfig{Enabled: true, Limit: 1}
+	calls := 0
+	handler := newTestHandler(t, config, func(rw http.ResponseWriter, req *http.Request) {
+		calls++
+	})
+
+	header := func(key string) map[string]string {
+		return map[string]string{"Idempotency-Key": key, "X-API-Key": "client"}
+	}
+	if rw := postGraphQL(handler, `{"query":"mutation { a }"}`, header("k1")); rw.Code != http.StatusOK {
+		t.Fatalf("k1: status %d: %s", rw.Code, rw.Body.String())
+	}
+	if rw := postGraphQL(handler, `{"query":"mutation { b }"}`, header("k2")); rw.Code != http.StatusTooManyRequests {
+		t.Fatalf("k2: status %d, want 429: %s", rw.Code, rw.Body.String())
+	}
+	// The key was not processed: retrying it is rejected by the quota, not
+	// as a key in use
+	if rw := postGraphQL(handler, `{"query":"mutation { b }"}`, header("k2")); rw.Code != http.StatusTooManyRequests {
+		t.Errorf("k2 retried: status %d, want 429: %s", rw.Code, rw.Body.String())
+	}
+	if calls != 1 {
+		t.Errorf("%d calls, want 1", calls)
+	}
+}
+
+func TestIdempotencyReleasedOnOpenCircuit(t *testing.T) {
+	config := CreateConfig()
+	config.Idempotency = IdempotencyConfig{Enabled: true}
+	config.CircuitBreaker = CircuitBreakerConfig{Enabled: true, MinRequests: 1, Cooldown: "50ms"}
+	failing := true
+	handler := newTestHandler(t, config, func(rw http.ResponseWriter, req *http.Request) {
+		if failing {
+			rw.WriteHeader(http.StatusInternalServerError)
+		}
+	})
+
+	if rw := postGraphQL(handler, `{"query":"mutation { a }"}`, map[string]string{"Idempotency-Key": "k1"}); rw.Code != http.StatusInternalServerError {
+		t.Fatalf("k1: status %d, want 500", rw.Code)
+	}
+	if rw := postGraphQL(handler, `{"query":"mutation { a }"}`, map[string]string{"Idempotency-Key": "k2"}); rw.Code != http.StatusServiceUnavailable {
+		t.Fatalf("k2: status %d, want 503: %s", rw.Code, rw.Body.String())
+	}
+	// Once the circuit cooled down, the key rejected while it was open goes through
+	failing = false
+	time.Sleep(100 * time.Millisecond)
+	if rw := postGraphQL(handler, `{"query":"mutation { a }"}`, map[string]string{"Idempotency-Key": "k2"}); rw.Code != http.StatusOK {
+		t.Errorf("k2 retried: status %d, want 200: %s", rw.Code, rw.Body.String())
+	}
+}
+
+func TestReplayedHeader(t *testing.T) {
+	s, err := newIdempotencyStore(IdempotencyConfig{Enabled: true}, nil, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	header := http.Header{}
+	header.Set("Content-Type", "application/json")
+	header.Set("Set-Cookie", "session=1")
+	header.Set("Connection", "X-Hop, keep-alive")
+	header.Set("X-Hop", "1")
+	header.Set("Transfer-Encoding", "chunked")
+	header.Set(defaultRequestIDHeader, "abc")
+
+	replayed := s.replayedHeader(header)
+	if len(replayed) != 1 || replayed.Get("Content-Type") != "application/json" {
+		t.Errorf("replayed %v, want the Content-Type only", replayed)
+	}
+}
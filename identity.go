@@ -0,0 +1,183 @@
+package trafico
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// ClientIdentityConfig resolves who sends each request from the first of
+// Sources that yields a value, for quotas and telemetry to agree on
+// it. The identity is forwarded in Header and added to the access log, the
+// events and the spans.
+type ClientIdentityConfig struct {
+	Enabled bool                   `json:"enabled,omitempty"`
+	Sources []IdentitySourceConfig `json:"sources,omitempty"`
+	// Header carries the identity to the backend, X-Client-Identity by default
+	Header string `json:"header,omitempty"`
+}
+
+// IdentitySourceConfig is a source of the client identity
+type IdentitySourceConfig struct {
+	// Type is jwt (a claim of a bearer token), apiKey (a header, hashed as
+	// keys are secrets), tlsSubject (the subject CN of the client certificate
+	// Traefik passes with passTLSClientCert) or ip (the remote address)
+	Type string `json:"type,omitempty"`
+	// Header is read by the source: Authorization for jwt, X-API-Key for
+	// apiKey and X-Forwarded-Tls-Client-Cert-Info for tlsSubject by default
+	Header string `json:"header,omitempty"`
+	// Claim is the JWT claim, sub by default. Tokens are not verified: an
+	// authentication middleware must run before this one.
+	Claim string `json:"claim,omitempty"`
+}
+
+const (
+	defaultIdentityHeader = "X-Client-Identity"
+	defaultTLSInfoHeader  = "X-Forwarded-Tls-Client-Cert-Info"
+	defaultJWTClaim       = "sub"
+
+	identityJWT        = "jwt"
+	identityAPIKey     = "apiKey"
+	identityTLSSubject = "tlsSubject"
+	identityIP         = "ip"
+)
+
+// identitySource is a compiled IdentitySourceConfig
+type identitySource struct {
+	kind   string
+	header string
+	claim  string
+}
+
+// identityResolver resolves client identities; a nil *identityResolver
+// resolves none
+type identityResolver struct {
+	sources []identitySource
+	header  string
+}
+
+func newIdentityResolver(config ClientIdentityConfig) (*identityResolver, error) {
+	if !config.Enabled {
+		return nil, nil
+	}
+	if len(config.Sources) == 0 {
+		return nil, fmt.Errorf("clientIdentity: sources is required")
+	}
+	r := &identityResolver{header: config.Header}
+	if r.header == "" {
+		r.header = defaultIdentityHeader
+	}
+	for _, sc := range config.Sources {
+		source := identitySource{kind: sc.Type, header: sc.Header, claim: sc.Claim}
+		switch sc.Type {
+		case identityJWT:
+			if source.header == "" {
+				source.header = "Authorization"
+			}
+			if source.claim == "" {
+				source.claim = defaultJWTClaim
+			}
+		case identityAPIKey:
+			if source.header == "" {
+				source.header = defaultQuotaKeyHeader
+			}
+		case identityTLSSubject:
+			if source.header == "" {
+				source.header = defaultTLSInfoHeader
+			}
+		case identityIP:
+		default:
+			return nil, fmt.Errorf("clientIdentity: unknown source type %q, expected jwt, apiKey, tlsSubject or ip", sc.Type)
+		}
+		r.sources = append(r.sources, source)
+	}
+	return r, nil
+}
+
+// resolve returns the identity of the request, prefixed by the kind of its
+// source so that identities of different sources never collide, and
+// forwards it in the identity header
+func (r *identityResolver) resolve(req *http.Request) string {
+	if r == nil {
+		return ""
+	}
+	req.Header.Del(r.header)
+	for _, source := range r.sources {
+		var identity string
+		switch source.kind {
+		case identityJWT:
+			identity = jwtClaim(req.Header.Get(source.header), source.claim)
+		case identityAPIKey:
+			if key := req.Header.Get(source.header); key != "" {
+				sum := sha256.Sum256([]byte(key))
+				identity = hex.EncodeToString(sum[:8])
+			}
+		case identityTLSSubject:
+			identity = tlsSubjectCN(req.Header.Get(source.header))
+		case identityIP:
+			identity = clientIP(req)
+		}
+		if identity = normalizeClientInfo(identity); identity != "" {
+			identity = source.kind + ":" + identity
+			req.Header.Set(r.header, identity)
+			return identity
+		}
+	}
+	return ""
+}
+
+// jwtClaim returns a string or number claim of a bearer token, without
+// verifying the token
+func jwtClaim(authorization, claim string) string {
+	token := strings.TrimSpace(authorization)
+	if len(token) > 7 && strings.EqualFold(token[:7], "bearer ") {
+		token = strings.TrimSpace(token[7:])
+	}
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return ""
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return ""
+	}
+	var claims map[string]any
+	if err := json.Unmarshal(payload, &claims); err != nil {
+		return ""
+	}
+	switch value := claims[claim].(type) {
+	case string:
+		return value
+	case float64:
+		return headerValue(value)
+	}
+	return ""
+}
+
+// tlsSubjectCN returns the subject common name of the certificate info
+// Traefik passes, such as Subject="C=FR,O=Acme,CN=client1";Issuer="..."
+func tlsSubjectCN(info string) string {
+	if info == "" {
+		return ""
+	}
+	if unescaped, err := url.QueryUnescape(info); err == nil {
+		info = unescaped
+	}
+	// The first subject is the one of the client certificate
+	_, subject, ok := strings.Cut(info, `Subject="`)
+	if !ok {
+		return ""
+	}
+	subject, _, _ = strings.Cut(subject, `"`)
+	for _, attribute := range strings.Split(subject, ",") {
+		if cn, ok := strings.CutPrefix(strings.TrimSpace(attribute), "CN="); ok {
+			return cn
+		}
+	}
+	return ""
+}
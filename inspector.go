@@ -0,0 +1,59 @@
+package trafico
+
+import (
+	"context"
+	"errors"
+	"net/http"
+)
+
+// Inspector is an extension point of the library mode: inspectors run in order
+// for every parsed GraphQL request, after the plugin headers are set and before
+// the request is forwarded. They may add headers to req, record metrics or
+// validate the request; a non-nil error rejects it.
+type Inspector interface {
+	Inspect(ctx context.Context, parsed *ParsedRequest, req *http.Request) error
+}
+
+// InspectorFunc adapts a function to the Inspector interface
+type InspectorFunc func(ctx context.Context, parsed *ParsedRequest, req *http.Request) error
+
+// Inspect calls f
+func (f InspectorFunc) Inspect(ctx context.Context, parsed *ParsedRequest, req *http.Request) error {
+	return f(ctx, parsed, req)
+}
+
+// RejectError is returned by an Inspector to choose how the request is
+// rejected; any other error rejects it with 400 Bad Request
+type RejectError struct {
+	Status  int
+	Reason  string
+	Message string
+}
+
+func (e *RejectError) Error() string {
+	return e.Message
+}
+
+// inspect runs the inspectors and returns the rejection of the first failing one
+func (g *GraphQLParser) inspect(req *http.Request, parsed *ParsedRequest) *RejectError {
+	for _, inspector := range g.inspectors {
+		err := inspector.Inspect(req.Context(), parsed, req)
+		if err == nil {
+			continue
+		}
+
+		var reject *RejectError
+		if !errors.As(err, &reject) {
+			return &RejectError{Status: http.StatusBadRequest, Reason: "inspector", Message: err.Error()}
+		}
+		rejection := *reject
+		if rejection.Status == 0 {
+			rejection.Status = http.StatusBadRequest
+		}
+		if rejection.Reason == "" {
+			rejection.Reason = "inspector"
+		}
+		return &rejection
+	}
+	return nil
+}
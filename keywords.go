@@ -0,0 +1,73 @@
+package trafico
+
+import (
+	"strings"
+
+	"github.com/alainrk/trafico/parser"
+)
+
+// graphqlKeywords are reserved words kept out of the headers
+var graphqlKeywords = map[string]bool{
+	"query":        true,
+	"mutation":     true,
+	"subscription": true,
+	"fragment":     true,
+	"on":           true,
+	"true":         true,
+	"false":        true,
+	"null":         true,
+	"type":         true,
+	"input":        true,
+	"interface":    true,
+	"union":        true,
+	"enum":         true,
+	"scalar":       true,
+	"schema":       true,
+	"extend":       true,
+	"implements":   true,
+	"directive":    true,
+}
+
+// isGraphQLKeyword checks if a word is a GraphQL keyword
+func isGraphQLKeyword(word string) bool {
+	return graphqlKeywords[strings.ToLower(word)]
+}
+
+// fieldFilter decides which root fields are kept out of the field headers:
+// GraphQL keywords and additionalReservedWords, compared case-insensitively,
+// and ignoredFields, compared exactly
+type fieldFilter struct {
+	reserved map[string]bool
+	ignored  map[string]bool
+}
+
+func newFieldFilter(additionalReservedWords, ignoredFields []string) fieldFilter {
+	f := fieldFilter{}
+	for _, word := range additionalReservedWords {
+		if word == "" {
+			continue
+		}
+		if f.reserved == nil {
+			f.reserved = make(map[string]bool)
+		}
+		f.reserved[strings.ToLower(word)] = true
+	}
+	for _, field := range ignoredFields {
+		if field == "" {
+			continue
+		}
+		if f.ignored == nil {
+			f.ignored = make(map[string]bool)
+		}
+		f.ignored[field] = true
+	}
+	return f
+}
+
+// excludes reports whether the root field is left out of the headers
+func (f fieldFilter) excludes(field *parser.Selection) bool {
+	if isGraphQLKeyword(field.Name) || f.ignored[field.Name] {
+		return true
+	}
+	return f.reserved != nil && f.reserved[strings.ToLower(field.Name)]
+}
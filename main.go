@@ -1,19 +1,162 @@
 package trafico
 
 import (
-	"bytes"
 	"context"
-	"encoding/json"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
 	"io"
+	"log"
 	"net/http"
-	"regexp"
+	"sort"
 	"strings"
+	"time"
+
+	"github.com/alainrk/trafico/parser"
 )
 
 // Config holds the plugin configuration
 type Config struct {
 	QueryHeader    string `json:"queryHeader,omitempty"`
 	MutationHeader string `json:"mutationHeader,omitempty"`
+	// FieldNames selects what the query and mutation headers list for aliased
+	// root fields: field (the real name, default), alias, or pair (alias=field)
+	FieldNames string `json:"fieldNames,omitempty"`
+
+	// AdditionalReservedWords extends the GraphQL keywords kept out of the
+	// field headers (case-insensitive) and IgnoredFields lists root fields
+	// left out as well, such as __typename
+	AdditionalReservedWords []string `json:"additionalReservedWords,omitempty"`
+	IgnoredFields           []string `json:"ignoredFields,omitempty"`
+	// HeaderFormat renders list-valued headers as csv (default), json or multi
+	// (one header line per value); HeaderDelimiter separates csv values
+	HeaderFormat    string `json:"headerFormat,omitempty"`
+	HeaderDelimiter string `json:"headerDelimiter,omitempty"`
+	// MaxHeaderBytes caps each list-valued header, dropping the last values;
+	// X-GraphQL-Fields-Truncated is set when a field header is capped
+	MaxHeaderBytes int `json:"maxHeaderBytes,omitempty"`
+
+	// MaxBufferedBodyKB bounds the memory used per request: only the first
+	// kilobytes of larger bodies are buffered to identify the operation, the
+	// rest is streamed downstream; 0 buffers whole bodies
+	MaxBufferedBodyKB int `json:"maxBufferedBodyKB,omitempty"`
+	// MaxFormBodyKB caps the application/x-www-form-urlencoded bodies that
+	// are decoded, 1024 by default; larger ones are not inspected
+	MaxFormBodyKB int `json:"maxFormBodyKB,omitempty"`
+
+	// MaxTokens and MaxSelectionSetNodes bound the parsing work per document;
+	// documents exceeding them are rejected. 0 means no limit.
+	MaxTokens            int `json:"maxTokens,omitempty"`
+	MaxSelectionSetNodes int `json:"maxSelectionSetNodes,omitempty"`
+	// MaxFieldsPerLevel and MaxTotalFields bound the fields an operation
+	// selects at any one depth and in all, fragments expanded, to catch wide
+	// and shallow documents. 0 means no limit.
+	MaxFieldsPerLevel int `json:"maxFieldsPerLevel,omitempty"`
+	MaxTotalFields    int `json:"maxTotalFields,omitempty"`
+	// MaxDepth bounds the nesting of selection sets and values, 128 by default
+	MaxDepth int `json:"maxDepth,omitempty"`
+
+	// RequireOperationName rejects anonymous operations, and documents with
+	// several operations that do not select one with operationName
+	RequireOperationName bool `json:"requireOperationName,omitempty"`
+
+	// MaxOperationsPerDocument bounds the operations a document defines and
+	// MaxBatchSize the requests of a batched body; 0 means no limit
+	MaxOperationsPerDocument int `json:"maxOperationsPerDocument,omitempty"`
+	MaxBatchSize             int `json:"maxBatchSize,omitempty"`
+
+	Metrics   MetricsConfig   `json:"metrics,omitempty"`
+	Tracing   TracingConfig   `json:"tracing,omitempty"`
+	AccessLog AccessLogConfig `json:"accessLog,omitempty"`
+	Events    EventsConfig    `json:"events,omitempty"`
+	Variables VariablesConfig `json:"variables,omitempty"`
+
+	TenantSource TenantSourceConfig `json:"tenantSource,omitempty"`
+	TenantHeader string             `json:"tenantHeader,omitempty"`
+
+	// ExtractArgs maps root fields to the arguments forwarded in
+	// ArgHeaderPrefix+Field-Argument headers (e.g. X-GraphQL-Arg-User-Id)
+	ExtractArgs     map[string][]string `json:"extractArgs,omitempty"`
+	ArgHeaderPrefix string              `json:"argHeaderPrefix,omitempty"`
+
+	Routing    RoutingConfig    `json:"routing,omitempty"`
+	Federation FederationConfig `json:"federation,omitempty"`
+	Clients    ClientsConfig    `json:"clients,omitempty"`
+	FieldPaths FieldPathsConfig `json:"fieldPaths,omitempty"`
+	Directives DirectivesConfig `json:"directives,omitempty"`
+
+	// Schema is an inline SDL document; SchemaFile loads it from disk, or
+	// from an HTTP(S) URL, instead
+	Schema       string            `json:"schema,omitempty"`
+	SchemaFile   string            `json:"schemaFile,omitempty"`
+	Deprecations DeprecationConfig `json:"deprecations,omitempty"`
+
+	// ReloadInterval re-reads the policy files (schemaFile, the clients
+	// allowlistFile) when they changed, checking at most once per interval;
+	// empty never reloads files and refreshes URLs every minute. URLs are
+	// refreshed with conditional requests on their ETag.
+	ReloadInterval string `json:"reloadInterval,omitempty"`
+	// PolicyStartupFailure is "fail" (default) to refuse to start when a
+	// policy cannot be loaded, or "continue" to start without it and retry
+	// at the reload interval; an allowlist not loaded yet allows every
+	// client unless failureMode.policies is closed
+	PolicyStartupFailure string `json:"policyStartupFailure,omitempty"`
+
+	// FailureMode decides whether requests the plugin fails to check are
+	// forwarded or rejected
+	FailureMode FailureModeConfig `json:"failureMode,omitempty"`
+
+	// DryRun reports would-be rejections (logs, metrics, access log) and
+	// forwards the requests; DryRunRules does so for some rules only:
+	// limits, operationName, clients, inspectors, failureMode, idempotency,
+	// circuitBreaker, csrf, screening, quota, endpoint, rules and
+	// subscriptions
+	DryRun      bool     `json:"dryRun,omitempty"`
+	DryRunRules []string `json:"dryRunRules,omitempty"`
+
+	// Debug answers requests carrying its secret header with a report of
+	// what was parsed and decided, without forwarding them
+	Debug DebugConfig `json:"debug,omitempty"`
+
+	Idempotency IdempotencyConfig `json:"idempotency,omitempty"`
+	Timeouts    TimeoutsConfig    `json:"timeouts,omitempty"`
+
+	CircuitBreaker CircuitBreakerConfig `json:"circuitBreaker,omitempty"`
+	Retries        RetryConfig          `json:"retries,omitempty"`
+	Mirror         MirrorConfig         `json:"mirror,omitempty"`
+	Canary         CanaryConfig         `json:"canary,omitempty"`
+	Coalescing     CoalescingConfig     `json:"coalescing,omitempty"`
+	CSRF           CSRFConfig           `json:"csrf,omitempty"`
+	Screening      ScreeningConfig      `json:"screening,omitempty"`
+	Signing        SigningConfig        `json:"signing,omitempty"`
+	QueryHash      QueryHashConfig      `json:"queryHash,omitempty"`
+	Usage          UsageConfig          `json:"usage,omitempty"`
+	Apollo         ApolloConfig         `json:"apollo,omitempty"`
+	NullFields     NullFieldsConfig     `json:"nullFields,omitempty"`
+	Rewrite        RewriteConfig        `json:"rewrite,omitempty"`
+	Quota          QuotaConfig          `json:"quota,omitempty"`
+	Endpoint       EndpointConfig       `json:"endpoint,omitempty"`
+	PreFilter      PreFilterConfig      `json:"preFilter,omitempty"`
+	SDL            SDLConfig            `json:"sdl,omitempty"`
+	Errors         ErrorsConfig         `json:"errors,omitempty"`
+	RequestID      RequestIDConfig      `json:"requestId,omitempty"`
+	Rules          []RuleConfig         `json:"rules,omitempty"`
+	ClientIdentity ClientIdentityConfig `json:"clientIdentity,omitempty"`
+	Subscriptions  SubscriptionsConfig  `json:"subscriptions,omitempty"`
+
+	// Storage keeps the quota counters and idempotency keys in Redis, for
+	// replicas to share them
+	Storage StorageConfig `json:"storage,omitempty"`
+
+	// Runtime is yaegi when Traefik interprets the plugin, which disables the
+	// features the interpreter cannot run and logs them at startup, or native
+	// when it is compiled. It defaults to yaegi in New, which only Traefik
+	// calls, and to native in NewMiddleware.
+	Runtime string `json:"runtime,omitempty"`
+
+	// Profiles override the settings above for the requests they match,
+	// the first matching profile applies
+	Profiles []ProfileConfig `json:"profiles,omitempty"`
 }
 
 // CreateConfig creates the default plugin configuration
@@ -30,6 +173,58 @@ type GraphQLParser struct {
 	name           string
 	queryHeader    string
 	mutationHeader string
+	fieldNames     string
+	filter         fieldFilter
+	lists          listHeaders
+	bufferLimit    int
+	maxFormBytes   int
+	limits         parser.Limits
+	requireOpName  bool
+	maxOperations  int
+	maxBatchSize   int
+	metrics        *metrics
+	tracer         *tracer
+	accessLog      *accessLog
+	events         *eventEmitter
+	variables      *variablePolicy
+	tenant         *tenantExtractor
+	args           *argumentExtractor
+	router         *router
+	federation     *federation
+	clients        *clientIdentifier
+	fieldPaths     *fieldPathExtractor
+	directives     *directiveExtractor
+	deprecations   *deprecationDetector
+	failures       failurePolicy
+	dryRun         bool
+	dryRunRules    map[string]bool
+	debug          *debugEcho
+	idempotency    *idempotencyStore
+	timeouts       *timeoutPolicy
+	breaker        *circuitBreaker
+	retries        *retryPolicy
+	mirror         *mirror
+	canary         *canaryBucketer
+	coalescer      *coalescer
+	csrf           *csrfPolicy
+	screening      *variableScreener
+	signer         *signer
+	queryHash      *queryHasher
+	usage          *usageAggregator
+	apollo         *apolloReporter
+	nullFields     *nullFieldsReporter
+	rewriter       *documentRewriter
+	quota          *quotaTracker
+	endpoint       *endpointPolicy
+	sdl            *sdlEndpoint
+	preFilter      *preFilter
+	errors         *errorRenderer
+	requestIDs     *requestIDs
+	rules          []*policyRule
+	identity       *identityResolver
+	subscriptions  *subscriptionLimits
+	inspectors     []Inspector
+	profiles       []*profile
 }
 
 // GraphQLRequest represents a GraphQL request
@@ -37,10 +232,70 @@ type GraphQLRequest struct {
 	Query         string         `json:"query"`
 	OperationName string         `json:"operationName,omitempty"`
 	Variables     map[string]any `json:"variables,omitempty"`
+	Extensions    map[string]any `json:"extensions,omitempty"`
+}
+
+// ParsedRequest is what the plugin learned about a GraphQL request; it is
+// shared with the logging subsystems and with Inspector implementations
+type ParsedRequest struct {
+	Request GraphQLRequest
+	// Document is nil when the query could not be parsed
+	Document *parser.Document
+	// Batch holds the entries of a batched body (a JSON array of requests);
+	// Request and Document are then empty and the other fields summarize the
+	// batch, OperationType being empty when the entries mix types
+	Batch     []*ParsedRequest
+	Queries   []string
+	Mutations []string
+	// FieldPaths are the nested selection paths, when fieldPaths is enabled
+	FieldPaths     []string
+	OperationName  string
+	OperationType  string
+	OperationCount int
+	ClientName     string
+	ClientVersion  string
+	// ClientIdentity is who sent the request, when clientIdentity is enabled
+	ClientIdentity string
+
+	start time.Time
+	// dryRunReason is the first rejection reported in dry run
+	dryRunReason string
+	// debug collects the report of a debug echo request
+	debug *debugReport
 }
 
 // New creates a new plugin instance
 func New(ctx context.Context, next http.Handler, config *Config, name string) (http.Handler, error) {
+	// Traefik only ever calls New from its interpreter
+	if config.Runtime == "" {
+		config.Runtime = runtimeYaegi
+	}
+	g, err := newGraphQLParser(config, name)
+	if err != nil {
+		return nil, err
+	}
+	return g.withNext(next, nil), nil
+}
+
+// NewMiddleware builds a standard net/http middleware from the configuration,
+// so trafico can be embedded in any Go gateway and not only run under Traefik;
+// a nil config uses the defaults of CreateConfig. Inspectors run in order on
+// every parsed request.
+func NewMiddleware(config *Config, name string, inspectors ...Inspector) (func(http.Handler) http.Handler, error) {
+	if config == nil {
+		config = CreateConfig()
+	}
+	g, err := newGraphQLParser(config, name)
+	if err != nil {
+		return nil, err
+	}
+	return func(next http.Handler) http.Handler {
+		return g.withNext(next, inspectors)
+	}, nil
+}
+
+// newGraphQLParser validates the configuration and builds every subsystem
+func newGraphQLParser(config *Config, name string) (*GraphQLParser, error) {
 	if config.QueryHeader == "" {
 		config.QueryHeader = "X-GraphQL-Queries"
 	}
@@ -48,312 +303,817 @@ func New(ctx context.Context, next http.Handler, config *Config, name string) (h
 		config.MutationHeader = "X-GraphQL-Mutations"
 	}
 
-	return &GraphQLParser{
-		next:           next,
+	if err := validateConfig(config); err != nil {
+		return nil, err
+	}
+	if err := applyRuntime(config, name); err != nil {
+		return nil, err
+	}
+
+	if config.MaxBufferedBodyKB < 0 {
+		return nil, fmt.Errorf("maxBufferedBodyKB must not be negative, got %d", config.MaxBufferedBodyKB)
+	}
+	if config.MaxFormBodyKB < 0 {
+		return nil, fmt.Errorf("maxFormBodyKB must not be negative, got %d", config.MaxFormBodyKB)
+	}
+	maxFormBodyKB := config.MaxFormBodyKB
+	if maxFormBodyKB == 0 {
+		maxFormBodyKB = defaultMaxFormBodyKB
+	}
+	if config.MaxTokens < 0 || config.MaxSelectionSetNodes < 0 {
+		return nil, fmt.Errorf("maxTokens and maxSelectionSetNodes must not be negative")
+	}
+	if config.MaxFieldsPerLevel < 0 || config.MaxTotalFields < 0 {
+		return nil, fmt.Errorf("maxFieldsPerLevel and maxTotalFields must not be negative")
+	}
+	if config.MaxDepth < 0 {
+		return nil, fmt.Errorf("maxDepth must not be negative, got %d", config.MaxDepth)
+	}
+	if config.MaxOperationsPerDocument < 0 || config.MaxBatchSize < 0 {
+		return nil, fmt.Errorf("maxOperationsPerDocument and maxBatchSize must not be negative")
+	}
+
+	if err := validFieldNames(config.FieldNames); err != nil {
+		return nil, err
+	}
+
+	lists, err := newListHeaders(config.HeaderFormat, config.HeaderDelimiter, config.MaxHeaderBytes)
+	if err != nil {
+		return nil, err
+	}
+
+	m, err := newMetrics(config.Metrics, name)
+	if err != nil {
+		return nil, err
+	}
+
+	t, err := newTracer(config.Tracing)
+	if err != nil {
+		return nil, err
+	}
+
+	a, err := newAccessLog(config.AccessLog, name)
+	if err != nil {
+		return nil, err
+	}
+
+	e, err := newEventEmitter(config.Events, name)
+	if err != nil {
+		return nil, err
+	}
+
+	tenant, err := newTenantExtractor(config.TenantSource, config.TenantHeader, lists)
+	if err != nil {
+		return nil, err
+	}
+
+	args, err := newArgumentExtractor(config.ExtractArgs, config.ArgHeaderPrefix, lists)
+	if err != nil {
+		return nil, err
+	}
+
+	r, err := newRouter(config.Routing, lists)
+	if err != nil {
+		return nil, err
+	}
+
+	paths, err := newFieldPathExtractor(config.FieldPaths, lists)
+	if err != nil {
+		return nil, err
+	}
+
+	reloadInterval, err := parseReloadInterval(config.ReloadInterval)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := validPolicyStartupFailure(config.PolicyStartupFailure); err != nil {
+		return nil, err
+	}
+
+	failures, err := newFailurePolicy(config.FailureMode)
+	if err != nil {
+		return nil, err
+	}
+
+	dryRunRules, err := newDryRunRules(config.DryRunRules)
+	if err != nil {
+		return nil, err
+	}
+
+	debug, err := newDebugEcho(config.Debug)
+	if err != nil {
+		return nil, err
+	}
+
+	storage, err := newStorage(config.Storage)
+	if err != nil {
+		return nil, err
+	}
+
+	idempotency, err := newIdempotencyStore(config.Idempotency, storage, config.RequestID.Header)
+	if err != nil {
+		return nil, err
+	}
+
+	errs, err := newErrorRenderer(config.Errors)
+	if err != nil {
+		return nil, err
+	}
+
+	timeouts, err := newTimeoutPolicy(config.Timeouts, errs)
+	if err != nil {
+		return nil, err
+	}
+
+	breaker, err := newCircuitBreaker(config.CircuitBreaker)
+	if err != nil {
+		return nil, err
+	}
+
+	retries, err := newRetryPolicy(config.Retries)
+	if err != nil {
+		return nil, err
+	}
+
+	shadow, err := newMirror(config.Mirror)
+	if err != nil {
+		return nil, err
+	}
+
+	canary, err := newCanaryBucketer(config.Canary)
+	if err != nil {
+		return nil, err
+	}
+
+	coalescer, err := newCoalescer(config.Coalescing)
+	if err != nil {
+		return nil, err
+	}
+
+	screening, err := newVariableScreener(config.Screening, lists)
+	if err != nil {
+		return nil, err
+	}
+
+	signer, err := newSigner(config.Signing)
+	if err != nil {
+		return nil, err
+	}
+
+	usage, err := newUsageAggregator(config.Usage, name, lists)
+	if err != nil {
+		return nil, err
+	}
+
+	apollo, err := newApolloReporter(config.Apollo)
+	if err != nil {
+		return nil, err
+	}
+
+	nullFields, err := newNullFieldsReporter(config.NullFields, lists)
+	if err != nil {
+		return nil, err
+	}
+
+	rewriter, err := newDocumentRewriter(config.Rewrite)
+	if err != nil {
+		return nil, err
+	}
+
+	quota, err := newQuotaTracker(config.Quota, name, storage)
+	if err != nil {
+		return nil, err
+	}
+
+	c, err := newClientIdentifier(config.Clients, reloadInterval, config.PolicyStartupFailure, failures.policies)
+	if err != nil {
+		return nil, err
+	}
+
+	schema, err := loadSchema(config.Schema, config.SchemaFile, reloadInterval, config.PolicyStartupFailure)
+	if err != nil {
+		return nil, err
+	}
+
+	d, err := newDeprecationDetector(config.Deprecations, schema, lists)
+	if err != nil {
+		return nil, err
+	}
+
+	sdl, err := newSDLEndpoint(config.SDL, schema)
+	if err != nil {
+		return nil, err
+	}
+
+	filter, err := newPreFilter(config.PreFilter)
+	if err != nil {
+		return nil, err
+	}
+
+	ids, err := newRequestIDs(config.RequestID)
+	if err != nil {
+		return nil, err
+	}
+
+	rules, err := newPolicyRules(config.Rules)
+	if err != nil {
+		return nil, err
+	}
+
+	identity, err := newIdentityResolver(config.ClientIdentity)
+	if err != nil {
+		return nil, err
+	}
+
+	subscriptions, err := newSubscriptionLimits(config.Subscriptions, name)
+	if err != nil {
+		return nil, err
+	}
+
+	limits := parser.Limits{
+		MaxTokens:            config.MaxTokens,
+		MaxSelectionSetNodes: config.MaxSelectionSetNodes,
+		MaxFieldsPerLevel:    config.MaxFieldsPerLevel,
+		MaxTotalFields:       config.MaxTotalFields,
+		MaxDepth:             config.MaxDepth,
+	}
+
+	g := &GraphQLParser{
 		name:           name,
 		queryHeader:    config.QueryHeader,
 		mutationHeader: config.MutationHeader,
-	}, nil
+		fieldNames:     config.FieldNames,
+		filter:         newFieldFilter(config.AdditionalReservedWords, config.IgnoredFields),
+		lists:          lists,
+		bufferLimit:    config.MaxBufferedBodyKB * 1024,
+		maxFormBytes:   maxFormBodyKB * 1024,
+		limits:         limits,
+		requireOpName:  config.RequireOperationName,
+		maxOperations:  config.MaxOperationsPerDocument,
+		maxBatchSize:   config.MaxBatchSize,
+		metrics:        m,
+		tracer:         t,
+		accessLog:      a,
+		events:         e,
+		variables:      newVariablePolicy(config.Variables, lists),
+		tenant:         tenant,
+		args:           args,
+		router:         r,
+		federation:     newFederation(config.Federation, lists),
+		clients:        c,
+		fieldPaths:     paths,
+		directives:     newDirectiveExtractor(config.Directives, lists),
+		deprecations:   d,
+		failures:       failures,
+		dryRun:         config.DryRun,
+		dryRunRules:    dryRunRules,
+		debug:          debug,
+		idempotency:    idempotency,
+		timeouts:       timeouts,
+		breaker:        breaker,
+		retries:        retries,
+		mirror:         shadow,
+		canary:         canary,
+		coalescer:      coalescer,
+		csrf:           newCSRFPolicy(config.CSRF),
+		screening:      screening,
+		signer:         signer,
+		queryHash:      newQueryHasher(config.QueryHash, lists),
+		usage:          usage,
+		apollo:         apollo,
+		nullFields:     nullFields,
+		rewriter:       rewriter,
+		quota:          quota,
+		sdl:            sdl,
+		preFilter:      filter,
+		errors:         errs,
+		requestIDs:     ids,
+		rules:          rules,
+		identity:       identity,
+		subscriptions:  subscriptions,
+	}
+
+	requestHeaders, responseHeaders := g.metadataHeaders()
+	g.endpoint, err = newEndpointPolicy(config.Endpoint, requestHeaders, responseHeaders)
+	if err != nil {
+		return nil, err
+	}
+
+	g.profiles, err = newProfiles(config, name)
+	if err != nil {
+		return nil, err
+	}
+	return g, nil
 }
 
 // ServeHTTP implements the http.Handler interface
 func (g *GraphQLParser) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
+	if profile := g.profileFor(req); profile != nil {
+		profile.ServeHTTP(rw, req)
+		return
+	}
+	req = g.requestIDs.assign(rw, req)
+
+	if g.sdl.serve(rw, req) {
+		return
+	}
+
+	answered, status := g.endpoint.serve(rw, req)
+	if answered {
+		return
+	}
+	if status != 0 {
+		parsed := &ParsedRequest{start: time.Now()}
+		message := "method " + req.Method + " is not allowed on this GraphQL endpoint"
+		if g.enforce(req, parsed, status, "method_not_allowed", message) {
+			g.reject(rw, req, parsed, status, "method_not_allowed", message)
+			return
+		}
+	}
+
+	if g.subscriptions != nil && isWebSocketUpgrade(req) {
+		g.serveWebSocket(rw, req)
+		return
+	}
+
 	// Only process POST requests with GraphQL content
 	if req.Method != http.MethodPost {
 		g.next.ServeHTTP(rw, req)
 		return
 	}
 
+	if g.csrf.blocked(req) {
+		parsed := &ParsedRequest{start: time.Now()}
+		if g.enforce(req, parsed, http.StatusBadRequest, "csrf", g.csrf.message) {
+			g.reject(rw, req, parsed, http.StatusBadRequest, "csrf", g.csrf.message)
+			return
+		}
+	}
+
 	// Check Content-Type
 	contentType := req.Header.Get("Content-Type")
-	if !strings.Contains(contentType, "application/json") && !strings.Contains(contentType, "application/graphql") {
+	if !isGraphQLContentType(contentType) {
 		g.next.ServeHTTP(rw, req)
 		return
 	}
 
-	// Read body
-	body, err := io.ReadAll(req.Body)
+	start := time.Now()
+	g.signer.strip(req.Header)
+
+	var report *debugReport
+	if g.debug.requested(req) {
+		report = newDebugReport(req)
+	}
+
+	// Read body into a pooled buffer, recycled once the request is served
+	pooled, err := readBody(req, g.bufferLimit)
 	if err != nil {
+		g.metrics.parseFailure("body_read")
+		parsed := &ParsedRequest{start: start}
+		if g.failures.bodyRead && g.enforce(req, parsed, http.StatusBadRequest, "body_read", "failed to read request body") {
+			g.reject(rw, req, parsed, http.StatusBadRequest, "body_read", "failed to read request body")
+			return
+		}
 		g.next.ServeHTTP(rw, req)
 		return
 	}
 
 	// Restore body for downstream handlers
-	req.Body = io.NopCloser(bytes.NewReader(body))
+	req.Body = pooled
+	defer pooled.release()
 
-	// Parse GraphQL request
-	var graphqlReq GraphQLRequest
-	if err := json.Unmarshal(body, &graphqlReq); err != nil {
-		// If it's not JSON, try to parse as raw GraphQL
-		graphqlReq.Query = string(body)
+	// handled is set once the request is answered or handed over downstream
+	handled := false
+	defer g.recoverPanic(rw, req, pooled, &handled)
+
+	if g.preFilter.bypass(contentType, pooled.bytes()) {
+		g.metrics.bypass()
+		handled = true
+		g.next.ServeHTTP(rw, req)
+		return
 	}
 
-	// Extract resource names (root fields) instead of operation names
-	queries, mutations := g.extractResourceNames(graphqlReq.Query)
+	// Parse GraphQL request
+	parsed, rejection := g.parseRequest(req, pooled)
+	parsed.start = start
+	parsed.debug = report
+	if rejection != nil && g.enforce(req, parsed, rejection.status, rejection.reason, rejection.message) {
+		handled = true
+		g.reject(rw, req, parsed, rejection.status, rejection.reason, rejection.message)
+		return
+	}
+	g.rewrite(req, parsed, pooled)
+	inlineVariables(parsed)
+	queries, mutations := parsed.Queries, parsed.Mutations
+	g.metrics.observeRequest(queries, mutations, time.Since(start))
 
 	// Set headers
-	if len(queries) > 0 {
-		req.Header.Set(g.queryHeader, strings.Join(queries, ","))
+	req.Header.Del(fieldsTruncatedHeader)
+	truncated := false
+	queryLabels, mutationLabels := queries, mutations
+	if g.fieldNames == fieldNamesAlias || g.fieldNames == fieldNamesPair {
+		queryLabels, mutationLabels = g.rootFieldLabels(parsed, "query"), g.rootFieldLabels(parsed, "mutation")
 	}
-	if len(mutations) > 0 {
-		req.Header.Set(g.mutationHeader, strings.Join(mutations, ","))
+	if len(queryLabels) > 0 {
+		truncated = g.lists.set(req.Header, g.queryHeader, queryLabels)
 	}
+	if len(mutationLabels) > 0 {
+		truncated = g.lists.set(req.Header, g.mutationHeader, mutationLabels) || truncated
+	}
+	fieldPaths, pathsTruncated := g.fieldPaths.extract(parsed)
+	parsed.FieldPaths = fieldPaths
+	if g.fieldPaths.setHeader(req.Header, fieldPaths, pathsTruncated) {
+		truncated = true
+	}
+	if truncated {
+		req.Header.Set(fieldsTruncatedHeader, "true")
+	}
+	setOperationCount(req.Header, parsed)
+	g.variables.setHeaders(req.Header, parsed)
+	g.tenant.setHeader(req.Header, parsed)
+	g.args.setHeaders(req.Header, parsed)
+	g.router.apply(req, queries, mutations)
+	g.federation.setHeaders(req.Header, parsed)
+	g.directives.setHeader(req.Header, parsed)
+	g.queryHash.setHeaders(req.Header, parsed)
 
-	g.next.ServeHTTP(rw, req)
-}
-
-// extractResourceNames parses the GraphQL query and extracts root field names (resources)
-func (g *GraphQLParser) extractResourceNames(query string) ([]string, []string) {
-	var queries []string
-	var mutations []string
-
-	// Remove comments
-	query = removeComments(query)
-
-	// Parse the query to extract root fields
-	queries = g.extractRootFieldsFromOperation(query, "query")
-	mutations = g.extractRootFieldsFromOperation(query, "mutation")
+	parsed.ClientName, parsed.ClientVersion = g.clients.identify(req.Header, parsed)
+	parsed.ClientIdentity = g.identity.resolve(req)
+	g.canary.setHeader(req.Header, parsed)
 
-	return queries, mutations
-}
+	if g.requireOpName {
+		for _, entry := range parsed.entries() {
+			reason, message := operationNameViolation(entry.Document, entry.Request.OperationName)
+			if reason != "" && g.enforce(req, parsed, http.StatusBadRequest, reason, message) {
+				handled = true
+				g.reject(rw, req, parsed, http.StatusBadRequest, reason, message)
+				return
+			}
+		}
+	}
 
-// extractRootFieldsFromOperation extracts root fields from a specific operation type
-func (g *GraphQLParser) extractRootFieldsFromOperation(query string, opType string) []string {
-	var fields []string
+	if !g.clients.allowed(parsed.ClientName) && g.enforce(req, parsed, http.StatusForbidden, "unknown_client", "client is not allowed") {
+		handled = true
+		g.reject(rw, req, parsed, http.StatusForbidden, "unknown_client", "client is not allowed")
+		return
+	}
 
-	// Normalize whitespace
-	query = regexp.MustCompile(`\s+`).ReplaceAllString(query, " ")
-	query = strings.TrimSpace(query)
+	if message := g.checkRules(req, parsed); message != "" && g.enforce(req, parsed, http.StatusForbidden, "rule_violation", message) {
+		handled = true
+		g.reject(rw, req, parsed, http.StatusForbidden, "rule_violation", message)
+		return
+	}
 
-	var operationBlocks []string
+	g.deprecations.apply(rw, req, parsed)
 
-	if opType == "query" {
-		// Handle both named queries and anonymous queries
-		operationBlocks = g.findOperationBlocks(query, []string{"query", "anonymous"})
-	} else if opType == "mutation" {
-		operationBlocks = g.findOperationBlocks(query, []string{"mutation"})
+	if findings := g.screening.screen(req.Header, parsed); len(findings) > 0 && g.screening.block {
+		message := screeningMessage(findings)
+		if g.enforce(req, parsed, http.StatusBadRequest, "suspicious_variables", message) {
+			handled = true
+			g.reject(rw, req, parsed, http.StatusBadRequest, "suspicious_variables", message)
+			return
+		}
 	}
 
-	// Extract root fields from each operation block
-	for _, block := range operationBlocks {
-		rootFields := g.parseRootFields(block)
-		fields = append(fields, rootFields...)
+	if rejection := g.inspect(req, parsed); rejection != nil && g.enforce(req, parsed, rejection.Status, rejection.Reason, rejection.Message) {
+		handled = true
+		g.reject(rw, req, parsed, rejection.Status, rejection.Reason, rejection.Message)
+		return
 	}
 
-	return fields
-}
-
-// findOperationBlocks finds operation blocks of the specified types
-func (g *GraphQLParser) findOperationBlocks(query string, opTypes []string) []string {
-	var blocks []string
-
-	for _, opType := range opTypes {
-		var pattern *regexp.Regexp
+	if parsed.debug != nil {
+		handled = true
+		parsed.debug.write(rw, req, parsed)
+		return
+	}
 
-		if opType == "anonymous" {
-			// Match anonymous queries (starting with {)
-			pattern = regexp.MustCompile(`^\s*\{`)
-		} else {
-			// Match named operations
-			pattern = regexp.MustCompile(`(?i)\b` + opType + `\s+\w+[^{]*\{`)
-		}
+	idempotencyEntry, answered := g.checkIdempotency(rw, req, parsed)
+	if answered {
+		handled = true
+		return
+	}
 
-		matches := pattern.FindAllStringIndex(query, -1)
-		for _, match := range matches {
-			// Find the matching closing brace
-			block := g.extractBalancedBlock(query, match[0])
-			if block != "" {
-				blocks = append(blocks, block)
+	var breakerFieldKeys map[string]string
+	if g.breaker != nil {
+		breakerFieldKeys = breakerFields(parsed)
+		field, probe := g.breaker.allow(breakerFieldKeys)
+		defer g.breaker.release(probe)
+		if field != "" {
+			message := "root field " + field + " is temporarily unavailable"
+			if g.enforce(req, parsed, http.StatusServiceUnavailable, "circuit_open", message) {
+				handled = true
+				g.idempotency.release(idempotencyEntry)
+				g.reject(rw, req, parsed, http.StatusServiceUnavailable, "circuit_open", message)
+				return
 			}
 		}
+	}
 
-		// Special case for anonymous queries
-		if opType == "anonymous" && len(blocks) == 0 {
-			// Check if the entire query is an anonymous query
-			if strings.HasPrefix(strings.TrimSpace(query), "{") {
-				blocks = append(blocks, query)
-			}
+	if decision := g.quota.spend(rw, req, parsed); decision != nil && decision.exhausted {
+		if g.enforce(req, parsed, http.StatusTooManyRequests, "quota_exhausted", decision.message()) {
+			handled = true
+			g.idempotency.release(idempotencyEntry)
+			decision.setRetryAfter(rw.Header())
+			g.reject(rw, req, parsed, http.StatusTooManyRequests, "quota_exhausted", decision.message())
+			return
 		}
 	}
 
-	return blocks
-}
+	g.usage.observe(parsed)
+	g.signer.sign(req)
+	g.mirror.send(req, parsed, pooled)
 
-// extractBalancedBlock extracts a balanced block starting from the given position
-func (g *GraphQLParser) extractBalancedBlock(query string, startPos int) string {
-	// Find the first opening brace
-	bracePos := strings.Index(query[startPos:], "{")
-	if bracePos < 0 {
-		return ""
+	if g.tracer == nil && g.accessLog == nil && g.events == nil && idempotencyEntry == nil && g.breaker == nil && g.apollo == nil && g.nullFields == nil {
+		handled = true
+		g.forward(rw, req, parsed, pooled)
+		return
 	}
 
-	start := startPos + bracePos
-	braceCount := 0
-	inString := false
-	escaped := false
+	span := g.tracer.start(req, parsed.OperationName, parsed.OperationType, parsed.ClientIdentity, queries, mutations)
+	var held *heldResponse
+	if held = g.nullFields.hold(rw); held != nil {
+		rw = held
+	}
+	recorder := newStatusRecorder(rw)
+	captureLimit := 0
+	if (g.events != nil && g.events.countErrors) || (g.breaker != nil && g.breaker.inspect) || g.apollo != nil {
+		captureLimit = defaultErrorCaptureBytes
+	}
+	if idempotencyEntry != nil && g.idempotency.replay && g.idempotency.maxResponseBytes > captureLimit {
+		captureLimit = g.idempotency.maxResponseBytes
+	}
+	if captureLimit > 0 {
+		recorder.capture(captureLimit)
+	}
+	handled = true
+	g.forward(recorder, req, parsed, pooled)
+	g.nullFields.finish(held, parsed)
+	g.tracer.finish(span, recorder.Status())
+	g.idempotency.complete(idempotencyEntry, recorder)
+	g.breaker.record(breakerFieldKeys, recorder)
 
-	for i := start; i < len(query); i++ {
-		char := query[i]
+	errorCount := 0
+	if body, ok := recorder.capturedJSON(); ok {
+		errorCount = graphqlErrorCount(body)
+	}
+	g.apollo.observe(parsed, time.Since(start), errorCount > 0 || recorder.Status() >= http.StatusInternalServerError)
+	g.record(req, parsed, parsed.decision(), parsed.dryRunReason, recorder.Status(), errorCount)
+}
 
-		if escaped {
-			escaped = false
-			continue
-		}
+// forward hands the request over to the next handler, coalescing identical
+// queries in flight
+func (g *GraphQLParser) forward(rw http.ResponseWriter, req *http.Request, parsed *ParsedRequest, body *pooledBody) {
+	if key := g.coalescer.key(req, parsed, body); key != "" {
+		g.coalescer.serve(key, rw, req, g.metrics, func(w http.ResponseWriter) {
+			g.deliver(w, req, parsed, body)
+		})
+		return
+	}
+	g.deliver(rw, req, parsed, body)
+}
 
-		if char == '\\' {
-			escaped = true
-			continue
-		}
+// deliver hands the request over to the next handler within its deadline,
+// retrying query documents on retryable statuses
+func (g *GraphQLParser) deliver(rw http.ResponseWriter, req *http.Request, parsed *ParsedRequest, body *pooledBody) {
+	rw, req, tw := g.timeouts.apply(rw, req, parsed)
+	if tw != nil {
+		defer tw.cancel()
+	}
+	if g.retries.retryable(parsed, body) {
+		g.retries.serve(g.next, rw, req, body, g.metrics)
+	} else {
+		g.next.ServeHTTP(rw, req)
+	}
+	if tw != nil && tw.finish() {
+		g.metrics.rejection("timeout")
+	}
+}
 
-		if char == '"' {
-			inString = !inString
-			continue
-		}
+// recoverPanic forwards the request when the plugin panics before handing it
+// over, so that no hostile body can take down the middleware chain; panics of
+// downstream handlers are propagated untouched
+func (g *GraphQLParser) recoverPanic(rw http.ResponseWriter, req *http.Request, body io.ReadCloser, handled *bool) {
+	if *handled {
+		return
+	}
+	r := recover()
+	if r == nil {
+		return
+	}
+	if r == http.ErrAbortHandler {
+		panic(r)
+	}
 
-		if !inString {
-			if char == '{' {
-				braceCount++
-			} else if char == '}' {
-				braceCount--
-				if braceCount == 0 {
-					return query[start+1 : i] // Return content between braces
-				}
-			}
-		}
+	logRequestf(req, "recovered from panic while parsing a request to %s: %v", req.URL.Path, r)
+	g.metrics.parseFailure("panic")
+	if g.failures.panic && g.enforce(req, &ParsedRequest{}, http.StatusInternalServerError, "panic", "internal error") {
+		g.metrics.rejection("panic")
+		g.errors.write(rw, req.Header.Get("Accept"), http.StatusInternalServerError, "panic", "internal error")
+		return
 	}
+	req.Body = body
+	g.next.ServeHTTP(rw, req)
+}
 
-	return ""
+// reject answers a request the plugin refuses to forward with a GraphQL error
+// response and records the decision
+func (g *GraphQLParser) reject(rw http.ResponseWriter, req *http.Request, parsed *ParsedRequest, status int, reason, message string) {
+	g.metrics.rejection(reason)
+	g.errors.write(rw, req.Header.Get("Accept"), status, reason, message)
+	g.record(req, parsed, decisionBlocked, reason, status, 0)
 }
 
-// parseRootFields extracts root field names from an operation block
-func (g *GraphQLParser) parseRootFields(block string) []string {
-	var fields []string
+// record writes the access log entry and the analytics event of a request
+func (g *GraphQLParser) record(req *http.Request, parsed *ParsedRequest, decision, reason string, status, errorCount int) {
+	if g.accessLog == nil && g.events == nil {
+		return
+	}
+
+	latency := time.Since(parsed.start)
+	query := parsed.query()
+	variables := g.variables.redacted(parsed.Request.Variables)
 
-	// First, let's try a simpler approach - find all root-level fields in one pass
-	// This regex looks for field patterns at the beginning of the selection set
-	rootFieldPattern := regexp.MustCompile(`(?m)(\w+)(?:\s*\([^)]*\))?\s*\{[^}]*\}`)
-	matches := rootFieldPattern.FindAllStringSubmatch(block, -1)
+	entry := accessLogEntry{
+		Time:           parsed.start.UTC().Format(time.RFC3339Nano),
+		RequestID:      requestID(req),
+		ClientIP:       clientIP(req),
+		ClientName:     parsed.ClientName,
+		ClientVersion:  parsed.ClientVersion,
+		ClientIdentity: parsed.ClientIdentity,
+		OperationName:  parsed.OperationName,
+		OperationType:  parsed.OperationType,
+		Queries:        parsed.Queries,
+		Mutations:      parsed.Mutations,
+		VariablesHash:  variablesHash(variables),
+		DocumentSize:   len(query),
+		Decision:       decision,
+		Reason:         reason,
+		Status:         status,
+		LatencyMs:      float64(latency.Microseconds()) / 1000,
+	}
+	if g.accessLog != nil && g.accessLog.logVariables {
+		entry.Variables = variables
+	}
+	if g.fieldPaths != nil && g.fieldPaths.toLog {
+		entry.FieldPaths = parsed.FieldPaths
+	}
+	g.accessLog.log(entry)
 
-	for _, match := range matches {
-		if len(match) >= 2 {
-			fieldName := match[1]
-			if !isGraphQLKeyword(fieldName) && !strings.HasPrefix(fieldName, "@") {
-				fields = append(fields, fieldName)
-			}
+	if g.events != nil {
+		event := &operationEvent{
+			Time:           parsed.start.UTC().Format(time.RFC3339Nano),
+			RequestID:      requestID(req),
+			Fingerprint:    operationFingerprint(query),
+			OperationName:  parsed.OperationName,
+			OperationType:  parsed.OperationType,
+			Queries:        parsed.Queries,
+			Mutations:      parsed.Mutations,
+			ClientID:       parsed.ClientName,
+			ClientIdentity: parsed.ClientIdentity,
+			Status:         status,
+			LatencyMs:      float64(latency.Microseconds()) / 1000,
+			ErrorCount:     errorCount,
 		}
+		if g.events.includeVariables {
+			event.Variables = variables
+		}
+		if g.events.clientIDHeader != "" {
+			event.ClientID = req.Header.Get(g.events.clientIDHeader)
+		}
+		g.events.emit(event)
 	}
+}
 
-	// If the above didn't work, try a more flexible approach
-	if len(fields) == 0 {
-		// Split by lines and look for field patterns
-		lines := strings.Split(block, "\n")
-		braceLevel := 0
+// extractResourceNames returns the sorted, distinct root field names (resources)
+// of the query and mutation operations
+func (g *GraphQLParser) extractResourceNames(doc *parser.Document) ([]string, []string) {
+	var queries []string
+	var mutations []string
 
-		for _, line := range lines {
-			line = strings.TrimSpace(line)
+	if doc == nil {
+		return nil, nil
+	}
 
-			// Skip empty lines and comments
-			if line == "" || strings.HasPrefix(line, "#") {
+	for _, op := range doc.Operations {
+		for _, field := range doc.RootFields(op) {
+			// Reserved words and ignored fields are kept out of the headers
+			if g.filter.excludes(field) {
 				continue
 			}
-
-			// Count braces to determine nesting level
-			openBraces := strings.Count(line, "{")
-			closeBraces := strings.Count(line, "}")
-
-			// If we're at root level (braceLevel == 0), look for field names
-			if braceLevel == 0 {
-				fieldPattern := regexp.MustCompile(`^(\w+)(?:\s*\([^)]*\))?`)
-				matches := fieldPattern.FindStringSubmatch(line)
-
-				if len(matches) >= 2 {
-					fieldName := matches[1]
-					if !isGraphQLKeyword(fieldName) && !strings.HasPrefix(fieldName, "@") {
-						fields = append(fields, fieldName)
-					}
-				}
+			switch op.Type {
+			case "query":
+				queries = append(queries, field.Name)
+			case "mutation":
+				mutations = append(mutations, field.Name)
 			}
-
-			// Update brace level
-			braceLevel += openBraces - closeBraces
 		}
 	}
 
-	// Final fallback: parse the entire block more carefully
-	if len(fields) == 0 {
-		// Remove all content between nested braces to isolate root fields
-		simplified := g.simplifyToRootLevel(block)
-
-		// Now extract field names from the simplified version
-		fieldPattern := regexp.MustCompile(`(\w+)(?:\s*\([^)]*\))?`)
-		matches := fieldPattern.FindAllStringSubmatch(simplified, -1)
+	// Fields repeated under aliases or across operations are listed once
+	return sortedUnique(queries), sortedUnique(mutations)
+}
 
-		for _, match := range matches {
-			if len(match) >= 2 {
-				fieldName := match[1]
-				if !isGraphQLKeyword(fieldName) && !strings.HasPrefix(fieldName, "@") {
-					fields = append(fields, fieldName)
-				}
-			}
+// sortedUnique sorts the values and removes duplicates in place
+func sortedUnique(values []string) []string {
+	if len(values) < 2 {
+		return values
+	}
+	sort.Strings(values)
+	unique := values[:1]
+	for _, value := range values[1:] {
+		if value != unique[len(unique)-1] {
+			unique = append(unique, value)
 		}
 	}
-
-	return fields
+	return unique
 }
 
-// simplifyToRootLevel removes nested selections to help identify root fields
-func (g *GraphQLParser) simplifyToRootLevel(block string) string {
-	var result strings.Builder
-	braceLevel := 0
+// inlineVariables substitutes the variables, and the defaults of those not
+// sent, into the arguments of the executed operations, so that the policies
+// reading arguments see the values the backend resolves
+func inlineVariables(parsed *ParsedRequest) {
+	for _, entry := range parsed.entries() {
+		for _, op := range executedOperations(entry.Document, entry.Request.OperationName) {
+			parser.InlineVariables(entry.Document, op, entry.Request.Variables)
+		}
+	}
+}
 
-	for _, char := range block {
-		if char == '{' {
-			braceLevel++
-			if braceLevel == 1 {
-				result.WriteRune(' ') // Replace opening brace with space
+// rootFieldArgument returns the resolved value of an argument passed to a
+// root field of the executed operation(s)
+func rootFieldArgument(doc *parser.Document, operationName, field, argument string, variables map[string]any) (any, bool) {
+	for _, op := range executedOperations(doc, operationName) {
+		for _, selection := range doc.RootFields(op) {
+			if selection.Name != field {
+				continue
 			}
-		} else if char == '}' {
-			braceLevel--
-			if braceLevel == 0 {
-				result.WriteRune(' ') // Replace closing brace with space
+			if arg := selection.Argument(argument); arg != nil {
+				value := arg.Value.Resolve(variables)
+				return value, value != nil
 			}
-		} else if braceLevel == 0 {
-			// Only include characters that are at root level
-			result.WriteRune(char)
 		}
 	}
+	return nil, false
+}
+
+// executedOperations returns the operation selected by name, falling back to
+// every operation of the document when the selection is ambiguous
+func executedOperations(doc *parser.Document, operationName string) []*parser.Operation {
+	if doc == nil {
+		return nil
+	}
+	if op := doc.Operation(operationName); op != nil {
+		return []*parser.Operation{op}
+	}
+	return doc.Operations
+}
+
+// operationInfo returns the name and type of the operation selected for execution
+func operationInfo(doc *parser.Document, operationName string) (string, string) {
+	if doc == nil {
+		return operationName, ""
+	}
+	op := doc.Operation(operationName)
+	if op == nil {
+		return operationName, ""
+	}
+	return op.Name, op.Type
+}
 
-	return result.String()
+// operationFingerprint identifies a document independently of comments and
+// formatting; documents that do not lex are hashed as sent
+func operationFingerprint(query string) string {
+	normalized, err := parser.Normalize(query)
+	if err != nil {
+		normalized = strings.TrimSpace(query)
+	}
+	sum := sha256.Sum256([]byte(normalized))
+	return hex.EncodeToString(sum[:])
 }
 
-// removeComments removes GraphQL comments from the query
-func removeComments(query string) string {
-	// Remove single-line comments
-	re := regexp.MustCompile(`#[^\n]*`)
-	return re.ReplaceAllString(query, "")
+// operationNameViolation checks that the document only holds named operations
+// and that operationName selects one of them, returning the rejection reason
+// and the GraphQL error message otherwise
+func operationNameViolation(doc *parser.Document, operationName string) (string, string) {
+	if doc == nil {
+		return "", ""
+	}
+	for _, op := range doc.Operations {
+		if op.Name == "" {
+			return "anonymous_operation", "Anonymous operations are not allowed, the operation must be named."
+		}
+	}
+	switch {
+	case operationName == "" && len(doc.Operations) > 1:
+		return "missing_operation_name", "Must provide operation name if query contains multiple operations."
+	case operationName != "" && doc.Operation(operationName) == nil:
+		return "unknown_operation_name", fmt.Sprintf("Unknown operation named %q.", operationName)
+	}
+	return "", ""
 }
 
-// isGraphQLKeyword checks if a word is a GraphQL keyword
-func isGraphQLKeyword(word string) bool {
-	keywords := map[string]bool{
-		"query":        true,
-		"mutation":     true,
-		"subscription": true,
-		"fragment":     true,
-		"on":           true,
-		"true":         true,
-		"false":        true,
-		"null":         true,
-		"type":         true,
-		"input":        true,
-		"interface":    true,
-		"union":        true,
-		"enum":         true,
-		"scalar":       true,
-		"schema":       true,
-		"extend":       true,
-		"implements":   true,
-		"directive":    true,
-	}
-	return keywords[strings.ToLower(word)]
+// logf writes a plugin log line; Traefik collects plugin output from the standard logger
+func logf(format string, args ...any) {
+	log.Printf("[trafico] "+format, args...)
 }
@@ -0,0 +1,456 @@
+package trafico
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// MetricsConfig configures the optional Prometheus metrics subsystem
+type MetricsConfig struct {
+	Enabled bool `json:"enabled,omitempty"`
+	// Address starts a dedicated listener (e.g. ":9180") serving the metrics
+	Address string `json:"address,omitempty"`
+	Path    string `json:"path,omitempty"`
+	// PushGatewayURL periodically pushes the metrics to a Prometheus push gateway
+	PushGatewayURL string    `json:"pushGatewayURL,omitempty"`
+	PushInterval   string    `json:"pushInterval,omitempty"`
+	Job            string    `json:"job,omitempty"`
+	Buckets        []float64 `json:"buckets,omitempty"`
+	// MaxFieldSeries caps the number of distinct root field label values
+	MaxFieldSeries int `json:"maxFieldSeries,omitempty"`
+}
+
+const (
+	defaultMetricsPath    = "/metrics"
+	defaultPushInterval   = 15 * time.Second
+	defaultMetricsJob     = "trafico"
+	defaultMaxFieldSeries = 1000
+	otherFieldLabel       = "__other__"
+)
+
+var defaultDurationBuckets = []float64{0.00005, 0.0001, 0.00025, 0.0005, 0.001, 0.0025, 0.005, 0.01, 0.025, 0.05}
+
+// registry is shared by every plugin instance in the process so that Traefik
+// configuration reloads don't reset counters or bind listeners twice
+var registry = newMetricsRegistry()
+
+type metricsRegistry struct {
+	mu         sync.Mutex
+	families   []*metricFamily
+	byName     map[string]*metricFamily
+	listeners  map[string]*http.Server
+	pushers    map[string]bool
+	httpClient *http.Client
+}
+
+func newMetricsRegistry() *metricsRegistry {
+	return &metricsRegistry{
+		byName:     make(map[string]*metricFamily),
+		listeners:  make(map[string]*http.Server),
+		pushers:    make(map[string]bool),
+		httpClient: &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+// metricFamily is a counter or histogram with a fixed set of label names
+type metricFamily struct {
+	name    string
+	help    string
+	kind    string
+	labels  []string
+	buckets []float64
+
+	mu     sync.Mutex
+	series map[string]*metricSeries
+}
+
+type metricSeries struct {
+	labelValues []string
+	value       float64
+	counts      []uint64
+	sum         float64
+	count       uint64
+}
+
+// family returns the registered family with the given name, creating it if needed
+func (r *metricsRegistry) family(name, help, kind string, labels []string, buckets []float64) *metricFamily {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if f, ok := r.byName[name]; ok {
+		return f
+	}
+	f := &metricFamily{
+		name:    name,
+		help:    help,
+		kind:    kind,
+		labels:  labels,
+		buckets: buckets,
+		series:  make(map[string]*metricSeries),
+	}
+	r.byName[name] = f
+	r.families = append(r.families, f)
+	return f
+}
+
+func (f *metricFamily) get(labelValues []string) *metricSeries {
+	key := strings.Join(labelValues, "\xff")
+	s, ok := f.series[key]
+	if !ok {
+		s = &metricSeries{labelValues: append([]string(nil), labelValues...)}
+		if f.kind == "histogram" {
+			s.counts = make([]uint64, len(f.buckets))
+		}
+		f.series[key] = s
+	}
+	return s
+}
+
+func (f *metricFamily) add(v float64, labelValues ...string) {
+	f.mu.Lock()
+	f.get(labelValues).value += v
+	f.mu.Unlock()
+}
+
+func (f *metricFamily) observe(v float64, labelValues ...string) {
+	f.mu.Lock()
+	s := f.get(labelValues)
+	for i, upper := range f.buckets {
+		if v <= upper {
+			s.counts[i]++
+		}
+	}
+	s.sum += v
+	s.count++
+	f.mu.Unlock()
+}
+
+// seriesCount returns the number of series currently tracked for the family
+func (f *metricFamily) seriesCount() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return len(f.series)
+}
+
+// hasSeries reports whether a series already exists for the label values
+func (f *metricFamily) hasSeries(labelValues ...string) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	_, ok := f.series[strings.Join(labelValues, "\xff")]
+	return ok
+}
+
+// writeText renders all families in the Prometheus text exposition format
+func (r *metricsRegistry) writeText(buf *bytes.Buffer) {
+	r.mu.Lock()
+	families := append([]*metricFamily(nil), r.families...)
+	r.mu.Unlock()
+
+	for _, f := range families {
+		f.mu.Lock()
+		keys := make([]string, 0, len(f.series))
+		for k := range f.series {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+
+		fmt.Fprintf(buf, "# HELP %s %s\n", f.name, f.help)
+		fmt.Fprintf(buf, "# TYPE %s %s\n", f.name, f.kind)
+		for _, k := range keys {
+			s := f.series[k]
+			if f.kind == "histogram" {
+				for i, upper := range f.buckets {
+					labels := formatLabels(f.labels, s.labelValues, "le", formatFloat(upper))
+					fmt.Fprintf(buf, "%s_bucket%s %d\n", f.name, labels, s.counts[i])
+				}
+				fmt.Fprintf(buf, "%s_bucket%s %d\n", f.name, formatLabels(f.labels, s.labelValues, "le", "+Inf"), s.count)
+				fmt.Fprintf(buf, "%s_sum%s %s\n", f.name, formatLabels(f.labels, s.labelValues, "", ""), formatFloat(s.sum))
+				fmt.Fprintf(buf, "%s_count%s %d\n", f.name, formatLabels(f.labels, s.labelValues, "", ""), s.count)
+				continue
+			}
+			fmt.Fprintf(buf, "%s%s %s\n", f.name, formatLabels(f.labels, s.labelValues, "", ""), formatFloat(s.value))
+		}
+		f.mu.Unlock()
+	}
+}
+
+func formatLabels(names, values []string, extraName, extraValue string) string {
+	if len(names) == 0 && extraName == "" {
+		return ""
+	}
+	var b strings.Builder
+	b.WriteByte('{')
+	for i, name := range names {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		b.WriteString(name)
+		b.WriteString(`="`)
+		b.WriteString(escapeLabelValue(values[i]))
+		b.WriteByte('"')
+	}
+	if extraName != "" {
+		if len(names) > 0 {
+			b.WriteByte(',')
+		}
+		b.WriteString(extraName)
+		b.WriteString(`="`)
+		b.WriteString(extraValue)
+		b.WriteByte('"')
+	}
+	b.WriteByte('}')
+	return b.String()
+}
+
+func escapeLabelValue(v string) string {
+	v = strings.ReplaceAll(v, `\`, `\\`)
+	v = strings.ReplaceAll(v, "\n", `\n`)
+	return strings.ReplaceAll(v, `"`, `\"`)
+}
+
+func formatFloat(v float64) string {
+	if math.IsInf(v, 1) {
+		return "+Inf"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+// ServeHTTP exposes the registry on the dedicated metrics listener
+func (r *metricsRegistry) ServeHTTP(rw http.ResponseWriter, _ *http.Request) {
+	var buf bytes.Buffer
+	r.writeText(&buf)
+	rw.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	_, _ = rw.Write(buf.Bytes())
+}
+
+// listen starts a metrics listener on the address unless one is already running
+func (r *metricsRegistry) listen(address, path string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.listeners[address]; ok {
+		return
+	}
+	mux := http.NewServeMux()
+	mux.Handle(path, r)
+	srv := &http.Server{Addr: address, Handler: mux, ReadHeaderTimeout: 5 * time.Second}
+	r.listeners[address] = srv
+
+	go func() {
+		if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			logf("metrics listener on %s stopped: %v", address, err)
+			r.mu.Lock()
+			delete(r.listeners, address)
+			r.mu.Unlock()
+		}
+	}()
+}
+
+// push starts a goroutine pushing the registry to a push gateway unless one is already running
+func (r *metricsRegistry) push(gatewayURL, job string, interval time.Duration) {
+	target := strings.TrimRight(gatewayURL, "/") + "/metrics/job/" + job
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.pushers[target] {
+		return
+	}
+	r.pushers[target] = true
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for range ticker.C {
+			var buf bytes.Buffer
+			r.writeText(&buf)
+			req, err := http.NewRequest(http.MethodPut, target, &buf)
+			if err != nil {
+				logf("metrics push to %s failed: %v", target, err)
+				continue
+			}
+			req.Header.Set("Content-Type", "text/plain; version=0.0.4")
+			resp, err := r.httpClient.Do(req)
+			if err != nil {
+				logf("metrics push to %s failed: %v", target, err)
+				continue
+			}
+			resp.Body.Close()
+			if resp.StatusCode >= 300 {
+				logf("metrics push to %s failed: status %d", target, resp.StatusCode)
+			}
+		}
+	}()
+}
+
+// metrics records GraphQL traffic for a single plugin instance; a nil
+// *metrics is valid and records nothing
+type metrics struct {
+	middleware     string
+	maxFieldSeries int
+
+	requests      *metricFamily
+	rootFields    *metricFamily
+	parseDuration *metricFamily
+	parseFailures *metricFamily
+	cacheHits     *metricFamily
+	rejected      *metricFamily
+	dryRun        *metricFamily
+	retries       *metricFamily
+	bypassed      *metricFamily
+}
+
+// newMetrics registers the metric families and starts the configured exporters
+func newMetrics(config MetricsConfig, middleware string) (*metrics, error) {
+	if !config.Enabled {
+		return nil, nil
+	}
+	if config.Address == "" && config.PushGatewayURL == "" {
+		return nil, fmt.Errorf("metrics: either address or pushGatewayURL must be set")
+	}
+
+	buckets := config.Buckets
+	if len(buckets) == 0 {
+		buckets = defaultDurationBuckets
+	}
+	buckets = append([]float64(nil), buckets...)
+	sort.Float64s(buckets)
+
+	m := &metrics{
+		middleware:     middleware,
+		maxFieldSeries: config.MaxFieldSeries,
+		requests: registry.family("trafico_graphql_requests_total",
+			"GraphQL requests by operation type.", "counter", []string{"middleware", "operation_type"}, nil),
+		rootFields: registry.family("trafico_graphql_root_fields_total",
+			"Requested GraphQL root fields.", "counter", []string{"middleware", "operation_type", "field"}, nil),
+		parseDuration: registry.family("trafico_parse_duration_seconds",
+			"Time spent reading and parsing GraphQL requests.", "histogram", []string{"middleware"}, buckets),
+		parseFailures: registry.family("trafico_parse_failures_total",
+			"GraphQL requests that could not be parsed.", "counter", []string{"middleware", "reason"}, nil),
+		cacheHits: registry.family("trafico_cache_hits_total",
+			"Cache hits by cache name.", "counter", []string{"middleware", "cache"}, nil),
+		rejected: registry.family("trafico_rejected_requests_total",
+			"Requests rejected by the plugin by reason.", "counter", []string{"middleware", "reason"}, nil),
+		dryRun: registry.family("trafico_dry_run_rejections_total",
+			"Requests the plugin would have rejected in dry run, by reason.", "counter", []string{"middleware", "reason"}, nil),
+		retries: registry.family("trafico_retries_total",
+			"Query requests sent again to the backend, by the status that was retried.", "counter", []string{"middleware", "status"}, nil),
+		bypassed: registry.family("trafico_bypassed_requests_total",
+			"Requests forwarded without parsing, their body not looking like GraphQL.", "counter", []string{"middleware"}, nil),
+	}
+	if m.maxFieldSeries <= 0 {
+		m.maxFieldSeries = defaultMaxFieldSeries
+	}
+
+	if config.Address != "" {
+		path := config.Path
+		if path == "" {
+			path = defaultMetricsPath
+		}
+		registry.listen(config.Address, path)
+	}
+
+	if config.PushGatewayURL != "" {
+		interval := defaultPushInterval
+		if config.PushInterval != "" {
+			d, err := time.ParseDuration(config.PushInterval)
+			if err != nil || d <= 0 {
+				return nil, fmt.Errorf("metrics: invalid pushInterval %q", config.PushInterval)
+			}
+			interval = d
+		}
+		job := config.Job
+		if job == "" {
+			job = defaultMetricsJob
+		}
+		registry.push(config.PushGatewayURL, job, interval)
+	}
+
+	return m, nil
+}
+
+// observeRequest records the operation types and root fields of a parsed request
+func (m *metrics) observeRequest(queries, mutations []string, duration time.Duration) {
+	if m == nil {
+		return
+	}
+	m.parseDuration.observe(duration.Seconds(), m.middleware)
+
+	if len(queries) == 0 && len(mutations) == 0 {
+		m.requests.add(1, m.middleware, "none")
+		return
+	}
+	if len(queries) > 0 {
+		m.requests.add(1, m.middleware, "query")
+		m.observeFields("query", queries)
+	}
+	if len(mutations) > 0 {
+		m.requests.add(1, m.middleware, "mutation")
+		m.observeFields("mutation", mutations)
+	}
+}
+
+func (m *metrics) observeFields(opType string, fields []string) {
+	for _, field := range fields {
+		// Field names come from the client, so cap the label cardinality
+		if !m.rootFields.hasSeries(m.middleware, opType, field) && m.rootFields.seriesCount() >= m.maxFieldSeries {
+			field = otherFieldLabel
+		}
+		m.rootFields.add(1, m.middleware, opType, field)
+	}
+}
+
+// parseFailure records a request whose body could not be parsed
+func (m *metrics) parseFailure(reason string) {
+	if m == nil {
+		return
+	}
+	m.parseFailures.add(1, m.middleware, reason)
+}
+
+// cacheHit records a hit in one of the plugin caches
+func (m *metrics) cacheHit(cache string) {
+	if m == nil {
+		return
+	}
+	m.cacheHits.add(1, m.middleware, cache)
+}
+
+// rejection records a request rejected by the plugin
+func (m *metrics) rejection(reason string) {
+	if m == nil {
+		return
+	}
+	m.rejected.add(1, m.middleware, reason)
+}
+
+// dryRunRejection records a request forwarded although it would have been rejected
+func (m *metrics) dryRunRejection(reason string) {
+	if m == nil {
+		return
+	}
+	m.dryRun.add(1, m.middleware, reason)
+}
+
+// bypass records a request the pre-filter forwarded without parsing it
+func (m *metrics) bypass() {
+	if m == nil {
+		return
+	}
+	m.bypassed.add(1, m.middleware)
+}
+
+// retry records a request sent again after a retryable status
+func (m *metrics) retry(status int) {
+	if m == nil {
+		return
+	}
+	m.retries.add(1, m.middleware, strconv.Itoa(status))
+}
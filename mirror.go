@@ -0,0 +1,212 @@
+package trafico
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// MirrorConfig duplicates matching requests to a shadow backend in the
+// background; the shadow responses are discarded
+type MirrorConfig struct {
+	Enabled bool `json:"enabled,omitempty"`
+	// URL is the shadow backend, the path and query of the request being
+	// appended to it
+	URL string `json:"url,omitempty"`
+	// OperationTypes and Fields select the requests executing an operation of
+	// one of the types or selecting one of the root fields; queries only when
+	// both are empty
+	OperationTypes []string `json:"operationTypes,omitempty"`
+	Fields         []string `json:"fields,omitempty"`
+	// SampleRate is the fraction of matching requests mirrored, 1 by default
+	SampleRate float64 `json:"sampleRate,omitempty"`
+	// Timeout bounds each shadow request, 5s by default
+	Timeout string `json:"timeout,omitempty"`
+	// BufferSize bounds the requests waiting to be mirrored, the ones beyond
+	// being dropped; Concurrency is the number of requests mirrored at once
+	BufferSize  int `json:"bufferSize,omitempty"`
+	Concurrency int `json:"concurrency,omitempty"`
+}
+
+const (
+	defaultMirrorTimeout     = 5 * time.Second
+	defaultMirrorBufferSize  = 100
+	defaultMirrorConcurrency = 4
+)
+
+// mirror sends copies of requests to the shadow backend; a nil *mirror does nothing
+type mirror struct {
+	target     *url.URL
+	opTypes    map[string]bool
+	fields     map[string]bool
+	sampleRate float64
+	sink       *mirrorSink
+
+	mu  sync.Mutex
+	rnd *rand.Rand
+}
+
+// mirrorSink is the bounded queue and workers shared by instances mirroring
+// to the same shadow backend, so that reloads do not start new workers; the
+// first instance sets the timeout, buffer size and concurrency
+type mirrorSink struct {
+	host    string
+	client  *http.Client
+	queue   chan *http.Request
+	dropped int64
+}
+
+var (
+	mirrorSinksMu sync.Mutex
+	mirrorSinks   = make(map[string]*mirrorSink)
+)
+
+func newMirror(config MirrorConfig) (*mirror, error) {
+	if !config.Enabled {
+		return nil, nil
+	}
+	target, err := url.Parse(config.URL)
+	if err != nil || (target.Scheme != "http" && target.Scheme != "https") || target.Host == "" {
+		return nil, fmt.Errorf("mirror: invalid url %q", config.URL)
+	}
+	if config.SampleRate < 0 || config.SampleRate > 1 {
+		return nil, fmt.Errorf("mirror: sampleRate must be between 0 and 1, got %v", config.SampleRate)
+	}
+	if config.BufferSize < 0 || config.Concurrency < 0 {
+		return nil, fmt.Errorf("mirror: bufferSize and concurrency must not be negative")
+	}
+
+	m := &mirror{
+		target:     target,
+		opTypes:    make(map[string]bool),
+		fields:     make(map[string]bool),
+		sampleRate: config.SampleRate,
+		rnd:        rand.New(rand.NewSource(time.Now().UnixNano())),
+	}
+	for _, opType := range config.OperationTypes {
+		if opType != "query" && opType != "mutation" && opType != "subscription" {
+			return nil, fmt.Errorf("mirror: unknown operation type %q", opType)
+		}
+		m.opTypes[opType] = true
+	}
+	for _, field := range config.Fields {
+		m.fields[field] = true
+	}
+	if len(m.opTypes) == 0 && len(m.fields) == 0 {
+		m.opTypes["query"] = true
+	}
+	if m.sampleRate == 0 {
+		m.sampleRate = 1
+	}
+	timeout := defaultMirrorTimeout
+	if config.Timeout != "" {
+		d, err := time.ParseDuration(config.Timeout)
+		if err != nil || d <= 0 {
+			return nil, fmt.Errorf("mirror: invalid timeout %q", config.Timeout)
+		}
+		timeout = d
+	}
+	bufferSize := config.BufferSize
+	if bufferSize == 0 {
+		bufferSize = defaultMirrorBufferSize
+	}
+	concurrency := config.Concurrency
+	if concurrency == 0 {
+		concurrency = defaultMirrorConcurrency
+	}
+
+	mirrorSinksMu.Lock()
+	defer mirrorSinksMu.Unlock()
+
+	m.sink = mirrorSinks[target.String()]
+	if m.sink == nil {
+		m.sink = &mirrorSink{
+			host:   target.Host,
+			client: &http.Client{Timeout: timeout},
+			queue:  make(chan *http.Request, bufferSize),
+		}
+		mirrorSinks[target.String()] = m.sink
+		for i := 0; i < concurrency; i++ {
+			go m.sink.run()
+		}
+	}
+	return m, nil
+}
+
+// matches reports whether an executed operation of the request is selected
+// by its type or one of its root fields
+func (m *mirror) matches(parsed *ParsedRequest) bool {
+	for _, entry := range parsed.entries() {
+		for _, op := range executedOperations(entry.Document, entry.Request.OperationName) {
+			if m.opTypes[op.Type] {
+				return true
+			}
+			if len(m.fields) == 0 {
+				continue
+			}
+			for _, field := range entry.Document.RootFields(op) {
+				if m.fields[field.Name] {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+// send queues a copy of the request, as it is about to be forwarded, for the
+// shadow backend; requests whose body is streamed are not mirrored
+func (m *mirror) send(req *http.Request, parsed *ParsedRequest, body *pooledBody) {
+	if m == nil || body.streamed() || !m.matches(parsed) {
+		return
+	}
+	if m.sampleRate < 1 {
+		m.mu.Lock()
+		skip := m.rnd.Float64() >= m.sampleRate
+		m.mu.Unlock()
+		if skip {
+			return
+		}
+	}
+
+	// The pooled buffer is recycled once the request is served, so the
+	// shadow request gets its own copy
+	data := append([]byte(nil), body.bytes()...)
+	target := *m.target
+	target.Path = strings.TrimSuffix(target.Path, "/") + req.URL.Path
+	target.RawQuery = req.URL.RawQuery
+	shadow, err := http.NewRequest(req.Method, target.String(), bytes.NewReader(data))
+	if err != nil {
+		return
+	}
+	shadow.Header = req.Header.Clone()
+	shadow.Header.Del("Content-Length")
+
+	select {
+	case m.sink.queue <- shadow:
+	default:
+		atomic.AddInt64(&m.sink.dropped, 1)
+	}
+}
+
+func (s *mirrorSink) run() {
+	for shadow := range s.queue {
+		if dropped := atomic.SwapInt64(&s.dropped, 0); dropped > 0 {
+			logf("mirror buffer for %s full, dropped %d requests", s.host, dropped)
+		}
+		resp, err := s.client.Do(shadow)
+		if err != nil {
+			logf("mirroring to %s failed: %v", s.host, err)
+			continue
+		}
+		_, _ = io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+	}
+}
@@ -0,0 +1,159 @@
+package trafico
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// NullFieldsConfig sets a response header listing the requested root fields
+// that came back null (or missing) in a response carrying errors, to spot
+// partially failing resolvers behind 200 responses. Responses are held back
+// until complete to set the header; larger ones are passed through without it.
+type NullFieldsConfig struct {
+	Enabled bool   `json:"enabled,omitempty"`
+	Header  string `json:"header,omitempty"`
+	// MaxResponseKB bounds the response held back, 1024 by default
+	MaxResponseKB int `json:"maxResponseKB,omitempty"`
+}
+
+const (
+	defaultNullFieldsHeader        = "X-GraphQL-Null-Fields"
+	defaultNullFieldsMaxResponseKB = 1024
+)
+
+// nullFieldsReporter inspects responses; a nil *nullFieldsReporter does nothing
+type nullFieldsReporter struct {
+	header           string
+	maxResponseBytes int
+	lists            listHeaders
+}
+
+func newNullFieldsReporter(config NullFieldsConfig, lists listHeaders) (*nullFieldsReporter, error) {
+	if !config.Enabled {
+		return nil, nil
+	}
+	if config.MaxResponseKB < 0 {
+		return nil, fmt.Errorf("nullFields: maxResponseKB must not be negative")
+	}
+	r := &nullFieldsReporter{header: config.Header, maxResponseBytes: config.MaxResponseKB * 1024, lists: lists}
+	if r.header == "" {
+		r.header = defaultNullFieldsHeader
+	}
+	if r.maxResponseBytes == 0 {
+		r.maxResponseBytes = defaultNullFieldsMaxResponseKB * 1024
+	}
+	return r, nil
+}
+
+// hold returns the writer holding the response back until finish
+func (r *nullFieldsReporter) hold(rw http.ResponseWriter) *heldResponse {
+	if r == nil {
+		return nil
+	}
+	return &heldResponse{ResponseWriter: rw, limit: r.maxResponseBytes}
+}
+
+// finish writes the held response with the header listing the response keys
+// of the root fields that are null in data while errors are reported
+func (r *nullFieldsReporter) finish(held *heldResponse, parsed *ParsedRequest) {
+	if held == nil || held.passthrough {
+		return
+	}
+	header := held.Header()
+	header.Del(r.header)
+	if strings.Contains(header.Get("Content-Type"), "json") {
+		if body, ok := decodeContent(header.Get("Content-Encoding"), held.buf.Bytes()); ok {
+			if keys := nullRootFields(body, breakerFields(parsed)); len(keys) > 0 {
+				r.lists.set(header, r.header, keys)
+			}
+		}
+	}
+	held.release()
+}
+
+// nullRootFields returns the sorted response keys of the requested root
+// fields that are null or missing, when the response reports errors
+func nullRootFields(body []byte, fields map[string]string) []string {
+	var resp struct {
+		Data   map[string]json.RawMessage `json:"data"`
+		Errors []json.RawMessage          `json:"errors"`
+	}
+	if err := json.Unmarshal(body, &resp); err != nil || len(resp.Errors) == 0 {
+		return nil
+	}
+	var keys []string
+	for key := range fields {
+		value, ok := resp.Data[key]
+		if !ok || bytes.Equal(bytes.TrimSpace(value), []byte("null")) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// heldResponse buffers a response up to limit bytes; beyond, or when the
+// backend flushes, it passes the response through as written
+type heldResponse struct {
+	http.ResponseWriter
+	limit int
+
+	status      int
+	buf         bytes.Buffer
+	passthrough bool
+}
+
+func (w *heldResponse) WriteHeader(status int) {
+	if w.passthrough {
+		w.ResponseWriter.WriteHeader(status)
+		return
+	}
+	if w.status == 0 {
+		w.status = status
+	}
+}
+
+func (w *heldResponse) Write(b []byte) (int, error) {
+	if w.passthrough {
+		return w.ResponseWriter.Write(b)
+	}
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	if w.buf.Len()+len(b) > w.limit {
+		if err := w.release(); err != nil {
+			return 0, err
+		}
+		return w.ResponseWriter.Write(b)
+	}
+	return w.buf.Write(b)
+}
+
+// Flush passes streaming responses through
+func (w *heldResponse) Flush() {
+	if !w.passthrough {
+		_ = w.release()
+	}
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// release writes what was held back and passes the rest through
+func (w *heldResponse) release() error {
+	w.passthrough = true
+	if w.status == 0 {
+		return nil
+	}
+	w.ResponseWriter.WriteHeader(w.status)
+	if w.buf.Len() == 0 {
+		return nil
+	}
+	_, err := w.ResponseWriter.Write(w.buf.Bytes())
+	w.buf.Reset()
+	return err
+}
@@ -0,0 +1,51 @@
+package parser
+
+import "testing"
+
+const benchmarkQuery = `query Dashboard($id: ID!, $first: Int = 10, $status: [OrderStatus!]) {
+  viewer: user(id: $id) {
+    id
+    name
+    ...Avatar
+    orders(first: $first, filter: {status: $status, since: "2024-01-01"}) @connection(key: "orders") {
+      edges { node { id total currency items { sku quantity price } } }
+      pageInfo { hasNextPage endCursor }
+    }
+  }
+  notifications(unread: true) { id message createdAt }
+}
+
+fragment Avatar on User {
+  avatar(size: 64) { url width height }
+}`
+
+func BenchmarkParse(b *testing.B) {
+	b.ReportAllocs()
+	b.SetBytes(int64(len(benchmarkQuery)))
+	for i := 0; i < b.N; i++ {
+		if _, err := Parse(benchmarkQuery); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkExtractOperations(b *testing.B) {
+	doc, err := Parse(benchmarkQuery)
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		ExtractOperations(doc)
+	}
+}
+
+func BenchmarkNormalize(b *testing.B) {
+	b.ReportAllocs()
+	b.SetBytes(int64(len(benchmarkQuery)))
+	for i := 0; i < b.N; i++ {
+		if _, err := Normalize(benchmarkQuery); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
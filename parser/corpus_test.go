@@ -0,0 +1,106 @@
+package parser
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// The corpus holds tricky documents that have been mis-parsed in the past:
+// every file under valid/ must parse, and every file under invalid/ must fail
+// with a SyntaxError rather than a panic or a silently wrong document
+
+func TestCorpusValid(t *testing.T) {
+	for _, path := range corpusFiles(t, "valid") {
+		t.Run(filepath.Base(path), func(t *testing.T) {
+			source := readCorpusFile(t, path)
+			doc, err := Parse(source)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if len(doc.Operations) == 0 {
+				t.Fatal("no operation parsed")
+			}
+
+			// Normalizing must preserve the document
+			normalized, err := Normalize(source)
+			if err != nil {
+				t.Fatalf("normalize: %v", err)
+			}
+			if _, err := Parse(normalized); err != nil {
+				t.Fatalf("normalized document %q does not parse: %v", normalized, err)
+			}
+		})
+	}
+}
+
+func TestCorpusInvalid(t *testing.T) {
+	for _, path := range corpusFiles(t, "invalid") {
+		t.Run(filepath.Base(path), func(t *testing.T) {
+			_, err := Parse(readCorpusFile(t, path))
+			var syntaxErr *SyntaxError
+			if !errors.As(err, &syntaxErr) {
+				t.Fatalf("got %v, want a *SyntaxError", err)
+			}
+		})
+	}
+}
+
+func TestCorpusValues(t *testing.T) {
+	doc, err := Parse(readCorpusFile(t, filepath.Join("testdata", "corpus", "valid", "escapes.graphql")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	field := doc.Operations[0].SelectionSet[0]
+	want := map[string]string{
+		"quote":    `say "hi"`,
+		"slash":    `a/b\c`,
+		"controls": "\b\f\n\r\t",
+		"bmp":      "café",
+		"pair":     "😀",
+		"braced":   "😀",
+		"raw":      "unicode ☕ text",
+	}
+	for name, value := range want {
+		arg := field.Argument(name)
+		if arg == nil {
+			t.Errorf("argument %s not parsed", name)
+			continue
+		}
+		if got := arg.Value.Resolve(nil); got != value {
+			t.Errorf("argument %s: got %q, want %q", name, got, value)
+		}
+	}
+
+	doc, err = Parse(readCorpusFile(t, filepath.Join("testdata", "corpus", "valid", "block_string_braces.graphql")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	body := doc.Operations[0].SelectionSet[0].Argument("body").Value.Raw
+	wantBody := "function f() { return \"}\"; }\n# not a comment\n\"\"\" escaped quotes \"\"\""
+	if body != wantBody {
+		t.Errorf("block string: got %q, want %q", body, wantBody)
+	}
+}
+
+func corpusFiles(t *testing.T, kind string) []string {
+	t.Helper()
+	paths, err := filepath.Glob(filepath.Join("testdata", "corpus", kind, "*.graphql"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(paths) == 0 {
+		t.Fatalf("no %s corpus files", kind)
+	}
+	return paths
+}
+
+func readCorpusFile(t *testing.T, path string) string {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
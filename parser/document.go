@@ -0,0 +1,723 @@
+// Package parser lexes and parses GraphQL executable documents and schemas,
+// and extracts the operation metadata trafico exposes as headers. It has no
+// dependency on Traefik and can be embedded in any Go gateway.
+package parser
+
+import (
+	"strings"
+)
+
+// Document is a parsed executable GraphQL document
+type Document struct {
+	Operations []*Operation
+	Fragments  []*Fragment
+}
+
+// Operation is a query, mutation or subscription definition
+type Operation struct {
+	Type                string
+	Name                string
+	VariableDefinitions []*VariableDefinition
+	Directives          []*Directive
+	SelectionSet        []*Selection
+}
+
+// VariableDefinition declares an operation variable
+type VariableDefinition struct {
+	Name         string
+	Type         *TypeRef
+	DefaultValue *Value
+	Directives   []*Directive
+}
+
+// Fragment is a named fragment definition
+type Fragment struct {
+	Name          string
+	TypeCondition string
+	Directives    []*Directive
+	SelectionSet  []*Selection
+}
+
+// SelectionKind identifies the kind of a selection
+type SelectionKind int
+
+// Selection kinds
+const (
+	FieldSelection SelectionKind = iota
+	FragmentSpreadSelection
+	InlineFragmentSelection
+)
+
+// Selection is a field, a fragment spread (Name is the fragment name) or an
+// inline fragment (TypeCondition may be empty)
+type Selection struct {
+	Kind          SelectionKind
+	Alias         string
+	Name          string
+	Arguments     []*Argument
+	Directives    []*Directive
+	TypeCondition string
+	SelectionSet  []*Selection
+}
+
+// ResponseKey returns the alias of a field, or its name when it isn't aliased
+func (s *Selection) ResponseKey() string {
+	if s.Alias != "" {
+		return s.Alias
+	}
+	return s.Name
+}
+
+// Argument is a named argument of a field or directive
+type Argument struct {
+	Name  string
+	Value *Value
+}
+
+// Directive is a directive applied to a definition or selection
+type Directive struct {
+	Name      string
+	Arguments []*Argument
+}
+
+// ValueKind identifies the kind of a value literal
+type ValueKind int
+
+// Value kinds
+const (
+	VariableValue ValueKind = iota
+	IntValue
+	FloatValue
+	StringValue
+	BooleanValue
+	NullValue
+	EnumValue
+	ListValue
+	ObjectValue
+)
+
+// Value is an input value literal; Raw holds the scalar text, the variable
+// name or the decoded string
+type Value struct {
+	Kind   ValueKind
+	Raw    string
+	List   []*Value
+	Fields []*ObjectField
+}
+
+// ObjectField is a field of an input object literal
+type ObjectField struct {
+	Name  string
+	Value *Value
+}
+
+// Yaegi resolves recursive types nested in another recursive type, such as
+// Value within Selection, to the outer one; a package-level Value has it
+// resolve on its own
+var _ = Value{}
+
+// TypeRef is a possibly wrapped type reference such as [ID!]!
+type TypeRef struct {
+	Name    string
+	Elem    *TypeRef
+	NonNull bool
+}
+
+// NamedType returns the innermost named type
+func (t *TypeRef) NamedType() string {
+	for t.Elem != nil {
+		t = t.Elem
+	}
+	return t.Name
+}
+
+func (t *TypeRef) String() string {
+	s := t.Name
+	if t.Elem != nil {
+		s = "[" + t.Elem.String() + "]"
+	}
+	if t.NonNull {
+		s += "!"
+	}
+	return s
+}
+
+// Fragment returns the fragment definition with the given name
+func (d *Document) Fragment(name string) *Fragment {
+	for _, f := range d.Fragments {
+		if f.Name == name {
+			return f
+		}
+	}
+	return nil
+}
+
+// Operation returns the operation selected by name, or the only operation of
+// the document when name is empty
+func (d *Document) Operation(name string) *Operation {
+	if name == "" {
+		if len(d.Operations) == 1 {
+			return d.Operations[0]
+		}
+		return nil
+	}
+	for _, op := range d.Operations {
+		if op.Name == name {
+			return op
+		}
+	}
+	return nil
+}
+
+// Parse parses an executable GraphQL document
+func Parse(source string) (*Document, error) {
+	return ParseWithLimits(source, Limits{})
+}
+
+func (p *parser) parseDocument() (*Document, error) {
+	doc := &Document{}
+	for p.token.Kind != TokenEOF {
+		switch {
+		case p.peek("{"):
+			selections, err := p.parseSelectionSet()
+			if err != nil {
+				return nil, err
+			}
+			doc.Operations = append(doc.Operations, &Operation{Type: "query", SelectionSet: selections})
+		// One expression per case, as yaegi only evaluates the first of a list
+		case p.peekName("query") || p.peekName("mutation") || p.peekName("subscription"):
+			op, err := p.parseOperation()
+			if err != nil {
+				return nil, err
+			}
+			doc.Operations = append(doc.Operations, op)
+		case p.peekName("fragment"):
+			fragment, err := p.parseFragment()
+			if err != nil {
+				return nil, err
+			}
+			doc.Fragments = append(doc.Fragments, fragment)
+		default:
+			return nil, p.unexpected()
+		}
+	}
+	return doc, nil
+}
+
+// parser is a recursive descent parser over the lexer tokens
+type parser struct {
+	lexer  lexer
+	token  Token
+	limits Limits
+	tokens int
+	nodes  int
+	depth  int
+
+	// Nodes are carved out of slabs to amortize allocations; the slabs live
+	// as long as the document referencing them
+	selectionSlab []Selection
+	argumentSlab  []Argument
+	valueSlab     []Value
+
+	// Stacks collect the children of the nodes being parsed, so that each
+	// list is allocated once at its final size
+	selectionStack []*Selection
+	argumentStack  []*Argument
+}
+
+// slabSize estimates how many nodes to allocate at once from the source
+// length, assuming one node per bytesPerNode bytes
+func (p *parser) slabSize(bytesPerNode int) int {
+	size := len(p.lexer.source) / bytesPerNode
+	if size < 4 {
+		return 4
+	}
+	if size > 256 {
+		return 256
+	}
+	return size
+}
+
+func (p *parser) newSelection(kind SelectionKind) *Selection {
+	if len(p.selectionSlab) == 0 {
+		p.selectionSlab = make([]Selection, p.slabSize(16))
+	}
+	s := &p.selectionSlab[0]
+	p.selectionSlab = p.selectionSlab[1:]
+	s.Kind = kind
+	return s
+}
+
+func (p *parser) newArgument(name string, value *Value) *Argument {
+	if len(p.argumentSlab) == 0 {
+		p.argumentSlab = make([]Argument, p.slabSize(48))
+	}
+	a := &p.argumentSlab[0]
+	p.argumentSlab = p.argumentSlab[1:]
+	a.Name, a.Value = name, value
+	return a
+}
+
+func (p *parser) newValue(kind ValueKind, raw string) *Value {
+	if len(p.valueSlab) == 0 {
+		p.valueSlab = make([]Value, p.slabSize(48))
+	}
+	v := &p.valueSlab[0]
+	p.valueSlab = p.valueSlab[1:]
+	v.Kind, v.Raw = kind, raw
+	return v
+}
+
+func newParser(source string, limits Limits) (*parser, error) {
+	if limits.MaxDepth == 0 {
+		limits.MaxDepth = DefaultMaxDepth
+	}
+	p := &parser{lexer: lexer{source: source}, limits: limits}
+	if err := p.advance(); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+func (p *parser) advance() error {
+	token, err := p.lexer.next()
+	if err != nil {
+		return err
+	}
+	p.token = token
+	if token.Kind != TokenEOF {
+		p.tokens++
+		if p.limits.MaxTokens > 0 && p.tokens > p.limits.MaxTokens {
+			return &LimitError{Limit: "maxTokens", Max: p.limits.MaxTokens}
+		}
+	}
+	return nil
+}
+
+// enter descends into a nested selection set, value or type, failing past
+// the depth limit; each successful enter is paired with a leave
+func (p *parser) enter() error {
+	p.depth++
+	if p.depth > p.limits.MaxDepth {
+		p.depth--
+		return &LimitError{Limit: "maxDepth", Max: p.limits.MaxDepth}
+	}
+	return nil
+}
+
+func (p *parser) leave() {
+	p.depth--
+}
+
+// peek reports whether the current token is the given punctuator
+func (p *parser) peek(punctuator string) bool {
+	return p.token.Kind == TokenPunctuator && p.token.Value == punctuator
+}
+
+// peekName reports whether the current token is the given name
+func (p *parser) peekName(name string) bool {
+	return p.token.Kind == TokenName && p.token.Value == name
+}
+
+func (p *parser) unexpected() error {
+	if p.token.Kind == TokenEOF {
+		return newSyntaxError(p.lexer.source, p.token.Start, "unexpected <EOF>")
+	}
+	return newSyntaxError(p.lexer.source, p.token.Start, "unexpected %s %q", p.token.Kind.String(), p.token.Value)
+}
+
+// expect consumes the given punctuator
+func (p *parser) expect(punctuator string) error {
+	if !p.peek(punctuator) {
+		return newSyntaxError(p.lexer.source, p.token.Start, "expected %q, found %s", punctuator, p.describe())
+	}
+	return p.advance()
+}
+
+// skip consumes the punctuator if present and reports whether it did
+func (p *parser) skip(punctuator string) (bool, error) {
+	if !p.peek(punctuator) {
+		return false, nil
+	}
+	return true, p.advance()
+}
+
+// expectName consumes a name token and returns it
+func (p *parser) expectName() (string, error) {
+	if p.token.Kind != TokenName {
+		return "", newSyntaxError(p.lexer.source, p.token.Start, "expected name, found %s", p.describe())
+	}
+	name := p.token.Value
+	return name, p.advance()
+}
+
+// expectKeyword consumes the given name
+func (p *parser) expectKeyword(keyword string) error {
+	if !p.peekName(keyword) {
+		return newSyntaxError(p.lexer.source, p.token.Start, "expected %q, found %s", keyword, p.describe())
+	}
+	return p.advance()
+}
+
+func (p *parser) describe() string {
+	if p.token.Kind == TokenEOF {
+		return "<EOF>"
+	}
+	return p.token.Kind.String() + " " + quote(p.token.Value)
+}
+
+func quote(s string) string {
+	return `"` + strings.ReplaceAll(s, `"`, `\"`) + `"`
+}
+
+func (p *parser) parseOperation() (*Operation, error) {
+	op := &Operation{Type: p.token.Value}
+	if err := p.advance(); err != nil {
+		return nil, err
+	}
+
+	var err error
+	if p.token.Kind == TokenName {
+		if op.Name, err = p.expectName(); err != nil {
+			return nil, err
+		}
+	}
+	if op.VariableDefinitions, err = p.parseVariableDefinitions(); err != nil {
+		return nil, err
+	}
+	if op.Directives, err = p.parseDirectives(false); err != nil {
+		return nil, err
+	}
+	if op.SelectionSet, err = p.parseSelectionSet(); err != nil {
+		return nil, err
+	}
+	return op, nil
+}
+
+func (p *parser) parseVariableDefinitions() ([]*VariableDefinition, error) {
+	if ok, err := p.skip("("); !ok || err != nil {
+		return nil, err
+	}
+
+	var definitions []*VariableDefinition
+	for {
+		if ok, err := p.skip(")"); ok || err != nil {
+			if len(definitions) == 0 && err == nil {
+				return nil, newSyntaxError(p.lexer.source, p.token.Start, "expected variable definition")
+			}
+			return definitions, err
+		}
+
+		definition := &VariableDefinition{}
+		if err := p.expect("$"); err != nil {
+			return nil, err
+		}
+		var err error
+		if definition.Name, err = p.expectName(); err != nil {
+			return nil, err
+		}
+		if err := p.expect(":"); err != nil {
+			return nil, err
+		}
+		if definition.Type, err = p.parseTypeRef(); err != nil {
+			return nil, err
+		}
+		if ok, err := p.skip("="); err != nil {
+			return nil, err
+		} else if ok {
+			if definition.DefaultValue, err = p.parseValue(true); err != nil {
+				return nil, err
+			}
+		}
+		if definition.Directives, err = p.parseDirectives(true); err != nil {
+			return nil, err
+		}
+		definitions = append(definitions, definition)
+	}
+}
+
+func (p *parser) parseTypeRef() (*TypeRef, error) {
+	var t *TypeRef
+	if ok, err := p.skip("["); err != nil {
+		return nil, err
+	} else if ok {
+		if err := p.enter(); err != nil {
+			return nil, err
+		}
+		elem, err := p.parseTypeRef()
+		p.leave()
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expect("]"); err != nil {
+			return nil, err
+		}
+		t = &TypeRef{Elem: elem}
+	} else {
+		name, err := p.expectName()
+		if err != nil {
+			return nil, err
+		}
+		t = &TypeRef{Name: name}
+	}
+
+	ok, err := p.skip("!")
+	t.NonNull = ok
+	return t, err
+}
+
+func (p *parser) parseSelectionSet() ([]*Selection, error) {
+	if err := p.expect("{"); err != nil {
+		return nil, err
+	}
+	if err := p.enter(); err != nil {
+		return nil, err
+	}
+	defer p.leave()
+
+	base := len(p.selectionStack)
+	defer func() { p.selectionStack = p.selectionStack[:base] }()
+	for {
+		if ok, err := p.skip("}"); ok || err != nil {
+			if len(p.selectionStack) == base && err == nil {
+				return nil, newSyntaxError(p.lexer.source, p.token.Start, "expected selection")
+			}
+			if err != nil {
+				return nil, err
+			}
+			return append([]*Selection(nil), p.selectionStack[base:]...), nil
+		}
+		selection, err := p.parseSelection()
+		if err != nil {
+			return nil, err
+		}
+		p.selectionStack = append(p.selectionStack, selection)
+	}
+}
+
+func (p *parser) parseSelection() (*Selection, error) {
+	var err error
+
+	p.nodes++
+	if p.limits.MaxSelectionSetNodes > 0 && p.nodes > p.limits.MaxSelectionSetNodes {
+		return nil, &LimitError{Limit: "maxSelectionSetNodes", Max: p.limits.MaxSelectionSetNodes}
+	}
+
+	if ok, err := p.skip("..."); err != nil {
+		return nil, err
+	} else if ok {
+		if p.token.Kind == TokenName && !p.peekName("on") {
+			spread := p.newSelection(FragmentSpreadSelection)
+			spread.Name = p.token.Value
+			if err := p.advance(); err != nil {
+				return nil, err
+			}
+			if spread.Directives, err = p.parseDirectives(false); err != nil {
+				return nil, err
+			}
+			return spread, nil
+		}
+
+		inline := p.newSelection(InlineFragmentSelection)
+		if p.peekName("on") {
+			if err := p.advance(); err != nil {
+				return nil, err
+			}
+			if inline.TypeCondition, err = p.expectName(); err != nil {
+				return nil, err
+			}
+		}
+		if inline.Directives, err = p.parseDirectives(false); err != nil {
+			return nil, err
+		}
+		if inline.SelectionSet, err = p.parseSelectionSet(); err != nil {
+			return nil, err
+		}
+		return inline, nil
+	}
+
+	field := p.newSelection(FieldSelection)
+	if field.Name, err = p.expectName(); err != nil {
+		return nil, err
+	}
+	if ok, err := p.skip(":"); err != nil {
+		return nil, err
+	} else if ok {
+		field.Alias = field.Name
+		if field.Name, err = p.expectName(); err != nil {
+			return nil, err
+		}
+	}
+	if field.Arguments, err = p.parseArguments(false); err != nil {
+		return nil, err
+	}
+	if field.Directives, err = p.parseDirectives(false); err != nil {
+		return nil, err
+	}
+	if p.peek("{") {
+		if field.SelectionSet, err = p.parseSelectionSet(); err != nil {
+			return nil, err
+		}
+	}
+	return field, nil
+}
+
+func (p *parser) parseArguments(constant bool) ([]*Argument, error) {
+	if ok, err := p.skip("("); !ok || err != nil {
+		return nil, err
+	}
+
+	base := len(p.argumentStack)
+	defer func() { p.argumentStack = p.argumentStack[:base] }()
+	for {
+		if ok, err := p.skip(")"); ok || err != nil {
+			if len(p.argumentStack) == base && err == nil {
+				return nil, newSyntaxError(p.lexer.source, p.token.Start, "expected argument")
+			}
+			if err != nil {
+				return nil, err
+			}
+			return append([]*Argument(nil), p.argumentStack[base:]...), nil
+		}
+		name, err := p.expectName()
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expect(":"); err != nil {
+			return nil, err
+		}
+		value, err := p.parseValue(constant)
+		if err != nil {
+			return nil, err
+		}
+		p.argumentStack = append(p.argumentStack, p.newArgument(name, value))
+	}
+}
+
+func (p *parser) parseDirectives(constant bool) ([]*Directive, error) {
+	var directives []*Directive
+	for p.peek("@") {
+		if err := p.advance(); err != nil {
+			return nil, err
+		}
+		name, err := p.expectName()
+		if err != nil {
+			return nil, err
+		}
+		arguments, err := p.parseArguments(constant)
+		if err != nil {
+			return nil, err
+		}
+		directives = append(directives, &Directive{Name: name, Arguments: arguments})
+	}
+	return directives, nil
+}
+
+// parseValue parses a value literal; variables are rejected in constant contexts
+func (p *parser) parseValue(constant bool) (*Value, error) {
+	token := p.token
+
+	switch token.Kind {
+	case TokenInt:
+		return p.newValue(IntValue, token.Value), p.advance()
+	case TokenFloat:
+		return p.newValue(FloatValue, token.Value), p.advance()
+	case TokenString, TokenBlockString:
+		return p.newValue(StringValue, token.Value), p.advance()
+	case TokenName:
+		switch token.Value {
+		case "true", "false":
+			return p.newValue(BooleanValue, token.Value), p.advance()
+		case "null":
+			return p.newValue(NullValue, token.Value), p.advance()
+		default:
+			return p.newValue(EnumValue, token.Value), p.advance()
+		}
+	}
+
+	switch {
+	case p.peek("$") && !constant:
+		if err := p.advance(); err != nil {
+			return nil, err
+		}
+		name, err := p.expectName()
+		if err != nil {
+			return nil, err
+		}
+		return p.newValue(VariableValue, name), nil
+	case p.peek("["):
+		if err := p.advance(); err != nil {
+			return nil, err
+		}
+		if err := p.enter(); err != nil {
+			return nil, err
+		}
+		defer p.leave()
+		list := p.newValue(ListValue, "")
+		for {
+			if ok, err := p.skip("]"); ok || err != nil {
+				return list, err
+			}
+			item, err := p.parseValue(constant)
+			if err != nil {
+				return nil, err
+			}
+			list.List = append(list.List, item)
+		}
+	case p.peek("{"):
+		if err := p.advance(); err != nil {
+			return nil, err
+		}
+		if err := p.enter(); err != nil {
+			return nil, err
+		}
+		defer p.leave()
+		object := p.newValue(ObjectValue, "")
+		for {
+			if ok, err := p.skip("}"); ok || err != nil {
+				return object, err
+			}
+			name, err := p.expectName()
+			if err != nil {
+				return nil, err
+			}
+			if err := p.expect(":"); err != nil {
+				return nil, err
+			}
+			value, err := p.parseValue(constant)
+			if err != nil {
+				return nil, err
+			}
+			object.Fields = append(object.Fields, &ObjectField{Name: name, Value: value})
+		}
+	}
+	return nil, p.unexpected()
+}
+
+func (p *parser) parseFragment() (*Fragment, error) {
+	if err := p.expectKeyword("fragment"); err != nil {
+		return nil, err
+	}
+
+	fragment := &Fragment{}
+	var err error
+	if p.peekName("on") {
+		return nil, p.unexpected()
+	}
+	if fragment.Name, err = p.expectName(); err != nil {
+		return nil, err
+	}
+	if err := p.expectKeyword("on"); err != nil {
+		return nil, err
+	}
+	if fragment.TypeCondition, err = p.expectName(); err != nil {
+		return nil, err
+	}
+	if fragment.Directives, err = p.parseDirectives(false); err != nil {
+		return nil, err
+	}
+	if fragment.SelectionSet, err = p.parseSelectionSet(); err != nil {
+		return nil, err
+	}
+	return fragment, nil
+}
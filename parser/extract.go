@@ -0,0 +1,104 @@
+package parser
+
+import (
+	"strconv"
+)
+
+// OperationSummary describes an operation of a document
+type OperationSummary struct {
+	Type string
+	Name string
+	// RootFields are the names (not aliases) of the root fields, including
+	// those selected through fragments
+	RootFields []string
+}
+
+// ExtractOperations summarizes every operation of the document
+func ExtractOperations(doc *Document) []*OperationSummary {
+	summaries := make([]*OperationSummary, 0, len(doc.Operations))
+	for _, op := range doc.Operations {
+		summary := &OperationSummary{Type: op.Type, Name: op.Name}
+		for _, field := range doc.RootFields(op) {
+			summary.RootFields = append(summary.RootFields, field.Name)
+		}
+		summaries = append(summaries, summary)
+	}
+	return summaries
+}
+
+// RootFields returns the root field selections of the operation, flattening
+// inline fragments and fragment spreads
+func (d *Document) RootFields(op *Operation) []*Selection {
+	var fields []*Selection
+	var visited map[string]bool
+
+	var collect func(selections []*Selection)
+	collect = func(selections []*Selection) {
+		for _, selection := range selections {
+			switch selection.Kind {
+			case FieldSelection:
+				fields = append(fields, selection)
+			case InlineFragmentSelection:
+				collect(selection.SelectionSet)
+			case FragmentSpreadSelection:
+				fragment := d.Fragment(selection.Name)
+				if fragment == nil || visited[fragment.Name] {
+					continue
+				}
+				if visited == nil {
+					visited = make(map[string]bool)
+				}
+				visited[fragment.Name] = true
+				collect(fragment.SelectionSet)
+			}
+		}
+	}
+	collect(op.SelectionSet)
+	return fields
+}
+
+// Argument returns the argument with the given name
+func (s *Selection) Argument(name string) *Argument {
+	for _, arg := range s.Arguments {
+		if arg.Name == name {
+			return arg
+		}
+	}
+	return nil
+}
+
+// Resolve converts the literal to its JSON-like Go value (string, int64,
+// float64, bool, nil, []any or map[string]any), substituting variables
+func (v *Value) Resolve(variables map[string]any) any {
+	switch v.Kind {
+	case VariableValue:
+		return variables[v.Raw]
+	case IntValue:
+		if n, err := strconv.ParseInt(v.Raw, 10, 64); err == nil {
+			return n
+		}
+		f, _ := strconv.ParseFloat(v.Raw, 64)
+		return f
+	case FloatValue:
+		f, _ := strconv.ParseFloat(v.Raw, 64)
+		return f
+	case BooleanValue:
+		return v.Raw == "true"
+	case NullValue:
+		return nil
+	case ListValue:
+		list := make([]any, len(v.List))
+		for i, item := range v.List {
+			list[i] = item.Resolve(variables)
+		}
+		return list
+	case ObjectValue:
+		object := make(map[string]any, len(v.Fields))
+		for _, field := range v.Fields {
+			object[field.Name] = field.Value.Resolve(variables)
+		}
+		return object
+	default: // StringValue, EnumValue
+		return v.Raw
+	}
+}
@@ -0,0 +1,80 @@
+package parser
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// addCorpusSeeds seeds a fuzz target with the regression corpus
+func addCorpusSeeds(f *testing.F) {
+	paths, _ := filepath.Glob(filepath.Join("testdata", "corpus", "*", "*.graphql"))
+	for _, path := range paths {
+		if data, err := os.ReadFile(path); err == nil {
+			f.Add(string(data))
+		}
+	}
+	f.Add("")
+	f.Add("{")
+	f.Add(`{ a(x: "\u{`)
+	f.Add(`query Q($v: [[Int!]!] = [[1]]) { ...F } fragment F on Q { ...F }`)
+}
+
+func FuzzParse(f *testing.F) {
+	addCorpusSeeds(f)
+	f.Fuzz(func(t *testing.T, source string) {
+		doc, err := Parse(source)
+		if err != nil {
+			var syntaxErr *SyntaxError
+			if !errors.As(err, &syntaxErr) {
+				t.Fatalf("got %T error, want *SyntaxError: %v", err, err)
+			}
+			return
+		}
+
+		// Helpers used by the plugin must cope with any parsed document
+		for _, summary := range ExtractOperations(doc) {
+			_ = summary.RootFields
+		}
+		for _, op := range doc.Operations {
+			for _, field := range doc.RootFields(op) {
+				for _, arg := range field.Arguments {
+					_ = arg.Value.Resolve(map[string]any{"v": []any{1.0}})
+				}
+			}
+		}
+	})
+}
+
+func FuzzNormalize(f *testing.F) {
+	addCorpusSeeds(f)
+	f.Fuzz(func(t *testing.T, source string) {
+		normalized, err := Normalize(source)
+		if err != nil {
+			return
+		}
+		again, err := Normalize(normalized)
+		if err != nil {
+			t.Fatalf("normalized form %q does not lex: %v", normalized, err)
+		}
+		if again != normalized {
+			t.Fatalf("normalization is not stable: %q then %q", normalized, again)
+		}
+	})
+}
+
+func FuzzParseSchema(f *testing.F) {
+	f.Add("type Query { a(x: Int = 1): String @deprecated(reason: \"no\") }")
+	f.Add(`"""doc""" schema { query: Q } extend type Q implements A & B { a: [Int!]! }`)
+	f.Add("union U = | A | B enum E { A B } input I { a: Int = 1 } directive @d(a: Int) repeatable on FIELD | QUERY")
+	f.Fuzz(func(t *testing.T, source string) {
+		_, err := ParseSchema(source)
+		if err != nil {
+			var syntaxErr *SyntaxError
+			if !errors.As(err, &syntaxErr) {
+				t.Fatalf("got %T error, want *SyntaxError: %v", err, err)
+			}
+		}
+	})
+}
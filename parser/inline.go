@@ -0,0 +1,112 @@
+package parser
+
+import (
+	"math"
+	"sort"
+	"strconv"
+)
+
+// InlineVariables substitutes the variables referenced by the arguments of
+// the operation, and of the fragments it spreads, with their values or the
+// defaults the operation defines, so that policies read arguments as
+// literals. Variables without a value nor a default are left in place. The
+// document no longer matches the source afterwards and must not be printed
+// for forwarding. It reports whether the document changed.
+func InlineVariables(doc *Document, op *Operation, variables map[string]any) bool {
+	values := make(map[string]*Value, len(op.VariableDefinitions))
+	for _, definition := range op.VariableDefinitions {
+		if value, ok := variables[definition.Name]; ok {
+			values[definition.Name] = ValueOf(value)
+		} else if definition.DefaultValue != nil {
+			values[definition.Name] = definition.DefaultValue
+		}
+	}
+	if len(values) == 0 {
+		return false
+	}
+
+	changed := false
+	var inline func(v *Value) *Value
+	inline = func(v *Value) *Value {
+		switch v.Kind {
+		case VariableValue:
+			if value, ok := values[v.Raw]; ok {
+				changed = true
+				return value
+			}
+		case ListValue:
+			for i, item := range v.List {
+				v.List[i] = inline(item)
+			}
+		case ObjectValue:
+			for _, field := range v.Fields {
+				field.Value = inline(field.Value)
+			}
+		}
+		return v
+	}
+	inlineArguments := func(arguments []*Argument) {
+		for _, argument := range arguments {
+			argument.Value = inline(argument.Value)
+		}
+	}
+
+	visited := make(map[string]bool)
+	var walk func(selections []*Selection)
+	walk = func(selections []*Selection) {
+		for _, selection := range selections {
+			inlineArguments(selection.Arguments)
+			for _, directive := range selection.Directives {
+				inlineArguments(directive.Arguments)
+			}
+			if selection.Kind == FragmentSpreadSelection && !visited[selection.Name] {
+				visited[selection.Name] = true
+				if fragment := doc.Fragment(selection.Name); fragment != nil {
+					walk(fragment.SelectionSet)
+				}
+			}
+			walk(selection.SelectionSet)
+		}
+	}
+	walk(op.SelectionSet)
+	return changed
+}
+
+// ValueOf converts a JSON-like Go value, as variables are decoded, to a literal
+func ValueOf(value any) *Value {
+	switch v := value.(type) {
+	case nil:
+		return &Value{Kind: NullValue, Raw: "null"}
+	case bool:
+		return &Value{Kind: BooleanValue, Raw: strconv.FormatBool(v)}
+	case string:
+		return &Value{Kind: StringValue, Raw: v}
+	case int:
+		return &Value{Kind: IntValue, Raw: strconv.Itoa(v)}
+	case int64:
+		return &Value{Kind: IntValue, Raw: strconv.FormatInt(v, 10)}
+	case float64:
+		if v == math.Trunc(v) && math.Abs(v) < 1<<53 {
+			return &Value{Kind: IntValue, Raw: strconv.FormatInt(int64(v), 10)}
+		}
+		return &Value{Kind: FloatValue, Raw: strconv.FormatFloat(v, 'g', -1, 64)}
+	case []any:
+		list := &Value{Kind: ListValue, List: make([]*Value, len(v))}
+		for i, item := range v {
+			list.List[i] = ValueOf(item)
+		}
+		return list
+	case map[string]any:
+		names := make([]string, 0, len(v))
+		for name := range v {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		object := &Value{Kind: ObjectValue, Fields: make([]*ObjectField, len(names))}
+		for i, name := range names {
+			object.Fields[i] = &ObjectField{Name: name, Value: ValueOf(v[name])}
+		}
+		return object
+	}
+	return &Value{Kind: NullValue, Raw: "null"}
+}
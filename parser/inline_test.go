@@ -0,0 +1,53 @@
+package parser
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestInlineVariables(t *testing.T) {
+	tests := []struct {
+		name      string
+		source    string
+		variables map[string]any
+		want      string
+		changed   bool
+	}{
+		{"no variables", "{ a(first: 10) }", nil, "{a(first:10)}", false},
+		{"value", "query($n: Int) { a(first: $n) }", map[string]any{"n": float64(5)}, "query($n:Int){a(first:5)}", true},
+		{"default", "query($n: Int = 20) { a(first: $n) }", nil, "query($n:Int=20){a(first:20)}", true},
+		{"value over default", "query($n: Int = 20) { a(first: $n) }", map[string]any{"n": float64(3)}, "query($n:Int=20){a(first:3)}", true},
+		{"explicit null", "query($n: Int = 20) { a(first: $n) }", map[string]any{"n": nil}, "query($n:Int=20){a(first:null)}", true},
+		{"missing", "query($n: Int) { a(first: $n) }", nil, "query($n:Int){a(first:$n)}", false},
+		{"nested", `query($t: String, $s: Sort) { a(where: {tenant: $t, ids: [$t]}) @include(if: true) { b(sort: $s) } }`,
+			map[string]any{"t": "acme", "s": map[string]any{"by": "name", "desc": true}},
+			`query($t:String$s:Sort){a(where:{tenant:"acme"ids:["acme"]})@include(if:true){b(sort:{by:"name"desc:true})}}`, true},
+		{"fragment", "query($id: ID) { ...F } fragment F on Query { user(id: $id) { name } }", map[string]any{"id": "7"},
+			`query($id:ID){...F}fragment F on Query{user(id:"7"){name}}`, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			doc, err := Parse(tt.source)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if changed := InlineVariables(doc, doc.Operations[0], tt.variables); changed != tt.changed {
+				t.Errorf("changed = %v, want %v", changed, tt.changed)
+			}
+			if got := Print(doc); got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestValueOf(t *testing.T) {
+	values := []any{nil, true, "s", int64(-3), float64(4), 2.5, 1e300, []any{"a", float64(1)}, map[string]any{"k": []any{false}}}
+	want := []any{nil, true, "s", int64(-3), int64(4), 2.5, 1e300, []any{"a", int64(1)}, map[string]any{"k": []any{false}}}
+	for i, value := range values {
+		if got := ValueOf(value).Resolve(nil); !reflect.DeepEqual(got, want[i]) {
+			t.Errorf("ValueOf(%v) resolves to %#v, want %#v", value, got, want[i])
+		}
+	}
+}
@@ -0,0 +1,380 @@
+package parser
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf16"
+	"unicode/utf8"
+)
+
+// TokenKind identifies the lexical class of a token
+type TokenKind int
+
+// Token kinds of the GraphQL grammar
+const (
+	TokenEOF TokenKind = iota
+	TokenPunctuator
+	TokenName
+	TokenInt
+	TokenFloat
+	TokenString
+	TokenBlockString
+)
+
+func (k TokenKind) String() string {
+	switch k {
+	case TokenEOF:
+		return "<EOF>"
+	case TokenPunctuator:
+		return "punctuator"
+	case TokenName:
+		return "name"
+	case TokenInt:
+		return "int"
+	case TokenFloat:
+		return "float"
+	case TokenString, TokenBlockString:
+		return "string"
+	default:
+		return "unknown"
+	}
+}
+
+// Token is a lexical token; Value holds the decoded value of string tokens
+type Token struct {
+	Kind  TokenKind
+	Value string
+	Start int
+}
+
+// SyntaxError describes a lexing or parsing failure at a position of the source
+type SyntaxError struct {
+	Message string
+	Line    int
+	Column  int
+}
+
+func (e *SyntaxError) Error() string {
+	return fmt.Sprintf("syntax error at %d:%d: %s", e.Line, e.Column, e.Message)
+}
+
+// newSyntaxError resolves the byte offset into a line and column
+func newSyntaxError(source string, offset int, format string, args ...any) *SyntaxError {
+	if offset > len(source) {
+		offset = len(source)
+	}
+	line := 1 + strings.Count(source[:offset], "\n")
+	column := offset - strings.LastIndex(source[:offset], "\n")
+	return &SyntaxError{Message: fmt.Sprintf(format, args...), Line: line, Column: column}
+}
+
+// lexer splits a GraphQL source into tokens
+type lexer struct {
+	source string
+	pos    int
+}
+
+// next returns the next token, skipping ignored tokens (whitespace, commas, comments)
+func (l *lexer) next() (Token, error) {
+	l.skipIgnored()
+	if l.pos >= len(l.source) {
+		return Token{Kind: TokenEOF, Start: l.pos}, nil
+	}
+
+	start := l.pos
+	c := l.source[l.pos]
+	switch {
+	case c == '.':
+		if strings.HasPrefix(l.source[l.pos:], "...") {
+			l.pos += 3
+			return Token{Kind: TokenPunctuator, Value: "...", Start: start}, nil
+		}
+		return Token{}, newSyntaxError(l.source, start, "unexpected %q", ".")
+	case strings.IndexByte("!$&():=@[]{}|", c) >= 0:
+		l.pos++
+		return Token{Kind: TokenPunctuator, Value: l.source[start:l.pos], Start: start}, nil
+	case isNameStart(c):
+		l.pos++
+		for l.pos < len(l.source) && isNameContinue(l.source[l.pos]) {
+			l.pos++
+		}
+		return Token{Kind: TokenName, Value: l.source[start:l.pos], Start: start}, nil
+	case c == '-' || isDigit(c):
+		return l.readNumber()
+	case c == '"':
+		if strings.HasPrefix(l.source[l.pos:], `"""`) {
+			return l.readBlockString()
+		}
+		return l.readString()
+	default:
+		r, _ := utf8.DecodeRuneInString(l.source[l.pos:])
+		return Token{}, newSyntaxError(l.source, start, "unexpected character %q", r)
+	}
+}
+
+func (l *lexer) skipIgnored() {
+	for l.pos < len(l.source) {
+		switch c := l.source[l.pos]; c {
+		case ' ', '\t', '\n', '\r', ',':
+			l.pos++
+		case '#':
+			for l.pos < len(l.source) && l.source[l.pos] != '\n' && l.source[l.pos] != '\r' {
+				l.pos++
+			}
+		case 0xEF:
+			// Unicode BOM
+			if strings.HasPrefix(l.source[l.pos:], "\uFEFF") {
+				l.pos += 3
+				continue
+			}
+			return
+		default:
+			return
+		}
+	}
+}
+
+func (l *lexer) readNumber() (Token, error) {
+	start := l.pos
+	kind := TokenInt
+
+	if l.source[l.pos] == '-' {
+		l.pos++
+	}
+	if l.pos < len(l.source) && l.source[l.pos] == '0' {
+		l.pos++
+		if l.pos < len(l.source) && isDigit(l.source[l.pos]) {
+			return Token{}, newSyntaxError(l.source, l.pos, "invalid number, unexpected digit after 0")
+		}
+	} else if err := l.readDigits(); err != nil {
+		return Token{}, err
+	}
+
+	if l.pos < len(l.source) && l.source[l.pos] == '.' {
+		kind = TokenFloat
+		l.pos++
+		if err := l.readDigits(); err != nil {
+			return Token{}, err
+		}
+	}
+	if l.pos < len(l.source) && (l.source[l.pos] == 'e' || l.source[l.pos] == 'E') {
+		kind = TokenFloat
+		l.pos++
+		if l.pos < len(l.source) && (l.source[l.pos] == '+' || l.source[l.pos] == '-') {
+			l.pos++
+		}
+		if err := l.readDigits(); err != nil {
+			return Token{}, err
+		}
+	}
+	if l.pos < len(l.source) && (l.source[l.pos] == '.' || isNameStart(l.source[l.pos])) {
+		return Token{}, newSyntaxError(l.source, l.pos, "invalid number, unexpected %q", l.source[l.pos])
+	}
+
+	return Token{Kind: kind, Value: l.source[start:l.pos], Start: start}, nil
+}
+
+func (l *lexer) readDigits() error {
+	if l.pos >= len(l.source) || !isDigit(l.source[l.pos]) {
+		return newSyntaxError(l.source, l.pos, "invalid number, expected digit")
+	}
+	for l.pos < len(l.source) && isDigit(l.source[l.pos]) {
+		l.pos++
+	}
+	return nil
+}
+
+// readString reads a quoted string, decoding its escape sequences
+func (l *lexer) readString() (Token, error) {
+	start := l.pos
+	l.pos++
+
+	var value strings.Builder
+	chunkStart := l.pos
+	for l.pos < len(l.source) {
+		c := l.source[l.pos]
+		switch c {
+		case '"':
+			l.pos++
+			// Strings without escapes are slices of the source
+			if value.Len() == 0 && chunkStart == start+1 {
+				return Token{Kind: TokenString, Value: l.source[chunkStart : l.pos-1], Start: start}, nil
+			}
+			value.WriteString(l.source[chunkStart : l.pos-1])
+			return Token{Kind: TokenString, Value: value.String(), Start: start}, nil
+		case '\n', '\r':
+			return Token{}, newSyntaxError(l.source, l.pos, "unterminated string")
+		case '\\':
+			value.WriteString(l.source[chunkStart:l.pos])
+			if l.pos+1 >= len(l.source) {
+				return Token{}, newSyntaxError(l.source, l.pos, "unterminated string")
+			}
+			switch esc := l.source[l.pos+1]; esc {
+			case '"', '\\', '/':
+				value.WriteByte(esc)
+			case 'b':
+				value.WriteByte('\b')
+			case 'f':
+				value.WriteByte('\f')
+			case 'n':
+				value.WriteByte('\n')
+			case 'r':
+				value.WriteByte('\r')
+			case 't':
+				value.WriteByte('\t')
+			case 'u':
+				r, err := l.readUnicodeEscape()
+				if err != nil {
+					return Token{}, err
+				}
+				value.WriteRune(r)
+				chunkStart = l.pos
+				continue
+			default:
+				return Token{}, newSyntaxError(l.source, l.pos, "invalid escape sequence \\%c", esc)
+			}
+			l.pos += 2
+			chunkStart = l.pos
+		default:
+			if c < 0x20 && c != '\t' {
+				return Token{}, newSyntaxError(l.source, l.pos, "invalid character %q in string", rune(c))
+			}
+			l.pos++
+		}
+	}
+	return Token{}, newSyntaxError(l.source, l.pos, "unterminated string")
+}
+
+// readUnicodeEscape decodes the \uXXXX or \u{X...} escape at the current
+// position, combining a surrogate pair written as two fixed-width escapes
+func (l *lexer) readUnicodeEscape() (rune, error) {
+	start := l.pos
+	r, ok := l.readEscapedCodePoint()
+	if !ok {
+		return 0, newSyntaxError(l.source, start, "invalid unicode escape %q", l.source[start:l.pos])
+	}
+	if !utf16.IsSurrogate(r) {
+		return r, nil
+	}
+
+	// A leading surrogate must be followed by a trailing one
+	if r < 0xDC00 && strings.HasPrefix(l.source[l.pos:], `\u`) {
+		next := l.pos
+		if low, ok := l.readEscapedCodePoint(); ok {
+			if combined := utf16.DecodeRune(r, low); combined != utf8.RuneError {
+				return combined, nil
+			}
+		}
+		l.pos = next
+	}
+	return 0, newSyntaxError(l.source, start, "invalid unicode escape %q, unpaired surrogate", l.source[start:l.pos])
+}
+
+// readEscapedCodePoint reads a single \uXXXX or \u{X...} escape
+func (l *lexer) readEscapedCodePoint() (rune, bool) {
+	l.pos += 2
+	if l.pos < len(l.source) && l.source[l.pos] == '{' {
+		end := strings.IndexByte(l.source[l.pos:], '}')
+		if end < 2 || end > 9 {
+			return 0, false
+		}
+		digits := l.source[l.pos+1 : l.pos+end]
+		l.pos += end + 1
+		code, err := strconv.ParseUint(digits, 16, 32)
+		if err != nil || code > unicode.MaxRune || utf16.IsSurrogate(rune(code)) {
+			return 0, false
+		}
+		return rune(code), true
+	}
+
+	if l.pos+4 > len(l.source) {
+		l.pos = len(l.source)
+		return 0, false
+	}
+	digits := l.source[l.pos : l.pos+4]
+	l.pos += 4
+	code, err := strconv.ParseUint(digits, 16, 32)
+	if err != nil {
+		return 0, false
+	}
+	return rune(code), true
+}
+
+// readBlockString reads a triple-quoted string; only \""" is an escape and
+// the value is dedented as specified by BlockStringValue
+func (l *lexer) readBlockString() (Token, error) {
+	start := l.pos
+	l.pos += 3
+
+	var raw strings.Builder
+	chunkStart := l.pos
+	for l.pos < len(l.source) {
+		if strings.HasPrefix(l.source[l.pos:], `"""`) {
+			raw.WriteString(l.source[chunkStart:l.pos])
+			l.pos += 3
+			return Token{Kind: TokenBlockString, Value: blockStringValue(raw.String()), Start: start}, nil
+		}
+		if strings.HasPrefix(l.source[l.pos:], `\"""`) {
+			raw.WriteString(l.source[chunkStart:l.pos])
+			raw.WriteString(`"""`)
+			l.pos += 4
+			chunkStart = l.pos
+			continue
+		}
+		l.pos++
+	}
+	return Token{}, newSyntaxError(l.source, l.pos, "unterminated block string")
+}
+
+// blockStringValue removes the common indentation of a block string, as well
+// as its leading and trailing blank lines
+func blockStringValue(raw string) string {
+	lines := strings.Split(strings.ReplaceAll(strings.ReplaceAll(raw, "\r\n", "\n"), "\r", "\n"), "\n")
+
+	commonIndent := -1
+	for _, line := range lines[1:] {
+		indent := leadingWhitespace(line)
+		if indent < len(line) && (commonIndent < 0 || indent < commonIndent) {
+			commonIndent = indent
+		}
+	}
+	if commonIndent > 0 {
+		for i := 1; i < len(lines); i++ {
+			if len(lines[i]) < commonIndent {
+				lines[i] = ""
+			} else {
+				lines[i] = lines[i][commonIndent:]
+			}
+		}
+	}
+
+	for len(lines) > 0 && leadingWhitespace(lines[0]) == len(lines[0]) {
+		lines = lines[1:]
+	}
+	for len(lines) > 0 && leadingWhitespace(lines[len(lines)-1]) == len(lines[len(lines)-1]) {
+		lines = lines[:len(lines)-1]
+	}
+	return strings.Join(lines, "\n")
+}
+
+func leadingWhitespace(line string) int {
+	i := 0
+	for i < len(line) && (line[i] == ' ' || line[i] == '\t') {
+		i++
+	}
+	return i
+}
+
+func isNameStart(c byte) bool {
+	return c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
+
+func isNameContinue(c byte) bool {
+	return isNameStart(c) || isDigit(c)
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
@@ -0,0 +1,117 @@
+package parser
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestStringValues(t *testing.T) {
+	tests := []struct {
+		name   string
+		source string
+		want   string
+	}{
+		{"plain", `"hello"`, "hello"},
+		{"escaped quote", `"say \"hi\""`, `say "hi"`},
+		{"simple escapes", `"\\\/\b\f\n\r\t"`, "\\/\b\f\n\r\t"},
+		{"hash", `"# not a comment"`, "# not a comment"},
+		{"fixed unicode", `"caf\u00e9"`, "café"},
+		{"surrogate pair", `"\uD83D\uDE00"`, "😀"},
+		{"braced unicode", `"\u{1F600}"`, "😀"},
+		{"braced leading zeros", `"\u{0000041}"`, "A"},
+		{"raw unicode", `"☕"`, "☕"},
+		{"block", `"""{ "braces" # and hashes }"""`, `{ "braces" # and hashes }`},
+		{"block escaped quotes", `"""a \""" b"""`, `a """ b`},
+		{"block backslashes", `"""\nA"""`, `\nA`},
+		{"block empty", `""""""`, ""},
+		{"block dedent", "\"\"\"\n    Hello,\n      World!\n\n    Yours,\n      GraphQL.\n  \"\"\"", "Hello,\n  World!\n\nYours,\n  GraphQL."},
+		{"block first line kept", "\"\"\"  first\n    second\n    third\"\"\"", "  first\nsecond\nthird"},
+		{"block crlf", "\"\"\"\r\n  a\r\n  b\r\n\"\"\"", "a\nb"},
+		{"block tabs", "\"\"\"\n\t\ta\n\t\t\tb\n\"\"\"", "a\n\tb"},
+		{"block blank lines only", "\"\"\"\n   \n\t\n\"\"\"", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l := &lexer{source: tt.source}
+			token, err := l.next()
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if token.Kind != TokenString && token.Kind != TokenBlockString {
+				t.Fatalf("got %s token, want string", token.Kind)
+			}
+			if token.Value != tt.want {
+				t.Errorf("got %q, want %q", token.Value, tt.want)
+			}
+			if next, _ := l.next(); next.Kind != TokenEOF {
+				t.Errorf("string was not fully consumed, next token %q", next.Value)
+			}
+		})
+	}
+}
+
+func TestInvalidStrings(t *testing.T) {
+	tests := []struct {
+		name   string
+		source string
+	}{
+		{"unterminated", `"abc`},
+		{"line break", "\"a\nb\""},
+		{"control character", "\"a\x01b\""},
+		{"unknown escape", `"\x41"`},
+		{"short unicode", `"\u12"`},
+		{"bad hex", `"\u12G4"`},
+		{"lone leading surrogate", `"\uD83D"`},
+		{"lone trailing surrogate", `"\uDE00"`},
+		{"reversed surrogates", `"\uDE00\uD83D"`},
+		{"braced surrogate", `"\u{D83D}"`},
+		{"braced out of range", `"\u{110000}"`},
+		{"braced empty", `"\u{}"`},
+		{"braced unterminated", `"\u{1F600"`},
+		{"unterminated block", `"""abc""`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l := &lexer{source: tt.source}
+			_, err := l.next()
+			var syntaxErr *SyntaxError
+			if !errors.As(err, &syntaxErr) {
+				t.Fatalf("got %v, want a *SyntaxError", err)
+			}
+		})
+	}
+}
+
+func TestNormalize(t *testing.T) {
+	tests := []struct {
+		name   string
+		source string
+		want   string
+	}{
+		{"whitespace and comments", "query  Q {\n  # comment\n  a ,\n  b\n}", "query Q{a b}"},
+		{"hash in string", `{ a(x: "#1 { }") # comment` + "\n}", `{a(x:"#1 { }")}`},
+		{"block string", "{ a(x: \"\"\"\n  line \"quoted\"\n  \"\"\") }", `{a(x:"line \"quoted\"")}`},
+		{"spread after name", "{ ... on T { a } ...F }", "{...on T{a}...F}"},
+		{"numbers", "{ a(x: [1 2 -3.5e1]) }", "{a(x:[1 2 -3.5e1])}"},
+		{"control characters", `{ a(x: "\u0001\t") }`, `{a(x:"\u0001\t")}`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Normalize(tt.source)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+			// The normalized form must itself be stable
+			again, err := Normalize(got)
+			if err != nil || again != got {
+				t.Errorf("normalizing %q again gave %q, %v", got, again, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,107 @@
+package parser
+
+import "fmt"
+
+// DefaultMaxDepth is the nesting depth allowed when Limits.MaxDepth is zero
+const DefaultMaxDepth = 128
+
+// Limits bounds the work spent on a document; zero values mean no limit,
+// except for MaxDepth
+type Limits struct {
+	// MaxTokens caps the number of lexical tokens, ignored tokens excluded
+	MaxTokens int
+	// MaxSelectionSetNodes caps the number of fields, fragment spreads and
+	// inline fragments across the whole document, fragments included
+	MaxSelectionSetNodes int
+	// MaxFieldsPerLevel caps the fields selected at any one depth of an
+	// operation, and MaxTotalFields the fields of an operation, fragments
+	// expanded wherever they are spread; they are checked once parsed
+	MaxFieldsPerLevel int
+	MaxTotalFields    int
+	// MaxDepth caps the nesting of selection sets, list and object values
+	// and list types, DefaultMaxDepth when zero, so that deeply nested
+	// documents cannot exhaust the stack
+	MaxDepth int
+}
+
+// LimitError reports a document exceeding one of the Limits; parsing stops
+// as soon as the limit is crossed
+type LimitError struct {
+	// Limit is the name of the exceeded limit: maxTokens,
+	// maxSelectionSetNodes, maxFieldsPerLevel, maxTotalFields or maxDepth
+	Limit string
+	Max   int
+}
+
+func (e *LimitError) Error() string {
+	return fmt.Sprintf("document exceeds %s (%d)", e.Limit, e.Max)
+}
+
+// ParseWithLimits parses an executable GraphQL document, failing with a
+// *LimitError when it exceeds the limits
+func ParseWithLimits(source string, limits Limits) (*Document, error) {
+	p, err := newParser(source, limits)
+	if err != nil {
+		return nil, err
+	}
+	doc, err := p.parseDocument()
+	if err != nil {
+		return nil, err
+	}
+	if limits.MaxFieldsPerLevel > 0 || limits.MaxTotalFields > 0 {
+		for _, op := range doc.Operations {
+			if err := checkFieldLimits(doc, op, limits); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return doc, nil
+}
+
+// checkFieldLimits counts the fields of the operation by depth, expanding the
+// fragments, and stops at the first limit crossed
+func checkFieldLimits(doc *Document, op *Operation, limits Limits) error {
+	var perLevel []int
+	total := 0
+	spreading := make(map[string]bool)
+
+	var walk func(selections []*Selection, depth int) error
+	walk = func(selections []*Selection, depth int) error {
+		for _, selection := range selections {
+			switch selection.Kind {
+			case FieldSelection:
+				if depth == len(perLevel) {
+					perLevel = append(perLevel, 0)
+				}
+				perLevel[depth]++
+				total++
+				if limits.MaxFieldsPerLevel > 0 && perLevel[depth] > limits.MaxFieldsPerLevel {
+					return &LimitError{Limit: "maxFieldsPerLevel", Max: limits.MaxFieldsPerLevel}
+				}
+				if limits.MaxTotalFields > 0 && total > limits.MaxTotalFields {
+					return &LimitError{Limit: "maxTotalFields", Max: limits.MaxTotalFields}
+				}
+				if err := walk(selection.SelectionSet, depth+1); err != nil {
+					return err
+				}
+			case InlineFragmentSelection:
+				if err := walk(selection.SelectionSet, depth); err != nil {
+					return err
+				}
+			case FragmentSpreadSelection:
+				fragment := doc.Fragment(selection.Name)
+				if fragment == nil || spreading[fragment.Name] {
+					continue
+				}
+				spreading[fragment.Name] = true
+				err := walk(fragment.SelectionSet, depth)
+				spreading[fragment.Name] = false
+				if err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	}
+	return walk(op.SelectionSet, 0)
+}
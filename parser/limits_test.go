@@ -0,0 +1,57 @@
+package parser
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestParseWithLimits(t *testing.T) {
+	tests := []struct {
+		name      string
+		source    string
+		limits    Limits
+		wantLimit string
+	}{
+		{"within limits", "{ a { b } }", Limits{MaxTokens: 6, MaxSelectionSetNodes: 2}, ""},
+		{"too many tokens", "{ a { b } }", Limits{MaxTokens: 5}, "maxTokens"},
+		{"aliases", "{ " + strings.Repeat("x: a ", 1000) + "}", Limits{MaxSelectionSetNodes: 100}, "maxSelectionSetNodes"},
+		{"nesting", strings.Repeat("{ a ", 500) + strings.Repeat("}", 500), Limits{MaxSelectionSetNodes: 100}, "maxSelectionSetNodes"},
+		{"fragments count", "{ ...F } fragment F on Q { a b c }", Limits{MaxSelectionSetNodes: 3}, "maxSelectionSetNodes"},
+		{"values count as tokens", `{ a(x: [1, 2, 3, 4, 5]) }`, Limits{MaxTokens: 8}, "maxTokens"},
+		{"fields per level", "{ a { x y } b { z } }", Limits{MaxFieldsPerLevel: 3}, ""},
+		{"wide level", "{ a { x y } b { z w } }", Limits{MaxFieldsPerLevel: 3}, "maxFieldsPerLevel"},
+		{"wide through fragments", "{ ...F ...F ... on Q { a } } fragment F on Q { a b }", Limits{MaxFieldsPerLevel: 4}, "maxFieldsPerLevel"},
+		{"total fields", "{ a { b { c } } d }", Limits{MaxTotalFields: 4}, ""},
+		{"too many fields", "{ a { b { c } } d e }", Limits{MaxTotalFields: 4}, "maxTotalFields"},
+		{"fragment bomb", "{ ...A } fragment A on Q { ...B ...B ...B } fragment B on Q { ...C ...C ...C } fragment C on Q { x { y z } }",
+			Limits{MaxTotalFields: 20}, "maxTotalFields"},
+		{"depth", "{ a { b { c } } }", Limits{MaxDepth: 3}, ""},
+		{"too deep", "{ a { b { c { d } } } }", Limits{MaxDepth: 3}, "maxDepth"},
+		{"deep selection sets", strings.Repeat("{ a ", 200) + strings.Repeat("}", 200), Limits{}, "maxDepth"},
+		{"nested lists", "{ a(x: " + strings.Repeat("[", 1<<20) + ") }", Limits{}, "maxDepth"},
+		{"nested objects", "{ a(x: " + strings.Repeat("{ b: ", 1<<20) + ") }", Limits{}, "maxDepth"},
+		{"nested list types", "query($v: " + strings.Repeat("[", 1<<20) + "Int) { a }", Limits{}, "maxDepth"},
+		{"values within depth", "{ a(x: [[{ b: [1] }]]) }", Limits{MaxDepth: 5}, ""},
+		{"values too deep", "{ a(x: [[{ b: [1] }]]) }", Limits{MaxDepth: 4}, "maxDepth"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := ParseWithLimits(tt.source, tt.limits)
+			if tt.wantLimit == "" {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				return
+			}
+			var limitErr *LimitError
+			if !errors.As(err, &limitErr) {
+				t.Fatalf("got %v, want a *LimitError", err)
+			}
+			if limitErr.Limit != tt.wantLimit {
+				t.Errorf("got limit %s, want %s", limitErr.Limit, tt.wantLimit)
+			}
+		})
+	}
+}
@@ -0,0 +1,76 @@
+package parser
+
+import (
+	"strconv"
+	"strings"
+)
+
+// Normalize re-emits the tokens of a document without comments and with the
+// minimum of whitespace, so that formatting differences do not matter; block
+// strings become regular strings of the same value
+func Normalize(source string) (string, error) {
+	l := &lexer{source: source}
+	var out strings.Builder
+	out.Grow(len(source))
+
+	previous := TokenEOF
+	for {
+		token, err := l.next()
+		if err != nil {
+			return "", err
+		}
+		if token.Kind == TokenEOF {
+			return out.String(), nil
+		}
+
+		// Adjacent names, numbers and strings need a separator to lex back the
+		// same way ("" "" is not a block string opener)
+		if isWordToken(previous) && (isWordToken(token.Kind) || token.Value == "...") ||
+			isStringToken(previous) && isStringToken(token.Kind) {
+			out.WriteByte(' ')
+		}
+		switch token.Kind {
+		case TokenString, TokenBlockString:
+			writeQuoted(&out, token.Value)
+		default:
+			out.WriteString(token.Value)
+		}
+		previous = token.Kind
+	}
+}
+
+func isWordToken(kind TokenKind) bool {
+	return kind == TokenName || kind == TokenInt || kind == TokenFloat
+}
+
+func isStringToken(kind TokenKind) bool {
+	return kind == TokenString || kind == TokenBlockString
+}
+
+// writeQuoted writes value as a GraphQL string literal
+func writeQuoted(out *strings.Builder, value string) {
+	out.WriteByte('"')
+	for _, r := range value {
+		switch r {
+		case '"':
+			out.WriteString(`\"`)
+		case '\\':
+			out.WriteString(`\\`)
+		case '\n':
+			out.WriteString(`\n`)
+		case '\r':
+			out.WriteString(`\r`)
+		case '\t':
+			out.WriteString(`\t`)
+		default:
+			if r < 0x20 {
+				out.WriteString(`\u`)
+				hex := strconv.FormatInt(int64(r), 16)
+				out.WriteString(strings.Repeat("0", 4-len(hex)) + hex)
+				continue
+			}
+			out.WriteRune(r)
+		}
+	}
+	out.WriteByte('"')
+}
@@ -0,0 +1,170 @@
+package parser
+
+import "strings"
+
+// Print renders a document back to source, in the compact form of Normalize,
+// so that a document rewritten in place can be forwarded
+func Print(doc *Document) string {
+	p := &printer{}
+	for _, op := range doc.Operations {
+		p.operation(op)
+	}
+	for _, fragment := range doc.Fragments {
+		p.word("fragment")
+		p.word(fragment.Name)
+		p.word("on")
+		p.word(fragment.TypeCondition)
+		p.directives(fragment.Directives)
+		p.selectionSet(fragment.SelectionSet)
+	}
+	return p.out.String()
+}
+
+// printer writes tokens with the separators Normalize would keep
+type printer struct {
+	out      strings.Builder
+	previous TokenKind
+}
+
+func (p *printer) word(s string) {
+	if isWordToken(p.previous) {
+		p.out.WriteByte(' ')
+	}
+	p.out.WriteString(s)
+	p.previous = TokenName
+}
+
+func (p *printer) punct(s string) {
+	if s == "..." && isWordToken(p.previous) {
+		p.out.WriteByte(' ')
+	}
+	p.out.WriteString(s)
+	p.previous = TokenPunctuator
+}
+
+func (p *printer) str(s string) {
+	if isStringToken(p.previous) {
+		p.out.WriteByte(' ')
+	}
+	writeQuoted(&p.out, s)
+	p.previous = TokenString
+}
+
+func (p *printer) operation(op *Operation) {
+	// The query shorthand is kept for anonymous queries without variables
+	if op.Type != "query" || op.Name != "" || len(op.VariableDefinitions) > 0 || len(op.Directives) > 0 {
+		p.word(op.Type)
+		if op.Name != "" {
+			p.word(op.Name)
+		}
+	}
+	if len(op.VariableDefinitions) > 0 {
+		p.punct("(")
+		for _, definition := range op.VariableDefinitions {
+			p.punct("$")
+			p.word(definition.Name)
+			p.punct(":")
+			p.typeRef(definition.Type)
+			if definition.DefaultValue != nil {
+				p.punct("=")
+				p.value(definition.DefaultValue)
+			}
+			p.directives(definition.Directives)
+		}
+		p.punct(")")
+	}
+	p.directives(op.Directives)
+	p.selectionSet(op.SelectionSet)
+}
+
+func (p *printer) typeRef(t *TypeRef) {
+	if t.Elem != nil {
+		p.punct("[")
+		p.typeRef(t.Elem)
+		p.punct("]")
+	} else {
+		p.word(t.Name)
+	}
+	if t.NonNull {
+		p.punct("!")
+	}
+}
+
+func (p *printer) selectionSet(selections []*Selection) {
+	p.punct("{")
+	for _, selection := range selections {
+		switch selection.Kind {
+		case FieldSelection:
+			if selection.Alias != "" {
+				p.word(selection.Alias)
+				p.punct(":")
+			}
+			p.word(selection.Name)
+			p.arguments(selection.Arguments)
+			p.directives(selection.Directives)
+			if len(selection.SelectionSet) > 0 {
+				p.selectionSet(selection.SelectionSet)
+			}
+		case FragmentSpreadSelection:
+			p.punct("...")
+			p.word(selection.Name)
+			p.directives(selection.Directives)
+		case InlineFragmentSelection:
+			p.punct("...")
+			if selection.TypeCondition != "" {
+				p.word("on")
+				p.word(selection.TypeCondition)
+			}
+			p.directives(selection.Directives)
+			p.selectionSet(selection.SelectionSet)
+		}
+	}
+	p.punct("}")
+}
+
+func (p *printer) arguments(arguments []*Argument) {
+	if len(arguments) == 0 {
+		return
+	}
+	p.punct("(")
+	for _, argument := range arguments {
+		p.word(argument.Name)
+		p.punct(":")
+		p.value(argument.Value)
+	}
+	p.punct(")")
+}
+
+func (p *printer) directives(directives []*Directive) {
+	for _, directive := range directives {
+		p.punct("@")
+		p.word(directive.Name)
+		p.arguments(directive.Arguments)
+	}
+}
+
+func (p *printer) value(v *Value) {
+	switch v.Kind {
+	case VariableValue:
+		p.punct("$")
+		p.word(v.Raw)
+	case StringValue:
+		p.str(v.Raw)
+	case ListValue:
+		p.punct("[")
+		for _, item := range v.List {
+			p.value(item)
+		}
+		p.punct("]")
+	case ObjectValue:
+		p.punct("{")
+		for _, field := range v.Fields {
+			p.word(field.Name)
+			p.punct(":")
+			p.value(field.Value)
+		}
+		p.punct("}")
+	default:
+		p.word(v.Raw)
+	}
+}
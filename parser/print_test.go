@@ -0,0 +1,61 @@
+package parser
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestPrint(t *testing.T) {
+	tests := []struct {
+		name   string
+		source string
+		want   string
+	}{
+		{"shorthand", "{ a b { c } }", "{a b{c}}"},
+		{"anonymous query keyword", "query { a }", "{a}"},
+		{"named with variables", "query Q($id: ID! = 1, $l: [String!] @d) @op { a: user(id: $id) { name } }",
+			"query Q($id:ID!=1$l:[String!]@d)@op{a:user(id:$id){name}}"},
+		{"fragments", "{ ...F ... on T { a } ... @skip(if: true) { b } } fragment F on T @x { c }",
+			"{...F ...on T{a}...@skip(if:true){b}}fragment F on T@x{c}"},
+		{"values", `{ a(s: "q\"", b: """block""", n: null, e: ENUM, l: [1 2.5 "x" "y"], o: {k: $v}) }`,
+			`{a(s:"q\""b:"block"n:null e:ENUM l:[1 2.5"x" "y"]o:{k:$v})}`},
+		{"mutation", "mutation { a }", "mutation{a}"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			doc, err := Parse(tt.source)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got := Print(doc); got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPrintCorpus(t *testing.T) {
+	for _, path := range corpusFiles(t, "valid") {
+		t.Run(filepath.Base(path), func(t *testing.T) {
+			doc, err := Parse(readCorpusFile(t, path))
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			// The printed document parses back to the same document, and is
+			// already in normalized form
+			printed := Print(doc)
+			again, err := Parse(printed)
+			if err != nil {
+				t.Fatalf("printed document %q does not parse: %v", printed, err)
+			}
+			if reprinted := Print(again); reprinted != printed {
+				t.Errorf("printing again gave %q, want %q", reprinted, printed)
+			}
+			if normalized, err := Normalize(printed); err != nil || normalized != printed {
+				t.Errorf("normalizing %q gave %q, %v", printed, normalized, err)
+			}
+		})
+	}
+}
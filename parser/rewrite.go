@@ -0,0 +1,143 @@
+package parser
+
+// StripFields removes the fields whose name is in names wherever they are
+// selected, in operations and fragments. Selection sets left empty select
+// __typename instead, and the fragments and variable definitions no longer
+// used are removed, so that the document stays valid. It reports whether the
+// document changed.
+func StripFields(doc *Document, names map[string]bool) bool {
+	changed := false
+	var strip func(selections []*Selection) []*Selection
+	strip = func(selections []*Selection) []*Selection {
+		if len(selections) == 0 {
+			return selections
+		}
+		kept := make([]*Selection, 0, len(selections))
+		for _, selection := range selections {
+			if selection.Kind == FieldSelection && names[selection.Name] {
+				changed = true
+				continue
+			}
+			selection.SelectionSet = strip(selection.SelectionSet)
+			kept = append(kept, selection)
+		}
+		if len(kept) == 0 {
+			kept = append(kept, &Selection{Kind: FieldSelection, Name: "__typename"})
+		}
+		return kept
+	}
+
+	for _, op := range doc.Operations {
+		op.SelectionSet = strip(op.SelectionSet)
+	}
+	for _, fragment := range doc.Fragments {
+		fragment.SelectionSet = strip(fragment.SelectionSet)
+	}
+	if changed {
+		pruneUnused(doc)
+	}
+	return changed
+}
+
+// pruneUnused removes the fragments no operation spreads and the variable
+// definitions an operation no longer references
+func pruneUnused(doc *Document) {
+	spread := make(map[string]bool)
+	for _, op := range doc.Operations {
+		used := make(map[string]bool)
+		visited := make(map[string]bool)
+		var walk func(selections []*Selection)
+		walk = func(selections []*Selection) {
+			for _, selection := range selections {
+				for _, argument := range selection.Arguments {
+					collectVariables(argument.Value, used)
+				}
+				collectDirectiveVariables(selection.Directives, used)
+				if selection.Kind == FragmentSpreadSelection {
+					spread[selection.Name] = true
+					if fragment := doc.Fragment(selection.Name); fragment != nil && !visited[fragment.Name] {
+						visited[fragment.Name] = true
+						collectDirectiveVariables(fragment.Directives, used)
+						walk(fragment.SelectionSet)
+					}
+				}
+				walk(selection.SelectionSet)
+			}
+		}
+		collectDirectiveVariables(op.Directives, used)
+		walk(op.SelectionSet)
+
+		definitions := op.VariableDefinitions[:0]
+		for _, definition := range op.VariableDefinitions {
+			if used[definition.Name] {
+				definitions = append(definitions, definition)
+			}
+		}
+		op.VariableDefinitions = definitions
+	}
+
+	fragments := doc.Fragments[:0]
+	for _, fragment := range doc.Fragments {
+		if spread[fragment.Name] {
+			fragments = append(fragments, fragment)
+		}
+	}
+	doc.Fragments = fragments
+}
+
+func collectDirectiveVariables(directives []*Directive, used map[string]bool) {
+	for _, directive := range directives {
+		for _, argument := range directive.Arguments {
+			collectVariables(argument.Value, used)
+		}
+	}
+}
+
+func collectVariables(v *Value, used map[string]bool) {
+	switch v.Kind {
+	case VariableValue:
+		used[v.Raw] = true
+	case ListValue:
+		for _, item := range v.List {
+			collectVariables(item, used)
+		}
+	case ObjectValue:
+		for _, field := range v.Fields {
+			collectVariables(field.Value, used)
+		}
+	}
+}
+
+// InjectTypename selects __typename in every selection set below the roots of
+// the operations, as normalized client caches expect, unless a selection set
+// already selects it unaliased. It reports whether the document changed.
+func InjectTypename(doc *Document) bool {
+	changed := false
+	var inject func(selections []*Selection, root bool) []*Selection
+	inject = func(selections []*Selection, root bool) []*Selection {
+		if len(selections) == 0 {
+			return selections
+		}
+		found := false
+		for _, selection := range selections {
+			if selection.Kind == FieldSelection && selection.Name == "__typename" && selection.Alias == "" {
+				found = true
+			}
+			// Inline fragments belong to the selection set they are in
+			selection.SelectionSet = inject(selection.SelectionSet, root && selection.Kind == InlineFragmentSelection)
+		}
+		if found || root {
+			return selections
+		}
+		changed = true
+		return append(selections, &Selection{Kind: FieldSelection, Name: "__typename"})
+	}
+
+	for _, op := range doc.Operations {
+		op.SelectionSet = inject(op.SelectionSet, true)
+	}
+	for _, fragment := range doc.Fragments {
+		fragment.SelectionSet = inject(fragment.SelectionSet, false)
+	}
+	return changed
+}
@@ -0,0 +1,71 @@
+package parser
+
+import "testing"
+
+func TestStripFields(t *testing.T) {
+	names := map[string]bool{"__schema": true, "debug": true}
+	tests := []struct {
+		name    string
+		source  string
+		want    string
+		changed bool
+	}{
+		{"untouched", "{ a { b } }", "{a{b}}", false},
+		{"root field", "{ a __schema { types { name } } }", "{a}", true},
+		{"nested field", "{ user { name debug { trace } } }", "{user{name}}", true},
+		{"aliased", "{ user { d: debug } }", "{user{__typename}}", true},
+		{"only field", "{ __schema { types { name } } }", "{__typename}", true},
+		{"inline fragment", "{ user { ... on User { debug } } }", "{user{...on User{__typename}}}", true},
+		{"unused variable", "query Q($v: Int, $w: Int) { a(x: $w) debug(v: $v) }", "query Q($w:Int){a(x:$w)}", true},
+		{"variable in fragment", "query Q($v: Int) { ...F } fragment F on Query { a(x: $v) debug }",
+			"query Q($v:Int){...F}fragment F on Query{a(x:$v)}", true},
+		{"unused fragment", "{ a debug { ...F } } fragment F on Debug { trace ...G } fragment G on Debug { id }", "{a}", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			doc, err := Parse(tt.source)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if changed := StripFields(doc, names); changed != tt.changed {
+				t.Errorf("changed = %v, want %v", changed, tt.changed)
+			}
+			if got := Print(doc); got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestInjectTypename(t *testing.T) {
+	tests := []struct {
+		name    string
+		source  string
+		want    string
+		changed bool
+	}{
+		{"root untouched", "{ a b }", "{a b}", false},
+		{"nested", "{ user { name friends { id } } }", "{user{name friends{id __typename}__typename}}", true},
+		{"already selected", "{ user { __typename name } }", "{user{__typename name}}", false},
+		{"aliased", "{ user { t: __typename } }", "{user{t:__typename __typename}}", true},
+		{"root inline fragment", "subscription { ... on Subscription { event { id } } }",
+			"subscription{...on Subscription{event{id __typename}}}", true},
+		{"fragment", "{ ...F } fragment F on User { name }", "{...F}fragment F on User{name __typename}", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			doc, err := Parse(tt.source)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if changed := InjectTypename(doc); changed != tt.changed {
+				t.Errorf("changed = %v, want %v", changed, tt.changed)
+			}
+			if got := Print(doc); got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,426 @@
+package parser
+
+// Schema is the subset of a GraphQL type system needed to walk selections
+type Schema struct {
+	QueryType        string
+	MutationType     string
+	SubscriptionType string
+	Types            map[string]*TypeDefinition
+	// Source is the SDL the schema was parsed from
+	Source string
+}
+
+// TypeDefinition is a named type of the schema; Kind is one of SCALAR, OBJECT,
+// INTERFACE, UNION, ENUM and INPUT_OBJECT
+type TypeDefinition struct {
+	Kind          string
+	Name          string
+	Description   string
+	Fields        []*FieldDefinition
+	Interfaces    []string
+	PossibleTypes []string
+	EnumValues    []*EnumValueDefinition
+	Directives    []*Directive
+}
+
+// FieldDefinition is a field of an object, interface or input object type
+type FieldDefinition struct {
+	Name        string
+	Description string
+	Arguments   []*FieldDefinition
+	Type        *TypeRef
+	Directives  []*Directive
+}
+
+// EnumValueDefinition is a value of an enum type
+type EnumValueDefinition struct {
+	Name       string
+	Directives []*Directive
+}
+
+// Field returns the field definition with the given name
+func (t *TypeDefinition) Field(name string) *FieldDefinition {
+	for _, f := range t.Fields {
+		if f.Name == name {
+			return f
+		}
+	}
+	return nil
+}
+
+// Deprecation returns the deprecation reason when the field is marked @deprecated
+func (f *FieldDefinition) Deprecation() (string, bool) {
+	return deprecation(f.Directives)
+}
+
+func deprecation(directives []*Directive) (string, bool) {
+	for _, d := range directives {
+		if d.Name != "deprecated" {
+			continue
+		}
+		reason := "No longer supported"
+		for _, arg := range d.Arguments {
+			if arg.Name == "reason" && arg.Value.Kind == StringValue {
+				reason = arg.Value.Raw
+			}
+		}
+		return reason, true
+	}
+	return "", false
+}
+
+// RootType returns the root type name of an operation type
+func (s *Schema) RootType(operationType string) string {
+	switch operationType {
+	case "mutation":
+		return s.MutationType
+	case "subscription":
+		return s.SubscriptionType
+	default:
+		return s.QueryType
+	}
+}
+
+// ParseSchema parses a schema in the GraphQL SDL; type extensions are merged
+// into the extended types
+func ParseSchema(source string) (*Schema, error) {
+	p, err := newParser(source, Limits{})
+	if err != nil {
+		return nil, err
+	}
+
+	schema := &Schema{Types: make(map[string]*TypeDefinition), Source: source}
+	explicitRoots := false
+
+	for p.token.Kind != TokenEOF {
+		description := ""
+		if p.token.Kind == TokenString || p.token.Kind == TokenBlockString {
+			description = p.token.Value
+			if err := p.advance(); err != nil {
+				return nil, err
+			}
+		}
+
+		extend := false
+		if p.peekName("extend") {
+			extend = true
+			if err := p.advance(); err != nil {
+				return nil, err
+			}
+		}
+
+		switch {
+		case p.peekName("schema"):
+			if err := p.parseSchemaDefinition(schema); err != nil {
+				return nil, err
+			}
+			explicitRoots = true
+		case p.peekName("directive"):
+			if err := p.skipDirectiveDefinition(); err != nil {
+				return nil, err
+			}
+		case p.peekName("scalar") || p.peekName("type") || p.peekName("interface") ||
+			p.peekName("union") || p.peekName("enum") || p.peekName("input"):
+			def, err := p.parseTypeDefinition()
+			if err != nil {
+				return nil, err
+			}
+			def.Description = description
+			if existing, ok := schema.Types[def.Name]; ok && extend {
+				existing.Fields = append(existing.Fields, def.Fields...)
+				existing.Interfaces = append(existing.Interfaces, def.Interfaces...)
+				existing.PossibleTypes = append(existing.PossibleTypes, def.PossibleTypes...)
+				existing.EnumValues = append(existing.EnumValues, def.EnumValues...)
+				existing.Directives = append(existing.Directives, def.Directives...)
+				continue
+			}
+			schema.Types[def.Name] = def
+		default:
+			return nil, p.unexpected()
+		}
+	}
+
+	if !explicitRoots {
+		for _, root := range []string{"Query", "Mutation", "Subscription"} {
+			if _, ok := schema.Types[root]; !ok {
+				continue
+			}
+			switch root {
+			case "Query":
+				schema.QueryType = root
+			case "Mutation":
+				schema.MutationType = root
+			case "Subscription":
+				schema.SubscriptionType = root
+			}
+		}
+	}
+	return schema, nil
+}
+
+func (p *parser) parseSchemaDefinition(schema *Schema) error {
+	if err := p.expectKeyword("schema"); err != nil {
+		return err
+	}
+	if _, err := p.parseDirectives(true); err != nil {
+		return err
+	}
+	if !p.peek("{") {
+		// Schema extension with directives only
+		return nil
+	}
+	if err := p.advance(); err != nil {
+		return err
+	}
+	for {
+		if ok, err := p.skip("}"); ok || err != nil {
+			return err
+		}
+		operation, err := p.expectName()
+		if err != nil {
+			return err
+		}
+		if err := p.expect(":"); err != nil {
+			return err
+		}
+		typeName, err := p.expectName()
+		if err != nil {
+			return err
+		}
+		switch operation {
+		case "query":
+			schema.QueryType = typeName
+		case "mutation":
+			schema.MutationType = typeName
+		case "subscription":
+			schema.SubscriptionType = typeName
+		}
+	}
+}
+
+// skipDirectiveDefinition consumes "directive @name(args) repeatable on A | B"
+func (p *parser) skipDirectiveDefinition() error {
+	if err := p.expectKeyword("directive"); err != nil {
+		return err
+	}
+	if err := p.expect("@"); err != nil {
+		return err
+	}
+	if _, err := p.expectName(); err != nil {
+		return err
+	}
+	if _, err := p.parseArgumentDefinitions(); err != nil {
+		return err
+	}
+	if p.peekName("repeatable") {
+		if err := p.advance(); err != nil {
+			return err
+		}
+	}
+	if err := p.expectKeyword("on"); err != nil {
+		return err
+	}
+	if _, err := p.skip("|"); err != nil {
+		return err
+	}
+	if _, err := p.expectName(); err != nil {
+		return err
+	}
+	for p.peek("|") {
+		if err := p.advance(); err != nil {
+			return err
+		}
+		if _, err := p.expectName(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (p *parser) parseTypeDefinition() (*TypeDefinition, error) {
+	keyword := p.token.Value
+	if err := p.advance(); err != nil {
+		return nil, err
+	}
+
+	def := &TypeDefinition{}
+	var err error
+	if def.Name, err = p.expectName(); err != nil {
+		return nil, err
+	}
+
+	switch keyword {
+	case "scalar":
+		def.Kind = "SCALAR"
+		def.Directives, err = p.parseDirectives(true)
+		return def, err
+
+	case "type", "interface":
+		def.Kind = "OBJECT"
+		if keyword == "interface" {
+			def.Kind = "INTERFACE"
+		}
+		if p.peekName("implements") {
+			if err := p.advance(); err != nil {
+				return nil, err
+			}
+			if _, err := p.skip("&"); err != nil {
+				return nil, err
+			}
+			for p.token.Kind == TokenName {
+				def.Interfaces = append(def.Interfaces, p.token.Value)
+				if err := p.advance(); err != nil {
+					return nil, err
+				}
+				if ok, err := p.skip("&"); err != nil {
+					return nil, err
+				} else if !ok {
+					break
+				}
+			}
+		}
+		if def.Directives, err = p.parseDirectives(true); err != nil {
+			return nil, err
+		}
+		def.Fields, err = p.parseFieldDefinitions(true)
+		return def, err
+
+	case "input":
+		def.Kind = "INPUT_OBJECT"
+		if def.Directives, err = p.parseDirectives(true); err != nil {
+			return nil, err
+		}
+		def.Fields, err = p.parseFieldDefinitions(false)
+		return def, err
+
+	case "union":
+		def.Kind = "UNION"
+		if def.Directives, err = p.parseDirectives(true); err != nil {
+			return nil, err
+		}
+		if ok, err := p.skip("="); err != nil || !ok {
+			return def, err
+		}
+		if _, err := p.skip("|"); err != nil {
+			return nil, err
+		}
+		for {
+			name, err := p.expectName()
+			if err != nil {
+				return nil, err
+			}
+			def.PossibleTypes = append(def.PossibleTypes, name)
+			if ok, err := p.skip("|"); err != nil {
+				return nil, err
+			} else if !ok {
+				return def, nil
+			}
+		}
+
+	default: // enum
+		def.Kind = "ENUM"
+		if def.Directives, err = p.parseDirectives(true); err != nil {
+			return nil, err
+		}
+		if ok, err := p.skip("{"); err != nil || !ok {
+			return def, err
+		}
+		for {
+			if ok, err := p.skip("}"); ok || err != nil {
+				return def, err
+			}
+			if err := p.skipDescription(); err != nil {
+				return nil, err
+			}
+			value := &EnumValueDefinition{}
+			if value.Name, err = p.expectName(); err != nil {
+				return nil, err
+			}
+			if value.Directives, err = p.parseDirectives(true); err != nil {
+				return nil, err
+			}
+			def.EnumValues = append(def.EnumValues, value)
+		}
+	}
+}
+
+func (p *parser) skipDescription() error {
+	if p.token.Kind == TokenString || p.token.Kind == TokenBlockString {
+		return p.advance()
+	}
+	return nil
+}
+
+// parseFieldDefinitions parses a braced list of field (withArguments) or input value definitions
+func (p *parser) parseFieldDefinitions(withArguments bool) ([]*FieldDefinition, error) {
+	if ok, err := p.skip("{"); err != nil || !ok {
+		return nil, err
+	}
+
+	var fields []*FieldDefinition
+	for {
+		if ok, err := p.skip("}"); ok || err != nil {
+			return fields, err
+		}
+		field, err := p.parseInputOrFieldDefinition(withArguments)
+		if err != nil {
+			return nil, err
+		}
+		fields = append(fields, field)
+	}
+}
+
+func (p *parser) parseInputOrFieldDefinition(withArguments bool) (*FieldDefinition, error) {
+	field := &FieldDefinition{}
+	if p.token.Kind == TokenString || p.token.Kind == TokenBlockString {
+		field.Description = p.token.Value
+		if err := p.advance(); err != nil {
+			return nil, err
+		}
+	}
+
+	var err error
+	if field.Name, err = p.expectName(); err != nil {
+		return nil, err
+	}
+	if withArguments {
+		if field.Arguments, err = p.parseArgumentDefinitions(); err != nil {
+			return nil, err
+		}
+	}
+	if err := p.expect(":"); err != nil {
+		return nil, err
+	}
+	if field.Type, err = p.parseTypeRef(); err != nil {
+		return nil, err
+	}
+	if ok, err := p.skip("="); err != nil {
+		return nil, err
+	} else if ok {
+		if _, err := p.parseValue(true); err != nil {
+			return nil, err
+		}
+	}
+	if field.Directives, err = p.parseDirectives(true); err != nil {
+		return nil, err
+	}
+	return field, nil
+}
+
+func (p *parser) parseArgumentDefinitions() ([]*FieldDefinition, error) {
+	if ok, err := p.skip("("); err != nil || !ok {
+		return nil, err
+	}
+
+	var arguments []*FieldDefinition
+	for {
+		if ok, err := p.skip(")"); ok || err != nil {
+			return arguments, err
+		}
+		argument, err := p.parseInputOrFieldDefinition(false)
+		if err != nil {
+			return nil, err
+		}
+		arguments = append(arguments, argument)
+	}
+}
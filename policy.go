@@ -0,0 +1,206 @@
+package trafico
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	policyStartupFail     = "fail"
+	policyStartupContinue = "continue"
+
+	// defaultRemoteReloadInterval applies to URLs when reloadInterval is unset
+	defaultRemoteReloadInterval = time.Minute
+	remotePolicyTimeout         = 10 * time.Second
+	maxRemotePolicyBytes        = 16 << 20
+)
+
+var policyClient = &http.Client{Timeout: remotePolicyTimeout}
+
+// policySource holds a policy structure compiled from a file or an HTTP(S)
+// URL (schema, allowlist...) and recompiles it when the source changes.
+// Changes are detected lazily: the first request after each interval starts
+// a check in the background, so no goroutine outlives the middleware when
+// Traefik rebuilds it. A policy that fails to load is logged and the
+// previous one is kept.
+type policySource struct {
+	location string
+	remote   bool
+	interval time.Duration
+	compile  func(data []byte) (any, error)
+
+	mu      sync.RWMutex
+	value   any
+	modTime time.Time
+	size    int64
+	etag    string
+	checkAt time.Time
+	// checking lets a single check run at a time
+	checking int32
+}
+
+// newPolicySource loads the file or URL. Failures are returned unless
+// startupFailure is "continue", in which case the policy stays unset (nil)
+// until a later check loads it. An interval of 0 never reloads a file.
+func newPolicySource(location string, interval time.Duration, startupFailure string, compile func(data []byte) (any, error)) (*policySource, error) {
+	p := &policySource{location: location, interval: interval, compile: compile}
+	if strings.HasPrefix(location, "http://") || strings.HasPrefix(location, "https://") {
+		p.remote = true
+		if p.interval == 0 {
+			p.interval = defaultRemoteReloadInterval
+		}
+	}
+
+	if err := p.load(); err != nil {
+		if startupFailure != policyStartupContinue || p.interval == 0 {
+			return nil, err
+		}
+		logf("loading %s failed, continuing without it: %v", location, err)
+	}
+	p.checkAt = time.Now().Add(p.interval)
+	return p, nil
+}
+
+// newStaticPolicy wraps a policy that is not backed by a file or URL
+func newStaticPolicy(value any) *policySource {
+	return &policySource{value: value}
+}
+
+// current returns the compiled policy, starting a check for changes when due
+func (p *policySource) current() any {
+	if p.location != "" && p.interval > 0 {
+		now := time.Now()
+		p.mu.RLock()
+		due := !now.Before(p.checkAt)
+		p.mu.RUnlock()
+		if due && atomic.CompareAndSwapInt32(&p.checking, 0, 1) {
+			go p.refresh()
+		}
+	}
+
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return p.value
+}
+
+func (p *policySource) refresh() {
+	defer atomic.StoreInt32(&p.checking, 0)
+
+	if err := p.load(); err != nil {
+		logf("reloading %s failed, keeping the previous version: %v", p.location, err)
+	}
+	p.mu.Lock()
+	p.checkAt = time.Now().Add(p.interval)
+	p.mu.Unlock()
+}
+
+// load fetches and compiles the source if it changed, swapping the policy in on success
+func (p *policySource) load() error {
+	if p.remote {
+		return p.loadURL()
+	}
+	return p.loadFile()
+}
+
+func (p *policySource) loadFile() error {
+	info, err := os.Stat(p.location)
+	if err != nil {
+		return err
+	}
+	p.mu.RLock()
+	unchanged := p.value != nil && info.ModTime().Equal(p.modTime) && info.Size() == p.size
+	p.mu.RUnlock()
+	if unchanged {
+		return nil
+	}
+
+	data, err := os.ReadFile(p.location)
+	if err != nil {
+		return err
+	}
+	return p.swap(data, func() {
+		p.modTime = info.ModTime()
+		p.size = info.Size()
+	})
+}
+
+// loadURL sends a conditional GET, the server answering 304 Not Modified while
+// the ETag still matches
+func (p *policySource) loadURL() error {
+	req, err := http.NewRequest(http.MethodGet, p.location, nil)
+	if err != nil {
+		return err
+	}
+	p.mu.RLock()
+	if p.etag != "" && p.value != nil {
+		req.Header.Set("If-None-Match", p.etag)
+	}
+	p.mu.RUnlock()
+
+	resp, err := policyClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	switch {
+	case resp.StatusCode == http.StatusNotModified:
+		return nil
+	case resp.StatusCode != http.StatusOK:
+		return fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxRemotePolicyBytes+1))
+	if err != nil {
+		return err
+	}
+	if len(data) > maxRemotePolicyBytes {
+		return fmt.Errorf("policy exceeds %d bytes", maxRemotePolicyBytes)
+	}
+	etag := resp.Header.Get("ETag")
+	return p.swap(data, func() { p.etag = etag })
+}
+
+// swap compiles the data and replaces the policy, recording the version with mark
+func (p *policySource) swap(data []byte, mark func()) error {
+	value, err := p.compile(data)
+	if err != nil {
+		return err
+	}
+
+	p.mu.Lock()
+	reloaded := p.value != nil
+	p.value = value
+	mark()
+	p.mu.Unlock()
+
+	if reloaded {
+		logf("reloaded %s", p.location)
+	}
+	return nil
+}
+
+// parseReloadInterval reads the reloadInterval setting, empty disabling reloads
+func parseReloadInterval(value string) (time.Duration, error) {
+	if value == "" {
+		return 0, nil
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil || d < 0 {
+		return 0, fmt.Errorf("invalid reloadInterval %q, expected a positive duration such as 30s", value)
+	}
+	return d, nil
+}
+
+func validPolicyStartupFailure(value string) error {
+	switch value {
+	case "", policyStartupFail, policyStartupContinue:
+		return nil
+	}
+	return fmt.Errorf("unknown policyStartupFailure %q, expected fail or continue", value)
+}
@@ -0,0 +1,290 @@
+package trafico
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"sync"
+)
+
+// maxPooledBuffer is the largest body buffer returned to the pool, so that an
+// occasional huge request does not pin its memory
+const maxPooledBuffer = 1 << 20
+
+var bodyBuffers = sync.Pool{
+	New: func() any { return new(bytes.Buffer) },
+}
+
+// pooledBody replays a request body read into a pooled buffer; when the body
+// exceeds the buffering limit only its prefix is buffered and the remainder
+// is streamed from the client as the downstream handler reads it
+type pooledBody struct {
+	reader io.Reader
+	// rest is the unread remainder of a streamed body, nil when fully buffered
+	rest   io.ReadCloser
+	buffer *bytes.Buffer
+	closed bool
+}
+
+// readBody reads the request body into a pooled buffer, up to limit bytes
+// when limit is positive
+func readBody(req *http.Request, limit int) (*pooledBody, error) {
+	buffer := bodyBuffers.Get().(*bytes.Buffer)
+	buffer.Reset()
+
+	size := req.ContentLength
+	if limit > 0 && size > int64(limit) {
+		size = int64(limit)
+	}
+	if size > 0 && size <= maxPooledBuffer {
+		buffer.Grow(int(size))
+	}
+
+	source := io.Reader(req.Body)
+	if limit > 0 {
+		// One byte past the limit tells whether the body goes on
+		source = io.LimitReader(req.Body, int64(limit)+1)
+	}
+	if _, err := buffer.ReadFrom(source); err != nil {
+		bodyBuffers.Put(buffer)
+		return nil, err
+	}
+
+	body := &pooledBody{reader: bytes.NewReader(buffer.Bytes()), buffer: buffer}
+	if limit > 0 && buffer.Len() > limit {
+		body.rest = req.Body
+		body.reader = io.MultiReader(body.reader, req.Body)
+	}
+	return body, nil
+}
+
+// Read reads the buffered bytes, then the streamed remainder
+func (b *pooledBody) Read(p []byte) (int, error) {
+	return b.reader.Read(p)
+}
+
+// bytes returns the buffered body, or its prefix when streamed, valid until release
+func (b *pooledBody) bytes() []byte {
+	return b.buffer.Bytes()
+}
+
+// streamed reports whether the body exceeded the buffering limit
+func (b *pooledBody) streamed() bool {
+	return b.rest != nil
+}
+
+// rewind replays a fully buffered body from its start, for a new attempt
+func (b *pooledBody) rewind() {
+	b.reader = bytes.NewReader(b.buffer.Bytes())
+	b.closed = false
+}
+
+// replace swaps a fully buffered body for data, replayed from its start
+func (b *pooledBody) replace(data []byte) {
+	b.buffer.Reset()
+	b.buffer.Write(data)
+	b.rewind()
+}
+
+// Close marks the body as fully consumed; the transport closes the bodies it
+// sends once written
+func (b *pooledBody) Close() error {
+	b.closed = true
+	if b.rest != nil {
+		return b.rest.Close()
+	}
+	return nil
+}
+
+// release recycles the buffer once the request is served, unless a
+// downstream reader may still hold it because it never closed the body
+func (b *pooledBody) release() {
+	if !b.closed || b.buffer.Cap() > maxPooledBuffer {
+		return
+	}
+	b.reader = nil
+	bodyBuffers.Put(b.buffer)
+	b.buffer = nil
+}
+
+// decodeRequestPrefix extracts what it can from the beginning of a JSON
+// request envelope: members are decoded in order until the prefix runs out,
+// so the query is found as long as it precedes the bulky variables
+func decodeRequestPrefix(prefix []byte) GraphQLRequest {
+	var graphqlReq GraphQLRequest
+	decoder := json.NewDecoder(bytes.NewReader(prefix))
+	if token, err := decoder.Token(); err != nil || token != json.Delim('{') {
+		return graphqlReq
+	}
+
+	for decoder.More() {
+		token, err := decoder.Token()
+		if err != nil {
+			return graphqlReq
+		}
+		key, _ := token.(string)
+
+		var target any
+		switch key {
+		case "query":
+			target = &graphqlReq.Query
+		case "operationName":
+			target = &graphqlReq.OperationName
+		case "variables":
+			target = &graphqlReq.Variables
+		case "extensions":
+			target = &graphqlReq.Extensions
+		default:
+			target = &json.RawMessage{}
+		}
+		if err := decoder.Decode(target); err != nil {
+			return graphqlReq
+		}
+	}
+	return graphqlReq
+}
+
+// errRepeatedMember aborts a streamed body repeating the members that
+// identify its operation past the inspected prefix
+var errRepeatedMember = errors.New("request body repeats query or operationName past the inspected prefix")
+
+// maxGuardedKeyBytes is the longest raw JSON key that may spell
+// operationName, every character escaped
+const maxGuardedKeyBytes = 2 + 6*len("operationName")
+
+// guardMembers makes sure the operation decoded from the prefix of a streamed
+// JSON body is the one the backend receives: it reports false when the prefix
+// holds query or operationName twice, and aborts the remainder before a
+// later query or operationName member is handed downstream
+func (b *pooledBody) guardMembers() bool {
+	guard := &memberGuard{rest: b.rest}
+	guard.feed(b.buffer.Bytes(), false)
+	if guard.err != nil {
+		return false
+	}
+	b.rest = guard
+	b.reader = io.MultiReader(bytes.NewReader(b.buffer.Bytes()), guard)
+	return true
+}
+
+// memberGuard follows the top-level members of a JSON object, holding back
+// the bytes of each key until it is known not to name a guarded member
+type memberGuard struct {
+	rest io.ReadCloser
+	err  error
+
+	depth     int
+	inString  bool
+	escaped   bool
+	expectKey bool
+	inKey     bool
+	key       []byte
+	seen      map[string]bool
+
+	out     []byte
+	offset  int
+	pending []byte
+}
+
+func (m *memberGuard) Read(p []byte) (int, error) {
+	for m.offset == len(m.out) {
+		if m.err != nil {
+			return 0, m.err
+		}
+		m.out, m.offset = m.out[:0], 0
+		n, err := m.rest.Read(p)
+		m.feed(p[:n], true)
+		if err != nil && m.err == nil {
+			// A body ending within a key is invalid JSON anyway
+			m.out = append(m.out, m.pending...)
+			m.err = err
+		}
+	}
+	n := copy(p, m.out[m.offset:])
+	m.offset += n
+	return n, nil
+}
+
+func (m *memberGuard) Close() error {
+	return m.rest.Close()
+}
+
+// feed scans data, queuing it for downstream when emit is set; the prefix
+// is fed without emitting as it is replayed from the buffer
+func (m *memberGuard) feed(data []byte, emit bool) {
+	for _, c := range data {
+		if m.err != nil {
+			return
+		}
+		closed := false
+		switch {
+		case m.inString:
+			if m.escaped {
+				m.escaped = false
+			} else if c == '\\' {
+				m.escaped = true
+			} else if c == '"' {
+				m.inString = false
+				closed = m.inKey
+			}
+		case c == '"':
+			m.inString = true
+			if m.depth == 1 && m.expectKey {
+				m.inKey, m.expectKey, m.key = true, false, m.key[:0]
+			}
+		case c == '{' || c == '[':
+			m.depth++
+			m.expectKey = m.depth == 1 && c == '{'
+		case c == '}' || c == ']':
+			m.depth--
+		case c == ',':
+			m.expectKey = m.depth == 1
+		}
+
+		if !m.inKey {
+			if emit {
+				m.out = append(m.out, c)
+			}
+			continue
+		}
+		m.key = append(m.key, c)
+		if emit {
+			m.pending = append(m.pending, c)
+		}
+		if closed {
+			m.inKey = false
+			m.checkKey(emit)
+		} else if len(m.key) > maxGuardedKeyBytes {
+			// Too long to name a guarded member
+			m.inKey = false
+			m.out = append(m.out, m.pending...)
+			m.pending = m.pending[:0]
+		}
+	}
+}
+
+// checkKey judges the key just read: guarded members may appear once in the
+// prefix, spelled exactly, and never in the remainder
+func (m *memberGuard) checkKey(emit bool) {
+	var name string
+	_ = json.Unmarshal(m.key, &name)
+	member, _ := envelopeMember(name)
+	if member != "query" && member != "operationName" {
+		m.out = append(m.out, m.pending...)
+		m.pending = m.pending[:0]
+		return
+	}
+	if emit || name != member || m.seen[name] {
+		m.err = errRepeatedMember
+		m.pending = m.pending[:0]
+		return
+	}
+	if m.seen == nil {
+		m.seen = make(map[string]bool)
+	}
+	m.seen[name] = true
+}
+
+var _ io.ReadCloser = (*pooledBody)(nil)
@@ -0,0 +1,47 @@
+package trafico
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// PreFilterConfig forwards the JSON and form bodies that do not look like
+// GraphQL requests without parsing them, for routers where most POSTs are
+// not GraphQL. application/graphql bodies are always parsed.
+type PreFilterConfig struct {
+	Enabled bool `json:"enabled,omitempty"`
+	// Pattern is matched against the body, a query member or form field by
+	// default
+	Pattern string `json:"pattern,omitempty"`
+}
+
+const defaultPreFilterPattern = `"query"\s*:|(?:^|&)query=`
+
+// preFilter recognizes GraphQL bodies; a nil *preFilter lets every body through
+type preFilter struct {
+	pattern *regexp.Regexp
+}
+
+func newPreFilter(config PreFilterConfig) (*preFilter, error) {
+	if !config.Enabled {
+		return nil, nil
+	}
+	pattern := config.Pattern
+	if pattern == "" {
+		pattern = defaultPreFilterPattern
+	}
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, fmt.Errorf("preFilter: invalid pattern: %w", err)
+	}
+	return &preFilter{pattern: re}, nil
+}
+
+// bypass reports whether the body is not a GraphQL request
+func (f *preFilter) bypass(contentType string, body []byte) bool {
+	if f == nil || strings.Contains(contentType, "application/graphql") {
+		return false
+	}
+	return !f.pattern.Match(body)
+}
@@ -0,0 +1,188 @@
+package trafico
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// ProfileConfig applies a variant of the configuration to the requests it
+// matches, so that one instance can serve several GraphQL endpoints
+type ProfileConfig struct {
+	Name string `json:"name,omitempty"`
+	// Hosts match the request host, without port and case-insensitively; a
+	// leading "*." matches any subdomain
+	Hosts []string `json:"hosts,omitempty"`
+	// Header and HeaderValue match a request header; an empty HeaderValue
+	// matches any non-empty value
+	Header      string `json:"header,omitempty"`
+	HeaderValue string `json:"headerValue,omitempty"`
+	// Config overrides the top-level settings; the settings it leaves unset
+	// are inherited, so it cannot reset one to its zero value
+	Config *Config `json:"config,omitempty"`
+}
+
+// profile is a compiled ProfileConfig serving the requests it matches
+type profile struct {
+	name   string
+	hosts  []string
+	header string
+	value  string
+	parser *GraphQLParser
+}
+
+// newProfiles builds a parser per profile from the top-level configuration
+// merged with the profile overrides
+func newProfiles(config *Config, name string) ([]*profile, error) {
+	if len(config.Profiles) == 0 {
+		return nil, nil
+	}
+
+	base := *config
+	base.Profiles = nil
+
+	profiles := make([]*profile, 0, len(config.Profiles))
+	seen := make(map[string]bool)
+	for _, pc := range config.Profiles {
+		if pc.Name == "" {
+			return nil, fmt.Errorf("profiles: every profile needs a name")
+		}
+		if seen[pc.Name] {
+			return nil, fmt.Errorf("profiles: duplicate profile %q", pc.Name)
+		}
+		seen[pc.Name] = true
+		if len(pc.Hosts) == 0 && pc.Header == "" {
+			return nil, fmt.Errorf("profiles: profile %q needs hosts or a header to match", pc.Name)
+		}
+		if pc.Config != nil && len(pc.Config.Profiles) > 0 {
+			return nil, fmt.Errorf("profiles: profile %q must not define profiles", pc.Name)
+		}
+
+		merged, err := mergeConfig(&base, pc.Config)
+		if err != nil {
+			return nil, fmt.Errorf("profiles: profile %q: %w", pc.Name, err)
+		}
+		g, err := newGraphQLParser(merged, name+"/"+pc.Name)
+		if err != nil {
+			return nil, fmt.Errorf("profiles: profile %q: %w", pc.Name, err)
+		}
+
+		p := &profile{name: pc.Name, header: pc.Header, value: pc.HeaderValue, parser: g}
+		for _, host := range pc.Hosts {
+			p.hosts = append(p.hosts, strings.ToLower(host))
+		}
+		profiles = append(profiles, p)
+	}
+	return profiles, nil
+}
+
+// mergeConfig overlays the settings set in overrides on a copy of base;
+// nested settings and maps are merged, lists are replaced
+func mergeConfig(base, overrides *Config) (*Config, error) {
+	merged, err := configMap(base)
+	if err != nil {
+		return nil, err
+	}
+	if overrides != nil {
+		values, err := configMap(overrides)
+		if err != nil {
+			return nil, err
+		}
+		mergeMaps(merged, values)
+	}
+
+	data, err := json.Marshal(merged)
+	if err != nil {
+		return nil, err
+	}
+	config := &Config{}
+	if err := json.Unmarshal(data, config); err != nil {
+		return nil, err
+	}
+	return config, nil
+}
+
+func configMap(config *Config) (map[string]any, error) {
+	data, err := json.Marshal(config)
+	if err != nil {
+		return nil, err
+	}
+	values := make(map[string]any)
+	if err := json.Unmarshal(data, &values); err != nil {
+		return nil, err
+	}
+	return values, nil
+}
+
+func mergeMaps(dst, src map[string]any) {
+	for key, value := range src {
+		nested, ok := value.(map[string]any)
+		existing, isMap := dst[key].(map[string]any)
+		if ok && isMap {
+			mergeMaps(existing, nested)
+			continue
+		}
+		dst[key] = value
+	}
+}
+
+// matches reports whether the request is served by the profile: the host and
+// the header must both match when both are configured
+func (p *profile) matches(req *http.Request) bool {
+	if len(p.hosts) > 0 && !matchHost(p.hosts, req.Host) {
+		return false
+	}
+	if p.header != "" {
+		value := req.Header.Get(p.header)
+		if value == "" || (p.value != "" && value != p.value) {
+			return false
+		}
+	}
+	return true
+}
+
+func matchHost(patterns []string, host string) bool {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	host = strings.ToLower(host)
+	for _, pattern := range patterns {
+		if pattern == host {
+			return true
+		}
+		if strings.HasPrefix(pattern, "*.") && strings.HasSuffix(host, pattern[1:]) {
+			return true
+		}
+	}
+	return false
+}
+
+// profileFor returns the parser of the first profile matching the request,
+// or nil when the top-level configuration applies
+func (g *GraphQLParser) profileFor(req *http.Request) *GraphQLParser {
+	for _, p := range g.profiles {
+		if p.matches(req) {
+			return p.parser
+		}
+	}
+	return nil
+}
+
+// withNext returns a copy of the parser, and of its profile parsers, handing
+// requests over to next after running the inspectors
+func (g *GraphQLParser) withNext(next http.Handler, inspectors []Inspector) *GraphQLParser {
+	handler := *g
+	handler.next = next
+	handler.inspectors = inspectors
+	if len(g.profiles) > 0 {
+		handler.profiles = make([]*profile, len(g.profiles))
+		for i, p := range g.profiles {
+			copied := *p
+			copied.parser = p.parser.withNext(next, inspectors)
+			handler.profiles[i] = &copied
+		}
+	}
+	return &handler
+}
@@ -0,0 +1,80 @@
+package trafico
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+)
+
+// QueryHashConfig exposes the SHA-256 of the documents, so that backends can
+// correlate requests with persisted query stores and logs without hashing
+// the body again. The raw hash is the one of the document as sent, as used
+// by automatic persisted queries; the normalized one ignores comments and
+// formatting.
+type QueryHashConfig struct {
+	Enabled          bool   `json:"enabled,omitempty"`
+	Header           string `json:"header,omitempty"`
+	Normalized       bool   `json:"normalized,omitempty"`
+	NormalizedHeader string `json:"normalizedHeader,omitempty"`
+}
+
+const (
+	defaultQueryHashHeader           = "X-GraphQL-Query-Sha256"
+	defaultNormalizedQueryHashHeader = "X-GraphQL-Query-Normalized-Sha256"
+)
+
+// queryHasher writes the document hashes; a nil *queryHasher does nothing
+type queryHasher struct {
+	header           string
+	normalized       bool
+	normalizedHeader string
+	lists            listHeaders
+}
+
+func newQueryHasher(config QueryHashConfig, lists listHeaders) *queryHasher {
+	if !config.Enabled {
+		return nil
+	}
+	h := &queryHasher{
+		header:           config.Header,
+		normalized:       config.Normalized,
+		normalizedHeader: config.NormalizedHeader,
+		lists:            lists,
+	}
+	if h.header == "" {
+		h.header = defaultQueryHashHeader
+	}
+	if h.normalizedHeader == "" {
+		h.normalizedHeader = defaultNormalizedQueryHashHeader
+	}
+	return h
+}
+
+// setHeaders writes the hashes of the documents, one per entry of a batch in
+// order; entries without a document, such as persisted query hashes, are skipped
+func (h *queryHasher) setHeaders(header http.Header, parsed *ParsedRequest) {
+	if h == nil {
+		return
+	}
+	header.Del(h.header)
+	header.Del(h.normalizedHeader)
+
+	var raw, normalized []string
+	for _, entry := range parsed.entries() {
+		query := entry.Request.Query
+		if query == "" {
+			continue
+		}
+		sum := sha256.Sum256([]byte(query))
+		raw = append(raw, hex.EncodeToString(sum[:]))
+		if h.normalized {
+			normalized = append(normalized, operationFingerprint(query))
+		}
+	}
+	if len(raw) > 0 {
+		h.lists.setValues(header, h.header, raw)
+	}
+	if len(normalized) > 0 {
+		h.lists.setValues(header, h.normalizedHeader, normalized)
+	}
+}
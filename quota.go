@@ -0,0 +1,350 @@
+package trafico
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/alainrk/trafico/parser"
+)
+
+// QuotaConfig limits the field credits each client spends over a rolling
+// window, a request costing one credit per field it selects (fragments
+// counted wherever they are spread). The credits left are returned in Header
+// and exhausted quotas are answered with a 429.
+type QuotaConfig struct {
+	Enabled bool `json:"enabled,omitempty"`
+	// Limit is the credits a client may spend per Window (24h by default)
+	Limit  int64  `json:"limit,omitempty"`
+	Window string `json:"window,omitempty"`
+	// Source identifies the client: header (the value of KeyHeader,
+	// X-API-Key by default), client (the client name identified when
+	// clients is enabled) or identity (the client identity resolved when
+	// clientIdentity is enabled). Requests without a key are not counted.
+	Source    string `json:"source,omitempty"`
+	KeyHeader string `json:"keyHeader,omitempty"`
+	// Header carries the remaining credits on responses, X-Quota-Remaining
+	// by default
+	Header string `json:"header,omitempty"`
+	// Store keeps the counters: memory counts per instance, redis in the
+	// storage server shared by the replicas; storage.type by default
+	Store string `json:"store,omitempty"`
+	// MaxKeys bounds the clients counted in memory, 10000 by default
+	MaxKeys int `json:"maxKeys,omitempty"`
+}
+
+const (
+	defaultQuotaWindow    = 24 * time.Hour
+	defaultQuotaKeyHeader = "X-API-Key"
+	defaultQuotaHeader    = "X-Quota-Remaining"
+	defaultQuotaMaxKeys   = 10000
+
+	quotaSourceHeader   = "header"
+	quotaSourceClient   = "client"
+	quotaSourceIdentity = "identity"
+
+	// quotaScript spends the credits of KEYS[1], the current window, unless
+	// they would exceed the limit, KEYS[2] being the previous window.
+	// ARGV holds the cost, the limit, the weight of the previous window and
+	// the expiry of the counters in milliseconds.
+	quotaScript = `local current = tonumber(redis.call('GET', KEYS[1]) or '0')
+local previous = tonumber(redis.call('GET', KEYS[2]) or '0')
+local spent = current + math.floor(previous * tonumber(ARGV[3]))
+if spent + tonumber(ARGV[1]) > tonumber(ARGV[2]) then
+  return {0, spent}
+end
+redis.call('INCRBY', KEYS[1], ARGV[1])
+redis.call('PEXPIRE', KEYS[1], ARGV[4])
+return {1, spent + tonumber(ARGV[1])}`
+)
+
+// quotaStore counts the credits spent per key over a sliding window: the
+// credits of the current fixed window, plus those of the previous one
+// weighted by the part of it still within the sliding window
+type quotaStore interface {
+	// spend adds cost to the credits of key unless they would exceed limit,
+	// and returns the credits spent, including cost when it was added
+	spend(key string, cost, limit int64, window time.Duration, now time.Time) (spent int64, ok bool, err error)
+}
+
+// quotaTracker enforces the quotas; a nil *quotaTracker does nothing
+type quotaTracker struct {
+	limit     int64
+	window    time.Duration
+	source    string
+	keyHeader string
+	header    string
+	store     quotaStore
+}
+
+func newQuotaTracker(config QuotaConfig, middleware string, storage *redisClient) (*quotaTracker, error) {
+	if !config.Enabled {
+		return nil, nil
+	}
+	if config.Limit <= 0 {
+		return nil, fmt.Errorf("quota: limit must be positive")
+	}
+	if config.MaxKeys < 0 {
+		return nil, fmt.Errorf("quota: maxKeys must not be negative")
+	}
+	q := &quotaTracker{
+		limit:     config.Limit,
+		window:    defaultQuotaWindow,
+		source:    config.Source,
+		keyHeader: config.KeyHeader,
+		header:    config.Header,
+	}
+	if config.Window != "" {
+		d, err := time.ParseDuration(config.Window)
+		if err != nil || d <= 0 {
+			return nil, fmt.Errorf("quota: invalid window %q", config.Window)
+		}
+		q.window = d
+	}
+	switch q.source {
+	case "":
+		q.source = quotaSourceHeader
+	case quotaSourceHeader, quotaSourceClient, quotaSourceIdentity:
+	default:
+		return nil, fmt.Errorf("quota: unknown source %q, expected header, client or identity", config.Source)
+	}
+	if q.keyHeader == "" {
+		q.keyHeader = defaultQuotaKeyHeader
+	}
+	if q.header == "" {
+		q.header = defaultQuotaHeader
+	}
+	store := config.Store
+	if store == "" {
+		store = storageMemory
+		if storage != nil {
+			store = storageRedis
+		}
+	}
+	switch store {
+	case storageRedis:
+		if storage == nil {
+			return nil, fmt.Errorf("quota: the redis store requires storage.type redis")
+		}
+		q.store = &redisQuotaStore{client: storage, middleware: middleware}
+	case storageMemory:
+		maxKeys := config.MaxKeys
+		if maxKeys == 0 {
+			maxKeys = defaultQuotaMaxKeys
+		}
+		q.store = sharedMemoryQuotaStore(middleware, maxKeys)
+	default:
+		return nil, fmt.Errorf("quota: unknown store %q, expected memory or redis", config.Store)
+	}
+	return q, nil
+}
+
+// quotaDecision is the outcome of spending the credits of a request
+type quotaDecision struct {
+	remaining  int64
+	exhausted  bool
+	retryAfter time.Duration
+}
+
+// spend charges the request to its client and writes the remaining credits
+// on the response; it returns nil for requests that are not counted
+func (q *quotaTracker) spend(rw http.ResponseWriter, req *http.Request, parsed *ParsedRequest) *quotaDecision {
+	if q == nil {
+		return nil
+	}
+	var key string
+	switch q.source {
+	case quotaSourceHeader:
+		key = req.Header.Get(q.keyHeader)
+	case quotaSourceClient:
+		key = parsed.ClientName
+	case quotaSourceIdentity:
+		key = parsed.ClientIdentity
+	}
+	if key == "" {
+		return nil
+	}
+	// Keys are stored hashed, API keys being secrets
+	sum := sha256.Sum256([]byte(key))
+	key = hex.EncodeToString(sum[:16])
+
+	now := time.Now()
+	cost := fieldCount(parsed, q.limit+1)
+	spent, ok, err := q.store.spend(key, cost, q.limit, q.window, now)
+	if err != nil {
+		// Quotas fail open: an unavailable store does not block clients
+		logRequestf(req, "quota store failed: %v", err)
+		return nil
+	}
+
+	decision := &quotaDecision{remaining: q.limit - spent, exhausted: !ok}
+	if decision.remaining < 0 {
+		decision.remaining = 0
+	}
+	if !ok {
+		decision.retryAfter = q.window - time.Duration(now.UnixNano()%int64(q.window))
+	}
+	rw.Header().Set(q.header, strconv.FormatInt(decision.remaining, 10))
+	return decision
+}
+
+// message explains an exhausted quota
+func (d *quotaDecision) message() string {
+	return "quota exhausted, retry in " + d.retryAfter.Round(time.Second).String()
+}
+
+// setRetryAfter tells when the credits spent in the current window expire
+func (d *quotaDecision) setRetryAfter(header http.Header) {
+	header.Set("Retry-After", strconv.Itoa(int(d.retryAfter.Seconds())+1))
+}
+
+// fieldCount counts the field selections of the executed operations, each
+// fragment counted wherever it is spread, stopping past max
+func fieldCount(parsed *ParsedRequest, max int64) int64 {
+	var count int64
+	for _, entry := range parsed.entries() {
+		doc := entry.Document
+		spreading := make(map[string]bool)
+		var walk func(selections []*parser.Selection)
+		walk = func(selections []*parser.Selection) {
+			for _, selection := range selections {
+				if count > max {
+					return
+				}
+				switch selection.Kind {
+				case parser.FieldSelection:
+					count++
+				case parser.FragmentSpreadSelection:
+					fragment := doc.Fragment(selection.Name)
+					if fragment == nil || spreading[fragment.Name] {
+						continue
+					}
+					spreading[fragment.Name] = true
+					walk(fragment.SelectionSet)
+					spreading[fragment.Name] = false
+				}
+				walk(selection.SelectionSet)
+			}
+		}
+		for _, op := range executedOperations(doc, entry.Request.OperationName) {
+			walk(op.SelectionSet)
+		}
+	}
+	return count
+}
+
+// memoryQuotaStore keeps the counters of an instance
+type memoryQuotaStore struct {
+	maxKeys int
+
+	mu       sync.Mutex
+	counters map[string]*quotaCounter
+}
+
+type quotaCounter struct {
+	window   int64
+	current  int64
+	previous int64
+}
+
+var (
+	memoryQuotaStoresMu sync.Mutex
+	memoryQuotaStores   = make(map[string]*memoryQuotaStore)
+)
+
+// sharedMemoryQuotaStore returns the store of the middleware, shared by the
+// instances Traefik builds for it so that they count the same credits
+func sharedMemoryQuotaStore(middleware string, maxKeys int) *memoryQuotaStore {
+	memoryQuotaStoresMu.Lock()
+	defer memoryQuotaStoresMu.Unlock()
+	if existing, ok := memoryQuotaStores[middleware]; ok {
+		return existing
+	}
+	s := &memoryQuotaStore{maxKeys: maxKeys, counters: make(map[string]*quotaCounter)}
+	memoryQuotaStores[middleware] = s
+	return s
+}
+
+func (s *memoryQuotaStore) spend(key string, cost, limit int64, window time.Duration, now time.Time) (int64, bool, error) {
+	index := now.UnixNano() / int64(window)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	counter := s.counters[key]
+	if counter == nil {
+		if len(s.counters) >= s.maxKeys {
+			s.evict(index)
+		}
+		counter = &quotaCounter{window: index}
+		s.counters[key] = counter
+	}
+	switch {
+	case counter.window == index-1:
+		counter.window, counter.previous, counter.current = index, counter.current, 0
+	case counter.window < index-1:
+		counter.window, counter.previous, counter.current = index, 0, 0
+	}
+
+	spent := slidingSpent(counter.current, counter.previous, window, now)
+	if spent+cost > limit {
+		return spent, false, nil
+	}
+	counter.current += cost
+	return spent + cost, true, nil
+}
+
+// evict drops the counters of clients idle for two windows, or an arbitrary
+// one when none is
+func (s *memoryQuotaStore) evict(index int64) {
+	for key, counter := range s.counters {
+		if counter.window < index-1 {
+			delete(s.counters, key)
+		}
+	}
+	if len(s.counters) < s.maxKeys {
+		return
+	}
+	for key := range s.counters {
+		delete(s.counters, key)
+		return
+	}
+}
+
+// slidingSpent weighs the credits of the previous window by the part of it
+// the sliding window still covers
+func slidingSpent(current, previous int64, window time.Duration, now time.Time) int64 {
+	elapsed := float64(now.UnixNano()%int64(window)) / float64(window)
+	return current + int64(float64(previous)*(1-elapsed))
+}
+
+// redisQuotaStore keeps the counters in Redis, shared by the replicas
+type redisQuotaStore struct {
+	client     *redisClient
+	middleware string
+}
+
+func (s *redisQuotaStore) spend(key string, cost, limit int64, window time.Duration, now time.Time) (int64, bool, error) {
+	index := now.UnixNano() / int64(window)
+	elapsed := float64(now.UnixNano()%int64(window)) / float64(window)
+	// The hash tag keeps both windows of a key on the same cluster node
+	tag := "{" + s.middleware + ":" + key + "}"
+	reply, err := s.client.do("EVAL", quotaScript, "2",
+		s.client.key("quota", tag, strconv.FormatInt(index, 10)),
+		s.client.key("quota", tag, strconv.FormatInt(index-1, 10)),
+		strconv.FormatInt(cost, 10), strconv.FormatInt(limit, 10),
+		strconv.FormatFloat(1-elapsed, 'f', 6, 64), strconv.FormatInt(2*window.Milliseconds(), 10))
+	if err != nil {
+		return 0, false, err
+	}
+	values, _ := reply.([]any)
+	if len(values) != 2 {
+		return 0, false, fmt.Errorf("unexpected reply %v", reply)
+	}
+	ok, _ := values[0].(int64)
+	spent, _ := values[1].(int64)
+	return spent, ok == 1, nil
+}
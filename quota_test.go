@@ -0,0 +1,77 @@
+package trafico
+
+import (
+	"net/http"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestMemoryQuotaWindow(t *testing.T) {
+	store := &memoryQuotaStore{maxKeys: 10, counters: make(map[string]*quotaCounter)}
+	start := time.Unix(0, 0).Add(1000 * time.Hour)
+	steps := []struct {
+		name  string
+		at    time.Duration
+		cost  int64
+		spent int64
+		ok    bool
+	}{
+		{"first", 0, 6, 6, true},
+		{"over the limit", 30 * time.Minute, 5, 6, false},
+		{"up to the limit", 30 * time.Minute, 4, 10, true},
+		{"previous window in full", time.Hour, 1, 10, false},
+		{"previous window halved", 90 * time.Minute, 5, 10, true},
+		{"two windows later", 3 * time.Hour, 10, 10, true},
+	}
+	for _, step := range steps {
+		spent, ok, err := store.spend("key", step.cost, 10, time.Hour, start.Add(step.at))
+		if err != nil || spent != step.spent || ok != step.ok {
+			t.Errorf("%s: spent %d, ok %v, err %v; want %d and %v", step.name, spent, ok, err, step.spent, step.ok)
+		}
+	}
+	// Other keys have their own credits
+	if spent, ok, _ := store.spend("other", 10, 10, time.Hour, start.Add(3*time.Hour)); spent != 10 || !ok {
+		t.Errorf("other key: spent %d, ok %v", spent, ok)
+	}
+}
+
+func TestQuotaRetryAfter(t *testing.T) {
+	config := CreateConfig()
+	config.Quota = QuotaConfig{Enabled: true, Limit: 3, Window: "1h"}
+	calls := 0
+	handler := newTestHandler(t, config, func(rw http.ResponseWriter, req *http.Request) {
+		calls++
+	})
+
+	key := map[string]string{"X-API-Key": "client"}
+	steps := []struct {
+		name      string
+		body      string
+		header    map[string]string
+		status    int
+		remaining string
+	}{
+		{"two fields", `{"query":"{ a b }"}`, key, http.StatusOK, "1"},
+		{"fragment fields counted", `{"query":"{ ...F } fragment F on Q { a b }"}`, key, http.StatusTooManyRequests, "1"},
+		{"last credit", `{"query":"{ a }"}`, key, http.StatusOK, "0"},
+		{"exhausted", `{"query":"{ a }"}`, key, http.StatusTooManyRequests, "0"},
+		{"without a key", `{"query":"{ a }"}`, nil, http.StatusOK, ""},
+	}
+	for _, step := range steps {
+		rw := postGraphQL(handler, step.body, step.header)
+		if rw.Code != step.status || rw.Header().Get(defaultQuotaHeader) != step.remaining {
+			t.Fatalf("%s: status %d with %q remaining, want %d with %q: %s", step.name, rw.Code, rw.Header().Get(defaultQuotaHeader), step.status, step.remaining, rw.Body.String())
+		}
+		if step.status != http.StatusTooManyRequests {
+			continue
+		}
+		retryAfter, err := strconv.Atoi(rw.Header().Get("Retry-After"))
+		if err != nil || retryAfter < 1 || retryAfter > 3601 {
+			t.Errorf("%s: Retry-After = %q, want the seconds left in the window", step.name, rw.Header().Get("Retry-After"))
+		}
+	}
+	if calls != 3 {
+		t.Errorf("%d calls, want 3", calls)
+	}
+}
@@ -0,0 +1,333 @@
+package trafico
+
+import (
+	"bufio"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// StorageConfig selects where the state shared by the requests is kept:
+// quota counters and idempotency keys
+type StorageConfig struct {
+	// Type is memory (the default), keeping the state per Traefik instance,
+	// or redis, sharing it across the replicas of a deployment
+	Type  string      `json:"type,omitempty"`
+	Redis RedisConfig `json:"redis,omitempty"`
+}
+
+// RedisConfig connects to a Redis server or cluster
+type RedisConfig struct {
+	// Addresses are host:port of the server, or of cluster nodes; commands
+	// go to the first reachable one and cluster redirections are followed
+	Addresses []string `json:"addresses,omitempty"`
+	Username  string   `json:"username,omitempty"`
+	Password  string   `json:"password,omitempty"`
+	DB        int      `json:"db,omitempty"`
+	TLS       bool     `json:"tls,omitempty"`
+	// Timeout bounds dialing and each command, 1s by default
+	Timeout string `json:"timeout,omitempty"`
+	// PoolSize is the idle connections kept per node, 8 by default
+	PoolSize int `json:"poolSize,omitempty"`
+	// KeyPrefix namespaces the keys, trafico: by default
+	KeyPrefix string `json:"keyPrefix,omitempty"`
+}
+
+const (
+	storageMemory = "memory"
+	storageRedis  = "redis"
+
+	defaultRedisTimeout   = time.Second
+	defaultRedisPoolSize  = 8
+	defaultRedisKeyPrefix = "trafico:"
+
+	// maxRedisRedirects bounds the cluster redirections followed per command
+	maxRedisRedirects = 3
+)
+
+// redisError is an error reply of the server
+type redisError string
+
+func (e redisError) Error() string {
+	return string(e)
+}
+
+// asRedisError returns the error reply err is or wraps; the chain is walked
+// by hand as yaegi cannot run errors.As on interpreted types
+func asRedisError(err error) (redisError, bool) {
+	for err != nil {
+		if replyErr, ok := err.(redisError); ok {
+			return replyErr, true
+		}
+		err = errors.Unwrap(err)
+	}
+	return "", false
+}
+
+// redisClient sends commands over pooled connections
+type redisClient struct {
+	addresses []string
+	username  string
+	password  string
+	db        int
+	tls       bool
+	timeout   time.Duration
+	poolSize  int
+	prefix    string
+
+	mu    sync.Mutex
+	pools map[string]chan *redisConn
+}
+
+type redisConn struct {
+	conn   net.Conn
+	reader *bufio.Reader
+	writer *bufio.Writer
+}
+
+var (
+	redisClientsMu sync.Mutex
+	redisClients   = make(map[string]*redisClient)
+)
+
+// newStorage returns the Redis client of the storage configuration, nil when
+// the state is kept in memory. Clients are shared by the instances
+// connecting to the same server.
+func newStorage(config StorageConfig) (*redisClient, error) {
+	switch config.Type {
+	case "", storageMemory:
+		return nil, nil
+	case storageRedis:
+	default:
+		return nil, fmt.Errorf("storage: unknown type %q, expected memory or redis", config.Type)
+	}
+
+	redis := config.Redis
+	if len(redis.Addresses) == 0 {
+		return nil, fmt.Errorf("storage: redis.addresses is required")
+	}
+	if redis.PoolSize < 0 || redis.DB < 0 {
+		return nil, fmt.Errorf("storage: redis.poolSize and redis.db must not be negative")
+	}
+	c := &redisClient{
+		addresses: redis.Addresses,
+		username:  redis.Username,
+		password:  redis.Password,
+		db:        redis.DB,
+		tls:       redis.TLS,
+		timeout:   defaultRedisTimeout,
+		poolSize:  redis.PoolSize,
+		prefix:    redis.KeyPrefix,
+		pools:     make(map[string]chan *redisConn),
+	}
+	if redis.Timeout != "" {
+		d, err := time.ParseDuration(redis.Timeout)
+		if err != nil || d <= 0 {
+			return nil, fmt.Errorf("storage: invalid redis.timeout %q", redis.Timeout)
+		}
+		c.timeout = d
+	}
+	if c.poolSize == 0 {
+		c.poolSize = defaultRedisPoolSize
+	}
+	if c.prefix == "" {
+		c.prefix = defaultRedisKeyPrefix
+	}
+
+	key := strings.Join(c.addresses, ",") + "\n" + c.username + "\n" + strconv.Itoa(c.db) + "\n" + c.prefix
+	redisClientsMu.Lock()
+	defer redisClientsMu.Unlock()
+	if existing, ok := redisClients[key]; ok {
+		return existing, nil
+	}
+	redisClients[key] = c
+	return c, nil
+}
+
+// key namespaces a key with the prefix
+func (c *redisClient) key(parts ...string) string {
+	return c.prefix + strings.Join(parts, ":")
+}
+
+// do sends a command and returns its reply: a string, an int64, nil, a
+// []any or a redisError
+func (c *redisClient) do(args ...string) (any, error) {
+	var lastErr error
+	for _, address := range c.addresses {
+		reply, err := c.doAt(address, args, maxRedisRedirects)
+		if _, ok := asRedisError(err); err == nil || ok {
+			return reply, err
+		}
+		lastErr = err
+	}
+	return nil, lastErr
+}
+
+// doAt sends the command to a node, following cluster redirections
+func (c *redisClient) doAt(address string, args []string, redirects int) (any, error) {
+	conn, err := c.get(address)
+	if err != nil {
+		return nil, err
+	}
+	reply, err := conn.roundTrip(c.timeout, args)
+	replyErr, isReply := asRedisError(err)
+	if err != nil && !isReply {
+		conn.conn.Close()
+		return nil, err
+	}
+	c.put(address, conn)
+	if err == nil || redirects == 0 {
+		return reply, err
+	}
+
+	// MOVED <slot> <address> and ASK <slot> <address> point to another node
+	fields := strings.Fields(string(replyErr))
+	if len(fields) != 3 || (fields[0] != "MOVED" && fields[0] != "ASK") {
+		return reply, err
+	}
+	if fields[0] == "ASK" {
+		target, err := c.get(fields[2])
+		if err != nil {
+			return nil, err
+		}
+		if _, err := target.roundTrip(c.timeout, []string{"ASKING"}); err != nil {
+			target.conn.Close()
+			return nil, err
+		}
+		reply, err := target.roundTrip(c.timeout, args)
+		if _, ok := asRedisError(err); err != nil && !ok {
+			target.conn.Close()
+			return nil, err
+		}
+		c.put(fields[2], target)
+		return reply, err
+	}
+	return c.doAt(fields[2], args, redirects-1)
+}
+
+func (c *redisClient) pool(address string) chan *redisConn {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	pool, ok := c.pools[address]
+	if !ok {
+		pool = make(chan *redisConn, c.poolSize)
+		c.pools[address] = pool
+	}
+	return pool
+}
+
+// get takes an idle connection to the node, or dials a new one
+func (c *redisClient) get(address string) (*redisConn, error) {
+	select {
+	case conn := <-c.pool(address):
+		return conn, nil
+	default:
+	}
+
+	dialer := &net.Dialer{Timeout: c.timeout}
+	var conn net.Conn
+	var err error
+	if c.tls {
+		host, _, _ := net.SplitHostPort(address)
+		conn, err = tls.DialWithDialer(dialer, "tcp", address, &tls.Config{ServerName: host})
+	} else {
+		conn, err = dialer.Dial("tcp", address)
+	}
+	if err != nil {
+		return nil, err
+	}
+	rc := &redisConn{conn: conn, reader: bufio.NewReader(conn), writer: bufio.NewWriter(conn)}
+
+	var setup [][]string
+	switch {
+	case c.username != "":
+		setup = append(setup, []string{"AUTH", c.username, c.password})
+	case c.password != "":
+		setup = append(setup, []string{"AUTH", c.password})
+	}
+	if c.db != 0 {
+		setup = append(setup, []string{"SELECT", strconv.Itoa(c.db)})
+	}
+	for _, command := range setup {
+		if _, err := rc.roundTrip(c.timeout, command); err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("redis %s: %w", command[0], err)
+		}
+	}
+	return rc, nil
+}
+
+// put returns a healthy connection to the pool, closing it when full
+func (c *redisClient) put(address string, conn *redisConn) {
+	select {
+	case c.pool(address) <- conn:
+	default:
+		conn.conn.Close()
+	}
+}
+
+// roundTrip writes a command and reads its reply
+func (rc *redisConn) roundTrip(timeout time.Duration, args []string) (any, error) {
+	if err := rc.conn.SetDeadline(time.Now().Add(timeout)); err != nil {
+		return nil, err
+	}
+	rc.writer.WriteString("*" + strconv.Itoa(len(args)) + "\r\n")
+	for _, arg := range args {
+		rc.writer.WriteString("$" + strconv.Itoa(len(arg)) + "\r\n" + arg + "\r\n")
+	}
+	if err := rc.writer.Flush(); err != nil {
+		return nil, err
+	}
+	return rc.read()
+}
+
+// read decodes a RESP2 reply
+func (rc *redisConn) read() (any, error) {
+	line, err := rc.reader.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	if len(line) < 3 || !strings.HasSuffix(line, "\r\n") {
+		return nil, fmt.Errorf("redis: malformed reply %q", line)
+	}
+	kind, payload := line[0], line[1:len(line)-2]
+	switch kind {
+	case '+':
+		return payload, nil
+	case '-':
+		return nil, redisError(payload)
+	case ':':
+		return strconv.ParseInt(payload, 10, 64)
+	case '$':
+		size, err := strconv.Atoi(payload)
+		if err != nil || size < 0 {
+			return nil, err
+		}
+		data := make([]byte, size+2)
+		if _, err := io.ReadFull(rc.reader, data); err != nil {
+			return nil, err
+		}
+		return string(data[:size]), nil
+	case '*':
+		count, err := strconv.Atoi(payload)
+		if err != nil || count < 0 {
+			return nil, err
+		}
+		items := make([]any, count)
+		for i := range items {
+			item, err := rc.read()
+			if _, ok := asRedisError(err); err != nil && !ok {
+				return nil, err
+			}
+			items[i] = item
+		}
+		return items, nil
+	}
+	return nil, fmt.Errorf("redis: unknown reply type %q", kind)
+}
@@ -0,0 +1,349 @@
+package trafico
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/alainrk/trafico/parser"
+)
+
+const (
+	operationCountHeader = "X-GraphQL-Operation-Count"
+
+	formContentType = "application/x-www-form-urlencoded"
+	// defaultMaxFormBodyKB caps the form bodies decoded when maxFormBodyKB is unset
+	defaultMaxFormBodyKB = 1024
+)
+
+// isGraphQLContentType reports whether the plugin parses bodies of the content type
+func isGraphQLContentType(contentType string) bool {
+	return strings.Contains(contentType, "application/json") ||
+		strings.Contains(contentType, "application/graphql") ||
+		strings.Contains(contentType, formContentType)
+}
+
+// requestRejection is a request refused while parsing it
+type requestRejection struct {
+	status  int
+	reason  string
+	message string
+}
+
+// parseRequest decodes the body into a single request or a batch (a JSON
+// array of requests) and parses the documents within the configured limits
+func (g *GraphQLParser) parseRequest(req *http.Request, body *pooledBody) (*ParsedRequest, *requestRejection) {
+	contentType := req.Header.Get("Content-Type")
+	isJSON := strings.Contains(contentType, "application/json")
+	data := body.bytes()
+
+	var graphqlReq GraphQLRequest
+	switch {
+	case strings.Contains(contentType, formContentType):
+		if body.streamed() || len(data) > g.maxFormBytes {
+			return &ParsedRequest{}, g.truncated()
+		}
+		values, err := url.ParseQuery(string(data))
+		if err == nil {
+			graphqlReq, err = requestFromValues(values)
+		}
+		if err != nil {
+			if rejection := g.parseFailure("invalid_form", http.StatusBadRequest, "invalid form request body: "+err.Error()); rejection != nil {
+				return &ParsedRequest{}, rejection
+			}
+		}
+	case body.streamed() && isJSON:
+		// Only a prefix is buffered: the members it holds are decoded, and
+		// the remainder may not repeat those identifying the operation
+		graphqlReq = decodeRequestPrefix(data)
+		if !body.guardMembers() {
+			return &ParsedRequest{}, g.ambiguous("request body repeats query or operationName")
+		}
+		if graphqlReq.Query == "" {
+			return &ParsedRequest{Request: graphqlReq, OperationName: graphqlReq.OperationName}, g.truncated()
+		}
+	case body.streamed():
+		// A truncated raw document cannot be parsed
+		return &ParsedRequest{}, g.truncated()
+	case !isJSON:
+		// application/graphql bodies are raw documents, the other members
+		// of the request are passed in the URL
+		var err error
+		if graphqlReq, err = requestFromValues(req.URL.Query()); err != nil {
+			if rejection := g.parseFailure("invalid_json", http.StatusBadRequest, "invalid URL parameters: "+err.Error()); rejection != nil {
+				return &ParsedRequest{}, rejection
+			}
+		}
+		graphqlReq.Query = string(data)
+	case bytes.HasPrefix(bytes.TrimLeft(data, " \t\r\n"), []byte("[")):
+		return g.parseBatch(data)
+	default:
+		var err error
+		if graphqlReq, err = decodeRequest(data); err == errAmbiguousMember {
+			return &ParsedRequest{}, g.ambiguous(err.Error())
+		}
+		if err != nil {
+			graphqlReq = GraphQLRequest{}
+			if rejection := g.parseFailure("invalid_json", http.StatusBadRequest, "invalid JSON request body"); rejection != nil {
+				return &ParsedRequest{}, rejection
+			}
+			// Lenient clients send raw documents as JSON
+			graphqlReq.Query = string(data)
+		}
+	}
+
+	return g.parseEntry(graphqlReq)
+}
+
+// ambiguous refuses a body backends may not read the way the plugin did,
+// whatever the failure mode
+func (g *GraphQLParser) ambiguous(message string) *requestRejection {
+	g.metrics.parseFailure("invalid_json")
+	return &requestRejection{status: http.StatusBadRequest, reason: "invalid_json", message: message}
+}
+
+// errAmbiguousMember refuses request envelopes repeating a member, or
+// spelling it with another case as json.Unmarshal would match it: backends
+// may then execute another operation than the one inspected
+var errAmbiguousMember = errors.New("request body repeats query, operationName, variables or extensions")
+
+// decodeRequest decodes a JSON request envelope, refusing the member names
+// backends may read differently from json.Unmarshal
+func decodeRequest(data []byte) (GraphQLRequest, error) {
+	var request GraphQLRequest
+	if err := json.Unmarshal(data, &request); err != nil {
+		return request, err
+	}
+	if ambiguousMembers(data, 1) {
+		return request, errAmbiguousMember
+	}
+	return request, nil
+}
+
+// decodeBatch decodes a JSON array of request envelopes
+func decodeBatch(data []byte) ([]GraphQLRequest, error) {
+	var requests []GraphQLRequest
+	if err := json.Unmarshal(data, &requests); err != nil {
+		return nil, err
+	}
+	if ambiguousMembers(data, 2) {
+		return nil, errAmbiguousMember
+	}
+	return requests, nil
+}
+
+// ambiguousMembers scans the keys of the envelopes at depth of valid JSON
+// data for repeated or case variant request members. It does not allocate
+// unless a key holds escapes.
+func ambiguousMembers(data []byte, depth int) bool {
+	level, keyStart := 0, -1
+	inString, escaped, escapes, expectKey := false, false, false, false
+	var seen uint8
+	for i, c := range data {
+		if inString {
+			switch {
+			case escaped:
+				escaped = false
+			case c == '\\':
+				escaped, escapes = true, true
+			case c == '"':
+				inString = false
+				if keyStart < 0 {
+					break
+				}
+				key := data[keyStart+1 : i]
+				if escapes {
+					var unescaped string
+					if json.Unmarshal(data[keyStart:i+1], &unescaped) != nil {
+						return true
+					}
+					key = []byte(unescaped)
+				}
+				keyStart = -1
+				if member, bit := envelopeMember(string(key)); member != "" {
+					if member != string(key) || seen&bit != 0 {
+						return true
+					}
+					seen |= bit
+				}
+			}
+			continue
+		}
+		switch c {
+		case '"':
+			inString, escapes = true, false
+			if expectKey {
+				keyStart, expectKey = i, false
+			}
+		case '{':
+			level++
+			if level == depth {
+				expectKey, seen = true, 0
+			}
+		case '[':
+			level++
+		case '}', ']':
+			level--
+		case ',':
+			expectKey = level == depth
+		}
+	}
+	return false
+}
+
+// envelopeMember returns the request member the key names, case
+// insensitively like json.Unmarshal, and a bit identifying it. The member is
+// empty when the key names none.
+func envelopeMember(key string) (string, uint8) {
+	for i, member := range [...]string{"query", "operationName", "variables", "extensions"} {
+		if strings.EqualFold(key, member) {
+			return member, 1 << i
+		}
+	}
+	return "", 0
+}
+
+// requestFromValues reads the query, operationName, variables and extensions
+// parameters of a form or URL, the last two holding JSON objects
+func requestFromValues(values url.Values) (GraphQLRequest, error) {
+	request := GraphQLRequest{Query: values.Get("query"), OperationName: values.Get("operationName")}
+	if variables := values.Get("variables"); variables != "" {
+		if err := json.Unmarshal([]byte(variables), &request.Variables); err != nil {
+			return request, fmt.Errorf("variables: %w", err)
+		}
+	}
+	if extensions := values.Get("extensions"); extensions != "" {
+		if err := json.Unmarshal([]byte(extensions), &request.Extensions); err != nil {
+			return request, fmt.Errorf("extensions: %w", err)
+		}
+	}
+	return request, nil
+}
+
+// truncated handles a body too large for its document to be inspected
+func (g *GraphQLParser) truncated() *requestRejection {
+	return g.parseFailure("truncated", http.StatusRequestEntityTooLarge, "request body too large to be inspected")
+}
+
+// parseBatch parses every request of a batched body and summarizes them. In
+// dry run the batch is parsed in full and the first rejection is returned.
+func (g *GraphQLParser) parseBatch(data []byte) (*ParsedRequest, *requestRejection) {
+	requests, err := decodeBatch(data)
+	if err == errAmbiguousMember {
+		return &ParsedRequest{}, g.ambiguous("batch entry: " + err.Error())
+	}
+	if err != nil {
+		return &ParsedRequest{}, g.parseFailure("invalid_json", http.StatusBadRequest, "invalid JSON request body")
+	}
+
+	batch := &ParsedRequest{Batch: make([]*ParsedRequest, 0, len(requests))}
+	var found *requestRejection
+	if g.maxBatchSize > 0 && len(requests) > g.maxBatchSize {
+		found = &requestRejection{
+			status:  http.StatusBadRequest,
+			reason:  "batch_size",
+			message: fmt.Sprintf("batch of %d requests exceeds maxBatchSize (%d)", len(requests), g.maxBatchSize),
+		}
+		if g.enforced(found.reason) {
+			return batch, found
+		}
+	}
+
+	var queries, mutations []string
+	for i, request := range requests {
+		entry, rejection := g.parseEntry(request)
+		batch.Batch = append(batch.Batch, entry)
+		if rejection != nil {
+			rejection.message = "batch entry " + strconv.Itoa(i) + ": " + rejection.message
+			if g.enforced(rejection.reason) {
+				return batch, rejection
+			}
+			found = firstRejection(found, rejection)
+		}
+		queries = append(queries, entry.Queries...)
+		mutations = append(mutations, entry.Mutations...)
+		batch.OperationCount += entry.OperationCount
+		if i == 0 {
+			batch.OperationType = entry.OperationType
+		} else if batch.OperationType != entry.OperationType {
+			batch.OperationType = ""
+		}
+	}
+	batch.Queries, batch.Mutations = sortedUnique(queries), sortedUnique(mutations)
+	return batch, found
+}
+
+// parseEntry parses the document of a single request. When limits are in dry
+// run, documents exceeding them are parsed anyway and the rejection returned.
+func (g *GraphQLParser) parseEntry(graphqlReq GraphQLRequest) (*ParsedRequest, *requestRejection) {
+	parsed := &ParsedRequest{Request: graphqlReq, OperationName: graphqlReq.OperationName}
+
+	var found *requestRejection
+	doc, err := parser.ParseWithLimits(graphqlReq.Query, g.limits)
+	if err != nil {
+		// A type assertion rather than errors.As, which yaegi cannot run on
+		// interpreted types; the parser does not wrap its limit errors
+		if limitErr, ok := err.(*parser.LimitError); ok {
+			found = &requestRejection{status: http.StatusBadRequest, reason: "document_limit", message: limitErr.Error()}
+			if g.enforced(found.reason) {
+				return parsed, found
+			}
+			doc, err = parser.Parse(graphqlReq.Query)
+		}
+		if err != nil {
+			return parsed, firstRejection(found, g.parseFailure("syntax", http.StatusBadRequest, err.Error()))
+		}
+	}
+
+	parsed.Document = doc
+	parsed.OperationCount = len(doc.Operations)
+	if g.maxOperations > 0 && parsed.OperationCount > g.maxOperations {
+		rejection := &requestRejection{
+			status:  http.StatusBadRequest,
+			reason:  "too_many_operations",
+			message: fmt.Sprintf("document defines %d operations, exceeding maxOperationsPerDocument (%d)", parsed.OperationCount, g.maxOperations),
+		}
+		if g.enforced(rejection.reason) {
+			return parsed, rejection
+		}
+		found = firstRejection(found, rejection)
+	}
+
+	// Extract resource names (root fields) instead of operation names
+	parsed.Queries, parsed.Mutations = g.extractResourceNames(doc)
+	parsed.OperationName, parsed.OperationType = operationInfo(doc, graphqlReq.OperationName)
+	return parsed, found
+}
+
+// entries returns the requests of a batch, or the request itself
+func (p *ParsedRequest) entries() []*ParsedRequest {
+	if p.Batch != nil {
+		return p.Batch
+	}
+	return []*ParsedRequest{p}
+}
+
+// query returns the document, or the documents of a batch one per line
+func (p *ParsedRequest) query() string {
+	if p.Batch == nil {
+		return p.Request.Query
+	}
+	queries := make([]string, len(p.Batch))
+	for i, entry := range p.Batch {
+		queries[i] = entry.Request.Query
+	}
+	return strings.Join(queries, "\n")
+}
+
+// setOperationCount exposes the number of operations the request defines
+func setOperationCount(header http.Header, parsed *ParsedRequest) {
+	header.Del(operationCountHeader)
+	if parsed.OperationCount > 0 {
+		header.Set(operationCountHeader, strconv.Itoa(parsed.OperationCount))
+	}
+}
@@ -0,0 +1,127 @@
+package trafico
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestDecodeRequest(t *testing.T) {
+	tests := []struct {
+		name      string
+		body      string
+		query     string
+		ambiguous bool
+		invalid   bool
+	}{
+		{"members", `{"query":"{ a }","operationName":"A","variables":{"x":1},"extensions":{},"other":[1]}`, "{ a }", false, false},
+		{"null", `null`, "", false, false},
+		{"null members", `{"query":null,"variables":null}`, "", false, false},
+		{"repeated query", `{"query":"{ a }","query":"{ b }"}`, "", true, false},
+		{"repeated variables", `{"query":"{ a }","variables":{},"variables":{}}`, "", true, false},
+		{"case variant", `{"query":"mutation { a }","QUERY":"{ b }"}`, "", true, false},
+		{"case variant alone", `{"Query":"{ a }"}`, "", true, false},
+		{"folded variant", `{"query":"{ a }","operationName":"A","operationNaMe":"B"}`, "", true, false},
+		{"unicode fold", `{"query":"{ a }","variableſ":{}}`, "", true, false},
+		{"escaped repeat", `{"query":"{ a }","qu\u0065ry":"{ b }"}`, "", true, false},
+		{"escaped variant", `{"qu\u0045ry":"{ a }"}`, "", true, false},
+		{"batch entries", `[{"query":"{ a }"},{"query":"{ b }"}]`, "", false, true},
+		{"nested members", `{"query":"{ a }","variables":{"query":1,"Query":2}}`, "{ a }", false, false},
+		{"trailing data", `{"query":"{ a }"} {}`, "", false, true},
+		{"not an object", `"{ a }"`, "", false, true},
+		{"invalid member", `{"query":1}`, "", false, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			request, err := decodeRequest([]byte(tt.body))
+			switch {
+			case tt.ambiguous:
+				if err != errAmbiguousMember {
+					t.Errorf("got %v, want an ambiguous member", err)
+				}
+			case tt.invalid:
+				if err == nil || err == errAmbiguousMember {
+					t.Errorf("got %v, want invalid JSON", err)
+				}
+			case err != nil:
+				t.Fatal(err)
+			case request.Query != tt.query:
+				t.Errorf("query = %q, want %q", request.Query, tt.query)
+			}
+		})
+	}
+}
+
+func TestAmbiguousRequestRejected(t *testing.T) {
+	config := CreateConfig()
+	config.Rules = []RuleConfig{{OperationTypes: []string{"mutation"}}}
+	middleware, err := NewMiddleware(config, "ambiguous")
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler := middleware(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {}))
+
+	tests := []struct {
+		name string
+		body string
+		want int
+	}{
+		{"plain mutation", `{"query":"mutation { deleteAll }"}`, http.StatusForbidden},
+		{"case variant", `{"query":"mutation { deleteAll }","QUERY":"{ me }"}`, http.StatusBadRequest},
+		{"repeated query", `{"query":"mutation { deleteAll }","query":"{ me }"}`, http.StatusBadRequest},
+		{"batch case variant", `[{"query":"{ me }"},{"query":"mutation { deleteAll }","Query":"{ me }"}]`, http.StatusBadRequest},
+		{"query", `{"query":"{ me }"}`, http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/graphql", strings.NewReader(tt.body))
+			req.Header.Set("Content-Type", "application/json")
+			rw := httptest.NewRecorder()
+			handler.ServeHTTP(rw, req)
+			if rw.Code != tt.want {
+				t.Errorf("status %d, want %d: %s", rw.Code, tt.want, rw.Body.String())
+			}
+		})
+	}
+}
+
+func TestStreamedAmbiguousRequestRejected(t *testing.T) {
+	config := CreateConfig()
+	config.MaxBufferedBodyKB = 1
+	config.Rules = []RuleConfig{{OperationTypes: []string{"mutation"}}}
+	middleware, err := NewMiddleware(config, "ambiguous-streamed")
+	if err != nil {
+		t.Fatal(err)
+	}
+	padding := `"padding":"` + strings.Repeat("a", 4096) + `"`
+
+	tests := []struct {
+		name      string
+		body      string
+		status    int
+		forwarded bool
+	}{
+		{"query", `{"query":"{ me }",` + padding + `}`, http.StatusOK, true},
+		{"case variant in prefix", `{"query":"{ me }","QUERY":"mutation { deleteAll }",` + padding + `}`, http.StatusBadRequest, false},
+		{"case variant past prefix", `{"query":"{ me }",` + padding + `,"Query":"mutation { deleteAll }"}`, http.StatusOK, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			forwarded := false
+			handler := middleware(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+				_, err := io.ReadAll(req.Body)
+				forwarded = err == nil
+			}))
+			req := httptest.NewRequest(http.MethodPost, "/graphql", strings.NewReader(tt.body))
+			req.Header.Set("Content-Type", "application/json")
+			rw := httptest.NewRecorder()
+			handler.ServeHTTP(rw, req)
+			if rw.Code != tt.status || forwarded != tt.forwarded {
+				t.Errorf("status %d, forwarded %v, want %d and %v", rw.Code, forwarded, tt.status, tt.forwarded)
+			}
+		})
+	}
+}
@@ -0,0 +1,126 @@
+package trafico
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// RequestIDConfig gives every request an ID, kept when the client or an
+// upstream proxy sent one, forwarded in Header and echoed on the response.
+// The ID is included in the access log, the events and the plugin logs.
+type RequestIDConfig struct {
+	Enabled bool `json:"enabled,omitempty"`
+	// Header carries the ID, X-Request-ID by default
+	Header string `json:"header,omitempty"`
+	// Format of the generated IDs: uuidv7 (the default, ordered by time),
+	// uuidv4 or hex (32 random hexadecimal digits)
+	Format string `json:"format,omitempty"`
+}
+
+const (
+	defaultRequestIDHeader = "X-Request-ID"
+
+	requestIDUUIDv7 = "uuidv7"
+	requestIDUUIDv4 = "uuidv4"
+	requestIDHex    = "hex"
+
+	// maxRequestIDLength bounds the IDs accepted from clients
+	maxRequestIDLength = 128
+)
+
+// requestIDKey is the context key of the request ID
+type requestIDKey struct{}
+
+// requestIDs assigns the request IDs; a nil *requestIDs assigns none
+type requestIDs struct {
+	header string
+	format string
+}
+
+func newRequestIDs(config RequestIDConfig) (*requestIDs, error) {
+	if !config.Enabled {
+		return nil, nil
+	}
+	r := &requestIDs{header: config.Header, format: config.Format}
+	if r.header == "" {
+		r.header = defaultRequestIDHeader
+	}
+	switch r.format {
+	case "":
+		r.format = requestIDUUIDv7
+	case requestIDUUIDv7, requestIDUUIDv4, requestIDHex:
+	default:
+		return nil, fmt.Errorf("requestId: unknown format %q, expected uuidv7, uuidv4 or hex", config.Format)
+	}
+	return r, nil
+}
+
+// assign keeps the ID of the request, or generates one when it is missing or
+// malformed, sets it on the request and the response and returns the request
+// carrying it in its context
+func (r *requestIDs) assign(rw http.ResponseWriter, req *http.Request) *http.Request {
+	if r == nil {
+		return req
+	}
+	id := req.Header.Get(r.header)
+	if !validRequestID(id) {
+		id = r.generate(time.Now())
+		req.Header.Set(r.header, id)
+	}
+	rw.Header().Set(r.header, id)
+	return req.WithContext(context.WithValue(req.Context(), requestIDKey{}, id))
+}
+
+// generate returns a new ID in the configured format
+func (r *requestIDs) generate(now time.Time) string {
+	var b [16]byte
+	_, _ = rand.Read(b[:])
+	switch r.format {
+	case requestIDHex:
+		return hex.EncodeToString(b[:])
+	case requestIDUUIDv7:
+		// The first 48 bits are the Unix time in milliseconds
+		var ms [8]byte
+		binary.BigEndian.PutUint64(ms[:], uint64(now.UnixMilli()))
+		copy(b[:6], ms[2:])
+		b[6] = b[6]&0x0f | 0x70
+	default:
+		b[6] = b[6]&0x0f | 0x40
+	}
+	b[8] = b[8]&0x3f | 0x80
+	s := hex.EncodeToString(b[:])
+	return s[:8] + "-" + s[8:12] + "-" + s[12:16] + "-" + s[16:20] + "-" + s[20:]
+}
+
+// validRequestID reports whether an ID sent by a client can be kept: not
+// empty, bounded and made of visible ASCII characters
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLength {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		if id[i] <= ' ' || id[i] > '~' {
+			return false
+		}
+	}
+	return true
+}
+
+// requestID returns the ID assigned to the request, empty when none was
+func requestID(req *http.Request) string {
+	id, _ := req.Context().Value(requestIDKey{}).(string)
+	return id
+}
+
+// logRequestf logs a message about a request, with its ID when it has one
+func logRequestf(req *http.Request, format string, args ...any) {
+	if id := requestID(req); id != "" {
+		format += " (request " + id + ")"
+	}
+	logf(format, args...)
+}
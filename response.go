@@ -0,0 +1,94 @@
+package trafico
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// statusRecorder captures the status code written by the downstream handler
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+	bytes  int
+
+	// body optionally captures the response up to captureLimit bytes
+	body         *bytes.Buffer
+	captureLimit int
+	overflow     bool
+}
+
+func newStatusRecorder(rw http.ResponseWriter) *statusRecorder {
+	return &statusRecorder{ResponseWriter: rw}
+}
+
+// WriteHeader records the status code before forwarding it
+func (r *statusRecorder) WriteHeader(status int) {
+	if r.status == 0 {
+		r.status = status
+	}
+	r.ResponseWriter.WriteHeader(status)
+}
+
+// Write records an implicit 200 status and the number of bytes written
+func (r *statusRecorder) Write(b []byte) (int, error) {
+	if r.status == 0 {
+		r.status = http.StatusOK
+	}
+	n, err := r.ResponseWriter.Write(b)
+	r.bytes += n
+	if r.body != nil && !r.overflow {
+		if r.body.Len()+n > r.captureLimit {
+			r.overflow = true
+		} else {
+			r.body.Write(b[:n])
+		}
+	}
+	return n, err
+}
+
+// capture starts recording the response body up to limit bytes
+func (r *statusRecorder) capture(limit int) {
+	r.body = &bytes.Buffer{}
+	r.captureLimit = limit
+}
+
+// capturedJSON returns the captured body, decompressed, when it is a
+// complete JSON document; the body sent to the client is left untouched
+func (r *statusRecorder) capturedJSON() ([]byte, bool) {
+	if r.body == nil || r.overflow {
+		return nil, false
+	}
+	header := r.Header()
+	if !strings.Contains(header.Get("Content-Type"), "json") {
+		return nil, false
+	}
+	return decodeContent(header.Get("Content-Encoding"), r.body.Bytes())
+}
+
+// Flush keeps streaming responses working through the recorder
+func (r *statusRecorder) Flush() {
+	if f, ok := r.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Hijack keeps protocol upgrades working through the recorder
+func (r *statusRecorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	h, ok := r.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, fmt.Errorf("%T does not implement http.Hijacker", r.ResponseWriter)
+	}
+	return h.Hijack()
+}
+
+// Status returns the recorded status code, defaulting to 200
+func (r *statusRecorder) Status() int {
+	if r.status == 0 {
+		return http.StatusOK
+	}
+	return r.status
+}
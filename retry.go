@@ -0,0 +1,192 @@
+package trafico
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// RetryConfig retries documents made of queries only when the backend answers
+// with a retryable status; mutations and subscriptions are never retried
+type RetryConfig struct {
+	// Attempts is the number of retries after the first try, 0 disabling them
+	Attempts int `json:"attempts,omitempty"`
+	// Backoff is the wait before the first retry, 100ms by default, doubled
+	// for each following retry up to MaxBackoff, 2s by default
+	Backoff    string `json:"backoff,omitempty"`
+	MaxBackoff string `json:"maxBackoff,omitempty"`
+	// Statuses are the retryable statuses, 502, 503 and 504 by default
+	Statuses []int `json:"statuses,omitempty"`
+}
+
+const (
+	defaultRetryBackoff    = 100 * time.Millisecond
+	defaultRetryMaxBackoff = 2 * time.Second
+)
+
+var defaultRetryStatuses = []int{http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout}
+
+// retryPolicy retries query documents; a nil *retryPolicy does nothing
+type retryPolicy struct {
+	attempts   int
+	backoff    time.Duration
+	maxBackoff time.Duration
+	statuses   map[int]bool
+}
+
+func newRetryPolicy(config RetryConfig) (*retryPolicy, error) {
+	if config.Attempts == 0 {
+		return nil, nil
+	}
+	if config.Attempts < 0 {
+		return nil, fmt.Errorf("retries: attempts must not be negative")
+	}
+
+	r := &retryPolicy{
+		attempts:   config.Attempts,
+		backoff:    defaultRetryBackoff,
+		maxBackoff: defaultRetryMaxBackoff,
+		statuses:   make(map[int]bool),
+	}
+	for setting, value := range map[string]string{"backoff": config.Backoff, "maxBackoff": config.MaxBackoff} {
+		if value == "" {
+			continue
+		}
+		d, err := time.ParseDuration(value)
+		if err != nil || d < 0 {
+			return nil, fmt.Errorf("retries: invalid %s %q", setting, value)
+		}
+		if setting == "backoff" {
+			r.backoff = d
+		} else {
+			r.maxBackoff = d
+		}
+	}
+	statuses := config.Statuses
+	if len(statuses) == 0 {
+		statuses = defaultRetryStatuses
+	}
+	for _, status := range statuses {
+		if status < 100 || status > 599 {
+			return nil, fmt.Errorf("retries: invalid status %d", status)
+		}
+		r.statuses[status] = true
+	}
+	return r, nil
+}
+
+// retryable reports whether the request may be sent again: its executed
+// operations are all queries and its body is fully buffered
+func (r *retryPolicy) retryable(parsed *ParsedRequest, body *pooledBody) bool {
+	if r == nil || body == nil || body.streamed() {
+		return false
+	}
+	return onlyQueries(parsed)
+}
+
+// onlyQueries reports whether the request executes operations and all of
+// them are queries, which can safely be executed again
+func onlyQueries(parsed *ParsedRequest) bool {
+	executed := false
+	for _, entry := range parsed.entries() {
+		for _, op := range executedOperations(entry.Document, entry.Request.OperationName) {
+			if op.Type != "query" {
+				return false
+			}
+			executed = true
+		}
+	}
+	return executed
+}
+
+// serve hands the request over to next, again while it answers with a
+// retryable status and attempts are left
+func (r *retryPolicy) serve(next http.Handler, rw http.ResponseWriter, req *http.Request, body *pooledBody, metrics *metrics) {
+	backoff := r.backoff
+	for attempt := 0; ; attempt++ {
+		w := &retryWriter{ResponseWriter: rw, header: make(http.Header), statuses: r.statuses, last: attempt == r.attempts}
+		for name, values := range rw.Header() {
+			w.header[name] = append([]string(nil), values...)
+		}
+		next.ServeHTTP(w, req)
+		if !w.discarded {
+			return
+		}
+		metrics.retry(w.status)
+
+		timer := time.NewTimer(backoff)
+		select {
+		case <-req.Context().Done():
+			timer.Stop()
+			w.last = true
+			w.commit(w.status)
+			return
+		case <-timer.C:
+		}
+		if backoff *= 2; backoff > r.maxBackoff {
+			backoff = r.maxBackoff
+		}
+		body.rewind()
+	}
+}
+
+// retryWriter holds back the response of an attempt until its status is
+// known, and drops it when the status is retryable and attempts are left
+type retryWriter struct {
+	http.ResponseWriter
+	header   http.Header
+	statuses map[int]bool
+	last     bool
+
+	status    int
+	committed bool
+	discarded bool
+}
+
+func (w *retryWriter) Header() http.Header {
+	return w.header
+}
+
+func (w *retryWriter) WriteHeader(status int) {
+	if w.committed || w.discarded {
+		return
+	}
+	if !w.last && w.statuses[status] {
+		w.status, w.discarded = status, true
+		return
+	}
+	w.commit(status)
+}
+
+func (w *retryWriter) Write(b []byte) (int, error) {
+	if !w.committed && !w.discarded {
+		w.WriteHeader(http.StatusOK)
+	}
+	if w.discarded {
+		return len(b), nil
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+// commit writes the headers and the status of the attempt to the client
+func (w *retryWriter) commit(status int) {
+	header := w.ResponseWriter.Header()
+	for name := range header {
+		delete(header, name)
+	}
+	for name, values := range w.header {
+		header[name] = values
+	}
+	w.committed = true
+	w.ResponseWriter.WriteHeader(status)
+}
+
+// Flush keeps streaming responses working through the writer
+func (w *retryWriter) Flush() {
+	if !w.committed {
+		return
+	}
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
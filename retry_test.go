@@ -0,0 +1,50 @@
+package trafico
+
+import (
+	"net/http"
+	"strconv"
+	"testing"
+)
+
+func TestRetries(t *testing.T) {
+	tests := []struct {
+		name     string
+		query    string
+		statuses []int
+		status   int
+		calls    int
+	}{
+		{"success", "query Q { a }", []int{http.StatusOK}, http.StatusOK, 1},
+		{"retried", "query Q { a }", []int{http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusOK}, http.StatusOK, 3},
+		{"attempts exhausted", "query Q { a }", []int{http.StatusBadGateway, http.StatusBadGateway, http.StatusGatewayTimeout, http.StatusOK}, http.StatusGatewayTimeout, 3},
+		{"not retryable", "query Q { a }", []int{http.StatusInternalServerError, http.StatusOK}, http.StatusInternalServerError, 1},
+		{"mutation", "mutation Q { a }", []int{http.StatusBadGateway, http.StatusOK}, http.StatusBadGateway, 1},
+		{"mutation not executed", "query Q { a } mutation M { b }", []int{http.StatusBadGateway, http.StatusOK}, http.StatusOK, 2},
+	}
+
+	config := CreateConfig()
+	config.Retries = RetryConfig{Attempts: 2, Backoff: "1ms"}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			calls := 0
+			handler := newTestHandler(t, config, func(rw http.ResponseWriter, req *http.Request) {
+				status := tt.statuses[calls]
+				calls++
+				rw.Header().Set("X-Attempt", strconv.Itoa(calls))
+				rw.WriteHeader(status)
+				_, _ = rw.Write([]byte(`{"attempt":` + strconv.Itoa(calls) + `}`))
+			})
+
+			body := `{"query":"` + tt.query + `","operationName":"Q"}`
+			rw := postGraphQL(handler, body, nil)
+			if rw.Code != tt.status || calls != tt.calls {
+				t.Fatalf("status %d after %d calls, want %d after %d", rw.Code, calls, tt.status, tt.calls)
+			}
+			// Only the answer of the last attempt reaches the client
+			attempt := strconv.Itoa(calls)
+			if rw.Header().Get("X-Attempt") != attempt || rw.Body.String() != `{"attempt":`+attempt+`}` {
+				t.Errorf("answered %v %q, want attempt %s", rw.Header(), rw.Body.String(), attempt)
+			}
+		})
+	}
+}
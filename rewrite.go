@@ -0,0 +1,136 @@
+package trafico
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/alainrk/trafico/parser"
+)
+
+// RewriteConfig rewrites the documents before they are forwarded, instead of
+// rejecting the requests; the body and its Content-Length are replaced.
+// Bodies too large to be buffered are forwarded as sent.
+type RewriteConfig struct {
+	// StripFields are the names of the fields removed wherever they are
+	// selected, such as __schema or expensive debug fields
+	StripFields []string `json:"stripFields,omitempty"`
+	// InjectTypename selects __typename in every selection set below the
+	// operation roots, for backends and caches that require it
+	InjectTypename bool `json:"injectTypename,omitempty"`
+}
+
+// documentRewriter rewrites documents in place; a nil *documentRewriter does
+// nothing
+type documentRewriter struct {
+	strip    map[string]bool
+	typename bool
+}
+
+func newDocumentRewriter(config RewriteConfig) (*documentRewriter, error) {
+	if len(config.StripFields) == 0 && !config.InjectTypename {
+		return nil, nil
+	}
+	r := &documentRewriter{strip: make(map[string]bool, len(config.StripFields)), typename: config.InjectTypename}
+	for _, name := range config.StripFields {
+		if name == "" {
+			return nil, fmt.Errorf("rewrite: stripFields must not hold empty names")
+		}
+		r.strip[name] = true
+	}
+	return r, nil
+}
+
+// rewrite applies the rewrites to the document and reports whether it changed
+func (r *documentRewriter) rewrite(doc *parser.Document) bool {
+	changed := false
+	if len(r.strip) > 0 && parser.StripFields(doc, r.strip) {
+		changed = true
+	}
+	// Injected after stripping, so that emptied selection sets are not
+	// filled twice
+	if r.typename && parser.InjectTypename(doc) {
+		changed = true
+	}
+	return changed
+}
+
+// rewrite rewrites the documents of the request and replaces its body, so
+// that the later stages see and forward the rewritten request
+func (g *GraphQLParser) rewrite(req *http.Request, parsed *ParsedRequest, body *pooledBody) {
+	if g.rewriter == nil || body.streamed() {
+		return
+	}
+	changed := false
+	var queries, mutations []string
+	for _, entry := range parsed.entries() {
+		if entry.Document != nil && g.rewriter.rewrite(entry.Document) {
+			entry.Request.Query = parser.Print(entry.Document)
+			entry.Queries, entry.Mutations = g.extractResourceNames(entry.Document)
+			changed = true
+		}
+		queries = append(queries, entry.Queries...)
+		mutations = append(mutations, entry.Mutations...)
+	}
+	if !changed {
+		return
+	}
+	if parsed.Batch != nil {
+		parsed.Queries, parsed.Mutations = sortedUnique(queries), sortedUnique(mutations)
+	}
+
+	data, err := rewrittenBody(req.Header.Get("Content-Type"), parsed, body.bytes())
+	if err != nil {
+		logRequestf(req, "rewriting the request body failed: %v", err)
+		return
+	}
+	body.replace(data)
+	req.ContentLength = int64(len(data))
+	req.Header.Set("Content-Length", strconv.Itoa(len(data)))
+}
+
+// rewrittenBody encodes the rewritten documents in the body as it was sent,
+// keeping its other members
+func rewrittenBody(contentType string, parsed *ParsedRequest, data []byte) ([]byte, error) {
+	switch {
+	case strings.Contains(contentType, formContentType):
+		values, err := url.ParseQuery(string(data))
+		if err != nil {
+			return nil, err
+		}
+		values.Set("query", parsed.Request.Query)
+		return []byte(values.Encode()), nil
+	case !strings.Contains(contentType, "application/json"):
+		return []byte(parsed.Request.Query), nil
+	case parsed.Batch != nil:
+		var requests []map[string]json.RawMessage
+		if err := json.Unmarshal(data, &requests); err != nil {
+			return nil, err
+		}
+		if len(requests) != len(parsed.Batch) {
+			return nil, fmt.Errorf("batch of %d requests, %d parsed", len(requests), len(parsed.Batch))
+		}
+		for i, request := range requests {
+			if request != nil {
+				setQueryMember(request, parsed.Batch[i].Request.Query)
+			}
+		}
+		return json.Marshal(requests)
+	}
+
+	var request map[string]json.RawMessage
+	if err := json.Unmarshal(data, &request); err != nil || request == nil {
+		// Lenient clients send raw documents as JSON
+		return []byte(parsed.Request.Query), nil
+	}
+	setQueryMember(request, parsed.Request.Query)
+	return json.Marshal(request)
+}
+
+func setQueryMember(request map[string]json.RawMessage, query string) {
+	encoded, _ := json.Marshal(query)
+	request["query"] = encoded
+}
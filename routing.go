@@ -0,0 +1,96 @@
+package trafico
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// RoutingConfig maps root fields to the backend service that owns them
+type RoutingConfig struct {
+	// Fields maps a root field name to a service name
+	Fields         map[string]string `json:"fields,omitempty"`
+	DefaultService string            `json:"defaultService,omitempty"`
+	Header         string            `json:"header,omitempty"`
+	// RewritePath replaces the request path when a single service owns every
+	// root field; {service} is substituted (e.g. "/graphql/{service}")
+	RewritePath string `json:"rewritePath,omitempty"`
+}
+
+const (
+	defaultServiceHeader = "X-GraphQL-Service"
+	replacedPathHeader   = "X-Replaced-Path"
+)
+
+// router sets routing hints from root fields; a nil *router does nothing
+type router struct {
+	fields         map[string]string
+	defaultService string
+	header         string
+	rewritePath    string
+	lists          listHeaders
+}
+
+func newRouter(config RoutingConfig, lists listHeaders) (*router, error) {
+	if len(config.Fields) == 0 {
+		return nil, nil
+	}
+	if config.RewritePath != "" && !strings.HasPrefix(config.RewritePath, "/") {
+		return nil, fmt.Errorf("routing: rewritePath must start with /, got %q", config.RewritePath)
+	}
+
+	r := &router{
+		fields:         config.Fields,
+		defaultService: config.DefaultService,
+		header:         config.Header,
+		rewritePath:    config.RewritePath,
+		lists:          lists,
+	}
+	if r.header == "" {
+		r.header = defaultServiceHeader
+	}
+	return r, nil
+}
+
+// services returns the sorted set of services owning the root fields; unmapped
+// fields fall back to the default service or are ignored
+func (r *router) services(fields []string) []string {
+	seen := make(map[string]bool)
+	var services []string
+	for _, field := range fields {
+		service, ok := r.fields[field]
+		if !ok {
+			service = r.defaultService
+		}
+		if service == "" || seen[service] {
+			continue
+		}
+		seen[service] = true
+		services = append(services, service)
+	}
+	sort.Strings(services)
+	return services
+}
+
+// apply sets the service header and rewrites the path when the target is unambiguous
+func (r *router) apply(req *http.Request, queries, mutations []string) {
+	if r == nil {
+		return
+	}
+	req.Header.Del(r.header)
+
+	services := r.services(append(append([]string(nil), queries...), mutations...))
+	if len(services) == 0 {
+		return
+	}
+	r.lists.set(req.Header, r.header, services)
+
+	if r.rewritePath == "" || len(services) != 1 {
+		return
+	}
+	req.Header.Set(replacedPathHeader, req.URL.Path)
+	req.URL.Path = strings.ReplaceAll(r.rewritePath, "{service}", services[0])
+	req.URL.RawPath = ""
+	req.RequestURI = req.URL.RequestURI()
+}
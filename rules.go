@@ -0,0 +1,267 @@
+package trafico
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// RuleConfig restricts the requests matching all of its conditions, such as
+// mutations sent from outside the office network after hours. Rules are
+// evaluated in order and the first one restricting a request rejects it with
+// a 403.
+type RuleConfig struct {
+	Name string `json:"name,omitempty"`
+
+	// SourceRanges match client IPs within any of the CIDR ranges, and
+	// ExcludedSourceRanges those outside all of them
+	SourceRanges         []string `json:"sourceRanges,omitempty"`
+	ExcludedSourceRanges []string `json:"excludedSourceRanges,omitempty"`
+	// Headers match request headers by name; an empty value matches any
+	// non-empty one
+	Headers map[string]string `json:"headers,omitempty"`
+	// Days (mon to sun) and Hours (such as 18:00-08:00, wrapping past
+	// midnight) match the time of the request in TimeZone, UTC by default
+	Days     []string `json:"days,omitempty"`
+	Hours    string   `json:"hours,omitempty"`
+	TimeZone string   `json:"timeZone,omitempty"`
+
+	// OperationTypes are blocked, mutation for a read-only mode
+	OperationTypes []string `json:"operationTypes,omitempty"`
+	// Fields are root fields blocked
+	Fields []string `json:"fields,omitempty"`
+	// MaxFields bounds the fields a request selects, fragments counted
+	// wherever they are spread
+	MaxFields int64 `json:"maxFields,omitempty"`
+	// Message replaces the error message of the rejections
+	Message string `json:"message,omitempty"`
+}
+
+// policyRule is a compiled RuleConfig
+type policyRule struct {
+	name     string
+	include  []*net.IPNet
+	exclude  []*net.IPNet
+	headers  map[string]string
+	days     map[time.Weekday]bool
+	from, to int
+	hours    bool
+	location *time.Location
+
+	opTypes   map[string]bool
+	fields    map[string]bool
+	maxFields int64
+	message   string
+}
+
+var ruleDays = map[string]time.Weekday{
+	"sun": time.Sunday, "mon": time.Monday, "tue": time.Tuesday, "wed": time.Wednesday,
+	"thu": time.Thursday, "fri": time.Friday, "sat": time.Saturday,
+}
+
+// newPolicyRules compiles the rules
+func newPolicyRules(configs []RuleConfig) ([]*policyRule, error) {
+	rules := make([]*policyRule, 0, len(configs))
+	for i, config := range configs {
+		name := config.Name
+		if name == "" {
+			name = "rules[" + strconv.Itoa(i) + "]"
+		}
+		rule, err := newPolicyRule(config, name)
+		if err != nil {
+			return nil, fmt.Errorf("rules: %s: %w", name, err)
+		}
+		rules = append(rules, rule)
+	}
+	return rules, nil
+}
+
+func newPolicyRule(config RuleConfig, name string) (*policyRule, error) {
+	r := &policyRule{name: name, headers: config.Headers, location: time.UTC, maxFields: config.MaxFields, message: config.Message}
+	if len(config.OperationTypes) == 0 && len(config.Fields) == 0 && config.MaxFields == 0 {
+		return nil, fmt.Errorf("operationTypes, fields or maxFields is required")
+	}
+	if config.MaxFields < 0 {
+		return nil, fmt.Errorf("maxFields must not be negative")
+	}
+
+	var err error
+	if r.include, err = parseCIDRs(config.SourceRanges); err != nil {
+		return nil, err
+	}
+	if r.exclude, err = parseCIDRs(config.ExcludedSourceRanges); err != nil {
+		return nil, err
+	}
+	if config.TimeZone != "" {
+		if r.location, err = time.LoadLocation(config.TimeZone); err != nil {
+			return nil, fmt.Errorf("invalid timeZone %q", config.TimeZone)
+		}
+	}
+	for _, day := range config.Days {
+		weekday, ok := ruleDays[strings.ToLower(day)]
+		if !ok {
+			return nil, fmt.Errorf("unknown day %q, expected mon to sun", day)
+		}
+		if r.days == nil {
+			r.days = make(map[time.Weekday]bool)
+		}
+		r.days[weekday] = true
+	}
+	if config.Hours != "" {
+		from, to, ok := strings.Cut(config.Hours, "-")
+		if ok {
+			r.from, ok = parseClock(from)
+		}
+		if ok {
+			r.to, ok = parseClock(to)
+		}
+		if !ok || r.from == r.to {
+			return nil, fmt.Errorf("invalid hours %q, expected a range such as 18:00-08:00", config.Hours)
+		}
+		r.hours = true
+	}
+
+	for _, opType := range config.OperationTypes {
+		switch opType {
+		case "query", "mutation", "subscription":
+		default:
+			return nil, fmt.Errorf("unknown operation type %q", opType)
+		}
+		if r.opTypes == nil {
+			r.opTypes = make(map[string]bool)
+		}
+		r.opTypes[opType] = true
+	}
+	for _, field := range config.Fields {
+		if r.fields == nil {
+			r.fields = make(map[string]bool)
+		}
+		r.fields[field] = true
+	}
+	return r, nil
+}
+
+// parseCIDRs parses CIDR ranges, single addresses standing for themselves
+func parseCIDRs(ranges []string) ([]*net.IPNet, error) {
+	var networks []*net.IPNet
+	for _, value := range ranges {
+		if !strings.Contains(value, "/") {
+			ip := net.ParseIP(value)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid source range %q", value)
+			}
+			bits := 8 * len(ip.To16())
+			if ip.To4() != nil {
+				ip, bits = ip.To4(), 32
+			}
+			networks = append(networks, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, network, err := net.ParseCIDR(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid source range %q", value)
+		}
+		networks = append(networks, network)
+	}
+	return networks, nil
+}
+
+// parseClock parses hh:mm into minutes since midnight
+func parseClock(value string) (int, bool) {
+	t, err := time.Parse("15:04", strings.TrimSpace(value))
+	if err != nil {
+		return 0, false
+	}
+	return t.Hour()*60 + t.Minute(), true
+}
+
+// matches reports whether the request meets all the conditions of the rule
+func (r *policyRule) matches(req *http.Request, now time.Time) bool {
+	if len(r.include) > 0 || len(r.exclude) > 0 {
+		ip := net.ParseIP(clientIP(req))
+		if ip == nil || (len(r.include) > 0 && !containsIP(r.include, ip)) || containsIP(r.exclude, ip) {
+			return false
+		}
+	}
+	for name, value := range r.headers {
+		got := req.Header.Get(name)
+		if got == "" || (value != "" && got != value) {
+			return false
+		}
+	}
+	now = now.In(r.location)
+	if r.days != nil && !r.days[now.Weekday()] {
+		return false
+	}
+	if r.hours {
+		minute := now.Hour()*60 + now.Minute()
+		if r.from < r.to {
+			return minute >= r.from && minute < r.to
+		}
+		return minute >= r.from || minute < r.to
+	}
+	return true
+}
+
+func containsIP(networks []*net.IPNet, ip net.IP) bool {
+	for _, network := range networks {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// violation returns why the rule restricts the request, empty when it does not
+func (r *policyRule) violation(parsed *ParsedRequest) string {
+	found := r.blocked(parsed)
+	if found == "" && r.maxFields > 0 && fieldCount(parsed, r.maxFields) > r.maxFields {
+		found = "request selects more than " + strconv.FormatInt(r.maxFields, 10) + " fields"
+	}
+	if found == "" {
+		return ""
+	}
+	if r.message != "" {
+		return r.message
+	}
+	return r.name + ": " + found
+}
+
+// blocked returns the blocked operation type or root field the request
+// executes, empty when it executes none
+func (r *policyRule) blocked(parsed *ParsedRequest) string {
+	for _, entry := range parsed.entries() {
+		for _, op := range executedOperations(entry.Document, entry.Request.OperationName) {
+			if r.opTypes[op.Type] {
+				return op.Type + " operations are not allowed"
+			}
+			for _, field := range entry.Document.RootFields(op) {
+				if r.fields[field.Name] {
+					return "field " + field.Name + " is not allowed"
+				}
+			}
+		}
+	}
+	return ""
+}
+
+// checkRules returns the message of the first rule restricting the request,
+// empty when none does
+func (g *GraphQLParser) checkRules(req *http.Request, parsed *ParsedRequest) string {
+	if len(g.rules) == 0 {
+		return ""
+	}
+	now := time.Now()
+	for _, rule := range g.rules {
+		if !rule.matches(req, now) {
+			continue
+		}
+		if message := rule.violation(parsed); message != "" {
+			return message
+		}
+	}
+	return ""
+}
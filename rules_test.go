@@ -0,0 +1,129 @@
+package trafico
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/alainrk/trafico/parser"
+)
+
+func TestPolicyRuleMatches(t *testing.T) {
+	// Monday 2024-01-15
+	monday := func(clock string) time.Time {
+		at, err := time.Parse("2006-01-02 15:04", "2024-01-15 "+clock)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return at
+	}
+
+	tests := []struct {
+		name    string
+		rule    RuleConfig
+		remote  string
+		headers map[string]string
+		now     time.Time
+		want    bool
+	}{
+		{"no conditions", RuleConfig{}, "10.0.0.1:1234", nil, monday("12:00"), true},
+		{"in source range", RuleConfig{SourceRanges: []string{"10.0.0.0/8"}}, "10.1.2.3:1234", nil, monday("12:00"), true},
+		{"out of source range", RuleConfig{SourceRanges: []string{"10.0.0.0/8"}}, "192.168.0.1:1234", nil, monday("12:00"), false},
+		{"single address", RuleConfig{SourceRanges: []string{"192.168.0.1"}}, "192.168.0.1:1234", nil, monday("12:00"), true},
+		{"ipv6 range", RuleConfig{SourceRanges: []string{"2001:db8::/32"}}, "[2001:db8::1]:1234", nil, monday("12:00"), true},
+		{"excluded range", RuleConfig{ExcludedSourceRanges: []string{"10.0.0.0/8"}}, "10.1.2.3:1234", nil, monday("12:00"), false},
+		{"outside excluded range", RuleConfig{ExcludedSourceRanges: []string{"10.0.0.0/8"}}, "172.16.0.1:1234", nil, monday("12:00"), true},
+		{"header present", RuleConfig{Headers: map[string]string{"X-Env": ""}}, "10.0.0.1:1234", map[string]string{"X-Env": "prod"}, monday("12:00"), true},
+		{"header missing", RuleConfig{Headers: map[string]string{"X-Env": ""}}, "10.0.0.1:1234", nil, monday("12:00"), false},
+		{"header value", RuleConfig{Headers: map[string]string{"X-Env": "prod"}}, "10.0.0.1:1234", map[string]string{"X-Env": "staging"}, monday("12:00"), false},
+		{"day", RuleConfig{Days: []string{"mon", "tue"}}, "10.0.0.1:1234", nil, monday("12:00"), true},
+		{"other day", RuleConfig{Days: []string{"sat", "sun"}}, "10.0.0.1:1234", nil, monday("12:00"), false},
+		{"within hours", RuleConfig{Hours: "09:00-18:00"}, "10.0.0.1:1234", nil, monday("09:00"), true},
+		{"hours end excluded", RuleConfig{Hours: "09:00-18:00"}, "10.0.0.1:1234", nil, monday("18:00"), false},
+		{"hours past midnight", RuleConfig{Hours: "18:00-08:00"}, "10.0.0.1:1234", nil, monday("23:30"), true},
+		{"wrapped hours morning", RuleConfig{Hours: "18:00-08:00"}, "10.0.0.1:1234", nil, monday("07:59"), true},
+		{"outside wrapped hours", RuleConfig{Hours: "18:00-08:00"}, "10.0.0.1:1234", nil, monday("12:00"), false},
+		{"time zone", RuleConfig{Hours: "09:00-18:00", TimeZone: "Asia/Tokyo"}, "10.0.0.1:1234", nil, monday("12:00"), false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.rule.OperationTypes = []string{"mutation"}
+			rules, err := newPolicyRules([]RuleConfig{tt.rule})
+			if err != nil {
+				t.Fatal(err)
+			}
+			req := httptest.NewRequest(http.MethodPost, "/graphql", nil)
+			req.RemoteAddr = tt.remote
+			for name, value := range tt.headers {
+				req.Header.Set(name, value)
+			}
+			if got := rules[0].matches(req, tt.now); got != tt.want {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPolicyRuleViolation(t *testing.T) {
+	tests := []struct {
+		name  string
+		rule  RuleConfig
+		query string
+		want  string
+	}{
+		{"blocked operation type", RuleConfig{Name: "read-only", OperationTypes: []string{"mutation"}},
+			"mutation { a }", "read-only: mutation operations are not allowed"},
+		{"allowed operation type", RuleConfig{OperationTypes: []string{"mutation"}}, "{ a }", ""},
+		{"blocked field", RuleConfig{Name: "r", Fields: []string{"admin"}}, "{ a admin { b } }", "r: field admin is not allowed"},
+		{"nested field not blocked", RuleConfig{Fields: []string{"admin"}}, "{ a { admin } }", ""},
+		{"max fields", RuleConfig{Name: "r", MaxFields: 3}, "{ a { b c } d }", "r: request selects more than 3 fields"},
+		{"max fields through fragments", RuleConfig{Name: "r", MaxFields: 3}, "{ ...F ...F } fragment F on Q { a b }", "r: request selects more than 3 fields"},
+		{"within max fields", RuleConfig{MaxFields: 3}, "{ a { b c } }", ""},
+		{"message", RuleConfig{OperationTypes: []string{"mutation"}, Message: "read-only mode"}, "mutation { a }", "read-only mode"},
+		{"default name", RuleConfig{OperationTypes: []string{"subscription"}}, "subscription { a }", "rules[0]: subscription operations are not allowed"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rules, err := newPolicyRules([]RuleConfig{tt.rule})
+			if err != nil {
+				t.Fatal(err)
+			}
+			doc, err := parser.Parse(tt.query)
+			if err != nil {
+				t.Fatal(err)
+			}
+			parsed := &ParsedRequest{Request: GraphQLRequest{Query: tt.query}, Document: doc}
+			if got := rules[0].violation(parsed); got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNewPolicyRulesErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		rule RuleConfig
+	}{
+		{"no restriction", RuleConfig{SourceRanges: []string{"10.0.0.0/8"}}},
+		{"negative maxFields", RuleConfig{MaxFields: -1}},
+		{"invalid range", RuleConfig{Fields: []string{"a"}, SourceRanges: []string{"10.0.0.0/33"}}},
+		{"invalid address", RuleConfig{Fields: []string{"a"}, ExcludedSourceRanges: []string{"host"}}},
+		{"unknown day", RuleConfig{Fields: []string{"a"}, Days: []string{"monday"}}},
+		{"invalid hours", RuleConfig{Fields: []string{"a"}, Hours: "18:00"}},
+		{"empty hours range", RuleConfig{Fields: []string{"a"}, Hours: "08:00-08:00"}},
+		{"unknown time zone", RuleConfig{Fields: []string{"a"}, TimeZone: "Mars/Olympus"}},
+		{"unknown operation type", RuleConfig{OperationTypes: []string{"query", "update"}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := newPolicyRules([]RuleConfig{tt.rule}); err == nil {
+				t.Error("expected an error")
+			}
+		})
+	}
+}
@@ -0,0 +1,47 @@
+package trafico
+
+import "fmt"
+
+// Runtimes of Config.Runtime
+const (
+	runtimeNative = "native"
+	runtimeYaegi  = "yaegi"
+)
+
+// interpretedLimitation is a feature the yaegi interpreter cannot run
+type interpretedLimitation struct {
+	feature string
+	reason  string
+	enabled func(config *Config) bool
+	disable func(config *Config)
+}
+
+// interpretedLimitations are the features disabled when Traefik interprets
+// the plugin
+var interpretedLimitations = []interpretedLimitation{
+	{
+		feature: "subscriptions",
+		reason:  "the interpreter does not pass http.Hijacker through wrapped response writers, so WebSocket upgrades cannot be watched",
+		enabled: func(config *Config) bool { return config.Subscriptions.Enabled },
+		disable: func(config *Config) { config.Subscriptions.Enabled = false },
+	},
+}
+
+// applyRuntime disables the features the runtime cannot run, logging each
+// of them at startup
+func applyRuntime(config *Config, name string) error {
+	switch config.Runtime {
+	case "", runtimeNative:
+		return nil
+	case runtimeYaegi:
+	default:
+		return fmt.Errorf("runtime: unknown runtime %q, expected native or yaegi", config.Runtime)
+	}
+	for _, limitation := range interpretedLimitations {
+		if limitation.enabled(config) {
+			logf("middleware %s: %s is disabled in the yaegi runtime: %s", name, limitation.feature, limitation.reason)
+			limitation.disable(config)
+		}
+	}
+	return nil
+}
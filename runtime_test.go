@@ -0,0 +1,47 @@
+package trafico
+
+import (
+	"context"
+	"net/http"
+	"testing"
+)
+
+func TestRuntimeDefaults(t *testing.T) {
+	tests := []struct {
+		name    string
+		runtime string
+		build   func(config *Config) error
+		enabled bool
+	}{
+		{"traefik", "", func(config *Config) error {
+			_, err := New(context.Background(), http.NotFoundHandler(), config, "runtime")
+			return err
+		}, false},
+		{"traefik native", runtimeNative, func(config *Config) error {
+			_, err := New(context.Background(), http.NotFoundHandler(), config, "runtime")
+			return err
+		}, true},
+		{"middleware", "", func(config *Config) error {
+			_, err := NewMiddleware(config, "runtime")
+			return err
+		}, true},
+		{"middleware yaegi", runtimeYaegi, func(config *Config) error {
+			_, err := NewMiddleware(config, "runtime")
+			return err
+		}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := CreateConfig()
+			config.Runtime = tt.runtime
+			config.Subscriptions = SubscriptionsConfig{Enabled: true, MaxConnections: 1}
+			if err := tt.build(config); err != nil {
+				t.Fatal(err)
+			}
+			if config.Subscriptions.Enabled != tt.enabled {
+				t.Errorf("subscriptions enabled %v, want %v", config.Subscriptions.Enabled, tt.enabled)
+			}
+		})
+	}
+}
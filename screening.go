@@ -0,0 +1,184 @@
+package trafico
+
+import (
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+)
+
+// ScreeningConfig scans the variable values, and the keys of the objects
+// they hold, for payloads that look like injection attempts
+type ScreeningConfig struct {
+	Enabled bool `json:"enabled,omitempty"`
+	// Rules are built-in rules (sqli, nosqli, ssrf), named without a pattern,
+	// or custom ones; all built-in rules apply when empty
+	Rules []ScreeningRule `json:"rules,omitempty"`
+	// MaxStringLength flags longer strings, 0 meaning no limit; MaxDepth
+	// flags values nested deeper, 20 by default
+	MaxStringLength int `json:"maxStringLength,omitempty"`
+	MaxDepth        int `json:"maxDepth,omitempty"`
+	// Action is block (the default) to reject the request, or flag to
+	// forward it with Header listing the rules it matched
+	Action string `json:"action,omitempty"`
+	Header string `json:"header,omitempty"`
+}
+
+// ScreeningRule flags values matching Pattern, a regular expression
+type ScreeningRule struct {
+	Name    string `json:"name"`
+	Pattern string `json:"pattern,omitempty"`
+}
+
+const (
+	defaultScreeningHeader   = "X-GraphQL-Suspicious-Variables"
+	defaultScreeningMaxDepth = 20
+
+	screeningBlock = "block"
+	screeningFlag  = "flag"
+)
+
+// builtinScreeningRules catch the common shapes of injection payloads; they
+// favour precision, a WAF remains the place for exhaustive signatures
+var builtinScreeningRules = map[string]string{
+	"sqli":   `(?i)(\bunion\s+(all\s+)?select\b|'\s*(or|and)\s+['"\d]|\b(or|and)\s+\d+\s*=\s*\d+|;\s*(drop|delete|insert|update|alter|truncate)\s|\b(sleep|benchmark|pg_sleep)\s*\(|/\*[\s\S]*?\*/|--\s*$)`,
+	"nosqli": `(?i)^\$(where|ne|eq|gt|gte|lt|lte|in|nin|regex|exists|expr|or|and|not|nor)$|\$where\b`,
+	"ssrf":   `(?i)(\b(file|gopher|dict|ldap|tftp)://|://(localhost|127\.|0\.0\.0\.0|10\.|192\.168\.|172\.(1[6-9]|2\d|3[01])\.|169\.254\.|\[::1?\]|metadata\.google\.internal))`,
+}
+
+// variableScreener scans variables; a nil *variableScreener does nothing
+type variableScreener struct {
+	names     []string
+	patterns  []*regexp.Regexp
+	maxLength int
+	maxDepth  int
+	block     bool
+	header    string
+	lists     listHeaders
+}
+
+func newVariableScreener(config ScreeningConfig, lists listHeaders) (*variableScreener, error) {
+	if !config.Enabled {
+		return nil, nil
+	}
+	if config.MaxStringLength < 0 || config.MaxDepth < 0 {
+		return nil, fmt.Errorf("screening: maxStringLength and maxDepth must not be negative")
+	}
+
+	s := &variableScreener{
+		maxLength: config.MaxStringLength,
+		maxDepth:  config.MaxDepth,
+		header:    config.Header,
+		lists:     lists,
+	}
+	switch config.Action {
+	case "", screeningBlock:
+		s.block = true
+	case screeningFlag:
+	default:
+		return nil, fmt.Errorf("screening: unknown action %q, expected block or flag", config.Action)
+	}
+
+	rules := config.Rules
+	if len(rules) == 0 {
+		for _, name := range []string{"nosqli", "sqli", "ssrf"} {
+			rules = append(rules, ScreeningRule{Name: name})
+		}
+	}
+	for _, rule := range rules {
+		pattern := rule.Pattern
+		if pattern == "" {
+			builtin, ok := builtinScreeningRules[rule.Name]
+			if !ok {
+				return nil, fmt.Errorf("screening: rule %q has no pattern and is not a built-in rule", rule.Name)
+			}
+			pattern = builtin
+		}
+		if rule.Name == "" {
+			return nil, fmt.Errorf("screening: rule %q has no name", rule.Pattern)
+		}
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("screening: rule %q: %w", rule.Name, err)
+		}
+		s.names = append(s.names, rule.Name)
+		s.patterns = append(s.patterns, re)
+	}
+	if s.maxDepth == 0 {
+		s.maxDepth = defaultScreeningMaxDepth
+	}
+	if s.header == "" {
+		s.header = defaultScreeningHeader
+	}
+	return s, nil
+}
+
+// screen returns the sorted names of the rules the variables match, with
+// max_string_length and max_depth for the limits they exceed; in flag mode
+// they are written to the header
+func (s *variableScreener) screen(header http.Header, parsed *ParsedRequest) []string {
+	if s == nil {
+		return nil
+	}
+	header.Del(s.header)
+
+	matched := make(map[string]bool)
+	for _, entry := range parsed.entries() {
+		for name, value := range entry.Request.Variables {
+			s.check(name, matched)
+			s.walk(value, 1, matched)
+		}
+	}
+	if len(matched) == 0 {
+		return nil
+	}
+	findings := make([]string, 0, len(matched))
+	for name := range matched {
+		findings = append(findings, name)
+	}
+	findings = sortedUnique(findings)
+	if !s.block {
+		s.lists.set(header, s.header, findings)
+	}
+	return findings
+}
+
+func (s *variableScreener) walk(value any, depth int, matched map[string]bool) {
+	if depth > s.maxDepth {
+		matched["max_depth"] = true
+		return
+	}
+	switch v := value.(type) {
+	case string:
+		if s.maxLength > 0 && len(v) > s.maxLength {
+			matched["max_string_length"] = true
+		}
+		s.check(v, matched)
+	case map[string]any:
+		for key, item := range v {
+			s.check(key, matched)
+			s.walk(item, depth+1, matched)
+		}
+	case []any:
+		for _, item := range v {
+			s.walk(item, depth+1, matched)
+		}
+	}
+}
+
+// check matches a string against the rules not matched yet
+func (s *variableScreener) check(value string, matched map[string]bool) {
+	if value == "" {
+		return
+	}
+	for i, re := range s.patterns {
+		if !matched[s.names[i]] && re.MatchString(value) {
+			matched[s.names[i]] = true
+		}
+	}
+}
+
+// screeningMessage explains a blocked request without echoing the payload
+func screeningMessage(findings []string) string {
+	return "variables rejected by screening rules: " + strings.Join(findings, ", ")
+}
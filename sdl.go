@@ -0,0 +1,88 @@
+package trafico
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/alainrk/trafico/parser"
+)
+
+// SDLConfig serves the loaded schema as SDL to the holders of a token, so
+// that internal tooling keeps access to it when introspection is blocked
+type SDLConfig struct {
+	Enabled bool `json:"enabled,omitempty"`
+	// Path serves the SDL on GET, /graphql/sdl by default
+	Path string `json:"path,omitempty"`
+	// Token is expected as a bearer token in the Authorization header
+	Token string `json:"token,omitempty"`
+}
+
+const defaultSDLPath = "/graphql/sdl"
+
+// sdlEndpoint serves the schema; a nil *sdlEndpoint does nothing
+type sdlEndpoint struct {
+	path   string
+	token  []byte
+	schema *policySource
+}
+
+func newSDLEndpoint(config SDLConfig, schema *policySource) (*sdlEndpoint, error) {
+	if !config.Enabled {
+		return nil, nil
+	}
+	if schema == nil {
+		return nil, fmt.Errorf("sdl: a schema is required, set schema or schemaFile")
+	}
+	if config.Token == "" {
+		return nil, fmt.Errorf("sdl: a token is required")
+	}
+	s := &sdlEndpoint{path: config.Path, token: []byte(config.Token), schema: schema}
+	if s.path == "" {
+		s.path = defaultSDLPath
+	} else if !strings.HasPrefix(s.path, "/") {
+		return nil, fmt.Errorf("sdl: path %q must start with /", s.path)
+	}
+	return s, nil
+}
+
+// serve answers the requests of the SDL path and reports whether it did
+func (s *sdlEndpoint) serve(rw http.ResponseWriter, req *http.Request) bool {
+	if s == nil || req.URL.Path != s.path {
+		return false
+	}
+	if req.Method != http.MethodGet && req.Method != http.MethodHead {
+		rw.Header().Set("Allow", "GET, HEAD")
+		http.Error(rw, "method not allowed", http.StatusMethodNotAllowed)
+		return true
+	}
+	token, ok := strings.CutPrefix(req.Header.Get("Authorization"), "Bearer ")
+	if !ok || subtle.ConstantTimeCompare([]byte(token), s.token) != 1 {
+		rw.Header().Set("WWW-Authenticate", `Bearer realm="sdl"`)
+		http.Error(rw, "unauthorized", http.StatusUnauthorized)
+		return true
+	}
+	schema, _ := s.schema.current().(*parser.Schema)
+	if schema == nil {
+		http.Error(rw, "schema not loaded yet", http.StatusServiceUnavailable)
+		return true
+	}
+
+	sum := sha256.Sum256([]byte(schema.Source))
+	etag := `"` + hex.EncodeToString(sum[:16]) + `"`
+	header := rw.Header()
+	header.Set("ETag", etag)
+	header.Set("Cache-Control", "no-cache")
+	if req.Header.Get("If-None-Match") == etag {
+		rw.WriteHeader(http.StatusNotModified)
+		return true
+	}
+	header.Set("Content-Type", "text/plain; charset=utf-8")
+	if req.Method == http.MethodGet {
+		_, _ = rw.Write([]byte(schema.Source))
+	}
+	return true
+}
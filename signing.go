@@ -0,0 +1,164 @@
+package trafico
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// SigningConfig signs the metadata headers of forwarded requests with
+// HMAC-SHA256, so that backends can tell they were set by trafico and not by
+// a client reaching them directly. Headers starting with one of Prefixes are
+// removed from incoming requests and those set by trafico are signed; see
+// VerifySignature for the backend side.
+type SigningConfig struct {
+	Enabled bool   `json:"enabled,omitempty"`
+	Secret  string `json:"secret,omitempty"`
+	// Header carries the signature, X-Trafico-Signature by default
+	Header string `json:"header,omitempty"`
+	// Prefixes select the signed headers, X-GraphQL- by default
+	Prefixes []string `json:"prefixes,omitempty"`
+}
+
+const (
+	// DefaultSignatureHeader is the header carrying the signature
+	DefaultSignatureHeader = "X-Trafico-Signature"
+
+	defaultSignedPrefix = "X-GraphQL-"
+)
+
+// Errors returned by VerifySignature
+var (
+	ErrSignatureMissing = errors.New("trafico: request is not signed")
+	ErrSignatureInvalid = errors.New("trafico: invalid request signature")
+	ErrSignatureExpired = errors.New("trafico: request signature expired")
+)
+
+// signer signs the metadata headers; a nil *signer does nothing
+type signer struct {
+	secret   []byte
+	header   string
+	prefixes []string
+}
+
+func newSigner(config SigningConfig) (*signer, error) {
+	if !config.Enabled {
+		return nil, nil
+	}
+	if config.Secret == "" {
+		return nil, fmt.Errorf("signing: a secret is required")
+	}
+	s := &signer{secret: []byte(config.Secret), header: config.Header}
+	if s.header == "" {
+		s.header = DefaultSignatureHeader
+	}
+	prefixes := config.Prefixes
+	if len(prefixes) == 0 {
+		prefixes = []string{defaultSignedPrefix}
+	}
+	for _, prefix := range prefixes {
+		s.prefixes = append(s.prefixes, strings.ToLower(prefix))
+	}
+	return s, nil
+}
+
+func (s *signer) covers(name string) bool {
+	name = strings.ToLower(name)
+	for _, prefix := range s.prefixes {
+		if strings.HasPrefix(name, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// strip removes the signature and the headers it covers sent by the client,
+// so that only the ones trafico sets get signed
+func (s *signer) strip(header http.Header) {
+	if s == nil {
+		return
+	}
+	header.Del(s.header)
+	for name := range header {
+		if s.covers(name) {
+			delete(header, name)
+		}
+	}
+}
+
+// sign writes the signature of the covered headers of the request
+func (s *signer) sign(req *http.Request) {
+	if s == nil {
+		return
+	}
+	var names []string
+	for name := range req.Header {
+		if s.covers(name) {
+			names = append(names, strings.ToLower(name))
+		}
+	}
+	sort.Strings(names)
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	mac := signature(s.secret, timestamp, req, names)
+	req.Header.Set(s.header, "t="+timestamp+",h="+strings.Join(names, ";")+",v1="+mac)
+}
+
+// signature computes the HMAC of the timestamp, method, path and headers
+func signature(secret []byte, timestamp string, req *http.Request, names []string) string {
+	h := hmac.New(sha256.New, secret)
+	h.Write([]byte("v1\n" + timestamp + "\n" + req.Method + "\n" + req.URL.Path + "\n"))
+	for _, name := range names {
+		h.Write([]byte(name + ":" + strings.Join(req.Header.Values(name), ",") + "\n"))
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// VerifySignature checks the signature trafico added to a request, in header
+// or DefaultSignatureHeader when empty, with the shared secret. Signatures
+// older than maxAge are rejected when it is positive. It returns the
+// lowercased names of the signed headers, the only ones the backend should
+// trust.
+func VerifySignature(req *http.Request, header, secret string, maxAge time.Duration) ([]string, error) {
+	if header == "" {
+		header = DefaultSignatureHeader
+	}
+	value := req.Header.Get(header)
+	if value == "" {
+		return nil, ErrSignatureMissing
+	}
+
+	var timestamp, mac string
+	var names []string
+	for _, part := range strings.Split(value, ",") {
+		key, val, _ := strings.Cut(part, "=")
+		switch key {
+		case "t":
+			timestamp = val
+		case "h":
+			if val != "" {
+				names = strings.Split(val, ";")
+			}
+		case "v1":
+			mac = val
+		}
+	}
+	signedAt, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil || mac == "" {
+		return nil, ErrSignatureInvalid
+	}
+	expected := signature([]byte(secret), timestamp, req, names)
+	if !hmac.Equal([]byte(mac), []byte(expected)) {
+		return nil, ErrSignatureInvalid
+	}
+	if maxAge > 0 && time.Since(time.Unix(signedAt, 0)) > maxAge {
+		return nil, ErrSignatureExpired
+	}
+	return names, nil
+}
@@ -0,0 +1,77 @@
+package trafico
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestVerifySignature(t *testing.T) {
+	config := CreateConfig()
+	config.Signing = SigningConfig{Enabled: true, Secret: "secret"}
+	var forwarded *http.Request
+	handler := newTestHandler(t, config, func(rw http.ResponseWriter, req *http.Request) {
+		forwarded = req.Clone(req.Context())
+	})
+
+	req := httptest.NewRequest(http.MethodPost, "/graphql", strings.NewReader(`{"query":"mutation { a }"}`))
+	req.Header.Set("Content-Type", "application/json")
+	// Spoofed by the client
+	req.Header.Set("X-GraphQL-Tenant", "other")
+	req.Header.Set(DefaultSignatureHeader, "t=1,h=,v1=00")
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+	if forwarded == nil {
+		t.Fatal("the request was not forwarded")
+	}
+	if forwarded.Header.Get("X-GraphQL-Tenant") != "" {
+		t.Error("the spoofed header was forwarded")
+	}
+
+	names, err := VerifySignature(forwarded, "", "secret", time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"x-graphql-mutations", "x-graphql-operation-count"}; !reflect.DeepEqual(names, want) {
+		t.Errorf("signed %v, want %v", names, want)
+	}
+
+	hourAgo := strconv.FormatInt(time.Now().Add(-time.Hour).Unix(), 10)
+	tests := []struct {
+		name   string
+		tamper func(req *http.Request)
+		secret string
+		maxAge time.Duration
+		want   error
+	}{
+		{"wrong secret", func(req *http.Request) {}, "other", 0, ErrSignatureInvalid},
+		{"header changed", func(req *http.Request) { req.Header.Set("X-GraphQL-Mutations", "b") }, "secret", 0, ErrSignatureInvalid},
+		{"header removed", func(req *http.Request) { req.Header.Del("X-GraphQL-Mutations") }, "secret", 0, ErrSignatureInvalid},
+		{"path changed", func(req *http.Request) { req.URL.Path = "/admin" }, "secret", 0, ErrSignatureInvalid},
+		{"method changed", func(req *http.Request) { req.Method = http.MethodPut }, "secret", 0, ErrSignatureInvalid},
+		{"missing", func(req *http.Request) { req.Header.Del(DefaultSignatureHeader) }, "secret", 0, ErrSignatureMissing},
+		{"malformed", func(req *http.Request) { req.Header.Set(DefaultSignatureHeader, "v1=00") }, "secret", 0, ErrSignatureInvalid},
+		{"expired", func(req *http.Request) {
+			req.Header.Set(DefaultSignatureHeader, "t="+hourAgo+",h=,v1="+signature([]byte("secret"), hourAgo, req, nil))
+		}, "secret", time.Minute, ErrSignatureExpired},
+		{"unsigned header added", func(req *http.Request) { req.Header.Set("X-GraphQL-Extra", "1") }, "secret", 0, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := forwarded.Clone(forwarded.Context())
+			tt.tamper(req)
+			names, err := VerifySignature(req, "", tt.secret, tt.maxAge)
+			if err != tt.want {
+				t.Fatalf("got %v, want %v", err, tt.want)
+			}
+			for _, name := range names {
+				if name == "x-graphql-extra" {
+					t.Error("a header added after signing is reported as signed")
+				}
+			}
+		})
+	}
+}
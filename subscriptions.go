@@ -0,0 +1,364 @@
+package trafico
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// SubscriptionsConfig limits the WebSocket connections of GraphQL
+// subscriptions, so that a single client cannot hold thousands of streams
+// against the backend. Clients are told apart by their clientIdentity, or by
+// their IP.
+type SubscriptionsConfig struct {
+	Enabled bool `json:"enabled,omitempty"`
+	// MaxConnections bounds the concurrent connections of a client; further
+	// upgrades are answered with a 429
+	MaxConnections int `json:"maxConnections,omitempty"`
+	// MaxSubscriptions bounds the subscribe messages (start with the legacy
+	// subscriptions-transport-ws protocol) sent over a connection, which is
+	// closed with a policy violation past it. Text messages too large or
+	// compressed to be inspected count as subscriptions.
+	MaxSubscriptions int `json:"maxSubscriptions,omitempty"`
+	// MaxLifetime closes connections open for longer, such as 1h
+	MaxLifetime string `json:"maxLifetime,omitempty"`
+}
+
+const (
+	// maxInspectedMessageBytes bounds the client messages reassembled and
+	// decoded to find subscribe messages
+	maxInspectedMessageBytes = 64 << 10
+
+	wsOpContinuation = 0x0
+	wsOpText         = 0x1
+	wsOpClose        = 0x8
+	wsGoingAway      = 1001
+	wsPolicyCode     = 1008
+)
+
+// subscriptionLimits enforces the limits; a nil *subscriptionLimits does nothing
+type subscriptionLimits struct {
+	maxConnections   int
+	maxSubscriptions int
+	maxLifetime      time.Duration
+	connections      *connectionCounts
+}
+
+// connectionCounts counts the open connections per client
+type connectionCounts struct {
+	mu     sync.Mutex
+	counts map[string]int
+}
+
+var (
+	subscriptionConnectionsMu sync.Mutex
+	subscriptionConnections   = make(map[string]*connectionCounts)
+)
+
+func newSubscriptionLimits(config SubscriptionsConfig, middleware string) (*subscriptionLimits, error) {
+	if !config.Enabled {
+		return nil, nil
+	}
+	if config.MaxConnections < 0 || config.MaxSubscriptions < 0 {
+		return nil, fmt.Errorf("subscriptions: maxConnections and maxSubscriptions must not be negative")
+	}
+	s := &subscriptionLimits{maxConnections: config.MaxConnections, maxSubscriptions: config.MaxSubscriptions}
+	if config.MaxLifetime != "" {
+		d, err := time.ParseDuration(config.MaxLifetime)
+		if err != nil || d <= 0 {
+			return nil, fmt.Errorf("subscriptions: invalid maxLifetime %q", config.MaxLifetime)
+		}
+		s.maxLifetime = d
+	}
+	if s.maxConnections == 0 && s.maxSubscriptions == 0 && s.maxLifetime == 0 {
+		return nil, fmt.Errorf("subscriptions: maxConnections, maxSubscriptions or maxLifetime is required")
+	}
+
+	// Counts are shared by the instances Traefik builds for the middleware
+	subscriptionConnectionsMu.Lock()
+	defer subscriptionConnectionsMu.Unlock()
+	s.connections = subscriptionConnections[middleware]
+	if s.connections == nil {
+		s.connections = &connectionCounts{counts: make(map[string]int)}
+		subscriptionConnections[middleware] = s.connections
+	}
+	return s, nil
+}
+
+// acquire counts a connection of the client unless it holds the maximum
+func (c *connectionCounts) acquire(client string, max int) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if max > 0 && c.counts[client] >= max {
+		return false
+	}
+	c.counts[client]++
+	return true
+}
+
+func (c *connectionCounts) release(client string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.counts[client] <= 1 {
+		delete(c.counts, client)
+		return
+	}
+	c.counts[client]--
+}
+
+// isWebSocketUpgrade reports whether the request opens a WebSocket
+func isWebSocketUpgrade(req *http.Request) bool {
+	return req.Method == http.MethodGet && strings.EqualFold(req.Header.Get("Upgrade"), "websocket")
+}
+
+// serveWebSocket forwards a WebSocket upgrade within the limits of its client
+func (g *GraphQLParser) serveWebSocket(rw http.ResponseWriter, req *http.Request) {
+	limits := g.subscriptions
+	client := g.identity.resolve(req)
+	if client == "" {
+		client = identityIP + ":" + clientIP(req)
+	}
+
+	if !limits.connections.acquire(client, limits.maxConnections) {
+		parsed := &ParsedRequest{start: time.Now(), OperationType: "subscription", ClientIdentity: client}
+		message := "too many subscription connections, at most " + strconv.Itoa(limits.maxConnections) + " may be open"
+		if g.enforce(req, parsed, http.StatusTooManyRequests, "too_many_connections", message) {
+			g.reject(rw, req, parsed, http.StatusTooManyRequests, "too_many_connections", message)
+			return
+		}
+	} else {
+		defer limits.connections.release(client)
+	}
+
+	g.next.ServeHTTP(&upgradeWriter{ResponseWriter: rw, wrap: func(conn net.Conn, buffered []byte) (net.Conn, error) {
+		c := g.newSubscriptionConn(conn, req)
+		if !c.inspect(buffered) {
+			return nil, net.ErrClosed
+		}
+		return c, nil
+	}}, req)
+}
+
+// upgradeWriter wraps the connection the backend handler hijacks
+type upgradeWriter struct {
+	http.ResponseWriter
+	// wrap is also given the bytes the server read past the request, which
+	// the backend handler reads first
+	wrap func(conn net.Conn, buffered []byte) (net.Conn, error)
+}
+
+func (w *upgradeWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	h, ok := w.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, fmt.Errorf("%T does not implement http.Hijacker", w.ResponseWriter)
+	}
+	conn, brw, err := h.Hijack()
+	if err != nil {
+		return nil, nil, err
+	}
+	buffered, _ := brw.Reader.Peek(brw.Reader.Buffered())
+	wrapped, err := w.wrap(conn, buffered)
+	if err != nil {
+		return nil, nil, err
+	}
+	return wrapped, brw, nil
+}
+
+// subscriptionConn is the client connection of a WebSocket; it counts the
+// subscribe messages the client sends and closes the connection past the
+// limits. Close frames may land within a frame the proxy is writing, the
+// connection being closed right after anyway.
+type subscriptionConn struct {
+	net.Conn
+	g     *GraphQLParser
+	req   *http.Request
+	timer *time.Timer
+
+	writeMu   sync.Mutex
+	closeOnce sync.Once
+
+	// State of the frame being read
+	head      []byte
+	remaining uint64
+	mask      [4]byte
+	offset    uint64
+	control   bool
+	fin       bool
+	// State of the text message being reassembled from its frames; it is
+	// uninspectable when too large or compressed
+	text          bool
+	uninspectable bool
+	message       []byte
+	subscriptions int
+}
+
+func (g *GraphQLParser) newSubscriptionConn(conn net.Conn, req *http.Request) *subscriptionConn {
+	c := &subscriptionConn{Conn: conn, g: g, req: req}
+	if lifetime := g.subscriptions.maxLifetime; lifetime > 0 {
+		c.timer = time.AfterFunc(lifetime, func() {
+			c.closeWith(wsGoingAway, "maximum connection lifetime reached")
+		})
+	}
+	return c
+}
+
+func (c *subscriptionConn) Read(p []byte) (int, error) {
+	n, err := c.Conn.Read(p)
+	if n > 0 && !c.inspect(p[:n]) {
+		return 0, net.ErrClosed
+	}
+	return n, err
+}
+
+// inspect scans data the client sent, and reports false when it closed the
+// connection for exceeding the subscriptions limit
+func (c *subscriptionConn) inspect(data []byte) bool {
+	if len(data) == 0 || c.g.subscriptions.maxSubscriptions == 0 || c.scan(data) {
+		return true
+	}
+	message := "too many subscriptions, at most " + strconv.Itoa(c.g.subscriptions.maxSubscriptions) + " per connection"
+	if c.g.enforced("too_many_subscriptions") {
+		c.g.metrics.rejection("too_many_subscriptions")
+		c.closeWith(wsPolicyCode, message)
+		return false
+	}
+	logRequestf(c.req, "dry run: would close the connection to %s: %s", c.req.URL.Path, message)
+	c.g.metrics.dryRunRejection("too_many_subscriptions")
+	return true
+}
+
+func (c *subscriptionConn) Write(p []byte) (int, error) {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	return c.Conn.Write(p)
+}
+
+func (c *subscriptionConn) Close() error {
+	var err error
+	c.closeOnce.Do(func() {
+		if c.timer != nil {
+			c.timer.Stop()
+		}
+		err = c.Conn.Close()
+	})
+	return err
+}
+
+// closeWith sends a close frame and closes the connection
+func (c *subscriptionConn) closeWith(code int, reason string) {
+	frame := make([]byte, 0, 4+len(reason))
+	frame = append(frame, 0x80|wsOpClose, byte(2+len(reason)))
+	frame = binary.BigEndian.AppendUint16(frame, uint16(code))
+	frame = append(frame, reason...)
+	_, _ = c.Write(frame)
+	_ = c.Close()
+}
+
+// scan follows the frames the client sends and reports whether they keep
+// within the subscriptions limit
+func (c *subscriptionConn) scan(data []byte) bool {
+	for len(data) > 0 {
+		if c.remaining == 0 {
+			c.head = append(c.head, data[0])
+			data = data[1:]
+			if !c.startFrame() {
+				continue
+			}
+			if c.remaining == 0 && !c.endFrame() {
+				return false
+			}
+			continue
+		}
+
+		n := uint64(len(data))
+		if n > c.remaining {
+			n = c.remaining
+		}
+		if c.text && !c.control && !c.uninspectable {
+			for _, b := range data[:n] {
+				c.message = append(c.message, b^c.mask[c.offset%4])
+				c.offset++
+			}
+		}
+		data = data[n:]
+		c.remaining -= n
+		if c.remaining == 0 && !c.endFrame() {
+			return false
+		}
+	}
+	return true
+}
+
+// startFrame decodes the frame header once it is complete
+func (c *subscriptionConn) startFrame() bool {
+	if len(c.head) < 2 {
+		return false
+	}
+	size := 2
+	switch c.head[1] & 0x7f {
+	case 126:
+		size += 2
+	case 127:
+		size += 8
+	}
+	masked := c.head[1]&0x80 != 0
+	if masked {
+		size += 4
+	}
+	if len(c.head) < size {
+		return false
+	}
+
+	length := uint64(c.head[1] & 0x7f)
+	switch length {
+	case 126:
+		length = uint64(binary.BigEndian.Uint16(c.head[2:4]))
+	case 127:
+		length = binary.BigEndian.Uint64(c.head[2:10])
+	}
+	c.mask = [4]byte{}
+	if masked {
+		copy(c.mask[:], c.head[size-4:size])
+	}
+	c.fin = c.head[0]&0x80 != 0
+	opcode, compressed := c.head[0]&0x0f, c.head[0]&0x40 != 0
+	// Control frames may come between the frames of a message
+	c.control = opcode&0x8 != 0
+	switch {
+	case c.control:
+	case opcode == wsOpText:
+		c.text, c.uninspectable, c.message = true, compressed, c.message[:0]
+	case opcode != wsOpContinuation:
+		c.text = false
+	}
+	if c.text && !c.control && uint64(len(c.message))+length > maxInspectedMessageBytes {
+		c.uninspectable = true
+	}
+	c.remaining, c.offset, c.head = length, 0, c.head[:0]
+	return true
+}
+
+// endFrame counts the subscribe message the frame completes
+func (c *subscriptionConn) endFrame() bool {
+	if c.control || !c.fin || !c.text {
+		return true
+	}
+	c.text = false
+	if !c.uninspectable {
+		var message struct {
+			Type string `json:"type"`
+		}
+		if json.Unmarshal(c.message, &message) != nil || (message.Type != "subscribe" && message.Type != "start") {
+			return true
+		}
+	}
+	c.subscriptions++
+	return c.subscriptions <= c.g.subscriptions.maxSubscriptions
+}
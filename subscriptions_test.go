@@ -0,0 +1,196 @@
+package trafico
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// clientFrame encodes a masked client frame
+func clientFrame(fin, compressed bool, opcode byte, payload string) []byte {
+	first := opcode
+	if fin {
+		first |= 0x80
+	}
+	if compressed {
+		first |= 0x40
+	}
+	frame := []byte{first}
+	switch {
+	case len(payload) < 126:
+		frame = append(frame, 0x80|byte(len(payload)))
+	case len(payload) <= 0xffff:
+		frame = append(frame, 0x80|126)
+		frame = binary.BigEndian.AppendUint16(frame, uint16(len(payload)))
+	default:
+		frame = append(frame, 0x80|127)
+		frame = binary.BigEndian.AppendUint64(frame, uint64(len(payload)))
+	}
+	mask := [4]byte{0x12, 0x34, 0x56, 0x78}
+	frame = append(frame, mask[:]...)
+	for i := 0; i < len(payload); i++ {
+		frame = append(frame, payload[i]^mask[i%4])
+	}
+	return frame
+}
+
+func TestSubscriptionFrameScan(t *testing.T) {
+	const (
+		subscribe = `{"id":"1","type":"subscribe","payload":{"query":"subscription { a }"}}`
+		start     = `{"id":"1","type":"start","payload":{"query":"subscription { a }"}}`
+		ping      = `{"type":"ping"}`
+		binary    = 0x2
+		wsPing    = 0x9
+	)
+	large := `{"type":"subscribe","payload":{"query":"` + strings.Repeat("a", maxInspectedMessageBytes) + `"}}`
+
+	tests := []struct {
+		name   string
+		frames [][]byte
+		want   int
+	}{
+		{"subscribe", [][]byte{clientFrame(true, false, wsOpText, subscribe)}, 1},
+		{"legacy start", [][]byte{clientFrame(true, false, wsOpText, start)}, 1},
+		{"other messages", [][]byte{
+			clientFrame(true, false, wsOpText, `{"type":"connection_init"}`),
+			clientFrame(true, false, wsOpText, ping),
+			clientFrame(true, false, wsOpText, "not json"),
+		}, 0},
+		{"binary", [][]byte{clientFrame(true, false, binary, subscribe)}, 0},
+		{"fragmented", [][]byte{
+			clientFrame(false, false, wsOpText, subscribe[:10]),
+			clientFrame(false, false, wsOpContinuation, subscribe[10:30]),
+			clientFrame(true, false, wsOpContinuation, subscribe[30:]),
+		}, 1},
+		{"control frame between fragments", [][]byte{
+			clientFrame(false, false, wsOpText, subscribe[:10]),
+			clientFrame(true, false, wsPing, "ping"),
+			clientFrame(true, false, wsOpContinuation, subscribe[10:]),
+		}, 1},
+		{"fragmented others", [][]byte{
+			clientFrame(false, false, wsOpText, ping[:5]),
+			clientFrame(true, false, wsOpContinuation, ping[5:]),
+		}, 0},
+		{"oversized", [][]byte{clientFrame(true, false, wsOpText, large)}, 1},
+		{"oversized once reassembled", [][]byte{
+			clientFrame(false, false, wsOpText, large[:maxInspectedMessageBytes/2]),
+			clientFrame(true, false, wsOpContinuation, large[maxInspectedMessageBytes/2:]),
+		}, 1},
+		{"compressed", [][]byte{clientFrame(true, true, wsOpText, "compressed")}, 1},
+		{"several", [][]byte{
+			clientFrame(true, false, wsOpText, subscribe),
+			clientFrame(true, false, wsOpText, ping),
+			clientFrame(true, false, wsOpText, subscribe),
+		}, 2},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var stream []byte
+			for _, frame := range tt.frames {
+				stream = append(stream, frame...)
+			}
+			// Reads may split frames anywhere
+			for _, chunk := range []int{len(stream), 7, 1} {
+				c := &subscriptionConn{g: &GraphQLParser{subscriptions: &subscriptionLimits{maxSubscriptions: 100}}}
+				for data := stream; len(data) > 0; {
+					n := min(chunk, len(data))
+					if !c.scan(data[:n]) {
+						t.Fatalf("chunks of %d: limit reached", chunk)
+					}
+					data = data[n:]
+				}
+				if c.subscriptions != tt.want {
+					t.Errorf("chunks of %d: got %d subscriptions, want %d", chunk, c.subscriptions, tt.want)
+				}
+			}
+		})
+	}
+}
+
+func TestSubscriptionFrameScanLimit(t *testing.T) {
+	c := &subscriptionConn{g: &GraphQLParser{subscriptions: &subscriptionLimits{maxSubscriptions: 2}}}
+	subscribe := `{"id":"1","type":"subscribe","payload":{"query":"subscription { a }"}}`
+	for i, want := range []bool{true, true, false} {
+		frames := append(clientFrame(false, false, wsOpText, subscribe[:20]), clientFrame(true, false, wsOpContinuation, subscribe[20:])...)
+		if got := c.scan(frames); got != want {
+			t.Errorf("subscription %d: got %v, want %v", i+1, got, want)
+		}
+	}
+}
+
+// pipelinedConn is a client connection, the frames it sent with the upgrade
+// request being already buffered by the server
+type pipelinedConn struct {
+	net.Conn
+	in     *bytes.Reader
+	out    bytes.Buffer
+	closed bool
+}
+
+func (c *pipelinedConn) Read(p []byte) (int, error)  { return c.in.Read(p) }
+func (c *pipelinedConn) Write(p []byte) (int, error) { return c.out.Write(p) }
+func (c *pipelinedConn) Close() error                { c.closed = true; return nil }
+
+type hijackRecorder struct {
+	*httptest.ResponseRecorder
+	conn     net.Conn
+	buffered []byte
+}
+
+func (r *hijackRecorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	reader := bufio.NewReader(bytes.NewReader(r.buffered))
+	_, _ = reader.Peek(len(r.buffered))
+	return r.conn, bufio.NewReadWriter(reader, bufio.NewWriter(&bytes.Buffer{})), nil
+}
+
+func TestSubscriptionPipelinedFrames(t *testing.T) {
+	subscribe := clientFrame(true, false, wsOpText, `{"id":"1","type":"subscribe","payload":{"query":"subscription { a }"}}`)
+	tests := []struct {
+		name     string
+		buffered []byte
+		sent     []byte
+		hijacked bool
+		closed   bool
+	}{
+		{"within the limit", subscribe, nil, true, false},
+		{"buffered then sent", subscribe, subscribe, true, true},
+		{"buffered past the limit", append(append([]byte{}, subscribe...), subscribe...), nil, false, true},
+	}
+
+	config := CreateConfig()
+	config.Subscriptions = SubscriptionsConfig{Enabled: true, MaxSubscriptions: 1}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conn := &pipelinedConn{in: bytes.NewReader(tt.sent)}
+			var hijackErr error
+			handler := newTestHandler(t, config, func(rw http.ResponseWriter, req *http.Request) {
+				var wrapped net.Conn
+				wrapped, _, hijackErr = rw.(http.Hijacker).Hijack()
+				if hijackErr == nil {
+					_, _ = wrapped.Read(make([]byte, 512))
+				}
+			})
+
+			req := httptest.NewRequest(http.MethodGet, "/graphql", nil)
+			req.Header.Set("Upgrade", "websocket")
+			req.Header.Set("Connection", "Upgrade")
+			handler.ServeHTTP(&hijackRecorder{ResponseRecorder: httptest.NewRecorder(), conn: conn, buffered: tt.buffered}, req)
+
+			if hijacked := hijackErr == nil; hijacked != tt.hijacked {
+				t.Errorf("hijacked %v, want %v (%v)", hijacked, tt.hijacked, hijackErr)
+			}
+			if conn.closed != tt.closed {
+				t.Errorf("closed %v, want %v", conn.closed, tt.closed)
+			}
+			if conn.closed && !bytes.Contains(conn.out.Bytes(), []byte("too many subscriptions")) {
+				t.Errorf("no close frame sent: %q", conn.out.Bytes())
+			}
+		})
+	}
+}
@@ -0,0 +1,102 @@
+package trafico
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/alainrk/trafico/parser"
+)
+
+// TenantSourceConfig tells where the tenant identifier of a request is found
+type TenantSourceConfig struct {
+	// Variable is a variable name, or a dot-separated path into an input variable
+	Variable string `json:"variable,omitempty"`
+	// Argument is a "rootField.argument" path, optionally followed by keys of an
+	// input object (e.g. "orders.filter.tenant"); literals and variables are resolved
+	Argument string `json:"argument,omitempty"`
+}
+
+const defaultTenantHeader = "X-Tenant-ID"
+
+// tenantExtractor resolves the tenant of a request; a nil *tenantExtractor does nothing
+type tenantExtractor struct {
+	variablePath []string
+	field        string
+	argumentPath []string
+	header       string
+	lists        listHeaders
+}
+
+func newTenantExtractor(source TenantSourceConfig, header string, lists listHeaders) (*tenantExtractor, error) {
+	if source.Variable == "" && source.Argument == "" {
+		return nil, nil
+	}
+
+	t := &tenantExtractor{header: header, lists: lists}
+	if t.header == "" {
+		t.header = defaultTenantHeader
+	}
+	if source.Variable != "" {
+		t.variablePath = strings.Split(source.Variable, ".")
+	}
+	if source.Argument != "" {
+		parts := strings.SplitN(source.Argument, ".", 2)
+		if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+			return nil, fmt.Errorf("tenantSource: argument must be of the form rootField.argument, got %q", source.Argument)
+		}
+		t.field, t.argumentPath = parts[0], strings.Split(parts[1], ".")
+	}
+	return t, nil
+}
+
+// extract returns the tenant identifier, preferring the variable source
+func (t *tenantExtractor) extract(doc *parser.Document, operationName string, variables map[string]any) string {
+	if t.variablePath != nil {
+		if value := lookupPath(variables, t.variablePath); value != nil {
+			return headerValue(value)
+		}
+	}
+
+	if t.field != "" {
+		if value, ok := rootFieldArgument(doc, operationName, t.field, t.argumentPath[0], variables); ok {
+			if value = lookupPath(value, t.argumentPath[1:]); value != nil {
+				return headerValue(value)
+			}
+		}
+	}
+	return ""
+}
+
+// lookupPath follows object keys into a JSON-like value
+func lookupPath(value any, path []string) any {
+	for _, key := range path {
+		object, ok := value.(map[string]any)
+		if !ok {
+			return nil
+		}
+		value = object[key]
+	}
+	return value
+}
+
+// setHeader writes the tenant header, dropping any client-supplied value so
+// routing rules only ever see a tenant derived from the document; the entries
+// of a batch naming different tenants are all listed
+func (t *tenantExtractor) setHeader(header http.Header, parsed *ParsedRequest) {
+	if t == nil {
+		return
+	}
+	header.Del(t.header)
+
+	var tenants []string
+	for _, entry := range parsed.entries() {
+		tenant := t.extract(entry.Document, entry.Request.OperationName, entry.Request.Variables)
+		if tenant != "" && !containsString(tenants, tenant) {
+			tenants = append(tenants, tenant)
+		}
+	}
+	if len(tenants) > 0 {
+		t.lists.setValues(header, t.header, tenants)
+	}
+}
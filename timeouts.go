@@ -0,0 +1,182 @@
+package trafico
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// TimeoutsConfig bounds the time the backend may take, by operation type and
+// by root field. Each root field gets its own timeout, or the one of its
+// operation type, and the request is given the longest of them; a root field
+// without any timeout leaves the request unbounded.
+type TimeoutsConfig struct {
+	Query        string            `json:"query,omitempty"`
+	Mutation     string            `json:"mutation,omitempty"`
+	Subscription string            `json:"subscription,omitempty"`
+	Fields       map[string]string `json:"fields,omitempty"`
+	// Header tells the backend its budget in milliseconds
+	Header string `json:"header,omitempty"`
+}
+
+const defaultTimeoutHeader = "X-Request-Timeout-Ms"
+
+// timeoutPolicy computes request deadlines; a nil *timeoutPolicy does nothing
+type timeoutPolicy struct {
+	byType  map[string]time.Duration
+	byField map[string]time.Duration
+	header  string
+	errors  *errorRenderer
+}
+
+func newTimeoutPolicy(config TimeoutsConfig, errors *errorRenderer) (*timeoutPolicy, error) {
+	if config.Query == "" && config.Mutation == "" && config.Subscription == "" && len(config.Fields) == 0 {
+		return nil, nil
+	}
+
+	parse := func(setting, value string) (time.Duration, error) {
+		d, err := time.ParseDuration(value)
+		if err != nil || d <= 0 {
+			return 0, fmt.Errorf("timeouts: invalid %s %q, expected a positive duration such as 5s", setting, value)
+		}
+		return d, nil
+	}
+
+	t := &timeoutPolicy{
+		byType:  make(map[string]time.Duration),
+		byField: make(map[string]time.Duration, len(config.Fields)),
+		header:  config.Header,
+		errors:  errors,
+	}
+	for opType, value := range map[string]string{"query": config.Query, "mutation": config.Mutation, "subscription": config.Subscription} {
+		if value == "" {
+			continue
+		}
+		d, err := parse(opType, value)
+		if err != nil {
+			return nil, err
+		}
+		t.byType[opType] = d
+	}
+	for field, value := range config.Fields {
+		d, err := parse("fields."+field, value)
+		if err != nil {
+			return nil, err
+		}
+		t.byField[field] = d
+	}
+	if t.header == "" {
+		t.header = defaultTimeoutHeader
+	}
+	return t, nil
+}
+
+// timeout returns the time the request is given, 0 meaning no limit
+func (t *timeoutPolicy) timeout(parsed *ParsedRequest) time.Duration {
+	if t == nil {
+		return 0
+	}
+	var longest time.Duration
+	for _, entry := range parsed.entries() {
+		for _, op := range executedOperations(entry.Document, entry.Request.OperationName) {
+			for _, field := range entry.Document.RootFields(op) {
+				d, ok := t.byField[field.Name]
+				if !ok {
+					d, ok = t.byType[op.Type]
+				}
+				if !ok {
+					return 0
+				}
+				if d > longest {
+					longest = d
+				}
+			}
+		}
+	}
+	return longest
+}
+
+// apply sets the deadline of the request and tells the backend its budget;
+// the returned writer answers with a 504 GraphQL error when the deadline
+// passes before the backend answers, and is to be cancelled once it did
+func (t *timeoutPolicy) apply(rw http.ResponseWriter, req *http.Request, parsed *ParsedRequest) (http.ResponseWriter, *http.Request, *timeoutWriter) {
+	if t == nil {
+		return rw, req, nil
+	}
+	req.Header.Del(t.header)
+	timeout := t.timeout(parsed)
+	if timeout == 0 {
+		return rw, req, nil
+	}
+
+	ctx, cancel := context.WithTimeout(req.Context(), timeout)
+	req = req.WithContext(ctx)
+	req.Header.Set(t.header, strconv.FormatInt(timeout.Milliseconds(), 10))
+	tw := &timeoutWriter{ResponseWriter: rw, ctx: ctx, cancel: cancel, timeout: timeout, errors: t.errors, accept: req.Header.Get("Accept")}
+	return tw, req, tw
+}
+
+// timeoutWriter drops what the backend writes once the deadline passed, so
+// that the 504 can be written instead
+type timeoutWriter struct {
+	http.ResponseWriter
+	ctx     context.Context
+	cancel  context.CancelFunc
+	timeout time.Duration
+	errors  *errorRenderer
+	accept  string
+
+	mu          sync.Mutex
+	wroteHeader bool
+	timedOut    bool
+}
+
+func (w *timeoutWriter) WriteHeader(status int) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.expired() {
+		return
+	}
+	w.wroteHeader = true
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *timeoutWriter) Write(b []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.expired() {
+		return 0, context.DeadlineExceeded
+	}
+	w.wroteHeader = true
+	return w.ResponseWriter.Write(b)
+}
+
+// Flush keeps streaming responses working through the writer
+func (w *timeoutWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// expired reports whether the deadline passed before the response started
+func (w *timeoutWriter) expired() bool {
+	if !w.wroteHeader && w.ctx.Err() == context.DeadlineExceeded {
+		w.timedOut = true
+	}
+	return w.timedOut
+}
+
+// finish writes the 504 if the backend did not answer in time
+func (w *timeoutWriter) finish() bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if !w.expired() {
+		return false
+	}
+	w.errors.write(w.ResponseWriter, w.accept, http.StatusGatewayTimeout, "timeout",
+		fmt.Sprintf("the request did not complete within %s", w.timeout))
+	return true
+}
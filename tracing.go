@@ -0,0 +1,374 @@
+package trafico
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// TracingConfig configures OpenTelemetry trace propagation and span export
+type TracingConfig struct {
+	Enabled bool `json:"enabled,omitempty"`
+	// Baggage adds the GraphQL span attributes to the W3C baggage header
+	Baggage bool `json:"baggage,omitempty"`
+	// OTLPEndpoint exports the plugin spans using OTLP/HTTP JSON (e.g. http://collector:4318/v1/traces)
+	OTLPEndpoint string            `json:"otlpEndpoint,omitempty"`
+	OTLPHeaders  map[string]string `json:"otlpHeaders,omitempty"`
+	ServiceName  string            `json:"serviceName,omitempty"`
+}
+
+const (
+	traceparentHeader  = "traceparent"
+	baggageHeader      = "baggage"
+	defaultServiceName = "trafico"
+
+	spanQueueSize     = 1024
+	spanBatchSize     = 128
+	spanFlushInterval = 2 * time.Second
+)
+
+// traceContext is a parsed W3C traceparent header
+type traceContext struct {
+	traceID string
+	spanID  string
+	flags   string
+}
+
+// parseTraceparent parses a version 00 traceparent header
+func parseTraceparent(value string) (traceContext, bool) {
+	parts := strings.Split(strings.TrimSpace(value), "-")
+	if len(parts) < 4 || len(parts[0]) != 2 || parts[0] == "ff" {
+		return traceContext{}, false
+	}
+	if parts[0] == "00" && len(parts) != 4 {
+		return traceContext{}, false
+	}
+	tc := traceContext{traceID: parts[1], spanID: parts[2], flags: parts[3]}
+	if len(tc.traceID) != 32 || len(tc.spanID) != 16 || len(tc.flags) != 2 {
+		return traceContext{}, false
+	}
+	if !isLowerHex(tc.traceID) || !isLowerHex(tc.spanID) || !isLowerHex(tc.flags) {
+		return traceContext{}, false
+	}
+	if strings.Trim(tc.traceID, "0") == "" || strings.Trim(tc.spanID, "0") == "" {
+		return traceContext{}, false
+	}
+	return tc, true
+}
+
+func isLowerHex(s string) bool {
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if !(c >= '0' && c <= '9') && !(c >= 'a' && c <= 'f') {
+			return false
+		}
+	}
+	return true
+}
+
+func newSpanID() string {
+	var b [8]byte
+	_, _ = rand.Read(b[:])
+	return hex.EncodeToString(b[:])
+}
+
+// span is a finished or in-flight plugin span
+type span struct {
+	traceID      string
+	spanID       string
+	parentSpanID string
+	name         string
+	start        time.Time
+	end          time.Time
+	attributes   []spanAttribute
+	statusCode   int
+}
+
+type spanAttribute struct {
+	key    string
+	value  string
+	values []string
+}
+
+// tracer creates child spans for incoming traced requests
+type tracer struct {
+	baggage  bool
+	exporter *spanExporter
+}
+
+// newTracer builds the tracer for the configuration, or nil when tracing is disabled
+func newTracer(config TracingConfig) (*tracer, error) {
+	if !config.Enabled {
+		return nil, nil
+	}
+
+	t := &tracer{baggage: config.Baggage}
+	if config.OTLPEndpoint != "" {
+		if _, err := url.ParseRequestURI(config.OTLPEndpoint); err != nil {
+			return nil, fmt.Errorf("tracing: invalid otlpEndpoint %q: %w", config.OTLPEndpoint, err)
+		}
+		serviceName := config.ServiceName
+		if serviceName == "" {
+			serviceName = defaultServiceName
+		}
+		t.exporter = exporterFor(config.OTLPEndpoint, serviceName, config.OTLPHeaders)
+	}
+	return t, nil
+}
+
+// start creates a child span of the incoming trace context and propagates it
+// downstream; it returns nil when the request isn't traced
+func (t *tracer) start(req *http.Request, opName, opType, identity string, queries, mutations []string) *span {
+	if t == nil {
+		return nil
+	}
+	parent, ok := parseTraceparent(req.Header.Get(traceparentHeader))
+	if !ok {
+		return nil
+	}
+
+	s := &span{
+		traceID:      parent.traceID,
+		spanID:       newSpanID(),
+		parentSpanID: parent.spanID,
+		name:         spanName(opName, opType),
+		start:        time.Now(),
+	}
+	if opName != "" {
+		s.attributes = append(s.attributes, spanAttribute{key: "graphql.operation.name", value: opName})
+	}
+	if opType != "" {
+		s.attributes = append(s.attributes, spanAttribute{key: "graphql.operation.type", value: opType})
+	}
+	if identity != "" {
+		s.attributes = append(s.attributes, spanAttribute{key: "enduser.id", value: identity})
+	}
+	if fields := append(append([]string(nil), queries...), mutations...); len(fields) > 0 {
+		s.attributes = append(s.attributes, spanAttribute{key: "graphql.root_fields", values: fields})
+	}
+
+	// Downstream spans become children of the plugin span
+	req.Header.Set(traceparentHeader, "00-"+s.traceID+"-"+s.spanID+"-"+parent.flags)
+
+	if t.baggage {
+		var members []string
+		for _, attr := range s.attributes {
+			value := attr.value
+			if attr.values != nil {
+				value = strings.Join(attr.values, ",")
+			}
+			members = append(members, attr.key+"="+strings.ReplaceAll(url.QueryEscape(value), "+", "%20"))
+		}
+		if existing := req.Header.Get(baggageHeader); existing != "" {
+			members = append([]string{existing}, members...)
+		}
+		req.Header.Set(baggageHeader, strings.Join(members, ","))
+	}
+
+	return s
+}
+
+// finish ends the span and hands it to the exporter
+func (t *tracer) finish(s *span, status int) {
+	if t == nil || s == nil {
+		return
+	}
+	s.end = time.Now()
+	s.statusCode = status
+	t.exporter.enqueue(s)
+}
+
+// spanName follows the GraphQL semantic conventions: "{type} {name}" when known
+func spanName(opName, opType string) string {
+	switch {
+	case opType != "" && opName != "":
+		return opType + " " + opName
+	case opType != "":
+		return opType
+	default:
+		return "GraphQL Operation"
+	}
+}
+
+var (
+	exportersMu sync.Mutex
+	exporters   = make(map[string]*spanExporter)
+)
+
+// spanExporter batches spans and posts them to an OTLP/HTTP JSON endpoint
+type spanExporter struct {
+	endpoint    string
+	serviceName string
+	headers     map[string]string
+	queue       chan *span
+	client      *http.Client
+}
+
+// exporterFor returns the shared exporter for the endpoint, starting it if needed
+func exporterFor(endpoint, serviceName string, headers map[string]string) *spanExporter {
+	exportersMu.Lock()
+	defer exportersMu.Unlock()
+
+	key := endpoint + "|" + serviceName
+	if e, ok := exporters[key]; ok {
+		return e
+	}
+	e := &spanExporter{
+		endpoint:    endpoint,
+		serviceName: serviceName,
+		headers:     headers,
+		queue:       make(chan *span, spanQueueSize),
+		client:      &http.Client{Timeout: 10 * time.Second},
+	}
+	exporters[key] = e
+	go e.run()
+	return e
+}
+
+// enqueue never blocks: spans are dropped when the queue is full
+func (e *spanExporter) enqueue(s *span) {
+	if e == nil {
+		return
+	}
+	select {
+	case e.queue <- s:
+	default:
+	}
+}
+
+func (e *spanExporter) run() {
+	ticker := time.NewTicker(spanFlushInterval)
+	defer ticker.Stop()
+
+	var batch []*span
+	for {
+		select {
+		case s := <-e.queue:
+			batch = append(batch, s)
+			if len(batch) < spanBatchSize {
+				continue
+			}
+		case <-ticker.C:
+			if len(batch) == 0 {
+				continue
+			}
+		}
+		if err := e.export(batch); err != nil {
+			logf("span export to %s failed: %v", e.endpoint, err)
+		}
+		batch = nil
+	}
+}
+
+type otlpValue struct {
+	StringValue *string         `json:"stringValue,omitempty"`
+	IntValue    *string         `json:"intValue,omitempty"`
+	ArrayValue  *otlpArrayValue `json:"arrayValue,omitempty"`
+}
+
+// otlpArrayValue holds string values only, which also keeps the types from
+// being recursive as yaegi cannot interpret them
+type otlpArrayValue struct {
+	Values []otlpStringValue `json:"values"`
+}
+
+type otlpStringValue struct {
+	StringValue string `json:"stringValue"`
+}
+
+type otlpKeyValue struct {
+	Key   string    `json:"key"`
+	Value otlpValue `json:"value"`
+}
+
+type otlpSpan struct {
+	TraceID           string         `json:"traceId"`
+	SpanID            string         `json:"spanId"`
+	ParentSpanID      string         `json:"parentSpanId,omitempty"`
+	Name              string         `json:"name"`
+	Kind              int            `json:"kind"`
+	StartTimeUnixNano string         `json:"startTimeUnixNano"`
+	EndTimeUnixNano   string         `json:"endTimeUnixNano"`
+	Attributes        []otlpKeyValue `json:"attributes,omitempty"`
+	Status            struct {
+		Code int `json:"code"`
+	} `json:"status"`
+}
+
+func stringValue(s string) otlpValue {
+	return otlpValue{StringValue: &s}
+}
+
+func (e *spanExporter) export(batch []*span) error {
+	spans := make([]otlpSpan, 0, len(batch))
+	for _, s := range batch {
+		out := otlpSpan{
+			TraceID:           s.traceID,
+			SpanID:            s.spanID,
+			ParentSpanID:      s.parentSpanID,
+			Name:              s.name,
+			Kind:              1, // SPAN_KIND_INTERNAL
+			StartTimeUnixNano: strconv.FormatInt(s.start.UnixNano(), 10),
+			EndTimeUnixNano:   strconv.FormatInt(s.end.UnixNano(), 10),
+		}
+		for _, attr := range s.attributes {
+			kv := otlpKeyValue{Key: attr.key, Value: stringValue(attr.value)}
+			if attr.values != nil {
+				arr := &otlpArrayValue{}
+				for _, v := range attr.values {
+					arr.Values = append(arr.Values, otlpStringValue{StringValue: v})
+				}
+				kv.Value = otlpValue{ArrayValue: arr}
+			}
+			out.Attributes = append(out.Attributes, kv)
+		}
+		status := strconv.Itoa(s.statusCode)
+		out.Attributes = append(out.Attributes, otlpKeyValue{Key: "http.response.status_code", Value: otlpValue{IntValue: &status}})
+		if s.statusCode >= 500 {
+			out.Status.Code = 2 // STATUS_CODE_ERROR
+		}
+		spans = append(spans, out)
+	}
+
+	payload := map[string]any{
+		"resourceSpans": []any{map[string]any{
+			"resource": map[string]any{
+				"attributes": []otlpKeyValue{{Key: "service.name", Value: stringValue(e.serviceName)}},
+			},
+			"scopeSpans": []any{map[string]any{
+				"scope": map[string]any{"name": "trafico"},
+				"spans": spans,
+			}},
+		}},
+	}
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest(http.MethodPost, e.endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range e.headers {
+		req.Header.Set(k, v)
+	}
+	resp, err := e.client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("status %d", resp.StatusCode)
+	}
+	return nil
+}
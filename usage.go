@@ -0,0 +1,227 @@
+package trafico
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// UsageConfig aggregates the usage of root fields, and optionally of nested
+// field paths, and flushes a report every Interval
+type UsageConfig struct {
+	Enabled bool `json:"enabled,omitempty"`
+	// Interval is the reporting period, 1m by default
+	Interval string `json:"interval,omitempty"`
+	// Nested also counts the field paths, up to MaxDepth segments (3 by
+	// default)
+	Nested   bool `json:"nested,omitempty"`
+	MaxDepth int  `json:"maxDepth,omitempty"`
+	// MaxFields bounds the distinct fields and paths counted per interval,
+	// the others being counted as __other__; 10000 by default
+	MaxFields int `json:"maxFields,omitempty"`
+	// Output is log (default), file or http; reports are appended as JSON
+	// lines to FilePath, or POSTed as JSON to URL
+	Output   string `json:"output,omitempty"`
+	FilePath string `json:"filePath,omitempty"`
+	URL      string `json:"url,omitempty"`
+}
+
+const (
+	defaultUsageInterval  = time.Minute
+	defaultUsageMaxFields = 10000
+
+	usageOutputLog  = "log"
+	usageOutputFile = "file"
+	usageOutputHTTP = "http"
+)
+
+// usageReport is the JSON report of an interval
+type usageReport struct {
+	Middleware string         `json:"middleware"`
+	Start      string         `json:"start"`
+	End        string         `json:"end"`
+	Requests   int64          `json:"requests"`
+	RootFields map[string]int `json:"rootFields"`
+	FieldPaths map[string]int `json:"fieldPaths,omitempty"`
+}
+
+// usageAggregator counts field usage; a nil *usageAggregator does nothing
+type usageAggregator struct {
+	middleware string
+	paths      *fieldPathExtractor
+	maxFields  int
+	write      func(report *usageReport) error
+	target     string
+
+	mu         sync.Mutex
+	start      time.Time
+	requests   int64
+	rootFields map[string]int
+	fieldPaths map[string]int
+}
+
+var (
+	usageAggregatorsMu sync.Mutex
+	usageAggregators   = make(map[string]*usageAggregator)
+)
+
+// newUsageAggregator returns the aggregator of the middleware and output,
+// shared by the instances Traefik builds for it so that a single one flushes
+func newUsageAggregator(config UsageConfig, middleware string, lists listHeaders) (*usageAggregator, error) {
+	if !config.Enabled {
+		return nil, nil
+	}
+	if config.MaxFields < 0 {
+		return nil, fmt.Errorf("usage: maxFields must not be negative")
+	}
+	interval := defaultUsageInterval
+	if config.Interval != "" {
+		d, err := time.ParseDuration(config.Interval)
+		if err != nil || d <= 0 {
+			return nil, fmt.Errorf("usage: invalid interval %q", config.Interval)
+		}
+		interval = d
+	}
+
+	u := &usageAggregator{middleware: middleware, maxFields: config.MaxFields}
+	if u.maxFields == 0 {
+		u.maxFields = defaultUsageMaxFields
+	}
+	if config.Nested {
+		paths, err := newFieldPathExtractor(FieldPathsConfig{Enabled: true, MaxDepth: config.MaxDepth, MaxPaths: u.maxFields}, lists)
+		if err != nil {
+			return nil, fmt.Errorf("usage: %w", err)
+		}
+		u.paths = paths
+	}
+
+	switch config.Output {
+	case "", usageOutputLog:
+		u.target = usageOutputLog
+		u.write = func(report *usageReport) error {
+			line, err := json.Marshal(report)
+			if err == nil {
+				logf("field usage: %s", line)
+			}
+			return err
+		}
+	case usageOutputFile:
+		file, err := fileWriterFor(AccessLogConfig{FilePath: config.FilePath})
+		if err != nil {
+			return nil, fmt.Errorf("usage: %w", err)
+		}
+		u.target = config.FilePath
+		u.write = func(report *usageReport) error {
+			line, err := json.Marshal(report)
+			if err != nil {
+				return err
+			}
+			return file.writeLine(line)
+		}
+	case usageOutputHTTP:
+		if config.URL == "" {
+			return nil, fmt.Errorf("usage: url is required for http output")
+		}
+		client := &http.Client{Timeout: 10 * time.Second}
+		u.target = config.URL
+		u.write = func(report *usageReport) error {
+			body, err := json.Marshal(report)
+			if err != nil {
+				return err
+			}
+			resp, err := client.Post(config.URL, "application/json", bytes.NewReader(body))
+			if err != nil {
+				return err
+			}
+			resp.Body.Close()
+			if resp.StatusCode >= http.StatusMultipleChoices {
+				return fmt.Errorf("unexpected status %d", resp.StatusCode)
+			}
+			return nil
+		}
+	default:
+		return nil, fmt.Errorf("usage: unknown output %q", config.Output)
+	}
+
+	usageAggregatorsMu.Lock()
+	defer usageAggregatorsMu.Unlock()
+	key := middleware + "\n" + u.target
+	if existing, ok := usageAggregators[key]; ok {
+		return existing, nil
+	}
+	u.reset(time.Now())
+	usageAggregators[key] = u
+	go u.run(interval)
+	return u, nil
+}
+
+// observe counts the root fields and paths of the executed operations
+func (u *usageAggregator) observe(parsed *ParsedRequest) {
+	if u == nil {
+		return
+	}
+	var roots []string
+	for _, entry := range parsed.entries() {
+		for _, op := range executedOperations(entry.Document, entry.Request.OperationName) {
+			for _, field := range entry.Document.RootFields(op) {
+				roots = append(roots, op.Type+"."+field.Name)
+			}
+		}
+	}
+	paths, _ := u.paths.extract(parsed)
+
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	u.requests++
+	for _, root := range sortedUnique(roots) {
+		u.count(u.rootFields, root)
+	}
+	for _, path := range paths {
+		u.count(u.fieldPaths, path)
+	}
+}
+
+// count increments the key, folding new keys into __other__ past maxFields
+func (u *usageAggregator) count(counts map[string]int, key string) {
+	if _, ok := counts[key]; !ok && len(counts) >= u.maxFields {
+		key = otherFieldLabel
+	}
+	counts[key]++
+}
+
+func (u *usageAggregator) reset(now time.Time) {
+	u.start = now
+	u.requests = 0
+	u.rootFields = make(map[string]int)
+	if u.paths != nil {
+		u.fieldPaths = make(map[string]int)
+	}
+}
+
+func (u *usageAggregator) run(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for now := range ticker.C {
+		u.mu.Lock()
+		report := &usageReport{
+			Middleware: u.middleware,
+			Start:      u.start.UTC().Format(time.RFC3339),
+			End:        now.UTC().Format(time.RFC3339),
+			Requests:   u.requests,
+			RootFields: u.rootFields,
+			FieldPaths: u.fieldPaths,
+		}
+		u.reset(now)
+		u.mu.Unlock()
+
+		if report.Requests == 0 {
+			continue
+		}
+		if err := u.write(report); err != nil {
+			logf("writing the field usage report to %s failed: %v", u.target, err)
+		}
+	}
+}
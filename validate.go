@@ -0,0 +1,191 @@
+package trafico
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// validateConfig reports every setting that cannot work as configured, so that
+// Traefik surfaces the whole misconfiguration when the middleware is built
+// instead of the plugin silently misbehaving. Settings checked by the
+// subsystem constructors (durations, formats, files) are not repeated here.
+func validateConfig(config *Config) error {
+	var errs []error
+	add := func(format string, args ...any) {
+		errs = append(errs, fmt.Errorf(format, args...))
+	}
+
+	// Headers written on the forwarded request, by setting
+	outputs := map[string]string{
+		"queryHeader":                  config.QueryHeader,
+		"mutationHeader":               config.MutationHeader,
+		"tenantHeader":                 config.TenantHeader,
+		"variables.header":             config.Variables.Header,
+		"routing.header":               config.Routing.Header,
+		"federation.entityTypesHeader": config.Federation.EntityTypesHeader,
+		"federation.requestHeader":     config.Federation.RequestHeader,
+		"clients.nameHeader":           config.Clients.NameHeader,
+		"clients.versionHeader":        config.Clients.VersionHeader,
+		"fieldPaths.header":            config.FieldPaths.Header,
+		"directives.header":            config.Directives.Header,
+		"deprecations.header":          config.Deprecations.Header,
+		"timeouts.header":              config.Timeouts.Header,
+		"canary.header":                config.Canary.Header,
+		"screening.header":             config.Screening.Header,
+		"signing.header":               config.Signing.Header,
+		"queryHash.header":             config.QueryHash.Header,
+		"queryHash.normalizedHeader":   config.QueryHash.NormalizedHeader,
+		"nullFields.header":            config.NullFields.Header,
+		"quota.header":                 config.Quota.Header,
+		"requestId.header":             config.RequestID.Header,
+		"clientIdentity.header":        config.ClientIdentity.Header,
+	}
+	settings := make([]string, 0, len(outputs))
+	for setting := range outputs {
+		settings = append(settings, setting)
+	}
+	sort.Strings(settings)
+
+	owners := make(map[string]string)
+	for _, setting := range settings {
+		name := outputs[setting]
+		if name == "" {
+			continue
+		}
+		if !validHeaderName(name) {
+			add("%s: %q is not a valid header name", setting, name)
+			continue
+		}
+		key := http.CanonicalHeaderKey(name)
+		if owner, ok := owners[key]; ok {
+			add("%s: header %q is already set by %s", setting, name, owner)
+			continue
+		}
+		owners[key] = setting
+	}
+
+	// Header prefixes and headers read from the request
+	inputs := []struct{ setting, name string }{
+		{"variables.headerPrefix", config.Variables.HeaderPrefix},
+		{"argHeaderPrefix", config.ArgHeaderPrefix},
+		{"events.clientIdHeader", config.Events.ClientIDHeader},
+		{"debug.header", config.Debug.Header},
+		{"idempotency.header", config.Idempotency.Header},
+		{"quota.keyHeader", config.Quota.KeyHeader},
+	}
+	for i, source := range config.ClientIdentity.Sources {
+		inputs = append(inputs, struct{ setting, name string }{fmt.Sprintf("clientIdentity.sources[%d].header", i), source.Header})
+	}
+	for i, profile := range config.Profiles {
+		inputs = append(inputs, struct{ setting, name string }{fmt.Sprintf("profiles[%d].header", i), profile.Header})
+	}
+	for i, name := range config.CSRF.Headers {
+		inputs = append(inputs, struct{ setting, name string }{fmt.Sprintf("csrf.headers[%d]", i), name})
+	}
+	for i, name := range config.Endpoint.CORS.AllowedHeaders {
+		inputs = append(inputs, struct{ setting, name string }{fmt.Sprintf("endpoint.cors.allowedHeaders[%d]", i), name})
+	}
+	for i, name := range config.Endpoint.CORS.ExposedHeaders {
+		inputs = append(inputs, struct{ setting, name string }{fmt.Sprintf("endpoint.cors.exposedHeaders[%d]", i), name})
+	}
+	for _, input := range inputs {
+		if input.name != "" && !validHeaderName(input.name) {
+			add("%s: %q is not a valid header name", input.setting, input.name)
+		}
+	}
+
+	if config.MaxTokens > 0 && config.MaxSelectionSetNodes > config.MaxTokens {
+		add("maxSelectionSetNodes (%d) exceeds maxTokens (%d) and can never apply, every selection takes at least one token",
+			config.MaxSelectionSetNodes, config.MaxTokens)
+	}
+	if config.MaxTotalFields > 0 && config.MaxFieldsPerLevel > config.MaxTotalFields {
+		add("maxFieldsPerLevel (%d) exceeds maxTotalFields (%d) and can never apply", config.MaxFieldsPerLevel, config.MaxTotalFields)
+	}
+	if config.Schema != "" && config.SchemaFile != "" {
+		add("schema and schemaFile are both set, keep only one")
+	}
+
+	// Field maps name root fields, arguments and variables
+	fields := make([]string, 0, len(config.ExtractArgs))
+	for field := range config.ExtractArgs {
+		fields = append(fields, field)
+	}
+	sort.Strings(fields)
+	for _, field := range fields {
+		if !validGraphQLName(field) {
+			add("extractArgs: %q is not a valid field name", field)
+		}
+		for _, arg := range config.ExtractArgs[field] {
+			if arg != "" && !validGraphQLName(arg) {
+				add("extractArgs: %q of field %q is not a valid argument name", arg, field)
+			}
+		}
+	}
+	fields = fields[:0]
+	for field := range config.Routing.Fields {
+		fields = append(fields, field)
+	}
+	sort.Strings(fields)
+	for _, field := range fields {
+		if !validGraphQLName(field) {
+			add("routing.fields: %q is not a valid field name", field)
+		}
+		if service := config.Routing.Fields[field]; strings.TrimSpace(service) == "" {
+			add("routing.fields: field %q has no service", field)
+		}
+	}
+	for _, name := range config.Variables.Forward {
+		if !validGraphQLName(name) {
+			add("variables.forward: %q is not a valid variable name", name)
+		}
+	}
+	if path := config.TenantSource.Variable; path != "" && strings.Contains("."+path+".", "..") {
+		add("tenantSource.variable: %q has an empty path segment", path)
+	}
+	if config.Canary.Enabled && config.Canary.Source == canarySourceClient && !config.Clients.Enabled {
+		add("canary: the client source requires clients.enabled")
+	}
+	if config.Quota.Enabled && config.Quota.Source == quotaSourceClient && !config.Clients.Enabled {
+		add("quota: the client source requires clients.enabled")
+	}
+	if config.Quota.Enabled && config.Quota.Source == quotaSourceIdentity && !config.ClientIdentity.Enabled {
+		add("quota: the identity source requires clientIdentity.enabled")
+	}
+
+	return errors.Join(errs...)
+}
+
+// validHeaderName reports whether name is an HTTP field name (RFC 9110 token)
+func validHeaderName(name string) bool {
+	if name == "" {
+		return false
+	}
+	for i := 0; i < len(name); i++ {
+		c := name[i]
+		if isNameContinueByte(c) || strings.IndexByte("!#$%&'*+-.^_`|~", c) >= 0 {
+			continue
+		}
+		return false
+	}
+	return true
+}
+
+// validGraphQLName reports whether name matches /[_A-Za-z][_0-9A-Za-z]*/
+func validGraphQLName(name string) bool {
+	if name == "" || (name[0] >= '0' && name[0] <= '9') {
+		return false
+	}
+	for i := 0; i < len(name); i++ {
+		if !isNameContinueByte(name[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+func isNameContinueByte(c byte) bool {
+	return c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (c >= '0' && c <= '9')
+}
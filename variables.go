@@ -0,0 +1,146 @@
+package trafico
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+)
+
+// VariablesConfig controls how GraphQL variables are exposed and redacted
+type VariablesConfig struct {
+	// ExposeNames sets Header to the variable names, never their values
+	ExposeNames bool   `json:"exposeNames,omitempty"`
+	Header      string `json:"header,omitempty"`
+
+	// Forward lists variables whose values are forwarded in HeaderPrefix+Name headers
+	Forward      []string `json:"forward,omitempty"`
+	HeaderPrefix string   `json:"headerPrefix,omitempty"`
+
+	// Redact lists variables (matched case-insensitively, at any depth) scrubbed from logs and events
+	Redact []string `json:"redact,omitempty"`
+}
+
+const (
+	defaultVariablesHeader      = "X-GraphQL-Variables"
+	defaultVariableHeaderPrefix = "X-GraphQL-Variable-"
+	redactedValue               = "[REDACTED]"
+)
+
+// variablePolicy applies a VariablesConfig to request variables
+type variablePolicy struct {
+	exposeNames  bool
+	header       string
+	forward      []string
+	headerPrefix string
+	redact       map[string]bool
+	lists        listHeaders
+}
+
+func newVariablePolicy(config VariablesConfig, lists listHeaders) *variablePolicy {
+	p := &variablePolicy{
+		exposeNames:  config.ExposeNames,
+		header:       config.Header,
+		forward:      config.Forward,
+		headerPrefix: config.HeaderPrefix,
+		redact:       make(map[string]bool, len(config.Redact)),
+		lists:        lists,
+	}
+	if p.header == "" {
+		p.header = defaultVariablesHeader
+	}
+	if p.headerPrefix == "" {
+		p.headerPrefix = defaultVariableHeaderPrefix
+	}
+	for _, name := range config.Redact {
+		p.redact[strings.ToLower(name)] = true
+	}
+	return p
+}
+
+// setHeaders writes the variable names and the allowlisted variable values,
+// merged across the entries of a batch, replacing those the client sent
+func (p *variablePolicy) setHeaders(header http.Header, parsed *ParsedRequest) {
+	entries := parsed.entries()
+
+	if p.exposeNames {
+		header.Del(p.header)
+		var names []string
+		for _, entry := range entries {
+			for name := range entry.Request.Variables {
+				names = append(names, name)
+			}
+		}
+		if names = sortedUnique(names); len(names) > 0 {
+			p.lists.set(header, p.header, names)
+		}
+	}
+
+	for _, name := range p.forward {
+		header.Del(p.headerPrefix + name)
+		var values []string
+		for _, entry := range entries {
+			value, ok := entry.Request.Variables[name]
+			if !ok || value == nil {
+				continue
+			}
+			if rendered := headerValue(value); !containsString(values, rendered) {
+				values = append(values, rendered)
+			}
+		}
+		if len(values) > 0 {
+			p.lists.setValues(header, p.headerPrefix+name, values)
+		}
+	}
+}
+
+// redacted returns a copy of the variables with sensitive values scrubbed
+func (p *variablePolicy) redacted(variables map[string]any) map[string]any {
+	if len(variables) == 0 || len(p.redact) == 0 {
+		return variables
+	}
+	out, _ := p.redactValue(variables).(map[string]any)
+	return out
+}
+
+func (p *variablePolicy) redactValue(value any) any {
+	switch v := value.(type) {
+	case map[string]any:
+		out := make(map[string]any, len(v))
+		for key, item := range v {
+			if p.redact[strings.ToLower(key)] {
+				out[key] = redactedValue
+				continue
+			}
+			out[key] = p.redactValue(item)
+		}
+		return out
+	case []any:
+		out := make([]any, len(v))
+		for i, item := range v {
+			out[i] = p.redactValue(item)
+		}
+		return out
+	default:
+		return value
+	}
+}
+
+// headerValue renders a JSON value for use in a header, stripping control characters
+func headerValue(value any) string {
+	var s string
+	if str, ok := value.(string); ok {
+		s = str
+	} else {
+		data, err := json.Marshal(value)
+		if err != nil {
+			return ""
+		}
+		s = string(data)
+	}
+	return strings.Map(func(r rune) rune {
+		if r < 0x20 || r == 0x7f {
+			return -1
+		}
+		return r
+	}, s)
+}
@@ -0,0 +1,6 @@
+// Package yaegi loads the plugin with the yaegi interpreter, as Traefik does,
+// and serves requests through it. It is a module of its own so that the
+// plugin keeps no dependency; run it from this directory with
+//
+//	go mod tidy && go test ./...
+package yaegi
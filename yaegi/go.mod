@@ -0,0 +1,5 @@
+module github.com/alainrk/trafico/yaegi
+
+go 1.23.4
+
+require github.com/traefik/yaegi v0.16.1
@@ -0,0 +1,176 @@
+package yaegi
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/traefik/yaegi/interp"
+	"github.com/traefik/yaegi/stdlib"
+)
+
+const pluginImport = "github.com/alainrk/trafico"
+
+// loadPlugin interprets the plugin from a GOPATH linking to the repository
+// and returns its CreateConfig and New functions
+func loadPlugin(t *testing.T) (createConfig, newHandler reflect.Value) {
+	t.Helper()
+	root, err := filepath.Abs("..")
+	if err != nil {
+		t.Fatal(err)
+	}
+	goPath := t.TempDir()
+	dir := filepath.Join(goPath, "src", filepath.FromSlash(pluginImport))
+	if err := os.MkdirAll(filepath.Dir(dir), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(root, dir); err != nil {
+		t.Fatal(err)
+	}
+
+	i := interp.New(interp.Options{GoPath: goPath, Env: os.Environ()})
+	if err := i.Use(stdlib.Symbols); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := i.Eval(`import "` + pluginImport + `"`); err != nil {
+		t.Fatalf("the plugin does not load in yaegi: %v", err)
+	}
+	if createConfig, err = i.Eval("trafico.CreateConfig"); err != nil {
+		t.Fatal(err)
+	}
+	if newHandler, err = i.Eval("trafico.New"); err != nil {
+		t.Fatal(err)
+	}
+	return createConfig, newHandler
+}
+
+// testData reads the flat testData settings of .traefik.yml, which the
+// plugin catalog loads the plugin with
+func testData(t *testing.T) map[string]any {
+	t.Helper()
+	file, err := os.Open(filepath.Join("..", ".traefik.yml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	settings := make(map[string]any)
+	inTestData := false
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if !strings.HasPrefix(line, " ") {
+			inTestData = strings.TrimSpace(line) == "testData:"
+			continue
+		}
+		if key, value, ok := strings.Cut(strings.TrimSpace(line), ":"); inTestData && ok {
+			settings[key] = strings.TrimSpace(value)
+		}
+	}
+	return settings
+}
+
+// newPlugin builds the middleware from the settings, the way Traefik
+// decodes the dynamic configuration into the interpreted Config
+func newPlugin(t *testing.T, settings map[string]any, next http.Handler) http.Handler {
+	t.Helper()
+	createConfig, newHandler := loadPlugin(t)
+	config := createConfig.Call(nil)[0]
+	data, err := json.Marshal(settings)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := json.Unmarshal(data, config.Interface()); err != nil {
+		t.Fatal(err)
+	}
+
+	results := newHandler.Call([]reflect.Value{
+		reflect.ValueOf(context.Background()), reflect.ValueOf(next), config, reflect.ValueOf("yaegi"),
+	})
+	if err, _ := results[1].Interface().(error); err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	handler, ok := results[0].Interface().(http.Handler)
+	if !ok {
+		t.Fatalf("New returned %T, not an http.Handler", results[0].Interface())
+	}
+	return handler
+}
+
+func serve(handler http.Handler, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPost, "/graphql", strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	rw := httptest.NewRecorder()
+	handler.ServeHTTP(rw, req)
+	return rw
+}
+
+func TestExtractsFields(t *testing.T) {
+	var forwarded http.Header
+	handler := newPlugin(t, testData(t), http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		forwarded = req.Header.Clone()
+	}))
+
+	rw := serve(handler, `{"query":"query { user(id: 1, tags: [\"a\"], filter: {role: ADMIN}) { id } } mutation M { logout }","operationName":"M"}`)
+	if rw.Code != http.StatusOK {
+		t.Fatalf("status %d: %s", rw.Code, rw.Body.String())
+	}
+	if got := forwarded.Get("X-GraphQL-Queries"); got != "user" {
+		t.Errorf("queries header = %q, want user", got)
+	}
+	if got := forwarded.Get("X-GraphQL-Mutations"); got != "logout" {
+		t.Errorf("mutations header = %q, want logout", got)
+	}
+}
+
+func TestRejectsWithGraphQLErrors(t *testing.T) {
+	settings := testData(t)
+	settings["maxBatchSize"] = 1
+	handler := newPlugin(t, settings, http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		t.Error("the request was forwarded")
+	}))
+
+	rw := serve(handler, `[{"query":"{ a }"},{"query":"{ b }"}]`)
+	if rw.Code != http.StatusBadRequest {
+		t.Fatalf("status %d, want 400", rw.Code)
+	}
+	var response struct {
+		Errors []struct {
+			Extensions struct {
+				Code string `json:"code"`
+			} `json:"extensions"`
+		} `json:"errors"`
+	}
+	if err := json.Unmarshal(rw.Body.Bytes(), &response); err != nil || len(response.Errors) != 1 {
+		t.Fatalf("invalid GraphQL error response %q: %v", rw.Body.String(), err)
+	}
+	if code := response.Errors[0].Extensions.Code; code != "LIMIT_EXCEEDED" {
+		t.Errorf("code = %q, want LIMIT_EXCEEDED", code)
+	}
+}
+
+func TestDisablesInterpretedLimitations(t *testing.T) {
+	settings := testData(t)
+	settings["subscriptions"] = map[string]any{"enabled": true, "maxConnections": 1}
+	handler := newPlugin(t, settings, http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		rw.WriteHeader(http.StatusSwitchingProtocols)
+	}))
+
+	// Connections are not limited once subscriptions are disabled
+	for i := 0; i < 2; i++ {
+		req := httptest.NewRequest(http.MethodGet, "/graphql", nil)
+		req.Header.Set("Upgrade", "websocket")
+		rw := httptest.NewRecorder()
+		handler.ServeHTTP(rw, req)
+		if rw.Code != http.StatusSwitchingProtocols {
+			t.Fatalf("upgrade %d answered %d", i, rw.Code)
+		}
+	}
+}